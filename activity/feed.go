@@ -0,0 +1,203 @@
+package activity
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/spear-wind/cms/events"
+)
+
+// DefaultBufferSize is how many recent events a Feed keeps for clients
+// resuming with Last-Event-ID.
+const DefaultBufferSize = 1000
+
+// clientBufferSize is how many events may queue up for a slow client before
+// it is disconnected. The client can reconnect with Last-Event-ID to pick up
+// where it left off.
+const clientBufferSize = 64
+
+var errSlowClient = errors.New("The client has too many events queued")
+
+// Feed is an EventSubscriber that fans domain events out to a Subscription
+// per connected stream client, and remembers the most recent ones in a ring
+// buffer so that clients can resume after a dropped connection.
+type Feed struct {
+	mutex   sync.Mutex
+	buffer  []events.Envelope
+	start   int
+	count   int
+	ids     map[string]bool
+	clients map[*Subscription]bool
+	closed  bool
+}
+
+// Subscription is the EventSubscriber for one client. It only queues the
+// envelopes visible reports the client may see. Events arrives in the order
+// the feed received them and is closed when the client falls too far behind
+// or the feed is closed.
+type Subscription struct {
+	Events  <-chan events.Envelope
+	events  chan events.Envelope
+	visible func(events.Envelope) bool
+	mutex   sync.Mutex
+	closed  bool
+}
+
+// Receive queues envelope for the client if it may see it, and fails if the
+// client's queue is full.
+func (subscription *Subscription) Receive(e interface{}) error {
+	envelope, ok := e.(events.Envelope)
+	if !ok || !subscription.visible(envelope) {
+		return nil
+	}
+
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+
+	if subscription.closed {
+		return nil
+	}
+
+	select {
+	case subscription.events <- envelope:
+		return nil
+	default:
+		return errSlowClient
+	}
+}
+
+func (subscription *Subscription) close() {
+	subscription.mutex.Lock()
+	defer subscription.mutex.Unlock()
+
+	if !subscription.closed {
+		subscription.closed = true
+		close(subscription.events)
+	}
+}
+
+func NewFeed(bufferSize int) *Feed {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Feed{
+		buffer:  make([]events.Envelope, bufferSize),
+		ids:     make(map[string]bool),
+		clients: make(map[*Subscription]bool),
+	}
+}
+
+// Receive buffers envelopes and passes them to every subscription, dropping
+// clients that fall too far behind. Events already in the buffer are
+// ignored, since the outbox may deliver an event more than once.
+func (feed *Feed) Receive(e interface{}) error {
+	envelope, ok := e.(events.Envelope)
+	if !ok {
+		return nil
+	}
+
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if feed.closed || feed.ids[envelope.ID] {
+		return nil
+	}
+
+	feed.remember(envelope)
+
+	for subscription := range feed.clients {
+		if err := subscription.Receive(envelope); err != nil {
+			feed.drop(subscription)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe registers a new client that is sent the envelopes visible
+// reports it may see. If lastEventID is in the buffer, the visible events
+// after it are returned so the client can catch up; otherwise nothing is
+// replayed.
+func (feed *Feed) Subscribe(lastEventID string, visible func(events.Envelope) bool) (*Subscription, []events.Envelope) {
+	channel := make(chan events.Envelope, clientBufferSize)
+	subscription := &Subscription{Events: channel, events: channel, visible: visible}
+
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if feed.closed {
+		subscription.close()
+		return subscription, nil
+	}
+
+	feed.clients[subscription] = true
+
+	var backlog []events.Envelope
+	for _, envelope := range feed.since(lastEventID) {
+		if visible(envelope) {
+			backlog = append(backlog, envelope)
+		}
+	}
+
+	return subscription, backlog
+}
+
+// Unsubscribe removes a client from the feed.
+func (feed *Feed) Unsubscribe(subscription *Subscription) {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	if feed.clients[subscription] {
+		feed.drop(subscription)
+	}
+}
+
+// Close ends every subscription, so that open streams finish and the server
+// can shut down.
+func (feed *Feed) Close() {
+	feed.mutex.Lock()
+	defer feed.mutex.Unlock()
+
+	feed.closed = true
+	for subscription := range feed.clients {
+		feed.drop(subscription)
+	}
+}
+
+func (feed *Feed) drop(subscription *Subscription) {
+	delete(feed.clients, subscription)
+	subscription.close()
+}
+
+func (feed *Feed) remember(envelope events.Envelope) {
+	size := len(feed.buffer)
+	if feed.count == size {
+		delete(feed.ids, feed.buffer[feed.start].ID)
+		feed.buffer[feed.start] = envelope
+		feed.start = (feed.start + 1) % size
+	} else {
+		feed.buffer[(feed.start+feed.count)%size] = envelope
+		feed.count++
+	}
+
+	feed.ids[envelope.ID] = true
+}
+
+func (feed *Feed) since(lastEventID string) (envelopes []events.Envelope) {
+	if len(lastEventID) == 0 || !feed.ids[lastEventID] {
+		return envelopes
+	}
+
+	found := false
+	for i := 0; i < feed.count; i++ {
+		envelope := feed.buffer[(feed.start+i)%len(feed.buffer)]
+		if found {
+			envelopes = append(envelopes, envelope)
+		} else if envelope.ID == lastEventID {
+			found = true
+		}
+	}
+
+	return envelopes
+}
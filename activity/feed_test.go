@@ -0,0 +1,127 @@
+package activity
+
+import (
+	"testing"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/site"
+)
+
+func newEnvelope(t *testing.T, event events.DomainEvent) events.Envelope {
+	envelope, err := events.NewEnvelope(event, nil)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+
+	return envelope
+}
+
+func everything(events.Envelope) bool {
+	return true
+}
+
+func TestFeedResumesFromLastEventID(t *testing.T) {
+	feed := NewFeed(3)
+	var sent []events.Envelope
+	for i := 0; i < 4; i++ {
+		envelope := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+		sent = append(sent, envelope)
+		feed.Receive(envelope)
+	}
+
+	_, backlog := feed.Subscribe(sent[1].ID, everything)
+	if len(backlog) != 2 || backlog[0].ID != sent[2].ID || backlog[1].ID != sent[3].ID {
+		t.Errorf("Expected the two events after the last event ID, got %+v", backlog)
+	}
+
+	if _, backlog := feed.Subscribe(sent[0].ID, everything); len(backlog) != 0 {
+		t.Errorf("Expected nothing to be replayed for an event that has left the buffer, got %d events", len(backlog))
+	}
+
+	if _, backlog := feed.Subscribe("", everything); len(backlog) != 0 {
+		t.Errorf("Expected nothing to be replayed without a last event ID, got %d events", len(backlog))
+	}
+}
+
+func TestFeedOnlySendsVisibleEvents(t *testing.T) {
+	feed := NewFeed(10)
+	visible := func(envelope events.Envelope) bool {
+		return envelope.Payload.(site.CreatedEvent).SiteID == "1"
+	}
+
+	first := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(first)
+	feed.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "2"}))
+	missed := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(missed)
+
+	subscription, backlog := feed.Subscribe(first.ID, visible)
+	if len(backlog) != 1 || backlog[0].ID != missed.ID {
+		t.Errorf("Expected only the visible missed event to be replayed, got %+v", backlog)
+	}
+
+	hidden := newEnvelope(t, site.CreatedEvent{SiteID: "2"})
+	shown := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(hidden)
+	feed.Receive(shown)
+
+	if received := <-subscription.Events; received.ID != shown.ID {
+		t.Errorf("Expected only the visible event to be sent, got %+v", received)
+	}
+}
+
+func TestFeedSendsEachEventOnce(t *testing.T) {
+	feed := NewFeed(10)
+	subscription, _ := feed.Subscribe("", everything)
+
+	envelope := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(envelope)
+	feed.Receive(envelope)
+	feed.Receive("not an envelope")
+
+	if received := <-subscription.Events; received.ID != envelope.ID {
+		t.Errorf("Expected the envelope to be sent, got %+v", received)
+	}
+
+	select {
+	case received := <-subscription.Events:
+		t.Errorf("Expected a redelivered event to be sent once, also got %+v", received)
+	default:
+	}
+}
+
+func TestFeedDropsSlowClients(t *testing.T) {
+	feed := NewFeed(10)
+	subscription, _ := feed.Subscribe("", everything)
+
+	for i := 0; i <= clientBufferSize; i++ {
+		feed.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1"}))
+	}
+
+	count := 0
+	for range subscription.Events {
+		count++
+	}
+
+	if count != clientBufferSize {
+		t.Errorf("Expected the client to be disconnected after %d queued events, received %d", clientBufferSize, count)
+	}
+}
+
+func TestFeedCloseEndsSubscriptions(t *testing.T) {
+	feed := NewFeed(10)
+	subscription, _ := feed.Subscribe("", everything)
+
+	feed.Close()
+
+	if _, ok := <-subscription.Events; ok {
+		t.Errorf("Expected the subscription to be closed")
+	}
+
+	late, _ := feed.Subscribe("", everything)
+	if _, ok := <-late.Events; ok {
+		t.Errorf("Expected subscriptions after Close to be closed")
+	}
+
+	feed.Unsubscribe(subscription)
+}
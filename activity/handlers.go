@@ -0,0 +1,120 @@
+package activity
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+// DefaultHeartbeat is how often an idle stream sends a comment line, so that
+// proxies don't close the connection and clients notice when it drops.
+const DefaultHeartbeat = 15 * time.Second
+
+func InitRoutes(router *mux.Router, formatter *render.Render, feed *Feed, authorizer *membership.Authorizer, heartbeat time.Duration) {
+	if heartbeat <= 0 {
+		heartbeat = DefaultHeartbeat
+	}
+
+	router.HandleFunc("/events/stream", streamHandler(formatter, feed, authorizer, heartbeat)).Methods("GET")
+}
+
+// streamHandler subscribes the connection to the feed, filtered to the
+// events the caller is allowed to see, and sends them as Server-Sent Events
+// until the client disconnects.
+func streamHandler(formatter *render.Render, feed *Feed, authorizer *membership.Authorizer, heartbeat time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := user.FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": "Streaming is not supported",
+			})
+			return
+		}
+
+		lastEventID := req.Header.Get("Last-Event-ID")
+		if len(lastEventID) == 0 {
+			lastEventID = req.URL.Query().Get("last_event_id")
+		}
+
+		subscription, backlog := feed.Subscribe(lastEventID, func(envelope events.Envelope) bool {
+			return canSee(authorizer, caller, envelope)
+		})
+		defer feed.Unsubscribe(subscription)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		for _, envelope := range backlog {
+			if err := writeEvent(w, envelope); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case envelope, ok := <-subscription.Events:
+				if !ok {
+					return
+				}
+
+				if err := writeEvent(w, envelope); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-ticker.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// canSee allows callers to see events about sites they can view. User
+// events such as logins and failed logins are not streamed, since site
+// membership doesn't entitle anyone to watch another member's account.
+// Membership is checked for every event, so a caller removed from a site
+// stops seeing its events straight away.
+func canSee(authorizer *membership.Authorizer, caller *user.User, envelope events.Envelope) bool {
+	if event, ok := envelope.Payload.(events.SiteEvent); ok {
+		return authorizer.Can(caller, event.EventSiteID(), membership.PermissionViewSite)
+	}
+
+	return false
+}
+
+// writeEvent sends envelope to the client. An event that can't be encoded is
+// logged and skipped; an error is only returned when the write fails, which
+// means the client has gone.
+func writeEvent(w http.ResponseWriter, envelope events.Envelope) error {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Failed to encode %s event %s for streaming: %v", envelope.Type, envelope.ID, err)
+		return nil
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", envelope.ID, envelope.Type, data)
+	return err
+}
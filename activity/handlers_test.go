@@ -0,0 +1,167 @@
+package activity
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+// newTestServer serves the stream with caller injected into every request
+// context, standing in for auth.ResolveCaller.
+func newTestServer(feed *Feed, membershipRepository membership.MembershipRepository, caller *user.User, heartbeat time.Duration) *httptest.Server {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, feed, membership.NewAuthorizer(formatter, membershipRepository, nil), heartbeat)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}))
+}
+
+// openStream connects to the stream and returns a channel of the lines it
+// sends.
+func openStream(t *testing.T, url string, lastEventID string) (*http.Response, <-chan string) {
+	req, _ := http.NewRequest("GET", url+"/events/stream", nil)
+	if len(lastEventID) != 0 {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	return res, lines
+}
+
+func nextLine(t *testing.T, lines <-chan string, prefix string) string {
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("Stream ended while waiting for %q", prefix)
+			}
+			if strings.HasPrefix(line, prefix) {
+				return line
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for %q", prefix)
+		}
+	}
+}
+
+func TestStreamSendsVisibleEvents(t *testing.T) {
+	caller := user.NewUser(1, "Site", "Viewer", "viewer@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", caller.ID, membership.RoleViewer))
+
+	feed := NewFeed(10)
+	server := newTestServer(feed, membershipRepository, caller, time.Minute)
+	defer server.Close()
+
+	res, lines := openStream(t, server.URL, "")
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %s", res.Header.Get("Content-Type"))
+	}
+
+	hidden := newEnvelope(t, site.CreatedEvent{SiteID: "2"})
+	account := newEnvelope(t, user.LoggedInEvent{UserID: caller.ID})
+	visible := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(hidden)
+	feed.Receive(account)
+	feed.Receive(visible)
+
+	if line := nextLine(t, lines, "id: "); line != "id: "+visible.ID {
+		t.Errorf("Expected only the event for the caller's site, not other sites' or user events, got %s", line)
+	}
+
+	if line := nextLine(t, lines, "event: "); line != "event: site.created" {
+		t.Errorf("Expected the event type to be sent, got %s", line)
+	}
+}
+
+func TestStreamResumesFromLastEventID(t *testing.T) {
+	caller := user.NewUser(1, "Site", "Viewer", "viewer@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", caller.ID, membership.RoleViewer))
+
+	feed := NewFeed(10)
+	first := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	missed := newEnvelope(t, site.CreatedEvent{SiteID: "1"})
+	feed.Receive(first)
+	feed.Receive(missed)
+
+	server := newTestServer(feed, membershipRepository, caller, time.Minute)
+	defer server.Close()
+
+	res, lines := openStream(t, server.URL, first.ID)
+	defer res.Body.Close()
+
+	if line := nextLine(t, lines, "id: "); line != "id: "+missed.ID {
+		t.Errorf("Expected the missed event to be replayed, got %s", line)
+	}
+}
+
+func TestStreamSendsHeartbeats(t *testing.T) {
+	caller := user.NewUser(1, "Site", "Viewer", "viewer@spearwind.io")
+	server := newTestServer(NewFeed(10), membership.NewInMemoryRepository(), caller, 10*time.Millisecond)
+	defer server.Close()
+
+	res, lines := openStream(t, server.URL, "")
+	defer res.Body.Close()
+
+	nextLine(t, lines, ": heartbeat")
+}
+
+func TestStreamEndsWhenFeedCloses(t *testing.T) {
+	caller := user.NewUser(1, "Site", "Viewer", "viewer@spearwind.io")
+	feed := NewFeed(10)
+	server := newTestServer(feed, membership.NewInMemoryRepository(), caller, time.Minute)
+	defer server.Close()
+
+	res, lines := openStream(t, server.URL, "")
+	defer res.Body.Close()
+
+	feed.Close()
+
+	select {
+	case <-waitForEnd(lines):
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the stream to end when the feed is closed")
+	}
+}
+
+func waitForEnd(lines <-chan string) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for range lines {
+		}
+		close(done)
+	}()
+	return done
+}
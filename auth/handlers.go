@@ -1,27 +1,33 @@
 package auth
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/dgrijalva/jwt-go/request"
 	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/security"
 	"github.com/spear-wind/cms/user"
 	"github.com/unrolled/render"
 )
 
-var (
-	signingKey = []byte("Rsw!MPC60$dCF$*jK%0R")
-)
-
-func InitRoutes(router *mux.Router, formatter *render.Render, userRepository user.UserRepository) {
-	router.HandleFunc("/login", loginHandler(formatter, userRepository)).Methods("POST")
+func InitRoutes(router *mux.Router, formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository RefreshTokenRepository, denylist TokenDenylist, throttle *LoginThrottle, eventPublisher events.EventPublisher) {
+	router.HandleFunc("/login", loginHandler(formatter, userRepository, refreshTokenRepository, throttle, eventPublisher)).Methods("POST")
+	router.HandleFunc("/login/mfa", mfaLoginHandler(formatter, userRepository, refreshTokenRepository, throttle, eventPublisher)).Methods("POST")
+	router.HandleFunc("/token/refresh", refreshTokenHandler(formatter, refreshTokenRepository)).Methods("POST")
+	router.HandleFunc("/logout", logoutHandler(formatter, refreshTokenRepository, denylist)).Methods("POST")
+	router.HandleFunc("/.well-known/jwks.json", jwksHandler(formatter)).Methods("GET")
 }
 
-func IsAuthorized(formatter *render.Render) negroni.HandlerFunc {
+func IsAuthorized(formatter *render.Render, denylist TokenDenylist) negroni.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
 		token, err := parseToken(req)
 
@@ -29,13 +35,48 @@ func IsAuthorized(formatter *render.Render) negroni.HandlerFunc {
 			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
 		} else if err == nil && token.Valid != true {
 			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{"Invalid Token."})
+		} else if !isAccessToken(token) {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{"Invalid Token."})
+		} else if jti, ok := tokenID(token); !ok || denylist.IsDenied(jti) {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{"Invalid Token."})
 		} else {
 			next(w, req)
 		}
 	}
 }
 
-func loginHandler(formatter *render.Render, userRepository user.UserRepository) http.HandlerFunc {
+// ResolveCaller loads the user named by the token's sub claim and stores it
+// in the request context, where handlers can retrieve it with
+// user.FromContext. It is intended to run after IsAuthorized.
+func ResolveCaller(formatter *render.Render, userRepository user.UserRepository) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		token, err := parseToken(req)
+		if err != nil || token == nil || !token.Valid {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		userID, ok := subject(token)
+		if !ok || !isAccessToken(token) {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{"Invalid Token."})
+			return
+		}
+
+		caller := userRepository.FindByID(userID)
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		next(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}
+}
+
+// loginHandler answers every failed login the same way, whether or not an
+// account has the email, so that it can't be used to find out who is
+// registered. Users with two-factor authentication on get an MFAChallenge
+// instead of tokens.
+func loginHandler(formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository RefreshTokenRepository, throttle *LoginThrottle, eventPublisher events.EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		email := req.FormValue("email")
 		password := req.FormValue("password")
@@ -45,61 +86,309 @@ func loginHandler(formatter *render.Render, userRepository user.UserRepository)
 			return
 		}
 
-		user := userRepository.FindByEmail(email)
-		if user == nil {
-			formatter.Text(w, http.StatusNotFound, "User Not Found")
+		ip := throttle.ClientIP(req)
+
+		wait, err := throttle.Attempt(email, ip)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		if wait > 0 {
+			fmt.Printf("Security: throttled login for %s from %s\n", email, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			formatter.Text(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
 			return
 		}
 
-		success, newHash := user.Authenticate(password)
+		account := userRepository.FindByEmail(email)
+
+		success, rehashed := false, false
+		if account != nil {
+			success, rehashed = account.Authenticate(password)
+		} else {
+			user.VerifyDummy(password)
+		}
+
 		if success != true {
+			locked, err := throttle.Failed(email, ip)
+			if err != nil {
+				fmt.Printf("Failed to check the failed logins for %s from %s: %v\n", email, ip, err)
+			}
+
+			event := user.NewLoginFailedEvent(account, email, ip, locked)
+			fmt.Printf("Security: failed login for %s from %s (%s, locked: %v)\n", email, ip, event.Reason, locked)
+			events.Publish(eventPublisher, nil, event)
+
+			formatter.Text(w, http.StatusUnauthorized, "Unauthorized.")
+			return
+		}
+
+		// The old hash still works, so a failure to save the upgraded one
+		// shouldn't stop the user logging in; it is retried next login.
+		if rehashed {
+			if err := userRepository.Update(account); err != nil {
+				fmt.Printf("Failed to save the upgraded password hash of user %d: %v\n", account.ID, err)
+			}
+		}
+
+		// Failures are only cleared once the second factor is entered too,
+		// so that the password can't be used to reset the count between
+		// guesses at codes.
+		if account.MFAEnabled() {
+			if err := throttle.Release(email, ip); err != nil {
+				fmt.Printf("Failed to release the login for %s from %s: %v\n", email, ip, err)
+			}
+
+			challenge, err := IssueMFAChallenge(account.ID, user.LoginMethodPassword)
+			if err != nil {
+				formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+				return
+			}
+
+			formatter.JSON(w, http.StatusOK, challenge)
+			return
+		}
+
+		if err := throttle.Succeeded(email, ip); err != nil {
+			fmt.Printf("Failed to clear failed logins for %s: %v\n", email, err)
+		}
+
+		tokens, err := IssueTokens(refreshTokenRepository, account.ID)
+		if err != nil {
+			formatter.JSON(w, http.StatusOK, struct{ Message string }{err.Error()})
+			return
+		}
+
+		events.Publish(eventPublisher, account.Actor(), user.NewLoggedInEvent(account, user.LoginMethodPassword))
+
+		formatter.JSON(w, http.StatusOK, tokens)
+	}
+}
+
+// mfaLoginHandler completes a login that was answered with an MFAChallenge,
+// given the challenge's MFA token and a TOTP or recovery code. Wrong codes
+// count towards the same limits as wrong passwords.
+func mfaLoginHandler(formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository RefreshTokenRepository, throttle *LoginThrottle, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		mfaToken := req.FormValue("mfa_token")
+		code := req.FormValue("code")
+
+		if mfaToken == "" || code == "" {
+			formatter.Text(w, http.StatusBadRequest, "MFA token and code are required")
+			return
+		}
+
+		userID, method, err := parseMFAChallenge(mfaToken)
+		if err != nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{err.Error()})
+			return
+		}
+
+		account := userRepository.FindByID(userID)
+		if account == nil || !account.MFAEnabled() {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{ErrInvalidMFAChallenge.Error()})
+			return
+		}
+
+		ip := throttle.ClientIP(req)
+
+		wait, err := throttle.Attempt(account.Email, ip)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		if wait > 0 {
+			fmt.Printf("Security: throttled two-factor login for %s from %s\n", account.Email, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			formatter.Text(w, http.StatusTooManyRequests, "Too many failed login attempts. Try again later.")
+			return
+		}
+
+		if err := account.VerifyMFA(code); err != nil {
+			locked, err := throttle.Failed(account.Email, ip)
+			if err != nil {
+				fmt.Printf("Failed to check the failed logins for %s from %s: %v\n", account.Email, ip, err)
+			}
+
+			event := user.NewMFAFailedEvent(account, ip, locked)
+			fmt.Printf("Security: failed login for %s from %s (%s, locked: %v)\n", account.Email, ip, event.Reason, locked)
+			events.Publish(eventPublisher, nil, event)
+
 			formatter.Text(w, http.StatusUnauthorized, "Unauthorized.")
 			return
 		}
 
-		if newHash {
-			fmt.Println("Call to user.Authenticate resulted in newHash == true; we need to update this in the DB or next auth attempt will fail")
+		// The code is used up once saved; issuing tokens without saving
+		// would let it be replayed.
+		if err := userRepository.Update(account); err != nil {
+			if err := throttle.Release(account.Email, ip); err != nil {
+				fmt.Printf("Failed to release the login for %s from %s: %v\n", account.Email, ip, err)
+			}
+
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		if err := throttle.Succeeded(account.Email, ip); err != nil {
+			fmt.Printf("Failed to clear failed logins for %s: %v\n", account.Email, err)
 		}
 
-		tokenString, err := GenerateToken(user.ID)
+		tokens, err := IssueTokens(refreshTokenRepository, account.ID)
 		if err != nil {
 			formatter.JSON(w, http.StatusOK, struct{ Message string }{err.Error()})
 			return
 		}
 
-		formatter.JSON(w, http.StatusOK, struct{ Token string }{tokenString})
+		events.Publish(eventPublisher, account.Actor(), user.NewLoggedInEvent(account, method))
+
+		formatter.JSON(w, http.StatusOK, tokens)
+	}
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func refreshTokenHandler(formatter *render.Render, refreshTokenRepository RefreshTokenRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+		var refreshRequest refreshTokenRequest
+
+		if err := json.Unmarshal(payload, &refreshRequest); err != nil || len(refreshRequest.RefreshToken) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A refresh_token is required")
+			return
+		}
+
+		tokens, err := RefreshTokens(refreshTokenRepository, refreshRequest.RefreshToken)
+		if err == ErrInvalidRefreshToken || err == ErrRefreshTokenReused {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Message string }{err.Error()})
+			return
+		} else if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, tokens)
 	}
 }
 
+// logoutHandler revokes the refresh token family in the request body and,
+// when the request carries a valid access token, denylists that token until
+// it expires.
+func logoutHandler(formatter *render.Render, refreshTokenRepository RefreshTokenRepository, denylist TokenDenylist) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+		var logoutRequest refreshTokenRequest
+
+		if err := json.Unmarshal(payload, &logoutRequest); err != nil || len(logoutRequest.RefreshToken) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A refresh_token is required")
+			return
+		}
+
+		err := RevokeRefreshToken(refreshTokenRepository, logoutRequest.RefreshToken)
+		if err != nil && err != ErrInvalidRefreshToken {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		if token, err := parseToken(req); err == nil && token.Valid {
+			if jti, ok := tokenID(token); ok {
+				if err := denylist.Deny(jti, expiry(token)); err != nil {
+					formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+					return
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// jwksHandler publishes the public signing keys so other services can verify
+// access tokens without sharing a secret.
+func jwksHandler(formatter *render.Render) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		keys := signingKeys().JWKS()
+		if keys == nil {
+			keys = []JWK{}
+		}
+
+		formatter.JSON(w, http.StatusOK, struct {
+			Keys []JWK `json:"keys"`
+		}{keys})
+	}
+}
+
+// GenerateToken returns a signed access token for the user. Access tokens
+// are short-lived; clients renew them with a refresh token from IssueTokens.
 func GenerateToken(userID int64) (string, error) {
+	jti, err := security.GenerateRandomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	keys := signingKeys()
+
 	// Create the token
-	token := jwt.New(jwt.SigningMethodHS256)
+	token := jwt.New(keys.current.Method)
 
 	claims := token.Claims.(jwt.MapClaims)
 
 	claims["iat"] = time.Now().Unix()
-	claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
+	claims["exp"] = time.Now().Add(AccessTokenTTL).Unix()
 	claims["sub"] = userID
 	claims["iss"] = "https://cms.spearwind.io"
 	claims["aud"] = "TODO"
+	claims["jti"] = jti
 	// claims["nbf"] = ""
-	// claims["jti"] = ""
 
 	// Sign and get the complete encoded token as a string
-	tokenString, err := token.SignedString(signingKey)
+	tokenString, err := keys.sign(token)
 
 	return tokenString, err
 }
 
 func parseToken(req *http.Request) (*jwt.Token, error) {
-	token, err := request.ParseFromRequest(req, request.OAuth2Extractor, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		return signingKey, nil
-	})
+	token, err := request.ParseFromRequest(req, request.OAuth2Extractor, signingKeys().verificationKey)
 
 	return token, err
 }
+
+func subject(token *jwt.Token) (int64, bool) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+
+	switch sub := claims["sub"].(type) {
+	case float64:
+		return int64(sub), true
+	case string:
+		userID, err := strconv.ParseInt(sub, 10, 64)
+		return userID, err == nil
+	}
+
+	return 0, false
+}
+
+func tokenID(token *jwt.Token) (string, bool) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", false
+	}
+
+	jti, ok := claims["jti"].(string)
+	return jti, ok && len(jti) != 0
+}
+
+func expiry(token *jwt.Token) time.Time {
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0)
+		}
+	}
+
+	return time.Now().Add(AccessTokenTTL)
+}
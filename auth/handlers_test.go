@@ -1,13 +1,18 @@
 package auth
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/security"
 	"github.com/spear-wind/cms/user"
 	"github.com/unrolled/render"
 )
@@ -21,7 +26,7 @@ var (
 func TestLoginHandlerResposnseToInvalidData(t *testing.T) {
 	client := &http.Client{}
 	userRepository := user.NewInMemoryRepository()
-	server := httptest.NewServer(http.HandlerFunc(loginHandler(formatter, userRepository)))
+	server := httptest.NewServer(http.HandlerFunc(loginHandler(formatter, userRepository, NewInMemoryRefreshTokenRepository(), NewLoginThrottle(NewInMemoryLoginAttemptStore(), ThrottleOptions{}), events.NewSynchEventPublisher())))
 
 	form := url.Values{}
 	form.Add("foo", "asdf")
@@ -66,7 +71,7 @@ func TestLoginHandlerHappyPath(t *testing.T) {
 
 	userRepository.Add(&user)
 
-	server := httptest.NewServer(http.HandlerFunc(loginHandler(formatter, userRepository)))
+	server := httptest.NewServer(http.HandlerFunc(loginHandler(formatter, userRepository, NewInMemoryRefreshTokenRepository(), NewLoginThrottle(NewInMemoryLoginAttemptStore(), ThrottleOptions{}), events.NewSynchEventPublisher())))
 
 	form := url.Values{}
 	form.Add("email", "test@spearwind.io")
@@ -94,3 +99,175 @@ func TestLoginHandlerHappyPath(t *testing.T) {
 		t.Errorf("Successful login should result in http.StatusOK; \nstatus code: %v\nresponse body: %s", res.StatusCode, payload)
 	}
 }
+
+func TestResolveCallerStoresTokenSubjectInContext(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	caller := user.NewUser(-1, "Adam", "Spearwind", "test@spearwind.io")
+	userRepository.Add(caller)
+
+	tokenString, err := GenerateToken(caller.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error generating token: %v", err)
+	}
+
+	var resolved *user.User
+	middleware := ResolveCaller(formatter, userRepository)
+
+	req := httptest.NewRequest("GET", "/site", nil)
+	req.Header.Add("Authorization", "Bearer "+tokenString)
+	res := httptest.NewRecorder()
+
+	middleware(res, req, func(w http.ResponseWriter, req *http.Request) {
+		resolved = user.FromContext(req.Context())
+	})
+
+	if resolved == nil || resolved.ID != caller.ID {
+		t.Errorf("Expected the caller to be resolved from the token, got %v", resolved)
+	}
+}
+
+func TestResolveCallerRejectsUnknownSubject(t *testing.T) {
+	tokenString, _ := GenerateToken(42)
+	middleware := ResolveCaller(formatter, user.NewInMemoryRepository())
+
+	req := httptest.NewRequest("GET", "/site", nil)
+	req.Header.Add("Authorization", "Bearer "+tokenString)
+	res := httptest.NewRecorder()
+
+	middleware(res, req, func(w http.ResponseWriter, req *http.Request) {
+		t.Error("next should not be called for an unknown user")
+	})
+
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected response status 401, received %d", res.Code)
+	}
+}
+
+func TestLogoutRevokesRefreshTokenAndAccessToken(t *testing.T) {
+	refreshTokenRepository := NewInMemoryRefreshTokenRepository()
+	denylist := NewInMemoryTokenDenylist()
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, user.NewInMemoryRepository(), refreshTokenRepository, denylist, NewLoginThrottle(NewInMemoryLoginAttemptStore(), ThrottleOptions{}), events.NewSynchEventPublisher())
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	tokens, _ := IssueTokens(refreshTokenRepository, 1)
+
+	req, _ := http.NewRequest("POST", server.URL+"/logout", strings.NewReader(`{"refresh_token":"`+tokens.RefreshToken+`"}`))
+	req.Header.Add("Authorization", "Bearer "+tokens.Token)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to logoutHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected response status 204, received %s", res.Status)
+	}
+
+	res, err = http.Post(server.URL+"/token/refresh", "application/json", strings.NewReader(`{"refresh_token":"`+tokens.RefreshToken+`"}`))
+	if err != nil {
+		t.Fatalf("Error in POST to refreshTokenHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a logged out refresh token to be rejected, received %s", res.Status)
+	}
+
+	protected := httptest.NewRequest("GET", "/site", nil)
+	protected.Header.Add("Authorization", "Bearer "+tokens.Token)
+	recorder := httptest.NewRecorder()
+
+	IsAuthorized(formatter, denylist)(recorder, protected, func(w http.ResponseWriter, req *http.Request) {
+		t.Error("next should not be called for a revoked access token")
+	})
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked access token to be rejected, received %d", recorder.Code)
+	}
+}
+
+func TestLoginHandlerFailsTheSameWayForUnknownUsers(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	account := &user.User{FirstName: "Adam", LastName: "Spearwind", Email: "test@spearwind.io", Password: "abc123"}
+	account.Register()
+	userRepository.Add(account)
+
+	handler := loginHandler(formatter, userRepository, NewInMemoryRefreshTokenRepository(), NewLoginThrottle(NewInMemoryLoginAttemptStore(), ThrottleOptions{}), events.NewSynchEventPublisher())
+
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}, "password": {password}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		handler(res, req)
+		return res
+	}
+
+	wrongPassword := login("test@spearwind.io", "wrong")
+	unknownUser := login("nobody@spearwind.io", "wrong")
+
+	if wrongPassword.Code != http.StatusUnauthorized || unknownUser.Code != http.StatusUnauthorized {
+		t.Errorf("Expected both failures to be 401, received %d and %d", wrongPassword.Code, unknownUser.Code)
+	}
+
+	if wrongPassword.Body.String() != unknownUser.Body.String() {
+		t.Errorf("Expected the same response body, received %q and %q", wrongPassword.Body, unknownUser.Body)
+	}
+
+	retry := login("test@spearwind.io", "abc123")
+	if retry.Code != http.StatusTooManyRequests || len(retry.Header().Get("Retry-After")) == 0 {
+		t.Errorf("Expected an immediate retry to be throttled, received %d", retry.Code)
+	}
+}
+
+func TestLoginWithMFARequiresACode(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	account := &user.User{FirstName: "Adam", LastName: "Spearwind", Email: "test@spearwind.io", Password: "abc123"}
+	account.Register()
+	secret, _, _ := account.StartTOTPEnrollment()
+	previous, _ := security.TOTPCode(secret, time.Now().Add(-security.TOTPPeriod))
+	if _, err := account.ConfirmTOTP(previous); err != nil {
+		t.Fatalf("Failed to enable MFA: %v", err)
+	}
+	userRepository.Add(account)
+
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, userRepository, NewInMemoryRefreshTokenRepository(), NewInMemoryTokenDenylist(), NewLoginThrottle(NewInMemoryLoginAttemptStore(), ThrottleOptions{}), events.NewSynchEventPublisher())
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	res := post("/login", url.Values{"email": {"test@spearwind.io"}, "password": {"abc123"}})
+
+	var challenge MFAChallenge
+	if err := json.Unmarshal(res.Body.Bytes(), &challenge); err != nil || !challenge.MFARequired || len(challenge.MFAToken) == 0 {
+		t.Fatalf("Expected an MFA challenge, received %d: %s", res.Code, res.Body)
+	}
+
+	protected := httptest.NewRequest("GET", "/site", nil)
+	protected.Header.Add("Authorization", "Bearer "+challenge.MFAToken)
+	recorder := httptest.NewRecorder()
+
+	IsAuthorized(formatter, NewInMemoryTokenDenylist())(recorder, protected, func(w http.ResponseWriter, req *http.Request) {
+		t.Error("next should not be called for an MFA token")
+	})
+
+	code, _ := security.TOTPCode(secret, time.Now())
+	res = post("/login/mfa", url.Values{"mfa_token": {challenge.MFAToken}, "code": {code}})
+
+	var tokens TokenPair
+	if err := json.Unmarshal(res.Body.Bytes(), &tokens); err != nil || res.Code != http.StatusOK || len(tokens.Token) == 0 {
+		t.Fatalf("Expected tokens for a valid code, received %d: %s", res.Code, res.Body)
+	}
+
+	if res = post("/login/mfa", url.Values{"mfa_token": {challenge.MFAToken}, "code": {code}}); res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used code to be rejected, received %d", res.Code)
+	}
+}
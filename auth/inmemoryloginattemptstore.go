@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+type loginAttemptEntry struct {
+	attempts LoginAttempts
+	expires  time.Time
+}
+
+type inMemoryLoginAttemptStore struct {
+	mutex   sync.Mutex
+	entries map[string]loginAttemptEntry
+	swept   time.Time
+}
+
+// NewInMemoryLoginAttemptStore returns a LoginAttemptStore that only
+// throttles logins handled by this server.
+func NewInMemoryLoginAttemptStore() *inMemoryLoginAttemptStore {
+	store := &inMemoryLoginAttemptStore{}
+	store.entries = make(map[string]loginAttemptEntry)
+	return store
+}
+
+func (store *inMemoryLoginAttemptStore) Get(key string) (attempts LoginAttempts, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return attempts, nil
+	}
+
+	return entry.attempts, nil
+}
+
+func (store *inMemoryLoginAttemptStore) Reserve(key string, at time.Time, window time.Duration, allowed func(attempts LoginAttempts) bool) (attempts LoginAttempts, reserved bool, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Drop expired entries now and then, so that a stream of guesses at
+	// random emails doesn't grow the map without bound.
+	if now := time.Now(); now.Sub(store.swept) > time.Minute {
+		for id, entry := range store.entries {
+			if now.After(entry.expires) {
+				delete(store.entries, id)
+			}
+		}
+		store.swept = now
+	}
+
+	entry := store.entries[key]
+	if at.After(entry.expires) {
+		entry = loginAttemptEntry{}
+	}
+
+	if !allowed(entry.attempts) {
+		return entry.attempts, false, nil
+	}
+
+	attempts = entry.attempts
+	entry.attempts.Failures++
+	entry.attempts.Last = at
+	entry.expires = at.Add(window)
+	store.entries[key] = entry
+
+	return attempts, true, nil
+}
+
+func (store *inMemoryLoginAttemptStore) Release(key string) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entry, ok := store.entries[key]
+	if !ok {
+		return err
+	}
+
+	if entry.attempts.Failures--; entry.attempts.Failures <= 0 {
+		delete(store.entries, key)
+	} else {
+		store.entries[key] = entry
+	}
+
+	return err
+}
+
+func (store *inMemoryLoginAttemptStore) Reset(key string) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.entries, key)
+	return err
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"sync"
+)
+
+type inMemoryRefreshTokenRepository struct {
+	mutex  sync.Mutex
+	tokens map[string]RefreshToken
+}
+
+func NewInMemoryRefreshTokenRepository() *inMemoryRefreshTokenRepository {
+	repo := &inMemoryRefreshTokenRepository{}
+	repo.tokens = make(map[string]RefreshToken)
+	return repo
+}
+
+func (repo *inMemoryRefreshTokenRepository) Add(token *RefreshToken) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.tokens[token.ID]; ok {
+		return errors.New("Refresh token already exists")
+	}
+
+	repo.tokens[token.ID] = *token
+	return err
+}
+
+func (repo *inMemoryRefreshTokenRepository) MarkUsed(id string) (marked bool, err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	token, ok := repo.tokens[id]
+	if !ok {
+		return false, errors.New("Could not find refresh token in repository")
+	}
+
+	if token.Used {
+		return false, nil
+	}
+
+	token.Used = true
+	repo.tokens[id] = token
+	return true, nil
+}
+
+func (repo *inMemoryRefreshTokenRepository) Get(id string) (*RefreshToken, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if token, ok := repo.tokens[id]; ok {
+		return &token, nil
+	}
+
+	return nil, errors.New("Could not find refresh token in repository")
+}
+
+func (repo *inMemoryRefreshTokenRepository) RevokeFamily(familyID string) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for id, token := range repo.tokens {
+		if token.FamilyID == familyID {
+			token.Revoked = true
+			repo.tokens[id] = token
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+type inMemoryTokenDenylist struct {
+	mutex  sync.Mutex
+	denied map[string]time.Time
+}
+
+func NewInMemoryTokenDenylist() *inMemoryTokenDenylist {
+	denylist := &inMemoryTokenDenylist{}
+	denylist.denied = make(map[string]time.Time)
+	return denylist
+}
+
+func (denylist *inMemoryTokenDenylist) Deny(jti string, expires time.Time) (err error) {
+	denylist.mutex.Lock()
+	defer denylist.mutex.Unlock()
+
+	// Entries are only needed until the token would have expired anyway.
+	now := time.Now()
+	for id, until := range denylist.denied {
+		if now.After(until) {
+			delete(denylist.denied, id)
+		}
+	}
+
+	denylist.denied[jti] = expires
+	return err
+}
+
+func (denylist *inMemoryTokenDenylist) IsDenied(jti string) bool {
+	denylist.mutex.Lock()
+	defer denylist.mutex.Unlock()
+
+	_, ok := denylist.denied[jti]
+	return ok
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	keySet      *KeySet
+	keySetMutex sync.Mutex
+)
+
+// SigningKey is a key access tokens are signed or verified with, identified
+// in token headers by its kid. Keys loaded from a public key can only verify.
+type SigningKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// KeySet holds every key that access tokens may be verified with and the
+// one new tokens are signed with. Rotating a key means adding a new key,
+// making it current, and removing the old one once its tokens have expired.
+type KeySet struct {
+	current *SigningKey
+	keys    map[string]*SigningKey
+}
+
+// JWK is the public half of a signing key in JSON Web Key format.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+func NewHMACSigningKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+func NewRSASigningKey(id string, key *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}
+}
+
+func NewECDSASigningKey(id string, key *ecdsa.PrivateKey) (*SigningKey, error) {
+	method, err := ecdsaMethod(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{ID: id, Method: method, signKey: key, verifyKey: &key.PublicKey}, nil
+}
+
+// ParsePEMSigningKey reads an RSA or EC private key, or a public key that can
+// only be used to verify tokens signed before it was retired.
+func ParsePEMSigningKey(id string, pem []byte) (*SigningKey, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return NewRSASigningKey(id, key), nil
+	}
+
+	if key, err := jwt.ParseECPrivateKeyFromPEM(pem); err == nil {
+		return NewECDSASigningKey(id, key)
+	}
+
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, verifyKey: key}, nil
+	}
+
+	if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+		method, err := ecdsaMethod(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return &SigningKey{ID: id, Method: method, verifyKey: key}, nil
+	}
+
+	return nil, fmt.Errorf("Key %s is not a PEM encoded RSA or EC key", id)
+}
+
+func ecdsaMethod(key *ecdsa.PublicKey) (jwt.SigningMethod, error) {
+	switch key.Curve.Params().Name {
+	case elliptic.P256().Params().Name:
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384().Params().Name:
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521().Params().Name:
+		return jwt.SigningMethodES512, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported elliptic curve %s", key.Curve.Params().Name)
+}
+
+func NewKeySet(currentID string, keys ...*SigningKey) (*KeySet, error) {
+	ks := &KeySet{keys: make(map[string]*SigningKey)}
+
+	for _, key := range keys {
+		if _, ok := ks.keys[key.ID]; ok {
+			return nil, fmt.Errorf("Duplicate signing key %s", key.ID)
+		}
+
+		ks.keys[key.ID] = key
+	}
+
+	current, ok := ks.keys[currentID]
+	if !ok {
+		return nil, fmt.Errorf("Current signing key %s was not found", currentID)
+	}
+
+	if current.signKey == nil {
+		return nil, fmt.Errorf("Current signing key %s is a public key and cannot sign tokens", currentID)
+	}
+
+	ks.current = current
+	return ks, nil
+}
+
+// LoadKeySet reads every key in dir. Files named <kid>.pem hold RSA or EC
+// keys and files named <kid>.secret hold HS256 secrets. HS256 keys are not
+// published in the JWKS, so only this service can verify tokens they sign.
+func LoadKeySet(dir string, currentID string) (*KeySet, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []*SigningKey
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+
+		ext := filepath.Ext(file.Name())
+		id := strings.TrimSuffix(file.Name(), ext)
+		if ext != ".pem" && ext != ".secret" {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if ext == ".secret" {
+			keys = append(keys, NewHMACSigningKey(id, []byte(strings.TrimSpace(string(contents)))))
+			continue
+		}
+
+		key, err := ParsePEMSigningKey(id, contents)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return NewKeySet(currentID, keys...)
+}
+
+// NewEphemeralKeySet generates a single RS256 key that only lives as long as
+// the process. It is meant for local development and tests.
+func NewEphemeralKeySet() (*KeySet, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeySet("ephemeral", NewRSASigningKey("ephemeral", key))
+}
+
+// UseKeySet sets the keys used to sign and verify access tokens.
+func UseKeySet(ks *KeySet) {
+	keySetMutex.Lock()
+	defer keySetMutex.Unlock()
+
+	keySet = ks
+}
+
+func signingKeys() *KeySet {
+	keySetMutex.Lock()
+	defer keySetMutex.Unlock()
+
+	if keySet == nil {
+		ks, err := NewEphemeralKeySet()
+		if err != nil {
+			panic(fmt.Sprintf("Failed to generate an ephemeral signing key: %v", err))
+		}
+
+		keySet = ks
+	}
+
+	return keySet
+}
+
+// sign signs a token created with the current key's method.
+func (ks *KeySet) sign(token *jwt.Token) (string, error) {
+	token.Header["kid"] = ks.current.ID
+
+	return token.SignedString(ks.current.signKey)
+}
+
+// verificationKey is a jwt.Keyfunc that selects the key by the token's kid
+// and only accepts the algorithm that key was configured with.
+func (ks *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", token.Header["kid"])
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.verifyKey, nil
+}
+
+// JWKS returns the public keys in the set, ordered by kid. Symmetric keys
+// are left out.
+func (ks *KeySet) JWKS() (jwks []JWK) {
+	ids := make([]string, 0, len(ks.keys))
+	for id := range ks.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		key := ks.keys[id]
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeJWKInt(public.N, 0)
+			jwk.E = encodeJWKInt(big.NewInt(int64(public.E)), 0)
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = public.Curve.Params().Name
+			jwk.X = encodeJWKInt(public.X, size)
+			jwk.Y = encodeJWKInt(public.Y, size)
+		default:
+			continue
+		}
+
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
+
+// encodeJWKInt base64url encodes i big-endian, left padded to size bytes.
+func encodeJWKInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
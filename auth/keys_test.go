@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func withKeySet(t *testing.T, ks *KeySet) {
+	previous := signingKeys()
+	UseKeySet(ks)
+	t.Cleanup(func() { UseKeySet(previous) })
+}
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	return key
+}
+
+func newECKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	return key
+}
+
+func parseTokenString(tokenString string) (*jwt.Token, error) {
+	req := httptest.NewRequest("GET", "/site", nil)
+	req.Header.Add("Authorization", "Bearer "+tokenString)
+	return parseToken(req)
+}
+
+func TestTokensRoundTripForEachAlgorithm(t *testing.T) {
+	ecKey, _ := NewECDSASigningKey("ec", newECKey(t))
+	keys := []*SigningKey{
+		NewHMACSigningKey("hmac", []byte("secret")),
+		NewRSASigningKey("rsa", newRSAKey(t)),
+		ecKey,
+	}
+
+	for _, key := range keys {
+		ks, err := NewKeySet(key.ID, keys...)
+		if err != nil {
+			t.Fatalf("NewKeySet returned an unexpected error: %v", err)
+		}
+		withKeySet(t, ks)
+
+		tokenString, err := GenerateToken(1)
+		if err != nil {
+			t.Fatalf("GenerateToken with %s returned an unexpected error: %v", key.Method.Alg(), err)
+		}
+
+		token, err := parseTokenString(tokenString)
+		if err != nil || !token.Valid {
+			t.Errorf("Expected a %s token to verify, got %v", key.Method.Alg(), err)
+			continue
+		}
+
+		if token.Header["kid"] != key.ID || token.Method.Alg() != key.Method.Alg() {
+			t.Errorf("Expected kid %s and alg %s, got %v", key.ID, key.Method.Alg(), token.Header)
+		}
+	}
+}
+
+func TestRotatedKeysStillVerifyEarlierTokens(t *testing.T) {
+	oldKey := NewRSASigningKey("2016-01", newRSAKey(t))
+	newKey := NewRSASigningKey("2016-02", newRSAKey(t))
+
+	before, _ := NewKeySet(oldKey.ID, oldKey)
+	withKeySet(t, before)
+	tokenString, _ := GenerateToken(1)
+
+	after, _ := NewKeySet(newKey.ID, oldKey, newKey)
+	UseKeySet(after)
+
+	if token, err := parseTokenString(tokenString); err != nil || !token.Valid {
+		t.Errorf("Expected a token signed with a retired key to verify, got %v", err)
+	}
+
+	retired, _ := NewKeySet(newKey.ID, newKey)
+	UseKeySet(retired)
+
+	if _, err := parseTokenString(tokenString); err == nil {
+		t.Error("Expected a token signed with a removed key to be rejected")
+	}
+}
+
+func TestVerificationRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ks, _ := NewKeySet("rsa", NewRSASigningKey("rsa", rsaKey))
+	withKeySet(t, ks)
+
+	// An attacker signs with HS256 using the published RSA public key.
+	publicKey, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": 1, "jti": "forged"})
+	forged.Header["kid"] = "rsa"
+	tokenString, _ := forged.SignedString(publicKey)
+
+	if _, err := parseTokenString(tokenString); err == nil {
+		t.Error("Expected an HS256 token to be rejected for an RS256 key")
+	}
+}
+
+func TestJWKSPublishesOnlyPublicKeys(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey, _ := NewECDSASigningKey("ec", newECKey(t))
+	ks, _ := NewKeySet("rsa", NewRSASigningKey("rsa", rsaKey), ecKey, NewHMACSigningKey("hmac", []byte("secret")))
+
+	jwks := ks.JWKS()
+	if len(jwks) != 2 {
+		t.Fatalf("Expected two public keys, got %+v", jwks)
+	}
+
+	if jwks[0].KeyID != "ec" || jwks[0].Curve != "P-256" || len(jwks[0].X) != 43 || len(jwks[0].Y) != 43 {
+		t.Errorf("Unexpected EC JWK: %+v", jwks[0])
+	}
+
+	if jwks[1].KeyID != "rsa" || jwks[1].Algorithm != "RS256" || jwks[1].E != "AQAB" {
+		t.Errorf("Unexpected RSA JWK: %+v", jwks[1])
+	}
+}
+
+func TestLoadKeySet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey := newRSAKey(t)
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	ecBytes, _ := x509.MarshalECPrivateKey(newECKey(t))
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecBytes})
+	retiredBytes, _ := x509.MarshalPKIXPublicKey(&newRSAKey(t).PublicKey)
+	retiredPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: retiredBytes})
+
+	ioutil.WriteFile(filepath.Join(dir, "current.pem"), rsaPEM, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "ec.pem"), ecPEM, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "retired.pem"), retiredPEM, 0600)
+	ioutil.WriteFile(filepath.Join(dir, "internal.secret"), []byte("secret\n"), 0600)
+
+	ks, err := LoadKeySet(dir, "current")
+	if err != nil {
+		t.Fatalf("LoadKeySet returned an unexpected error: %v", err)
+	}
+
+	if len(ks.keys) != 4 || ks.current.Method != jwt.SigningMethodRS256 {
+		t.Errorf("Expected four keys with an RS256 current key, got %d keys, current %s", len(ks.keys), ks.current.Method.Alg())
+	}
+
+	if ks.keys["ec"].Method != jwt.SigningMethodES256 {
+		t.Errorf("Expected the EC key to sign with ES256, got %s", ks.keys["ec"].Method.Alg())
+	}
+
+	if _, err := LoadKeySet(dir, "retired"); err == nil {
+		t.Error("Expected a public key to be rejected as the current signing key")
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/spear-wind/cms/security"
+)
+
+// MFAChallengeTTL is how long a user has to enter a two-factor code after
+// the first step of logging in.
+const MFAChallengeTTL = 5 * time.Minute
+
+// mfaChallengeUse marks challenge tokens in their token_use claim, so that
+// they are never accepted as access tokens.
+const mfaChallengeUse = "mfa_challenge"
+
+var ErrInvalidMFAChallenge = errors.New("Invalid or expired MFA token; please log in again")
+
+// MFAChallenge is returned by the login endpoints instead of a TokenPair when
+// the user has two-factor authentication on. The MFAToken is exchanged,
+// together with a code, for a TokenPair at POST /login/mfa.
+type MFAChallenge struct {
+	MFARequired bool
+	MFAToken    string
+	ExpiresIn   int64
+}
+
+// IssueMFAChallenge returns a challenge for a user who has proved who they
+// are with method, e.g. user.LoginMethodPassword, and still has to enter a
+// code.
+func IssueMFAChallenge(userID int64, method string) (challenge MFAChallenge, err error) {
+	jti, err := security.GenerateRandomString(16)
+	if err != nil {
+		return challenge, err
+	}
+
+	keys := signingKeys()
+	token := jwt.New(keys.current.Method)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims["iat"] = time.Now().Unix()
+	claims["exp"] = time.Now().Add(MFAChallengeTTL).Unix()
+	claims["sub"] = userID
+	claims["iss"] = "https://cms.spearwind.io"
+	claims["jti"] = jti
+	claims["token_use"] = mfaChallengeUse
+	claims["login_method"] = method
+
+	tokenString, err := keys.sign(token)
+	if err != nil {
+		return challenge, err
+	}
+
+	return MFAChallenge{
+		MFARequired: true,
+		MFAToken:    tokenString,
+		ExpiresIn:   int64(MFAChallengeTTL / time.Second),
+	}, nil
+}
+
+// parseMFAChallenge returns the user and login method of an MFA token issued
+// by IssueMFAChallenge.
+func parseMFAChallenge(tokenString string) (userID int64, method string, err error) {
+	token, err := jwt.Parse(tokenString, signingKeys().verificationKey)
+	if err != nil || !token.Valid || tokenUse(token) != mfaChallengeUse {
+		return 0, "", ErrInvalidMFAChallenge
+	}
+
+	userID, ok := subject(token)
+	if !ok {
+		return 0, "", ErrInvalidMFAChallenge
+	}
+
+	method, _ = token.Claims.(jwt.MapClaims)["login_method"].(string)
+	return userID, method, nil
+}
+
+// isAccessToken reports whether token was issued by GenerateToken, rather
+// than being some other token signed with the same keys.
+func isAccessToken(token *jwt.Token) bool {
+	return len(tokenUse(token)) == 0
+}
+
+func tokenUse(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+
+	use, _ := claims["token_use"].(string)
+	return use
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoRefreshTokenRepository struct {
+	Collection cfmgo.Collection
+}
+
+type refreshTokenRecord struct {
+	ID       string    `bson:"_id" json:"id"`
+	FamilyID string    `bson:"family_id" json:"family_id"`
+	UserID   int64     `bson:"user_id" json:"user_id"`
+	Created  time.Time `bson:"date_created" json:"date_created"`
+	Expires  time.Time `bson:"expires" json:"expires"`
+	Used     bool      `bson:"used" json:"used"`
+	Revoked  bool      `bson:"revoked" json:"revoked"`
+}
+
+func NewMongoRefreshTokenRepository(col cfmgo.Collection) *mongoRefreshTokenRepository {
+	return &mongoRefreshTokenRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoRefreshTokenRepository) Add(token *RefreshToken) (err error) {
+	repo.Collection.Wake()
+	if _, err := repo.Get(token.ID); err == nil {
+		return errors.New("Refresh token already exists")
+	}
+
+	record := refreshTokenRecord(*token)
+	_, err = repo.Collection.UpsertID(record.ID, record)
+	return
+}
+
+// MarkUsed only matches the token while it is unused, so of two concurrent
+// exchanges only one can update it.
+func (repo *mongoRefreshTokenRepository) MarkUsed(id string) (marked bool, err error) {
+	repo.Collection.Wake()
+	var record refreshTokenRecord
+	_, err = repo.Collection.FindAndModify(bson.M{"_id": id, "used": false}, bson.M{"$set": bson.M{"used": true}}, &record)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (repo *mongoRefreshTokenRepository) Get(id string) (token *RefreshToken, err error) {
+	repo.Collection.Wake()
+	records, err := repo.find(bson.M{"_id": id})
+	if err == nil && len(records) == 0 {
+		err = errors.New("Could not find refresh token in repository")
+	}
+	if err == nil {
+		found := RefreshToken(records[0])
+		token = &found
+	}
+
+	return
+}
+
+func (repo *mongoRefreshTokenRepository) RevokeFamily(familyID string) (err error) {
+	repo.Collection.Wake()
+	records, err := repo.find(bson.M{"family_id": familyID})
+	for _, record := range records {
+		if err != nil {
+			break
+		}
+
+		record.Revoked = true
+		_, err = repo.Collection.UpsertID(record.ID, record)
+	}
+
+	return
+}
+
+func (repo *mongoRefreshTokenRepository) find(query bson.M) (records []refreshTokenRecord, err error) {
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	_, err = repo.Collection.Find(params, &records)
+	return
+}
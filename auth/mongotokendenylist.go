@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoTokenDenylist struct {
+	Collection cfmgo.Collection
+}
+
+// deniedTokenRecord keeps the expiry so a TTL index on "expires" can remove
+// entries once the token could no longer be used anyway.
+type deniedTokenRecord struct {
+	JTI     string    `bson:"_id" json:"jti"`
+	Expires time.Time `bson:"expires" json:"expires"`
+}
+
+func NewMongoTokenDenylist(col cfmgo.Collection) *mongoTokenDenylist {
+	return &mongoTokenDenylist{
+		Collection: col,
+	}
+}
+
+func (denylist *mongoTokenDenylist) Deny(jti string, expires time.Time) (err error) {
+	denylist.Collection.Wake()
+	record := deniedTokenRecord{JTI: jti, Expires: expires}
+	_, err = denylist.Collection.UpsertID(record.JTI, record)
+	return
+}
+
+func (denylist *mongoTokenDenylist) IsDenied(jti string) bool {
+	denylist.Collection.Wake()
+	var records []deniedTokenRecord
+	params := &params.RequestParams{
+		Q: bson.M{"_id": jti},
+	}
+
+	// Fail closed: a token is treated as revoked if the denylist can't be read.
+	count, err := denylist.Collection.Find(params, &records)
+	return err != nil || count > 0
+}
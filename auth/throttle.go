@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LoginAttemptStore counts logins by key, where a key names either an
+// account or a client IP address. A login is counted before the password is
+// checked, and only taken back if it succeeds. Implementations must be safe
+// for concurrent use; a store shared between servers throttles across all of
+// them.
+type LoginAttemptStore interface {
+	// Get returns the attempts recorded against key that haven't expired.
+	Get(key string) (attempts LoginAttempts, err error)
+	// Reserve calls allowed with key's attempts and, if it returns true,
+	// counts another attempt at the given time, all in one step, so that
+	// concurrent logins can't both get in under the limit. It returns the
+	// attempts allowed was called with. A key's attempts are forgotten once
+	// window has passed since the most recent one.
+	Reserve(key string, at time.Time, window time.Duration, allowed func(attempts LoginAttempts) bool) (attempts LoginAttempts, reserved bool, err error)
+	// Release takes back one attempt reserved against key.
+	Release(key string) (err error)
+	// Reset forgets key's attempts.
+	Reset(key string) (err error)
+}
+
+// LoginAttempts is the number of recent failed or unfinished logins for a
+// key, and when the last one started.
+type LoginAttempts struct {
+	Failures int
+	Last     time.Time
+}
+
+// ThrottleOptions configures a LoginThrottle.
+type ThrottleOptions struct {
+	// AccountLimit is how many failures lock an account.
+	AccountLimit int
+	// IPLimit is how many failures lock every login from an IP address. It
+	// is higher than AccountLimit since one address may be shared by many
+	// users.
+	IPLimit int
+	// Lockout is how long a locked account or address stays locked, and how
+	// long failures are remembered.
+	Lockout time.Duration
+	// Delay is how long an account waits after its first failure. Each
+	// further failure doubles it, up to MaxDelay, until AccountLimit is
+	// reached. Addresses aren't delayed, only locked, so that one user's
+	// typo doesn't hold up everyone else behind the same address.
+	Delay    time.Duration
+	MaxDelay time.Duration
+	// TrustForwardedFor takes the client address from the X-Forwarded-For
+	// header that a load balancer in front of the server adds. Without one,
+	// clients could set the header themselves to dodge the IP limit.
+	TrustForwardedFor bool
+}
+
+// DefaultThrottleOptions are used for any ThrottleOptions field left at zero.
+var DefaultThrottleOptions = ThrottleOptions{
+	AccountLimit: 5,
+	IPLimit:      20,
+	Lockout:      15 * time.Minute,
+	Delay:        time.Second,
+	MaxDelay:     30 * time.Second,
+}
+
+// LoginThrottle slows down password guessing. Each failed login makes the
+// next attempt for the same account wait a little longer, and enough
+// failures for an account, or from an address, lock it out for a while.
+// Attempts are keyed by the email that was tried whether or not an account
+// has it, so the throttle doesn't reveal which emails are registered.
+type LoginThrottle struct {
+	store   LoginAttemptStore
+	options ThrottleOptions
+	now     func() time.Time
+}
+
+func NewLoginThrottle(store LoginAttemptStore, options ThrottleOptions) *LoginThrottle {
+	if options.AccountLimit <= 0 {
+		options.AccountLimit = DefaultThrottleOptions.AccountLimit
+	}
+	if options.IPLimit <= 0 {
+		options.IPLimit = DefaultThrottleOptions.IPLimit
+	}
+	if options.Lockout <= 0 {
+		options.Lockout = DefaultThrottleOptions.Lockout
+	}
+	if options.Delay <= 0 {
+		options.Delay = DefaultThrottleOptions.Delay
+	}
+	if options.MaxDelay <= 0 {
+		options.MaxDelay = DefaultThrottleOptions.MaxDelay
+	}
+
+	return &LoginThrottle{store: store, options: options, now: time.Now}
+}
+
+// Attempt reserves a login for email from ip. It returns how long the login
+// must wait before it is allowed, or 0 if it can go ahead now, in which case
+// the login counts as failed until Succeeded or Release is called.
+func (throttle *LoginThrottle) Attempt(email string, ip string) (time.Duration, error) {
+	now := throttle.now()
+
+	account, reserved, err := throttle.store.Reserve(accountKey(email), now, throttle.options.Lockout, func(attempts LoginAttempts) bool {
+		return throttle.wait(attempts, throttle.options.AccountLimit, true) == 0
+	})
+	if err != nil {
+		return 0, err
+	} else if !reserved {
+		return throttle.wait(account, throttle.options.AccountLimit, true), nil
+	}
+
+	address, reserved, err := throttle.store.Reserve(ipKey(ip), now, throttle.options.Lockout, func(attempts LoginAttempts) bool {
+		return throttle.wait(attempts, throttle.options.IPLimit, false) == 0
+	})
+	if err == nil && !reserved {
+		err = throttle.store.Release(accountKey(email))
+	}
+	if err != nil {
+		return 0, err
+	} else if !reserved {
+		return throttle.wait(address, throttle.options.IPLimit, false), nil
+	}
+
+	return 0, nil
+}
+
+// Failed reports whether the failure of a login reserved with Attempt has
+// locked the account or the address. The failure was already counted when
+// the login was reserved.
+func (throttle *LoginThrottle) Failed(email string, ip string) (locked bool, err error) {
+	account, err := throttle.store.Get(accountKey(email))
+	if err != nil {
+		return false, err
+	}
+
+	address, err := throttle.store.Get(ipKey(ip))
+	if err != nil {
+		return false, err
+	}
+
+	return account.Failures >= throttle.options.AccountLimit || address.Failures >= throttle.options.IPLimit, nil
+}
+
+// Succeeded clears the failures recorded against email, and takes back the
+// login reserved against ip. The address keeps its other failures, so that
+// logging in to one account between guesses at others doesn't reset the IP
+// limit.
+func (throttle *LoginThrottle) Succeeded(email string, ip string) error {
+	if err := throttle.store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+
+	return throttle.store.Release(ipKey(ip))
+}
+
+// Release takes back a login reserved with Attempt that neither failed nor
+// finished, such as a correct password still waiting for its second factor.
+func (throttle *LoginThrottle) Release(email string, ip string) error {
+	if err := throttle.store.Release(accountKey(email)); err != nil {
+		return err
+	}
+
+	return throttle.store.Release(ipKey(ip))
+}
+
+func (throttle *LoginThrottle) wait(attempts LoginAttempts, limit int, progressive bool) time.Duration {
+	if attempts.Failures == 0 || (attempts.Failures < limit && !progressive) {
+		return 0
+	}
+
+	delay := throttle.options.Lockout
+	if attempts.Failures < limit {
+		delay = throttle.options.Delay
+		for i := 1; i < attempts.Failures && delay < throttle.options.MaxDelay; i++ {
+			delay *= 2
+		}
+		if delay > throttle.options.MaxDelay {
+			delay = throttle.options.MaxDelay
+		}
+	}
+
+	if wait := attempts.Last.Add(delay).Sub(throttle.now()); wait > 0 {
+		return wait
+	}
+
+	return 0
+}
+
+// ClientIP returns the address a request came from.
+func (throttle *LoginThrottle) ClientIP(req *http.Request) string {
+	if throttle.options.TrustForwardedFor {
+		// The load balancer appends the address it saw to any header the
+		// client sent, so only the last entry can be trusted.
+		if forwarded := req.Header["X-Forwarded-For"]; len(forwarded) != 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); len(ip) != 0 {
+				return ip
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
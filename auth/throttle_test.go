@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestThrottle(options ThrottleOptions) (*LoginThrottle, *time.Time) {
+	now := time.Now()
+	throttle := NewLoginThrottle(NewInMemoryLoginAttemptStore(), options)
+	throttle.now = func() time.Time { return now }
+	return throttle, &now
+}
+
+func TestLoginThrottleDelaysGrowUntilLockout(t *testing.T) {
+	throttle, now := newTestThrottle(ThrottleOptions{AccountLimit: 4, Delay: time.Second, MaxDelay: 3 * time.Second, Lockout: time.Minute})
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, time.Minute}
+	for i, delay := range expected {
+		if wait, _ := throttle.Attempt("john@tld.com", "10.0.0.1"); wait != 0 {
+			t.Fatalf("Attempt %d: expected no wait, got %s", i+1, wait)
+		}
+
+		locked, _ := throttle.Failed("john@tld.com", "10.0.0.1")
+		if last := i == len(expected)-1; locked != last {
+			t.Errorf("Failure %d: expected locked to be %v", i+1, last)
+		}
+
+		if wait, _ := throttle.Attempt("John@TLD.com", "10.0.0.2"); wait != delay {
+			t.Errorf("Failure %d: expected a wait of %s, got %s", i+1, delay, wait)
+		}
+
+		*now = now.Add(delay)
+	}
+
+	if wait, _ := throttle.Attempt("john@tld.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected the lockout to end, got a wait of %s", wait)
+	}
+}
+
+func TestLoginThrottleLimitsAddresses(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleOptions{IPLimit: 3, Lockout: time.Minute})
+
+	for _, email := range []string{"a@tld.com", "b@tld.com", "c@tld.com"} {
+		throttle.Attempt(email, "10.0.0.1")
+		throttle.Failed(email, "10.0.0.1")
+	}
+
+	if wait, _ := throttle.Attempt("d@tld.com", "10.0.0.1"); wait != time.Minute {
+		t.Errorf("Expected the address to be locked for a minute, got %s", wait)
+	}
+
+	// The account the locked address tried is not held up by it.
+	if wait, _ := throttle.Attempt("d@tld.com", "10.0.0.2"); wait != 0 {
+		t.Errorf("Expected other addresses not to wait, got %s", wait)
+	}
+}
+
+func TestLoginThrottleSuccessOnlyClearsTheAccount(t *testing.T) {
+	throttle, now := newTestThrottle(ThrottleOptions{IPLimit: 2})
+
+	throttle.Attempt("john@tld.com", "10.0.0.1")
+	throttle.Failed("john@tld.com", "10.0.0.1")
+
+	*now = now.Add(time.Second)
+	throttle.Attempt("john@tld.com", "10.0.0.2")
+	throttle.Succeeded("john@tld.com", "10.0.0.2")
+
+	if wait, _ := throttle.Attempt("john@tld.com", "10.0.0.3"); wait != 0 {
+		t.Errorf("Expected a successful login to clear the account's failures, got a wait of %s", wait)
+	}
+
+	throttle.Attempt("jane@tld.com", "10.0.0.1")
+	if wait, _ := throttle.Attempt("ann@tld.com", "10.0.0.1"); wait == 0 {
+		t.Errorf("Expected the address to keep its failures")
+	}
+}
+
+func TestLoginThrottleReservesConcurrentAttempts(t *testing.T) {
+	throttle, _ := newTestThrottle(ThrottleOptions{IPLimit: 5})
+
+	allowed := func(n int, attempt func(i int) (time.Duration, error)) int {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		count := 0
+
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if wait, err := attempt(i); err == nil && wait == 0 {
+					mutex.Lock()
+					count++
+					mutex.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		return count
+	}
+
+	sameAccount := allowed(10, func(i int) (time.Duration, error) {
+		return throttle.Attempt("john@tld.com", fmt.Sprintf("10.0.1.%d", i))
+	})
+	if sameAccount != 1 {
+		t.Errorf("Expected one of the concurrent logins to an account to go ahead, %d did", sameAccount)
+	}
+
+	sameAddress := allowed(20, func(i int) (time.Duration, error) {
+		return throttle.Attempt(fmt.Sprintf("user%d@tld.com", i), "10.0.0.1")
+	})
+	if sameAddress != 5 {
+		t.Errorf("Expected the IP limit of concurrent logins from an address to go ahead, %d did", sameAddress)
+	}
+}
+
+func TestLoginThrottleClientIP(t *testing.T) {
+	req := httptest.NewRequest("POST", "/login", nil)
+	req.RemoteAddr = "10.0.0.1:4321"
+	req.Header.Add("X-Forwarded-For", "1.2.3.4, 192.0.2.7")
+
+	if ip := NewLoginThrottle(nil, ThrottleOptions{}).ClientIP(req); ip != "10.0.0.1" {
+		t.Errorf("Expected X-Forwarded-For to be ignored by default, got %s", ip)
+	}
+
+	if ip := NewLoginThrottle(nil, ThrottleOptions{TrustForwardedFor: true}).ClientIP(req); ip != "192.0.2.7" {
+		t.Errorf("Expected the address the load balancer added, got %s", ip)
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/spear-wind/cms/security"
+)
+
+const (
+	// AccessTokenTTL is how long a signed access token is accepted for.
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is how long a refresh token can be exchanged for.
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("Invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("Refresh token has already been used; please log in again")
+)
+
+// RefreshTokenRepository stores issued refresh tokens by the digest of the
+// token, so the tokens themselves are never persisted.
+type RefreshTokenRepository interface {
+	Add(token *RefreshToken) (err error)
+	// MarkUsed marks the token used, unless it already was, in one atomic
+	// step. marked is false when another exchange got there first.
+	MarkUsed(id string) (marked bool, err error)
+	Get(id string) (token *RefreshToken, err error)
+	RevokeFamily(familyID string) (err error)
+}
+
+// TokenDenylist records the jti of access tokens that were revoked before
+// they expired.
+type TokenDenylist interface {
+	Deny(jti string, expires time.Time) (err error)
+	IsDenied(jti string) bool
+}
+
+// RefreshToken is one link in a family of rotating refresh tokens. Every
+// refresh exchanges the presented token for a new one in the same family;
+// presenting a token that was already exchanged revokes the whole family.
+type RefreshToken struct {
+	ID       string    `json:"id"`
+	FamilyID string    `json:"family_id"`
+	UserID   int64     `json:"user_id"`
+	Created  time.Time `json:"date_created"`
+	Expires  time.Time `json:"expires"`
+	Used     bool      `json:"used"`
+	Revoked  bool      `json:"revoked"`
+}
+
+// TokenPair is returned by every endpoint that logs a user in.
+type TokenPair struct {
+	Token        string
+	RefreshToken string
+	ExpiresIn    int64
+}
+
+func (token *RefreshToken) isUsable() bool {
+	return !token.Revoked && time.Now().Before(token.Expires)
+}
+
+// IssueTokens starts a new refresh token family for the user and returns it
+// together with a new access token.
+func IssueTokens(refreshTokenRepository RefreshTokenRepository, userID int64) (pair TokenPair, err error) {
+	familyID, err := security.GenerateRandomString(16)
+	if err != nil {
+		return pair, err
+	}
+
+	return issueTokens(refreshTokenRepository, userID, familyID)
+}
+
+// RefreshTokens exchanges a refresh token for a new token pair. A token can
+// only be exchanged once.
+func RefreshTokens(refreshTokenRepository RefreshTokenRepository, presented string) (pair TokenPair, err error) {
+	token, err := refreshTokenRepository.Get(refreshTokenDigest(presented))
+	if err != nil || !token.isUsable() {
+		return pair, ErrInvalidRefreshToken
+	}
+
+	// Two requests presenting the same token can both read it unused; only
+	// the one that marks it used gets new tokens, and the other counts as
+	// reuse.
+	marked := false
+	if !token.Used {
+		if marked, err = refreshTokenRepository.MarkUsed(token.ID); err != nil {
+			return pair, err
+		}
+	}
+
+	if !marked {
+		if err := refreshTokenRepository.RevokeFamily(token.FamilyID); err != nil {
+			return pair, err
+		}
+
+		return pair, ErrRefreshTokenReused
+	}
+
+	return issueTokens(refreshTokenRepository, token.UserID, token.FamilyID)
+}
+
+// RevokeRefreshToken revokes every token in the presented token's family.
+func RevokeRefreshToken(refreshTokenRepository RefreshTokenRepository, presented string) error {
+	token, err := refreshTokenRepository.Get(refreshTokenDigest(presented))
+	if err != nil {
+		return ErrInvalidRefreshToken
+	}
+
+	return refreshTokenRepository.RevokeFamily(token.FamilyID)
+}
+
+func issueTokens(refreshTokenRepository RefreshTokenRepository, userID int64, familyID string) (pair TokenPair, err error) {
+	accessToken, err := GenerateToken(userID)
+	if err != nil {
+		return pair, err
+	}
+
+	refreshToken, err := security.GenerateRandomString(32)
+	if err != nil {
+		return pair, err
+	}
+
+	now := time.Now()
+	err = refreshTokenRepository.Add(&RefreshToken{
+		ID:       refreshTokenDigest(refreshToken),
+		FamilyID: familyID,
+		UserID:   userID,
+		Created:  now,
+		Expires:  now.Add(RefreshTokenTTL),
+	})
+	if err != nil {
+		return pair, err
+	}
+
+	return TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL / time.Second),
+	}, nil
+}
+
+func refreshTokenDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
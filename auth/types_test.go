@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRefreshTokensRotatesRefreshToken(t *testing.T) {
+	repo := NewInMemoryRefreshTokenRepository()
+
+	issued, err := IssueTokens(repo, 1)
+	if err != nil {
+		t.Fatalf("IssueTokens returned an unexpected error: %v", err)
+	}
+
+	refreshed, err := RefreshTokens(repo, issued.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshTokens returned an unexpected error: %v", err)
+	}
+
+	if refreshed.RefreshToken == issued.RefreshToken || refreshed.Token == "" {
+		t.Errorf("Expected a new token pair, got %+v", refreshed)
+	}
+
+	if _, err := RefreshTokens(repo, refreshed.RefreshToken); err != nil {
+		t.Errorf("Expected the rotated refresh token to be usable, got %v", err)
+	}
+}
+
+func TestReusedRefreshTokenRevokesFamily(t *testing.T) {
+	repo := NewInMemoryRefreshTokenRepository()
+	issued, _ := IssueTokens(repo, 1)
+	refreshed, _ := RefreshTokens(repo, issued.RefreshToken)
+
+	if _, err := RefreshTokens(repo, issued.RefreshToken); err != ErrRefreshTokenReused {
+		t.Errorf("Expected reuse of a refresh token to be detected, got %v", err)
+	}
+
+	if _, err := RefreshTokens(repo, refreshed.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected reuse to revoke the rest of the family, got %v", err)
+	}
+}
+
+func TestRevokeRefreshTokenLeavesOtherFamilies(t *testing.T) {
+	repo := NewInMemoryRefreshTokenRepository()
+	laptop, _ := IssueTokens(repo, 1)
+	phone, _ := IssueTokens(repo, 1)
+
+	if err := RevokeRefreshToken(repo, laptop.RefreshToken); err != nil {
+		t.Fatalf("RevokeRefreshToken returned an unexpected error: %v", err)
+	}
+
+	if _, err := RefreshTokens(repo, laptop.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("Expected the revoked refresh token to be rejected, got %v", err)
+	}
+
+	if _, err := RefreshTokens(repo, phone.RefreshToken); err != nil {
+		t.Errorf("Expected other sessions to remain usable, got %v", err)
+	}
+}
+
+func TestConcurrentRefreshesWithSameTokenCountAsReuse(t *testing.T) {
+	repo := NewInMemoryRefreshTokenRepository()
+	issued, _ := IssueTokens(repo, 1)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	succeeded, reused := 0, 0
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := RefreshTokens(repo, issued.RefreshToken)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err == nil {
+				succeeded++
+			} else if err == ErrRefreshTokenReused {
+				reused++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded > 1 || succeeded+reused == 0 || reused == 0 {
+		t.Errorf("Expected one refresh to succeed and the rest to be caught as reuse, got %d succeeded and %d reused", succeeded, reused)
+	}
+}
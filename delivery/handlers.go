@@ -0,0 +1,80 @@
+package delivery
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/page"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/workflow"
+	"github.com/unrolled/render"
+)
+
+var errUnknownHost = map[string]interface{}{
+	"error": "No site is configured for this host",
+}
+
+type publishedPage struct {
+	Slug      string     `json:"slug"`
+	Title     string     `json:"title"`
+	Body      string     `json:"body,omitempty"`
+	Published *time.Time `json:"date_published,omitempty"`
+}
+
+// InitRoutes registers the public, unauthenticated content delivery routes.
+// The site being served is chosen from the request's Host header.
+func InitRoutes(router *mux.Router, formatter *render.Render, siteRepository site.SiteRepository, pageRepository page.PageRepository, cacheTTL time.Duration) {
+	cache := newHostCache(siteRepository, cacheTTL)
+
+	router.HandleFunc("/content", getPublishedPageListHandler(formatter, cache, pageRepository)).Methods("GET")
+	router.HandleFunc("/content/{slug}", getPublishedPageHandler(formatter, cache, pageRepository)).Methods("GET")
+}
+
+func getPublishedPageListHandler(formatter *render.Render, cache *hostCache, pageRepository page.PageRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		site := cache.resolve(req.Host)
+		if site == nil {
+			formatter.JSON(w, http.StatusNotFound, errUnknownHost)
+			return
+		}
+
+		pages := []publishedPage{}
+		for _, p := range pageRepository.ListBySite(site.ID) {
+			if p.Status == workflow.StatePublished {
+				pages = append(pages, publishedPage{Slug: p.Slug, Title: p.Title, Published: p.Published})
+			}
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"site":  site.Name,
+			"pages": pages,
+			"total": len(pages),
+		})
+	}
+}
+
+func getPublishedPageHandler(formatter *render.Render, cache *hostCache, pageRepository page.PageRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		site := cache.resolve(req.Host)
+		if site == nil {
+			formatter.JSON(w, http.StatusNotFound, errUnknownHost)
+			return
+		}
+
+		p := pageRepository.FindBySlug(site.ID, mux.Vars(req)["slug"])
+		if p == nil || p.Status != workflow.StatePublished {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": "Page not found",
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, publishedPage{
+			Slug:      p.Slug,
+			Title:     p.Title,
+			Body:      p.Body,
+			Published: p.Published,
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/page"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/workflow"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+
+	publishedAt = time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func newTestRouter() *mux.Router {
+	author := user.NewUser(1, "", "", "")
+	siteRepository := site.NewInMemoryRepository()
+	siteRepository.Add(newActiveSite("Spearwind", "spearwind.io"))
+
+	pageRepository := page.NewInMemoryRepository()
+	published := page.NewPage("1", "about-us", "About Us", "<p>Hello</p>", author)
+	published.ApplyTransition(workflow.Transition{From: workflow.StateInReview, To: workflow.StatePublished, When: publishedAt})
+	published.Updated = publishedAt.Add(time.Hour)
+	pageRepository.Add(published)
+	pageRepository.Add(page.NewPage("1", "coming-soon", "Coming Soon", "", author))
+
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, siteRepository, pageRepository, time.Minute)
+	return router
+}
+
+func get(router *mux.Router, host string, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	req.Host = host
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+func TestPublishedPageIsServedForKnownHost(t *testing.T) {
+	res := get(newTestRouter(), "spearwind.io", "/content/about-us")
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %d: %s", res.Code, res.Body)
+	}
+
+	var p publishedPage
+	if err := json.Unmarshal(res.Body.Bytes(), &p); err != nil {
+		t.Fatalf("Could not unmarshal payload: %v", err)
+	}
+
+	if p.Title != "About Us" || p.Body != "<p>Hello</p>" {
+		t.Errorf("Unexpected page served: %+v", p)
+	}
+
+	if p.Published == nil || !p.Published.Equal(publishedAt) {
+		t.Errorf("Expected the page to be dated when it was published, not last updated, got %v", p.Published)
+	}
+}
+
+func TestDraftPageIsNotServed(t *testing.T) {
+	if res := get(newTestRouter(), "spearwind.io", "/content/coming-soon"); res.Code != http.StatusNotFound {
+		t.Errorf("Expected a draft page to be not found, received %d", res.Code)
+	}
+}
+
+func TestUnknownHostIsNotFound(t *testing.T) {
+	if res := get(newTestRouter(), "example.com", "/content/about-us"); res.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown host to be not found, received %d", res.Code)
+	}
+}
+
+func TestPublishedPageListOnlyContainsPublishedPages(t *testing.T) {
+	res := get(newTestRouter(), "spearwind.io:443", "/content")
+
+	var list struct {
+		Pages []publishedPage `json:"pages"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &list)
+
+	if res.Code != http.StatusOK || len(list.Pages) != 1 || list.Pages[0].Slug != "about-us" {
+		t.Errorf("Expected only the published page to be listed, got %d: %s", res.Code, res.Body)
+	}
+}
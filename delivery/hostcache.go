@@ -0,0 +1,75 @@
+package delivery
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spear-wind/cms/site"
+)
+
+type cacheEntry struct {
+	site    *site.Site
+	expires time.Time
+}
+
+// hostCache resolves Host headers to sites, remembering each match for ttl so
+// that public traffic does not hit the site repository on every request.
+// Only sites whose domain has been verified are served. Misses are not cached
+// so newly verified sites are served straight away.
+type hostCache struct {
+	siteRepository site.SiteRepository
+	ttl            time.Duration
+	now            func() time.Time
+
+	mu      sync.RWMutex
+	entries map[string]cacheEntry
+}
+
+func newHostCache(siteRepository site.SiteRepository, ttl time.Duration) *hostCache {
+	return &hostCache{
+		siteRepository: siteRepository,
+		ttl:            ttl,
+		now:            time.Now,
+		entries:        make(map[string]cacheEntry),
+	}
+}
+
+func (c *hostCache) resolve(host string) *site.Site {
+	host = normalizeHost(host)
+	if host == "" {
+		return nil
+	}
+
+	c.mu.RLock()
+	entry, ok := c.entries[host]
+	c.mu.RUnlock()
+
+	if ok && c.now().Before(entry.expires) {
+		return entry.site
+	}
+
+	found := c.siteRepository.FindByDomainName(host)
+	if found != nil && !found.IsActive() {
+		found = nil
+	}
+
+	c.mu.Lock()
+	if found != nil {
+		c.entries[host] = cacheEntry{site: found, expires: c.now().Add(c.ttl)}
+	} else {
+		delete(c.entries, host)
+	}
+	c.mu.Unlock()
+
+	return found
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,74 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+)
+
+type countingSiteRepository struct {
+	site.SiteRepository
+	lookups int
+}
+
+func (r *countingSiteRepository) FindByDomainName(domainName string) *site.Site {
+	r.lookups++
+	return r.SiteRepository.FindByDomainName(domainName)
+}
+
+func newActiveSite(name string, domainName string) *site.Site {
+	s := site.NewSite(name, domainName, user.NewUser(1, "", "", ""))
+	s.Status = site.StatusActive
+	return s
+}
+
+func TestResolveCachesMatchesUntilTTLExpires(t *testing.T) {
+	repo := &countingSiteRepository{SiteRepository: site.NewInMemoryRepository()}
+	repo.Add(newActiveSite("Spearwind", "spearwind.io"))
+
+	now := time.Now()
+	cache := newHostCache(repo, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	if cache.resolve("spearwind.io") == nil || cache.resolve("SPEARWIND.IO:8080") == nil {
+		t.Fatal("Expected spearwind.io to resolve")
+	}
+
+	if repo.lookups != 1 {
+		t.Errorf("Expected a single repository lookup while cached, got %d", repo.lookups)
+	}
+
+	now = now.Add(2 * time.Minute)
+	cache.resolve("spearwind.io")
+
+	if repo.lookups != 2 {
+		t.Errorf("Expected an expired entry to be looked up again, got %d lookups", repo.lookups)
+	}
+}
+
+func TestResolveDoesNotCacheUnknownHosts(t *testing.T) {
+	repo := &countingSiteRepository{SiteRepository: site.NewInMemoryRepository()}
+	cache := newHostCache(repo, time.Minute)
+
+	if cache.resolve("unknown.io") != nil {
+		t.Fatal("Expected unknown.io not to resolve")
+	}
+
+	repo.Add(newActiveSite("Unknown", "unknown.io"))
+
+	if cache.resolve("unknown.io") == nil {
+		t.Error("Expected a newly added site to resolve without waiting for the cache")
+	}
+}
+
+func TestResolveIgnoresUnverifiedSites(t *testing.T) {
+	repo := site.NewInMemoryRepository()
+	repo.Add(site.NewSite("Spearwind", "spearwind.io", user.NewUser(1, "", "", "")))
+	cache := newHostCache(repo, time.Minute)
+
+	if cache.resolve("spearwind.io") != nil {
+		t.Error("Expected a site with an unverified domain not to resolve")
+	}
+}
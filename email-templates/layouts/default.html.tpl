@@ -0,0 +1,11 @@
+<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+	</head>
+	<body>
+		{{if .Name}}<p>{{.Name}},</p>{{end}}
+		{{template "content" .}}
+		{{template "footer" .}}
+	</body>
+</html>
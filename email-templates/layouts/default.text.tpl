@@ -0,0 +1,4 @@
+{{if .Name}}{{.Name}},
+
+{{end}}{{template "content" .}}
+{{template "footer" .}}
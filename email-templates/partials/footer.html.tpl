@@ -0,0 +1 @@
+<p>&mdash; The SpearWind.io team</p>
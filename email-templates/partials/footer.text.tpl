@@ -0,0 +1 @@
+-- The SpearWind.io team
@@ -0,0 +1,6 @@
+<p>Hemos recibido una solicitud para restablecer la contraseña de tu cuenta.</p>
+
+<p>Para elegir una nueva contraseña, visita la siguiente url en la próxima hora:
+<a href="http://spearwind.io/user/password/reset/{{.ResetToken}}">http://spearwind.io/user/password/reset/{{.ResetToken}}</a></p>
+
+<p>Si no has solicitado restablecer tu contraseña, puedes ignorar este correo.</p>
@@ -0,0 +1 @@
+SpearWind.io - Restablecer contraseña
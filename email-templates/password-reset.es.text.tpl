@@ -0,0 +1,6 @@
+Hemos recibido una solicitud para restablecer la contraseña de tu cuenta.
+
+Para elegir una nueva contraseña, visita la siguiente url en la próxima hora:
+http://spearwind.io/user/password/reset/{{.ResetToken}}
+
+Si no has solicitado restablecer tu contraseña, puedes ignorar este correo.
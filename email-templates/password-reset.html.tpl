@@ -0,0 +1,6 @@
+<p>We received a request to reset the password for your account.</p>
+
+<p>To choose a new password, visit the following url within the next hour:
+<a href="http://spearwind.io/user/password/reset/{{.ResetToken}}">http://spearwind.io/user/password/reset/{{.ResetToken}}</a></p>
+
+<p>If you did not request a password reset, you can safely ignore this email.</p>
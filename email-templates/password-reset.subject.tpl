@@ -0,0 +1 @@
+SpearWind.io - Password Reset
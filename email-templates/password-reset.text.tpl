@@ -0,0 +1,6 @@
+We received a request to reset the password for your account.
+
+To choose a new password, visit the following url within the next hour:
+http://spearwind.io/user/password/reset/{{.ResetToken}}
+
+If you did not request a password reset, you can safely ignore this email.
@@ -0,0 +1,4 @@
+<p>Gracias por crear una cuenta.</p>
+
+<p>Por favor, verifica tu dirección de correo electrónico visitando la siguiente url:
+<a href="http://spearwind.io/user/verify/{{.VerificationCode}}">http://spearwind.io/user/verify/{{.VerificationCode}}</a></p>
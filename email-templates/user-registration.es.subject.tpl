@@ -0,0 +1 @@
+SpearWind.io - Verificación de tu nueva cuenta
@@ -0,0 +1,4 @@
+Gracias por crear una cuenta.
+
+Por favor, verifica tu dirección de correo electrónico visitando la siguiente url:
+http://spearwind.io/user/verify/{{.VerificationCode}}
@@ -0,0 +1,4 @@
+<p>Thanks for signing up for an account.</p>
+
+<p>Please take a few moments to verify your email address by visiting the following url:
+<a href="http://spearwind.io/user/verify/{{.VerificationCode}}">http://spearwind.io/user/verify/{{.VerificationCode}}</a></p>
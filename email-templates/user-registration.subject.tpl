@@ -0,0 +1 @@
+SpearWind.io - New Account Verification
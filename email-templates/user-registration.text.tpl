@@ -0,0 +1,4 @@
+Thanks for signing up for an account.
+
+Please take a few moments to verify your email address by visiting the following url:
+http://spearwind.io/user/verify/{{.VerificationCode}}
@@ -1,13 +0,0 @@
-<!DOCTYPE html>
-<html>
-	<head>
-		<meta charset="UTF-8">
-		<title>SpearWind.io - New Account Verification</title>
-	</head>
-	<body>
-		Thanks for signing up for an account.
-
-    Please take a few moments to verify your email address by visiting the following url:
-    <a href="http://spearwind.io/user/verify/{{.}}">http://spearwind.io/user/verify/{{.}}</a>
-	</body>
-</html>
@@ -0,0 +1,192 @@
+package eventlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+// queryLimit caps how many events one query returns. Later events are read
+// by querying again from the last one's occurred_at.
+const queryLimit = 1000
+
+var errForbidden = errors.New("Only CMS administrators can read or replay events")
+
+// Subscribers looks up the event subscribers events can be replayed into by
+// name. It is implemented by events.OutboxPublisher.
+type Subscribers interface {
+	Subscriber(name string) (events.EventSubscriber, bool)
+}
+
+// replayRequest selects the events to replay, and names the subscriber to
+// replay them into.
+type replayRequest struct {
+	Subscriber string    `json:"subscriber"`
+	UserID     int64     `json:"user_id"`
+	SiteID     string    `json:"site_id"`
+	Types      []string  `json:"types"`
+	From       time.Time `json:"from"`
+	To         time.Time `json:"to"`
+}
+
+func InitRoutes(router *mux.Router, formatter *render.Render, store events.EventStore, subscribers Subscribers, authorizer *membership.Authorizer) {
+	router.HandleFunc("/events", queryHandler(formatter, store, authorizer)).Methods("GET")
+	router.HandleFunc("/events/replay", replayHandler(formatter, store, subscribers, authorizer)).Methods("POST")
+}
+
+func queryHandler(formatter *render.Render, store events.EventStore, authorizer *membership.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireAdmin(w, req, formatter, authorizer) {
+			return
+		}
+
+		query, err := parseQuery(req)
+		if err != nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		envelopes, err := store.Query(query)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if envelopes == nil {
+			envelopes = []events.Envelope{}
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"events": envelopes,
+			"total":  len(envelopes),
+		})
+	}
+}
+
+// replayHandler sends the selected events to the named subscriber straight
+// away, bypassing the outbox, and reports how many it received.
+func replayHandler(formatter *render.Render, store events.EventStore, subscribers Subscribers, authorizer *membership.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !requireAdmin(w, req, formatter, authorizer) {
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var request replayRequest
+
+		if err := json.Unmarshal(payload, &request); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse replay request")
+			return
+		}
+
+		subscriber, ok := subscribers.Subscriber(request.Subscriber)
+		if !ok {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": fmt.Sprintf("There is no subscriber named %q", request.Subscriber),
+			})
+			return
+		}
+
+		query := events.EventQuery{
+			UserID: request.UserID,
+			SiteID: request.SiteID,
+			Types:  request.Types,
+			From:   request.From,
+			To:     request.To,
+		}
+
+		caller := user.FromContext(req.Context())
+		replayed, err := events.Replay(store, query, subscriber)
+		fmt.Printf("Security: %s replayed %d events into %s\n", caller.Email, replayed, request.Subscriber)
+
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error":    err.Error(),
+				"replayed": replayed,
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"replayed": replayed,
+		})
+	}
+}
+
+func requireAdmin(w http.ResponseWriter, req *http.Request, formatter *render.Render, authorizer *membership.Authorizer) bool {
+	caller := user.FromContext(req.Context())
+	if caller == nil {
+		formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+		return false
+	}
+
+	if !authorizer.IsAdmin(caller) {
+		formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+			"error": errForbidden.Error(),
+		})
+		return false
+	}
+
+	return true
+}
+
+// parseQuery reads an EventQuery from the user_id, site_id, type, from, to
+// and limit query parameters. type may be repeated or comma-separated, and
+// from and to are RFC 3339 times.
+func parseQuery(req *http.Request) (query events.EventQuery, err error) {
+	values := req.URL.Query()
+
+	if userID := values.Get("user_id"); len(userID) != 0 {
+		if query.UserID, err = strconv.ParseInt(userID, 10, 64); err != nil {
+			return query, fmt.Errorf("Invalid user_id %q", userID)
+		}
+	}
+
+	query.SiteID = values.Get("site_id")
+
+	for _, types := range values["type"] {
+		for _, eventType := range strings.Split(types, ",") {
+			if eventType = strings.TrimSpace(eventType); len(eventType) != 0 {
+				query.Types = append(query.Types, eventType)
+			}
+		}
+	}
+
+	if query.From, err = parseTime(values.Get("from")); err != nil {
+		return query, fmt.Errorf("Invalid from time: %v", err)
+	}
+
+	if query.To, err = parseTime(values.Get("to")); err != nil {
+		return query, fmt.Errorf("Invalid to time: %v", err)
+	}
+
+	query.Limit, err = strconv.Atoi(values.Get("limit"))
+	if err != nil || query.Limit <= 0 || query.Limit > queryLimit {
+		query.Limit = queryLimit
+	}
+
+	return query, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,154 @@
+package eventlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+type subscriberMap map[string]events.EventSubscriber
+
+func (m subscriberMap) Subscriber(name string) (events.EventSubscriber, bool) {
+	s, ok := m[name]
+	return s, ok
+}
+
+type recordingSubscriber struct {
+	received []events.Envelope
+}
+
+func (s *recordingSubscriber) Receive(e interface{}) error {
+	s.received = append(s.received, e.(events.Envelope))
+	return nil
+}
+
+// newTestServer serves the routes with caller injected into every request
+// context, standing in for auth.ResolveCaller. Only root@spearwind.io is an
+// administrator.
+func newTestServer(store events.EventStore, subscribers Subscribers, caller *user.User) *httptest.Server {
+	authorizer := membership.NewAuthorizer(formatter, membership.NewInMemoryRepository(), nil)
+	authorizer.SetAdmins("root@spearwind.io")
+
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, store, subscribers, authorizer)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}))
+}
+
+func newTestEventStore(t *testing.T) events.EventStore {
+	store := events.NewInMemoryEventStore()
+	for _, siteID := range []string{"a", "b", "a"} {
+		s := site.NewSite("Test", "test.spearwind.io", nil)
+		s.ID = siteID
+
+		envelope, err := events.NewEnvelope(site.NewCreatedEvent(s), nil)
+		if err != nil {
+			t.Fatalf("Failed to create envelope: %v", err)
+		}
+
+		if err := store.Append(envelope); err != nil {
+			t.Fatalf("Failed to append event: %v", err)
+		}
+	}
+
+	return store
+}
+
+func newAdmin() *user.User {
+	admin := user.NewUser(1, "CMS", "Admin", "root@spearwind.io")
+	admin.Verified = true
+	return admin
+}
+
+func doRequest(t *testing.T, method string, url string, body string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error in %s to %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+	payload, _ := ioutil.ReadAll(res.Body)
+	return res, payload
+}
+
+func TestQueryEvents(t *testing.T) {
+	server := newTestServer(newTestEventStore(t), subscriberMap{}, newAdmin())
+	defer server.Close()
+
+	res, payload := doRequest(t, "GET", server.URL+"/events?site_id=a&type=site.created,site.updated&limit=1", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", res.StatusCode, payload)
+	}
+
+	var body struct {
+		Events []map[string]interface{} `json:"events"`
+		Total  int                      `json:"total"`
+	}
+	json.Unmarshal(payload, &body)
+
+	if body.Total != 1 || len(body.Events) != 1 || body.Events[0]["type"] != "site.created" {
+		t.Errorf("Expected the limit of one site.created event, got %s", payload)
+	}
+
+	if res, _ := doRequest(t, "GET", server.URL+"/events?from=yesterday", ""); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid time to be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestReplayEvents(t *testing.T) {
+	subscriber := &recordingSubscriber{}
+	server := newTestServer(newTestEventStore(t), subscriberMap{"webhooks": subscriber}, newAdmin())
+	defer server.Close()
+
+	res, payload := doRequest(t, "POST", server.URL+"/events/replay", `{"subscriber": "webhooks", "site_id": "a"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", res.StatusCode, payload)
+	}
+
+	if len(subscriber.received) != 2 {
+		t.Errorf("Expected both events for site a to be replayed, got %d", len(subscriber.received))
+	}
+
+	if res, _ := doRequest(t, "POST", server.URL+"/events/replay", `{"subscriber": "nobody"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown subscriber to be rejected, got %d", res.StatusCode)
+	}
+}
+
+func TestEventsRequireAdmin(t *testing.T) {
+	subscriber := &recordingSubscriber{}
+	owner := user.NewUser(2, "Site", "Owner", "owner@spearwind.io")
+	owner.Verified = true
+
+	server := newTestServer(newTestEventStore(t), subscriberMap{"webhooks": subscriber}, owner)
+	defer server.Close()
+
+	if res, _ := doRequest(t, "GET", server.URL+"/events", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a non-admin query to be forbidden, got %d", res.StatusCode)
+	}
+
+	if res, _ := doRequest(t, "POST", server.URL+"/events/replay", `{"subscriber": "webhooks"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a non-admin replay to be forbidden, got %d", res.StatusCode)
+	}
+
+	if len(subscriber.received) != 0 {
+		t.Errorf("Expected nothing to be replayed, got %d events", len(subscriber.received))
+	}
+}
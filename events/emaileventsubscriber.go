@@ -1,52 +1,86 @@
 package events
 
 import (
-	"fmt"
-	"os"
-	"time"
-
 	"github.com/dave-malone/email"
+	"github.com/spear-wind/cms/mail"
 )
 
-type EmailEvent struct {
-	When    time.Time
-	Message email.Message
+// Recipient is who an email is sent to. Locale chooses which translation of
+// the template is used.
+type Recipient struct {
+	Email  string `json:"email"`
+	Name   string `json:"name,omitempty"`
+	Locale string `json:"locale,omitempty"`
 }
 
-type emailEventSubscriber struct {
-	sender email.Sender
+// EmailRecipient lets structs that embed a Recipient implement Email.
+func (r Recipient) EmailRecipient() Recipient {
+	return r
 }
 
-func (s emailEventSubscriber) Receive(e interface{}) {
-	if emailEvent, ok := e.(EmailEvent); ok {
-		s.sender.Send(&emailEvent.Message)
-	}
+// Email is an event that sends an email rendered from a template in the
+// mail.Registry. The event itself is the template's data, so each template
+// has its own event type listing the fields it uses.
+type Email interface {
+	EmailTemplate() string
+	EmailRecipient() Recipient
 }
 
-func NewEmailEventSubscriber(sender email.Sender) EventSubscriber {
-	return emailEventSubscriber{sender: sender}
+// VerificationEmail asks a new user to verify their email address.
+type VerificationEmail struct {
+	Recipient
+	VerificationCode string `json:"verification_code"`
 }
 
-func NewUserRegistrationEvent(emailAddress string, verificationCode string) *EmailEvent {
-	emailTemplateDir := os.Getenv("EMAIL_TEMPLATE_DIR")
-	if emailTemplateDir == "" {
-		emailTemplateDir = "../email-templates"
-		fmt.Printf("Using %s as the email template directory. Please set env var EMAIL_TEMPLATE_DIR to override this setting\n", emailTemplateDir)
-	}
+// PasswordResetEmail sends a user a link to choose a new password.
+type PasswordResetEmail struct {
+	Recipient
+	ResetToken string `json:"reset_token"`
+}
+
+func init() {
+	RegisterEventType(VerificationEmail{})
+	RegisterEventType(PasswordResetEmail{})
+}
+
+func NewVerificationEmail(recipient Recipient, verificationCode string) VerificationEmail {
+	return VerificationEmail{Recipient: recipient, VerificationCode: verificationCode}
+}
 
-	messageBody := email.NewFileBasedHTMLTemplateMessageBody(emailTemplateDir+"/user-registration.tpl", verificationCode)
+func NewPasswordResetEmail(recipient Recipient, resetToken string) PasswordResetEmail {
+	return PasswordResetEmail{Recipient: recipient, ResetToken: resetToken}
+}
+
+func (e VerificationEmail) EmailTemplate() string  { return "user-registration" }
+func (e PasswordResetEmail) EmailTemplate() string { return "password-reset" }
+
+type emailEventSubscriber struct {
+	sender    email.Sender
+	templates *mail.Registry
+	from      string
+}
 
-	emailMessage := email.NewMessage(
-		"no-reply@spearwind.io",
-		emailAddress,
-		"SpearWind.io - New Account Verification",
-		messageBody,
-	)
+// NewEmailEventSubscriber returns an EventSubscriber that renders Email
+// events with templates and sends them from the from address.
+func NewEmailEventSubscriber(sender email.Sender, templates *mail.Registry, from string) EventSubscriber {
+	return emailEventSubscriber{
+		sender:    sender,
+		templates: templates,
+		from:      from,
+	}
+}
+
+func (s emailEventSubscriber) Receive(e interface{}) error {
+	event, ok := e.(Email)
+	if !ok {
+		return nil
+	}
 
-	emailEvent := &EmailEvent{
-		When:    time.Now(),
-		Message: *emailMessage,
+	recipient := event.EmailRecipient()
+	rendered, err := s.templates.Render(event.EmailTemplate(), recipient.Locale, event)
+	if err != nil {
+		return err
 	}
 
-	return emailEvent
+	return s.sender.Send(email.NewMessage(s.from, recipient.Email, rendered.Subject, rendered))
 }
@@ -3,40 +3,113 @@ package events
 import (
 	"strings"
 	"testing"
+
+	"github.com/dave-malone/email"
+	"github.com/spear-wind/cms/mail"
 )
 
-func TestNewUserRegistrationEvent(t *testing.T) {
-	toEmail := "test@spearwind.io"
+type capturingSender struct {
+	sent []*email.Message
+}
+
+func (s *capturingSender) Send(message *email.Message) error {
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+func newTestEmailSubscriber(t *testing.T) (EventSubscriber, *capturingSender) {
+	templates, err := mail.LoadRegistry("../email-templates")
+	if err != nil {
+		t.Fatalf("Failed to load email templates: %v", err)
+	}
+
+	sender := &capturingSender{}
+	return NewEmailEventSubscriber(sender, templates, "no-reply@spearwind.io"), sender
+}
+
+func receiveEmail(t *testing.T, event Email) (*email.Message, *mail.Rendered) {
+	subscriber, sender := newTestEmailSubscriber(t)
+	if err := subscriber.Receive(event); err != nil {
+		t.Fatalf("Failed to send %T: %v", event, err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("Expected one email to be sent, got %d", len(sender.sent))
+	}
+
+	message := sender.sent[0]
+	rendered, ok := message.Body.(*mail.Rendered)
+	if !ok {
+		t.Fatalf("Expected the body to be rendered from a template, got %T", message.Body)
+	}
+
+	return message, rendered
+}
+
+func TestVerificationEmail(t *testing.T) {
 	verificationCode := "ABC123"
-	event := NewUserRegistrationEvent(toEmail, verificationCode)
+	message, rendered := receiveEmail(t, NewVerificationEmail(Recipient{Email: "test@spearwind.io", Name: "Test"}, verificationCode))
 
-	if &event.When == nil {
-		t.Errorf("event.When is nil")
+	if message.To != "test@spearwind.io" {
+		t.Errorf("message.To did not equal test@spearwind.io: %s", message.To)
 	}
 
-	if &event.Message == nil {
-		t.Errorf("event.Message is nil")
+	if message.Subject != "SpearWind.io - New Account Verification" {
+		t.Errorf("User registration email subject was not the expected value: %s", message.Subject)
 	}
 
-	if event.Message.To != toEmail {
-		t.Errorf("event.Message.To did not equal %s", toEmail)
+	if message.From != "no-reply@spearwind.io" {
+		t.Errorf("User registration email from address was not the expected value: %s", message.From)
 	}
 
-	if event.Message.Subject != "SpearWind.io - New Account Verification" {
-		t.Errorf("User registration event subject was not the expected value: %s", event.Message.Subject)
+	emailBody, err := message.Body.String()
+	if err != nil {
+		t.Errorf("Failed to load user registration email body: %v", err)
 	}
 
-	if event.Message.From != "no-reply@spearwind.io" {
-		t.Errorf("User registration event from email address was not the expected value: %s", event.Message.From)
+	if !strings.Contains(emailBody, "http://spearwind.io/user/verify/"+verificationCode) {
+		t.Errorf("User registration email body did not contain the correct user verification link")
 	}
 
-	emailBody, err := event.Message.Body.String()
+	if !strings.Contains(rendered.PlainText(), "http://spearwind.io/user/verify/"+verificationCode) || strings.Contains(rendered.PlainText(), "<a") {
+		t.Errorf("Expected a plain-text alternative with the verification link: %s", rendered.PlainText())
+	}
+
+	if !strings.HasPrefix(rendered.PlainText(), "Test,") {
+		t.Errorf("Expected the layout to greet the recipient by name: %s", rendered.PlainText())
+	}
+}
+
+func TestPasswordResetEmail(t *testing.T) {
+	resetToken := "XYZ789"
+	message, _ := receiveEmail(t, NewPasswordResetEmail(Recipient{Email: "test@spearwind.io"}, resetToken))
+
+	if message.Subject != "SpearWind.io - Password Reset" {
+		t.Errorf("Password reset email subject was not the expected value: %s", message.Subject)
+	}
 
+	emailBody, err := message.Body.String()
 	if err != nil {
-		t.Errorf("Failed to load user registration event email body: %v", err)
+		t.Errorf("Failed to load password reset email body: %v", err)
 	}
 
-	if strings.Contains(emailBody, "http://spearwind.io/user/verify/"+verificationCode) != true {
-		t.Errorf("User registration event email body did not contain the correct user verification link")
+	if !strings.Contains(emailBody, "http://spearwind.io/user/password/reset/"+resetToken) {
+		t.Errorf("Password reset email body did not contain the correct reset link")
+	}
+}
+
+func TestEmailsAreLocalized(t *testing.T) {
+	message, _ := receiveEmail(t, NewPasswordResetEmail(Recipient{Email: "test@spearwind.io", Locale: "es_MX"}, "XYZ789"))
+
+	if message.Subject != "SpearWind.io - Restablecer contraseña" {
+		t.Errorf("Expected the Spanish subject for an es-MX recipient, got %s", message.Subject)
+	}
+}
+
+func TestEmailSubscriberIgnoresOtherEvents(t *testing.T) {
+	subscriber, sender := newTestEmailSubscriber(t)
+
+	if err := subscriber.Receive("not an email"); err != nil || len(sender.sent) != 0 {
+		t.Errorf("Expected other events to be ignored, got %v and %d emails", err, len(sender.sent))
 	}
 }
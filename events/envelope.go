@@ -0,0 +1,140 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spear-wind/cms/security"
+)
+
+var (
+	domainEvents      = map[domainEventKey]reflect.Type{}
+	domainEventsMutex sync.RWMutex
+)
+
+type domainEventKey struct {
+	eventType string
+	version   int
+}
+
+// DomainEvent is something that happened in the CMS that other parts of the
+// system, or other services, may want to react to. Each event type is
+// versioned so its payload can change without breaking existing consumers;
+// bump the version and register the new struct alongside the old one.
+type DomainEvent interface {
+	// EventType names the event, e.g. "user.registered".
+	EventType() string
+	EventVersion() int
+}
+
+// Actor identifies the user whose request caused an event.
+type Actor struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+}
+
+// Envelope wraps a DomainEvent with the metadata every event shares. It is
+// what gets published, stored in the outbox and sent to webhooks.
+type Envelope struct {
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	Version    int         `json:"version"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	Actor      *Actor      `json:"actor,omitempty"`
+	Payload    DomainEvent `json:"payload"`
+}
+
+func init() {
+	RegisterEventType(Envelope{})
+}
+
+// RegisterDomainEvent adds prototype's type and version to the event
+// catalogue, so envelopes carrying it can be decoded. Event types are
+// registered by the package that defines them.
+func RegisterDomainEvent(prototype DomainEvent) {
+	domainEventsMutex.Lock()
+	defer domainEventsMutex.Unlock()
+
+	domainEvents[domainEventKey{prototype.EventType(), prototype.EventVersion()}] = reflect.TypeOf(prototype)
+}
+
+// DomainEventTypes returns the name of every event type in the catalogue.
+func DomainEventTypes() (eventTypes []string) {
+	domainEventsMutex.RLock()
+	defer domainEventsMutex.RUnlock()
+
+	seen := map[string]bool{}
+	for key := range domainEvents {
+		if !seen[key.eventType] {
+			seen[key.eventType] = true
+			eventTypes = append(eventTypes, key.eventType)
+		}
+	}
+
+	sort.Strings(eventTypes)
+	return eventTypes
+}
+
+func NewEnvelope(payload DomainEvent, actor *Actor) (Envelope, error) {
+	id, err := security.GenerateRandomString(16)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		ID:         id,
+		Type:       payload.EventType(),
+		Version:    payload.EventVersion(),
+		OccurredAt: time.Now().UTC(),
+		Actor:      actor,
+		Payload:    payload,
+	}, nil
+}
+
+// Publish wraps payload in an envelope and publishes it. Like
+// EventPublisher.Publish, failures are logged rather than returned so that
+// they never fail the request that caused the event.
+func Publish(publisher EventPublisher, actor *Actor, payload DomainEvent) {
+	envelope, err := NewEnvelope(payload, actor)
+	if err != nil {
+		fmt.Printf("Failed to create %s event: %v\n", payload.EventType(), err)
+		return
+	}
+
+	publisher.Publish(envelope)
+}
+
+// UnmarshalJSON decodes the payload into the struct registered for the
+// envelope's type and version.
+func (envelope *Envelope) UnmarshalJSON(data []byte) error {
+	type envelopeFields Envelope
+	var decoded struct {
+		envelopeFields
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	domainEventsMutex.RLock()
+	t, ok := domainEvents[domainEventKey{decoded.Type, decoded.Version}]
+	domainEventsMutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("Event type %s version %d is not in the event catalogue", decoded.Type, decoded.Version)
+	}
+
+	payload := reflect.New(t)
+	if err := json.Unmarshal(decoded.Payload, payload.Interface()); err != nil {
+		return err
+	}
+
+	*envelope = Envelope(decoded.envelopeFields)
+	envelope.Payload = payload.Elem().Interface().(DomainEvent)
+	return nil
+}
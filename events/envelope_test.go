@@ -0,0 +1,125 @@
+package events
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testCreatedEvent struct {
+	Name string `json:"name"`
+}
+
+func (e testCreatedEvent) EventType() string { return "test.created" }
+func (e testCreatedEvent) EventVersion() int { return 1 }
+
+type testCreatedEventV2 struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+func (e testCreatedEventV2) EventType() string { return "test.created" }
+func (e testCreatedEventV2) EventVersion() int { return 2 }
+
+func init() {
+	RegisterDomainEvent(testCreatedEvent{})
+	RegisterDomainEvent(testCreatedEventV2{})
+}
+
+func TestEnvelopeJSONRoundTrip(t *testing.T) {
+	envelope, err := NewEnvelope(testCreatedEvent{Name: "spearwind"}, &Actor{UserID: 7, Email: "actor@spearwind.io"})
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+
+	if len(envelope.ID) == 0 || envelope.Type != "test.created" || envelope.Version != 1 || envelope.OccurredAt.IsZero() {
+		t.Errorf("Expected the envelope to be filled in from the event, got %+v", envelope)
+	}
+
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %v", err)
+	}
+
+	var decoded Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal envelope: %v", err)
+	}
+
+	if decoded.ID != envelope.ID || !decoded.OccurredAt.Equal(envelope.OccurredAt) || decoded.Actor == nil || decoded.Actor.UserID != 7 {
+		t.Errorf("Expected the envelope fields to survive a round trip, got %+v", decoded)
+	}
+
+	if payload, ok := decoded.Payload.(testCreatedEvent); !ok || payload.Name != "spearwind" {
+		t.Errorf("Expected the payload to decode as a testCreatedEvent, got %#v", decoded.Payload)
+	}
+}
+
+func TestEnvelopeDecodesEachVersion(t *testing.T) {
+	envelope, _ := NewEnvelope(testCreatedEventV2{FirstName: "Spear", LastName: "Wind"}, nil)
+	data, _ := json.Marshal(envelope)
+
+	var decoded Envelope
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal envelope: %v", err)
+	}
+
+	if payload, ok := decoded.Payload.(testCreatedEventV2); !ok || payload.LastName != "Wind" {
+		t.Errorf("Expected a version 2 payload, got %#v", decoded.Payload)
+	}
+}
+
+func TestEnvelopeRejectsUnknownEvents(t *testing.T) {
+	var decoded Envelope
+	err := json.Unmarshal([]byte(`{"id":"1","type":"test.exploded","version":1,"payload":{}}`), &decoded)
+	if err == nil {
+		t.Errorf("Expected an event that is not in the catalogue to be rejected")
+	}
+}
+
+func TestEnvelopeSurvivesTheOutbox(t *testing.T) {
+	envelope, _ := NewEnvelope(testCreatedEvent{Name: "outbox"}, nil)
+
+	message, err := NewOutboxMessage(envelope)
+	if err != nil {
+		t.Fatalf("Expected envelopes to be accepted by the outbox, got %v", err)
+	}
+
+	event, err := message.Event()
+	if err != nil {
+		t.Fatalf("Failed to decode outbox message: %v", err)
+	}
+
+	decoded, ok := event.(Envelope)
+	if !ok || decoded.ID != envelope.ID || decoded.Payload.(testCreatedEvent).Name != "outbox" {
+		t.Errorf("Expected the envelope back from the outbox, got %#v", event)
+	}
+}
+
+func TestPublishWrapsEventsInEnvelopes(t *testing.T) {
+	subscriber := &countingSubscriber{}
+	publisher := NewSynchEventPublisher()
+	publisher.Add(subscriber)
+
+	Publish(publisher, &Actor{UserID: 1}, testCreatedEvent{Name: "published"})
+
+	if subscriber.count() != 1 {
+		t.Fatalf("Expected one event to be published, got %d", subscriber.count())
+	}
+
+	if envelope, ok := subscriber.received[0].(Envelope); !ok || envelope.Type != "test.created" || envelope.Actor.UserID != 1 {
+		t.Errorf("Expected an envelope to be published, got %#v", subscriber.received[0])
+	}
+}
+
+func TestDomainEventTypes(t *testing.T) {
+	count := 0
+	for _, eventType := range DomainEventTypes() {
+		if eventType == "test.created" {
+			count++
+		}
+	}
+
+	if count != 1 {
+		t.Errorf("Expected test.created to be listed once, got %d", count)
+	}
+}
@@ -0,0 +1,135 @@
+package events
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventStore keeps every published domain event so that it can be queried
+// and replayed later, for example to rebuild a projection.
+type EventStore interface {
+	// Append stores envelope. Appending an envelope with an ID that is
+	// already stored does nothing, so redelivered events are kept once.
+	Append(envelope Envelope) (err error)
+	// Query returns the matching events, oldest first.
+	Query(query EventQuery) (envelopes []Envelope, err error)
+}
+
+// SiteEvent is implemented by domain events that concern a site.
+type SiteEvent interface {
+	EventSiteID() string
+}
+
+// UserEvent is implemented by domain events that concern a user.
+type UserEvent interface {
+	EventUserID() int64
+}
+
+// EventQuery selects events from an EventStore. Zero fields match every
+// event.
+type EventQuery struct {
+	// UserID matches events whose payload concerns the user.
+	UserID int64
+	// SiteID matches events whose payload concerns the site.
+	SiteID string
+	Types  []string
+	// From and To bound when the event occurred; From is inclusive and To
+	// is exclusive.
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// Matches reports whether envelope is selected by the query, ignoring Limit.
+func (query EventQuery) Matches(envelope Envelope) bool {
+	if query.UserID != 0 && eventUserID(envelope.Payload) != query.UserID {
+		return false
+	}
+
+	if len(query.SiteID) != 0 && eventSiteID(envelope.Payload) != query.SiteID {
+		return false
+	}
+
+	if len(query.Types) != 0 && !containsString(query.Types, envelope.Type) {
+		return false
+	}
+
+	if !query.From.IsZero() && envelope.OccurredAt.Before(query.From) {
+		return false
+	}
+
+	if !query.To.IsZero() && !envelope.OccurredAt.Before(query.To) {
+		return false
+	}
+
+	return true
+}
+
+// Replay sends the events selected by query to subscriber, oldest first. It
+// stops at the first event the subscriber fails to receive, and returns how
+// many events were received before then.
+func Replay(store EventStore, query EventQuery, subscriber EventSubscriber) (replayed int, err error) {
+	envelopes, err := store.Query(query)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, envelope := range envelopes {
+		if err := subscriber.Receive(envelope); err != nil {
+			return replayed, fmt.Errorf("Failed to replay event %s: %v", envelope.ID, err)
+		}
+
+		replayed++
+	}
+
+	return replayed, nil
+}
+
+type eventStoreSubscriber struct {
+	store EventStore
+}
+
+// NewEventStoreSubscriber returns an EventSubscriber that appends every
+// envelope it receives to store. Add it to the EventPublisher to record all
+// domain events.
+//
+// Email events are deliberately not stored: they carry verification codes
+// and password reset tokens, which must not outlive the email, and the mail
+// queue already records what was sent to whom.
+func NewEventStoreSubscriber(store EventStore) EventSubscriber {
+	return eventStoreSubscriber{store: store}
+}
+
+func (s eventStoreSubscriber) Receive(e interface{}) error {
+	if envelope, ok := e.(Envelope); ok {
+		return s.store.Append(envelope)
+	}
+
+	return nil
+}
+
+func eventUserID(event DomainEvent) int64 {
+	if event, ok := event.(UserEvent); ok {
+		return event.EventUserID()
+	}
+
+	return 0
+}
+
+func eventSiteID(event DomainEvent) string {
+	if event, ok := event.(SiteEvent); ok {
+		return event.EventSiteID()
+	}
+
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+type testUserEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (e testUserEvent) EventType() string  { return "test.user_changed" }
+func (e testUserEvent) EventVersion() int  { return 1 }
+func (e testUserEvent) EventUserID() int64 { return e.UserID }
+
+type testSiteEvent struct {
+	SiteID string `json:"site_id"`
+}
+
+func (e testSiteEvent) EventType() string   { return "test.site_changed" }
+func (e testSiteEvent) EventVersion() int   { return 1 }
+func (e testSiteEvent) EventSiteID() string { return e.SiteID }
+
+func init() {
+	RegisterDomainEvent(testUserEvent{})
+	RegisterDomainEvent(testSiteEvent{})
+}
+
+var storeEpoch = time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+func appendAt(t *testing.T, store EventStore, minutes int, event DomainEvent) Envelope {
+	envelope, err := NewEnvelope(event, nil)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+
+	envelope.OccurredAt = storeEpoch.Add(time.Duration(minutes) * time.Minute)
+	if err := store.Append(envelope); err != nil {
+		t.Fatalf("Failed to append event: %v", err)
+	}
+
+	return envelope
+}
+
+func newTestEventStore(t *testing.T) EventStore {
+	store := NewInMemoryEventStore()
+	appendAt(t, store, 2, testUserEvent{UserID: 1})
+	appendAt(t, store, 0, testUserEvent{UserID: 1})
+	appendAt(t, store, 1, testUserEvent{UserID: 2})
+	appendAt(t, store, 3, testSiteEvent{SiteID: "a"})
+	appendAt(t, store, 4, testSiteEvent{SiteID: "b"})
+	return store
+}
+
+func TestEventStoreQueries(t *testing.T) {
+	store := newTestEventStore(t)
+
+	tests := []struct {
+		name     string
+		query    EventQuery
+		expected int
+	}{
+		{"everything", EventQuery{}, 5},
+		{"by user", EventQuery{UserID: 1}, 2},
+		{"by site", EventQuery{SiteID: "a"}, 1},
+		{"by type", EventQuery{Types: []string{"test.site_changed"}}, 2},
+		{"by time range", EventQuery{From: storeEpoch.Add(time.Minute), To: storeEpoch.Add(3 * time.Minute)}, 2},
+		{"with a limit", EventQuery{Limit: 3}, 3},
+	}
+
+	for _, test := range tests {
+		envelopes, err := store.Query(test.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		} else if len(envelopes) != test.expected {
+			t.Errorf("%s: expected %d events, got %d", test.name, test.expected, len(envelopes))
+		}
+	}
+}
+
+func TestEventStoreReturnsOldestFirst(t *testing.T) {
+	envelopes, _ := newTestEventStore(t).Query(EventQuery{})
+
+	for i := 1; i < len(envelopes); i++ {
+		if envelopes[i].OccurredAt.Before(envelopes[i-1].OccurredAt) {
+			t.Fatalf("Expected events in the order they occurred, got %v before %v", envelopes[i-1].OccurredAt, envelopes[i].OccurredAt)
+		}
+	}
+}
+
+func TestEventStoreIgnoresRedeliveredEvents(t *testing.T) {
+	store := NewInMemoryEventStore()
+	envelope := appendAt(t, store, 0, testUserEvent{UserID: 1})
+	store.Append(envelope)
+
+	if envelopes, _ := store.Query(EventQuery{}); len(envelopes) != 1 {
+		t.Errorf("Expected an event appended twice to be stored once, got %d", len(envelopes))
+	}
+}
+
+func TestEventStoreSubscriberRecordsEnvelopes(t *testing.T) {
+	store := NewInMemoryEventStore()
+	publisher := NewSynchEventPublisher()
+	publisher.Add(NewEventStoreSubscriber(store))
+
+	Publish(publisher, nil, testUserEvent{UserID: 7})
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+	publisher.Publish("not a domain event")
+
+	if envelopes, _ := store.Query(EventQuery{}); len(envelopes) != 1 || eventUserID(envelopes[0].Payload) != 7 {
+		t.Errorf("Expected only the published domain event to be stored, got %d events", len(envelopes))
+	}
+}
+
+func TestReplay(t *testing.T) {
+	store := newTestEventStore(t)
+	subscriber := &countingSubscriber{}
+
+	replayed, err := Replay(store, EventQuery{UserID: 1}, subscriber)
+	if err != nil || replayed != 2 || subscriber.count() != 2 {
+		t.Fatalf("Expected two events to be replayed, got %d, %v", replayed, err)
+	}
+
+	first := subscriber.received[0].(Envelope)
+	if !first.OccurredAt.Equal(storeEpoch) {
+		t.Errorf("Expected the oldest event to be replayed first, got %v", first.OccurredAt)
+	}
+}
+
+func TestReplayStopsAtFailure(t *testing.T) {
+	store := newTestEventStore(t)
+	subscriber := &countingSubscriber{failures: 1}
+
+	replayed, err := Replay(store, EventQuery{}, subscriber)
+	if err == nil || replayed != 0 || subscriber.count() != 0 {
+		t.Errorf("Expected the replay to stop at the first failure, got %d events and %v", replayed, err)
+	}
+}
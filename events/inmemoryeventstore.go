@@ -0,0 +1,52 @@
+package events
+
+import (
+	"sort"
+	"sync"
+)
+
+type inMemoryEventStore struct {
+	mutex     sync.RWMutex
+	envelopes []Envelope
+	ids       map[string]bool
+}
+
+func NewInMemoryEventStore() *inMemoryEventStore {
+	return &inMemoryEventStore{
+		ids: make(map[string]bool),
+	}
+}
+
+func (store *inMemoryEventStore) Append(envelope Envelope) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if store.ids[envelope.ID] {
+		return nil
+	}
+
+	store.ids[envelope.ID] = true
+	store.envelopes = append(store.envelopes, envelope)
+	return err
+}
+
+func (store *inMemoryEventStore) Query(query EventQuery) (envelopes []Envelope, err error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, envelope := range store.envelopes {
+		if query.Matches(envelope) {
+			envelopes = append(envelopes, envelope)
+		}
+	}
+
+	sort.SliceStable(envelopes, func(i, j int) bool {
+		return envelopes[i].OccurredAt.Before(envelopes[j].OccurredAt)
+	})
+
+	if query.Limit > 0 && len(envelopes) > query.Limit {
+		envelopes = envelopes[:query.Limit]
+	}
+
+	return envelopes, err
+}
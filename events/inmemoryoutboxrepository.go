@@ -0,0 +1,98 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type inMemoryOutboxRepository struct {
+	mutex    sync.Mutex
+	messages []*OutboxMessage
+}
+
+func NewInMemoryOutboxRepository() *inMemoryOutboxRepository {
+	return &inMemoryOutboxRepository{}
+}
+
+func (repo *inMemoryOutboxRepository) Add(message *OutboxMessage) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	stored := *message
+	repo.messages = append(repo.messages, &stored)
+	return err
+}
+
+func (repo *inMemoryOutboxRepository) ListPending(due time.Time, limit int) (messages []*OutboxMessage) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, message := range holdBack(repo.pending(), due, limit) {
+		pending := *message
+		pending.Delivered = append([]string(nil), message.Delivered...)
+		messages = append(messages, &pending)
+	}
+
+	return messages
+}
+
+func (repo *inMemoryOutboxRepository) NextDue() (due time.Time, pending bool) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	return nextDue(repo.pending())
+}
+
+func (repo *inMemoryOutboxRepository) pending() (messages []*OutboxMessage) {
+	for _, message := range repo.messages {
+		if message.pending() {
+			messages = append(messages, message)
+		}
+	}
+
+	return messages
+}
+
+func (repo *inMemoryOutboxRepository) MarkDelivered(id string, subscriber string) (err error) {
+	return repo.update(id, func(message *OutboxMessage) {
+		message.Delivered = append(message.Delivered, subscriber)
+	})
+}
+
+func (repo *inMemoryOutboxRepository) MarkDispatched(id string, dispatched time.Time) (err error) {
+	return repo.update(id, func(message *OutboxMessage) {
+		message.Attempts++
+		message.Dispatched = &dispatched
+	})
+}
+
+func (repo *inMemoryOutboxRepository) RecordFailure(id string, failure error, nextAttempt time.Time) (err error) {
+	return repo.update(id, func(message *OutboxMessage) {
+		message.Attempts++
+		message.LastError = failure.Error()
+		message.NextAttempt = nextAttempt
+	})
+}
+
+func (repo *inMemoryOutboxRepository) MarkDead(id string, failure error, dead time.Time) (err error) {
+	return repo.update(id, func(message *OutboxMessage) {
+		message.Attempts++
+		message.LastError = failure.Error()
+		message.Dead = &dead
+	})
+}
+
+func (repo *inMemoryOutboxRepository) update(id string, change func(message *OutboxMessage)) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, message := range repo.messages {
+		if message.ID == id {
+			change(message)
+			return nil
+		}
+	}
+
+	return errors.New("Could not find outbox message in repository")
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mongoEventStore uses an mgo collection directly, rather than through
+// cfmgo, so that queries are sorted and limited by MongoDB instead of
+// loading every matching event.
+type mongoEventStore struct {
+	Collection *mgo.Collection
+}
+
+// eventRecord keeps the fields events are queried by alongside the JSON of
+// the whole envelope, which is decoded through the event catalogue.
+type eventRecord struct {
+	ID         string    `bson:"_id" json:"id"`
+	Type       string    `bson:"type" json:"type"`
+	Version    int       `bson:"version" json:"version"`
+	OccurredAt time.Time `bson:"occurred_at" json:"occurred_at"`
+	UserID     int64     `bson:"user_id,omitempty" json:"user_id"`
+	SiteID     string    `bson:"site_id,omitempty" json:"site_id"`
+	Envelope   string    `bson:"envelope" json:"envelope"`
+}
+
+func NewMongoEventStore(col *mgo.Collection) *mongoEventStore {
+	for _, key := range [][]string{{"occurred_at"}, {"site_id", "occurred_at"}, {"user_id", "occurred_at"}} {
+		if err := col.EnsureIndexKey(key...); err != nil {
+			fmt.Printf("Failed to index the event store by %v: %v\n", key, err)
+		}
+	}
+
+	return &mongoEventStore{
+		Collection: col,
+	}
+}
+
+// Append upserts by envelope ID, so redelivered events are stored once.
+func (store *mongoEventStore) Append(envelope Envelope) (err error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	record := eventRecord{
+		ID:         envelope.ID,
+		Type:       envelope.Type,
+		Version:    envelope.Version,
+		OccurredAt: envelope.OccurredAt,
+		UserID:     eventUserID(envelope.Payload),
+		SiteID:     eventSiteID(envelope.Payload),
+		Envelope:   string(data),
+	}
+
+	col, session := store.collection()
+	defer session.Close()
+
+	_, err = col.UpsertId(record.ID, record)
+	return
+}
+
+func (store *mongoEventStore) Query(query EventQuery) (envelopes []Envelope, err error) {
+	col, session := store.collection()
+	defer session.Close()
+
+	var records []eventRecord
+	q := col.Find(toEventStoreQuery(query)).Sort("occurred_at", "_id")
+	if query.Limit > 0 {
+		q = q.Limit(query.Limit)
+	}
+
+	if err = q.All(&records); err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		var envelope Envelope
+		if err = json.Unmarshal([]byte(records[i].Envelope), &envelope); err != nil {
+			return nil, err
+		}
+
+		envelopes = append(envelopes, envelope)
+	}
+
+	return envelopes, nil
+}
+
+// collection returns the collection on a copy of its session, which the
+// caller must close.
+func (store *mongoEventStore) collection() (*mgo.Collection, *mgo.Session) {
+	session := store.Collection.Database.Session.Copy()
+	return store.Collection.With(session), session
+}
+
+func toEventStoreQuery(query EventQuery) bson.M {
+	q := bson.M{}
+
+	if query.UserID != 0 {
+		q["user_id"] = query.UserID
+	}
+
+	if len(query.SiteID) != 0 {
+		q["site_id"] = query.SiteID
+	}
+
+	if len(query.Types) != 0 {
+		q["type"] = bson.M{"$in": query.Types}
+	}
+
+	occurredAt := bson.M{}
+	if !query.From.IsZero() {
+		occurredAt["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		occurredAt["$lt"] = query.To
+	}
+	if len(occurredAt) != 0 {
+		q["occurred_at"] = occurredAt
+	}
+
+	return q
+}
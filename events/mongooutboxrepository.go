@@ -0,0 +1,161 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoOutboxRepository struct {
+	Collection cfmgo.Collection
+}
+
+type outboxRecord struct {
+	ID          string     `bson:"_id" json:"id"`
+	Type        string     `bson:"type" json:"type"`
+	Key         string     `bson:"key,omitempty" json:"key"`
+	Payload     string     `bson:"payload" json:"payload"`
+	Created     time.Time  `bson:"date_created" json:"date_created"`
+	Attempts    int        `bson:"attempts" json:"attempts"`
+	LastError   string     `bson:"last_error,omitempty" json:"last_error"`
+	NextAttempt time.Time  `bson:"next_attempt" json:"next_attempt"`
+	Delivered   []string   `bson:"delivered_to" json:"delivered_to"`
+	Dispatched  bool       `bson:"dispatched" json:"dispatched"`
+	DateSent    *time.Time `bson:"date_dispatched,omitempty" json:"date_dispatched"`
+	Dead        bool       `bson:"dead" json:"dead"`
+	DateDead    *time.Time `bson:"date_dead,omitempty" json:"date_dead"`
+}
+
+func NewMongoOutboxRepository(col cfmgo.Collection) *mongoOutboxRepository {
+	return &mongoOutboxRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoOutboxRepository) Add(message *OutboxMessage) (err error) {
+	repo.Collection.Wake()
+	record := toOutboxRecord(message)
+	_, err = repo.Collection.UpsertID(record.ID, record)
+	return
+}
+
+func (repo *mongoOutboxRepository) ListPending(due time.Time, limit int) (messages []*OutboxMessage) {
+	return holdBack(repo.pending(), due, limit)
+}
+
+func (repo *mongoOutboxRepository) NextDue() (due time.Time, pending bool) {
+	return nextDue(repo.pending())
+}
+
+// pending returns the messages that are neither dispatched nor dead, oldest
+// first.
+func (repo *mongoOutboxRepository) pending() (messages []*OutboxMessage) {
+	repo.Collection.Wake()
+	var records []outboxRecord
+	params := &params.RequestParams{
+		Q: bson.M{"dispatched": false, "dead": bson.M{"$ne": true}},
+	}
+
+	if _, err := repo.Collection.Find(params, &records); err != nil {
+		return messages
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+
+	for i := range records {
+		messages = append(messages, toOutboxMessage(&records[i]))
+	}
+
+	return messages
+}
+
+func (repo *mongoOutboxRepository) MarkDelivered(id string, subscriber string) (err error) {
+	return repo.update(id, func(record *outboxRecord) {
+		record.Delivered = append(record.Delivered, subscriber)
+	})
+}
+
+func (repo *mongoOutboxRepository) MarkDispatched(id string, dispatched time.Time) (err error) {
+	return repo.update(id, func(record *outboxRecord) {
+		record.Attempts++
+		record.Dispatched = true
+		record.DateSent = &dispatched
+	})
+}
+
+func (repo *mongoOutboxRepository) RecordFailure(id string, failure error, nextAttempt time.Time) (err error) {
+	return repo.update(id, func(record *outboxRecord) {
+		record.Attempts++
+		record.LastError = failure.Error()
+		record.NextAttempt = nextAttempt
+	})
+}
+
+func (repo *mongoOutboxRepository) MarkDead(id string, failure error, dead time.Time) (err error) {
+	return repo.update(id, func(record *outboxRecord) {
+		record.Attempts++
+		record.LastError = failure.Error()
+		record.Dead = true
+		record.DateDead = &dead
+	})
+}
+
+func (repo *mongoOutboxRepository) update(id string, change func(record *outboxRecord)) (err error) {
+	repo.Collection.Wake()
+	var records []outboxRecord
+	params := &params.RequestParams{
+		Q: bson.M{"_id": id},
+	}
+
+	count, err := repo.Collection.Find(params, &records)
+	if count == 0 {
+		err = errors.New("Could not find outbox message in repository")
+	}
+	if err == nil {
+		change(&records[0])
+		_, err = repo.Collection.UpsertID(id, records[0])
+	}
+
+	return
+}
+
+func toOutboxRecord(message *OutboxMessage) *outboxRecord {
+	return &outboxRecord{
+		ID:          message.ID,
+		Type:        message.Type,
+		Key:         message.Key,
+		Payload:     string(message.Payload),
+		Created:     message.Created,
+		Attempts:    message.Attempts,
+		LastError:   message.LastError,
+		NextAttempt: message.NextAttempt,
+		Delivered:   message.Delivered,
+		Dispatched:  message.Dispatched != nil,
+		DateSent:    message.Dispatched,
+		Dead:        message.Dead != nil,
+		DateDead:    message.Dead,
+	}
+}
+
+func toOutboxMessage(record *outboxRecord) *OutboxMessage {
+	return &OutboxMessage{
+		ID:          record.ID,
+		Type:        record.Type,
+		Key:         record.Key,
+		Payload:     json.RawMessage(record.Payload),
+		Created:     record.Created,
+		Attempts:    record.Attempts,
+		LastError:   record.LastError,
+		NextAttempt: record.NextAttempt,
+		Delivered:   record.Delivered,
+		Dispatched:  record.DateSent,
+		Dead:        record.DateDead,
+	}
+}
@@ -0,0 +1,197 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/spear-wind/cms/security"
+)
+
+var (
+	eventTypes      = map[string]reflect.Type{}
+	eventTypesMutex sync.RWMutex
+)
+
+// OutboxRepository stores events until an OutboxPublisher has delivered them.
+type OutboxRepository interface {
+	Add(message *OutboxMessage) (err error)
+	// ListPending returns messages that are neither dispatched nor dead and
+	// are due by due, oldest first. A message is left out while an older
+	// message with the same Key is waiting for its next attempt, so that
+	// messages about the same aggregate are delivered in order.
+	ListPending(due time.Time, limit int) (messages []*OutboxMessage)
+	// NextDue returns the earliest time a message that is neither
+	// dispatched nor dead is due, and false when there are none.
+	NextDue() (due time.Time, pending bool)
+	// MarkDelivered records that subscriber has received the message, so
+	// that it isn't sent to it again when another subscriber is retried.
+	MarkDelivered(id string, subscriber string) (err error)
+	MarkDispatched(id string, dispatched time.Time) (err error)
+	// RecordFailure counts a failed attempt and holds the message back until
+	// nextAttempt.
+	RecordFailure(id string, failure error, nextAttempt time.Time) (err error)
+	// MarkDead counts a failed attempt and stops the message from being
+	// tried again; it stays in the outbox for an operator to look at.
+	MarkDead(id string, failure error, dead time.Time) (err error)
+}
+
+// OutboxMessage is a stored event waiting to be delivered.
+type OutboxMessage struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Key names the aggregate the event concerns, such as a site. Messages
+	// with the same Key are delivered in the order they were saved; messages
+	// without one are delivered in any order.
+	Key       string          `json:"key,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Created   time.Time       `json:"date_created"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"last_error,omitempty"`
+	// NextAttempt is when a failed message is retried.
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	// Delivered names the subscribers that have received the message.
+	Delivered  []string   `json:"delivered_to,omitempty"`
+	Dispatched *time.Time `json:"date_dispatched,omitempty"`
+	// Dead is when the message was given up on after too many attempts.
+	Dead *time.Time `json:"date_dead,omitempty"`
+}
+
+// pending reports whether the message is still to be delivered.
+func (message *OutboxMessage) pending() bool {
+	return message.Dispatched == nil && message.Dead == nil
+}
+
+// holdBack filters messages, oldest first, down to those due by due, leaving
+// out any with the same Key as an earlier message that isn't due yet.
+func holdBack(messages []*OutboxMessage, due time.Time, limit int) (ready []*OutboxMessage) {
+	waiting := make(map[string]bool)
+
+	for _, message := range messages {
+		if len(ready) == limit {
+			break
+		}
+
+		if len(message.Key) != 0 && waiting[message.Key] {
+			continue
+		}
+
+		if message.NextAttempt.After(due) {
+			if len(message.Key) != 0 {
+				waiting[message.Key] = true
+			}
+			continue
+		}
+
+		ready = append(ready, message)
+	}
+
+	return ready
+}
+
+// nextDue returns the earliest NextAttempt of messages.
+func nextDue(messages []*OutboxMessage) (due time.Time, pending bool) {
+	for _, message := range messages {
+		if !pending || message.NextAttempt.Before(due) {
+			due, pending = message.NextAttempt, true
+		}
+	}
+
+	return due, pending
+}
+
+// RegisterEventType allows events of the same type as prototype to be stored
+// in the outbox. Event types are registered by the package that defines them.
+func RegisterEventType(prototype interface{}) {
+	t := reflect.TypeOf(prototype)
+
+	eventTypesMutex.Lock()
+	defer eventTypesMutex.Unlock()
+
+	eventTypes[t.String()] = t
+}
+
+// NewOutboxMessage serializes event so it can be saved alongside the change
+// that caused it.
+func NewOutboxMessage(event interface{}) (*OutboxMessage, error) {
+	value := reflect.ValueOf(event)
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+
+	typeName := value.Type().String()
+
+	eventTypesMutex.RLock()
+	_, ok := eventTypes[typeName]
+	eventTypesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Event type %s is not registered with the outbox", typeName)
+	}
+
+	payload, err := json.Marshal(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := security.GenerateRandomString(16)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxMessage{
+		ID:      id,
+		Type:    typeName,
+		Key:     outboxKey(event),
+		Payload: payload,
+		Created: time.Now(),
+	}, nil
+}
+
+// outboxKey keys envelopes by the site or user their event concerns.
+func outboxKey(event interface{}) string {
+	envelope, ok := event.(Envelope)
+	if !ok {
+		return ""
+	}
+
+	if siteID := eventSiteID(envelope.Payload); len(siteID) != 0 {
+		return "site:" + siteID
+	}
+
+	if userID := eventUserID(envelope.Payload); userID != 0 {
+		return fmt.Sprintf("user:%d", userID)
+	}
+
+	return ""
+}
+
+// Event decodes the stored event.
+func (message *OutboxMessage) Event() (interface{}, error) {
+	eventTypesMutex.RLock()
+	t, ok := eventTypes[message.Type]
+	eventTypesMutex.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Event type %s is not registered with the outbox", message.Type)
+	}
+
+	event := reflect.New(t)
+	if err := json.Unmarshal(message.Payload, event.Interface()); err != nil {
+		return nil, err
+	}
+
+	return event.Elem().Interface(), nil
+}
+
+// SaveToOutbox stores event for delivery by an OutboxPublisher.
+func SaveToOutbox(repository OutboxRepository, event interface{}) error {
+	message, err := NewOutboxMessage(event)
+	if err != nil {
+		return err
+	}
+
+	return repository.Add(message)
+}
@@ -0,0 +1,384 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OutboxOptions configures an OutboxPublisher.
+type OutboxOptions struct {
+	// PollInterval is how often the outbox is checked for new messages.
+	PollInterval time.Duration
+	// BatchSize is how many messages are delivered per poll.
+	BatchSize int
+	// Workers is how many messages of a batch are delivered concurrently.
+	Workers int
+	// MaxAttempts is how many times a failing message is tried before it is
+	// marked dead and left in the outbox for an operator to look at.
+	MaxAttempts int
+	// Backoff is the wait before a failed message is retried; it doubles on
+	// each retry, up to maxOutboxBackoff.
+	Backoff time.Duration
+}
+
+// DefaultOutboxOptions are used for any OutboxOptions field left at zero.
+var DefaultOutboxOptions = OutboxOptions{
+	PollInterval: time.Second,
+	BatchSize:    100,
+	Workers:      4,
+	MaxAttempts:  10,
+	Backoff:      time.Second,
+}
+
+const maxOutboxBackoff = time.Hour
+
+// OutboxPublisher saves published events to an OutboxRepository and relays
+// them to its subscribers in the background. Because the outbox outlives the
+// process, events saved before a crash or restart are delivered once the
+// relay runs again.
+//
+// Delivery is at least once. A subscriber that fails is retried with
+// exponential backoff, without sending the message again to the subscribers
+// that already have it; a message is also redelivered if the process stops
+// before its delivery is recorded. Deliveries are recorded against the name
+// each subscriber is added with, so names must stay the same across
+// restarts.
+//
+// Messages with the same Key are delivered one at a time, in order; a
+// message waiting to be retried holds back those saved after it until it is
+// delivered or marked dead.
+type OutboxPublisher struct {
+	repository OutboxRepository
+	options    OutboxOptions
+
+	subscribersMutex sync.RWMutex
+	subscribers      []namedSubscriber
+
+	dispatchMutex sync.Mutex
+	wake          chan struct{}
+	now           func() time.Time
+
+	stateMutex sync.Mutex
+	running    bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+type namedSubscriber struct {
+	name       string
+	subscriber EventSubscriber
+}
+
+func NewOutboxPublisher(repository OutboxRepository, options OutboxOptions) *OutboxPublisher {
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultOutboxOptions.PollInterval
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultOutboxOptions.BatchSize
+	}
+	if options.Workers <= 0 {
+		options.Workers = DefaultOutboxOptions.Workers
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultOutboxOptions.MaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultOutboxOptions.Backoff
+	}
+
+	return &OutboxPublisher{
+		repository: repository,
+		options:    options,
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// Publish saves the event to the outbox and returns without waiting for it
+// to be delivered.
+func (p *OutboxPublisher) Publish(e interface{}) {
+	if err := p.Save(e); err != nil {
+		fmt.Printf("Failed to save %T to the outbox: %v\n", e, err)
+	}
+}
+
+// Save is Publish that returns the error when the event can't be saved.
+func (p *OutboxPublisher) Save(e interface{}) error {
+	if err := SaveToOutbox(p.repository, e); err != nil {
+		return err
+	}
+
+	// Wake the relay rather than leaving the event for the next poll.
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Add adds s named after its type. Use AddNamed to add more than one
+// subscriber of a type.
+func (p *OutboxPublisher) Add(s EventSubscriber) {
+	p.AddNamed(fmt.Sprintf("%T", s), s)
+}
+
+// AddNamed adds s under name, which its deliveries are recorded against.
+// It panics if name is already taken.
+func (p *OutboxPublisher) AddNamed(name string, s EventSubscriber) {
+	p.subscribersMutex.Lock()
+	defer p.subscribersMutex.Unlock()
+
+	for _, subscriber := range p.subscribers {
+		if subscriber.name == name {
+			panic(fmt.Sprintf("An outbox subscriber named %s has already been added", name))
+		}
+	}
+
+	p.subscribers = append(p.subscribers, namedSubscriber{name: name, subscriber: s})
+}
+
+// Subscriber returns the subscriber added under name, for example to replay
+// stored events into it.
+func (p *OutboxPublisher) Subscriber(name string) (s EventSubscriber, ok bool) {
+	p.subscribersMutex.RLock()
+	defer p.subscribersMutex.RUnlock()
+
+	for _, subscriber := range p.subscribers {
+		if subscriber.name == name {
+			return subscriber.subscriber, true
+		}
+	}
+
+	return nil, false
+}
+
+// Start begins relaying messages from the outbox, starting with any left
+// over from a previous run.
+func (p *OutboxPublisher) Start() {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+
+	if p.running {
+		return
+	}
+
+	p.running = true
+	p.stop = make(chan struct{})
+	p.stopped = make(chan struct{})
+	stop, stopped := p.stop, p.stopped
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(p.options.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			p.DispatchPending()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			case <-p.wake:
+			}
+		}
+	}()
+}
+
+// DispatchPending delivers one batch of pending messages on the publisher's
+// workers and returns how many were dispatched to every subscriber. Messages
+// with the same Key are delivered in order by a single worker.
+func (p *OutboxPublisher) DispatchPending() (dispatched int) {
+	p.dispatchMutex.Lock()
+	defer p.dispatchMutex.Unlock()
+
+	p.subscribersMutex.RLock()
+	subscribers := make([]namedSubscriber, len(p.subscribers))
+	copy(subscribers, p.subscribers)
+	p.subscribersMutex.RUnlock()
+
+	partitions := partition(p.repository.ListPending(p.now(), p.options.BatchSize))
+	queue := make(chan []*OutboxMessage, len(partitions))
+	for _, messages := range partitions {
+		queue <- messages
+	}
+	close(queue)
+
+	var workers sync.WaitGroup
+	var countMutex sync.Mutex
+
+	for i := 0; i < p.options.Workers && i < len(partitions); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			for messages := range queue {
+				for _, message := range messages {
+					// Later messages wait until this one is delivered.
+					if !p.dispatch(message, subscribers) {
+						break
+					}
+
+					countMutex.Lock()
+					dispatched++
+					countMutex.Unlock()
+				}
+			}
+		}()
+	}
+
+	workers.Wait()
+	return dispatched
+}
+
+// partition groups messages by Key, keeping their order. Messages without a
+// Key are each a partition of their own.
+func partition(messages []*OutboxMessage) (partitions [][]*OutboxMessage) {
+	byKey := make(map[string]int)
+
+	for _, message := range messages {
+		if len(message.Key) != 0 {
+			if i, ok := byKey[message.Key]; ok {
+				partitions[i] = append(partitions[i], message)
+				continue
+			}
+
+			byKey[message.Key] = len(partitions)
+		}
+
+		partitions = append(partitions, []*OutboxMessage{message})
+	}
+
+	return partitions
+}
+
+// dispatch delivers message to each subscriber that doesn't have it yet, and
+// reports whether they all have it now. A message that fails its last
+// attempt is marked dead.
+func (p *OutboxPublisher) dispatch(message *OutboxMessage, subscribers []namedSubscriber) bool {
+	failed := p.deliver(message, subscribers)
+	if failed != nil {
+		attempts := message.Attempts + 1
+		if attempts >= p.options.MaxAttempts {
+			fmt.Printf("Outbox message %s (%s) is dead after %d attempts: %v\n", message.ID, message.Type, attempts, failed)
+			if err := p.repository.MarkDead(message.ID, failed, p.now()); err != nil {
+				fmt.Printf("Failed to mark outbox message %s dead: %v\n", message.ID, err)
+			}
+			return false
+		}
+
+		fmt.Printf("Failed to dispatch outbox message %s (%s): %v\n", message.ID, message.Type, failed)
+		if err := p.repository.RecordFailure(message.ID, failed, p.now().Add(p.backoff(attempts))); err != nil {
+			fmt.Printf("Failed to record outbox failure for %s: %v\n", message.ID, err)
+		}
+		return false
+	}
+
+	if err := p.repository.MarkDispatched(message.ID, p.now()); err != nil {
+		fmt.Printf("Failed to mark outbox message %s dispatched: %v\n", message.ID, err)
+		return false
+	}
+
+	return true
+}
+
+// backoff is the wait after the given number of failed attempts.
+func (p *OutboxPublisher) backoff(attempts int) time.Duration {
+	backoff := p.options.Backoff
+	for i := 1; i < attempts && backoff < maxOutboxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxOutboxBackoff {
+		return maxOutboxBackoff
+	}
+
+	return backoff
+}
+
+func (p *OutboxPublisher) deliver(message *OutboxMessage, subscribers []namedSubscriber) error {
+	event, err := message.Event()
+	if err != nil {
+		return err
+	}
+
+	delivered := make(map[string]bool)
+	for _, name := range message.Delivered {
+		delivered[name] = true
+	}
+
+	var failed error
+	for _, subscriber := range subscribers {
+		if delivered[subscriber.name] {
+			continue
+		}
+
+		if err := receive(subscriber.subscriber, event); err != nil {
+			failed = fmt.Errorf("%s: %v", subscriber.name, err)
+			continue
+		}
+
+		// If this isn't recorded, the subscriber gets the message again on
+		// the next poll.
+		if err := p.repository.MarkDelivered(message.ID, subscriber.name); err != nil {
+			failed = fmt.Errorf("Failed to record delivery to %s: %v", subscriber.name, err)
+		}
+	}
+
+	return failed
+}
+
+// Shutdown stops the relay after delivering what is already in the outbox,
+// retrying failed messages as their backoff allows, or when ctx is done.
+// Anything left over is delivered after the next Start.
+func (p *OutboxPublisher) Shutdown(ctx context.Context) error {
+	p.stateMutex.Lock()
+	running, stopped := p.running, p.stopped
+	if running {
+		p.running = false
+		close(p.stop)
+	}
+	p.stateMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		if running {
+			<-stopped
+		}
+		p.drain(ctx)
+	}()
+
+	select {
+	case <-drained:
+		return ctx.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain dispatches pending messages, waiting out the backoff of any that
+// fail, until none are left or ctx is done.
+func (p *OutboxPublisher) drain(ctx context.Context) {
+	for {
+		p.DispatchPending()
+
+		due, pending := p.repository.NextDue()
+		if !pending {
+			return
+		}
+
+		timer := time.NewTimer(due.Sub(p.now()))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
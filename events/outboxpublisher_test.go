@@ -0,0 +1,302 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type unregisteredEvent struct{}
+
+func TestOutboxMessageRoundTripsEmails(t *testing.T) {
+	email := NewVerificationEmail(Recipient{Email: "test@spearwind.io", Locale: "es"}, "ABC123")
+	message, err := NewOutboxMessage(email)
+	if err != nil {
+		t.Fatalf("NewOutboxMessage returned an unexpected error: %v", err)
+	}
+
+	decoded, err := message.Event()
+	if err != nil {
+		t.Fatalf("message.Event returned an unexpected error: %v", err)
+	}
+
+	if decoded != email {
+		t.Errorf("Expected the email to survive the outbox, got %#v", decoded)
+	}
+
+	if _, err := NewOutboxMessage(unregisteredEvent{}); err == nil {
+		t.Error("Expected an unregistered event type to be rejected")
+	}
+}
+
+func TestOutboxPublisherDeliversAfterRestart(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+
+	// Events saved by a process that stopped before relaying them...
+	NewOutboxPublisher(repo, OutboxOptions{}).Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	// ...are delivered by the next one.
+	subscriber := &countingSubscriber{}
+	publisher := NewOutboxPublisher(repo, OutboxOptions{PollInterval: time.Millisecond})
+	publisher.Add(subscriber)
+	publisher.Start()
+
+	if err := publisher.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned an unexpected error: %v", err)
+	}
+
+	if subscriber.count() != 1 {
+		t.Errorf("Expected the stored event to be delivered once, got %d", subscriber.count())
+	}
+
+	if pending := repo.ListPending(time.Now(), 10); len(pending) != 0 {
+		t.Errorf("Expected no pending messages, got %d", len(pending))
+	}
+}
+
+func TestOutboxPublisherRetriesFailedMessages(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	flaky := &countingSubscriber{failures: 1}
+	publisher := NewOutboxPublisher(repo, OutboxOptions{MaxAttempts: 2})
+	publisher.Add(flaky)
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	if dispatched := publisher.DispatchPending(); dispatched != 0 {
+		t.Fatalf("Expected the first delivery to fail, got %d dispatched", dispatched)
+	}
+
+	pending := repo.ListPending(time.Now().Add(time.Hour), 10)
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].LastError == "" {
+		t.Fatalf("Expected the failure to be recorded on the message, got %+v", pending)
+	}
+
+	if dispatched := publisher.DispatchPending(); dispatched != 0 || flaky.count() != 0 {
+		t.Fatalf("Expected the message to be held back until its next attempt, got %d dispatched", dispatched)
+	}
+
+	publisher.now = func() time.Time { return pending[0].NextAttempt }
+	if dispatched := publisher.DispatchPending(); dispatched != 1 || flaky.count() != 1 {
+		t.Errorf("Expected the retry to deliver the message, got %d dispatched", dispatched)
+	}
+}
+
+func TestOutboxPublisherOnlyRetriesFailedSubscribers(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	healthy := &countingSubscriber{}
+	flaky := &countingSubscriber{failures: 1}
+	publisher := NewOutboxPublisher(repo, OutboxOptions{})
+	publisher.AddNamed("healthy", healthy)
+	publisher.AddNamed("flaky", flaky)
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	if dispatched := publisher.DispatchPending(); dispatched != 0 {
+		t.Fatalf("Expected the first delivery to fail, got %d dispatched", dispatched)
+	}
+
+	publisher.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if dispatched := publisher.DispatchPending(); dispatched != 1 {
+		t.Fatalf("Expected the retry to deliver the message, got %d dispatched", dispatched)
+	}
+
+	if healthy.count() != 1 || flaky.count() != 1 {
+		t.Errorf("Expected each subscriber to get the message once, got %d and %d", healthy.count(), flaky.count())
+	}
+}
+
+func TestOutboxPublisherDeliversConcurrently(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	slow := &countingSubscriber{delay: 50 * time.Millisecond}
+	broken := &countingSubscriber{panics: true}
+	publisher := NewOutboxPublisher(repo, OutboxOptions{Workers: 4})
+	publisher.AddNamed("broken", broken)
+	publisher.AddNamed("slow", slow)
+
+	for i := 0; i < 4; i++ {
+		publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+	}
+
+	start := time.Now()
+	publisher.DispatchPending()
+
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected the four messages to be delivered side by side, took %v", elapsed)
+	}
+
+	if slow.count() != 4 {
+		t.Errorf("Expected a panicking subscriber not to affect the others, got %d events", slow.count())
+	}
+}
+
+func TestOutboxPublisherBacksOffExponentially(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	publisher := NewOutboxPublisher(repo, OutboxOptions{Backoff: time.Minute})
+	publisher.Add(&countingSubscriber{failures: 2})
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	now := time.Now()
+	publisher.now = func() time.Time { return now }
+
+	for attempt, backoff := range []time.Duration{time.Minute, 2 * time.Minute} {
+		publisher.DispatchPending()
+
+		due, pending := repo.NextDue()
+		if !pending || !due.Equal(now.Add(backoff)) {
+			t.Fatalf("Expected attempt %d to be retried after %v, got %v", attempt+1, backoff, due.Sub(now))
+		}
+
+		now = due
+	}
+}
+
+func TestOutboxPublisherDeliversWithoutBlocking(t *testing.T) {
+	slow := &countingSubscriber{delay: 50 * time.Millisecond}
+	publisher := NewOutboxPublisher(NewInMemoryOutboxRepository(), OutboxOptions{Workers: 2})
+	publisher.Add(slow)
+	publisher.Start()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+	}
+
+	if elapsed := time.Since(start); elapsed > 25*time.Millisecond {
+		t.Errorf("Expected Publish to return immediately, took %v", elapsed)
+	}
+
+	if err := publisher.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned an unexpected error: %v", err)
+	}
+
+	if slow.count() != 4 {
+		t.Errorf("Expected Shutdown to drain all four events, got %d", slow.count())
+	}
+}
+
+func TestOutboxPublisherRetriesEachSubscriber(t *testing.T) {
+	flaky := &countingSubscriber{failures: 2}
+	healthy := &countingSubscriber{}
+	broken := &countingSubscriber{panics: true}
+
+	publisher := NewOutboxPublisher(NewInMemoryOutboxRepository(), OutboxOptions{Workers: 1, MaxAttempts: 3, Backoff: time.Millisecond})
+	publisher.AddNamed("broken", broken)
+	publisher.AddNamed("flaky", flaky)
+	publisher.AddNamed("healthy", healthy)
+
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+	publisher.Shutdown(context.Background())
+
+	if flaky.count() != 1 {
+		t.Errorf("Expected the flaky subscriber to succeed on its third attempt, got %d events", flaky.count())
+	}
+
+	if healthy.count() != 1 {
+		t.Errorf("Expected a panicking subscriber not to affect the others, got %d events", healthy.count())
+	}
+}
+
+func TestOutboxPublisherShutdownHonoursContext(t *testing.T) {
+	stuck := &countingSubscriber{delay: time.Second}
+	publisher := NewOutboxPublisher(NewInMemoryOutboxRepository(), OutboxOptions{Workers: 1})
+	publisher.Add(stuck)
+	publisher.Start()
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := publisher.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected Shutdown to give up when the context expires, got %v", err)
+	}
+}
+
+func TestOutboxPublisherMarksMessagesDead(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	publisher := NewOutboxPublisher(repo, OutboxOptions{MaxAttempts: 2})
+	publisher.Add(&countingSubscriber{panics: true})
+	publisher.Publish(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, "ABC123"))
+
+	publisher.DispatchPending()
+	publisher.now = func() time.Time { return time.Now().Add(time.Hour) }
+	publisher.DispatchPending()
+
+	if _, pending := repo.NextDue(); pending {
+		t.Fatal("Expected a message that failed its last attempt to stop being retried")
+	}
+
+	if message := repo.messages[0]; message.Dead == nil || message.Attempts != 2 || message.LastError == "" {
+		t.Errorf("Expected the message to be kept and marked dead, got %+v", message)
+	}
+}
+
+// sequenceSubscriber records the verification codes it receives, in order.
+type sequenceSubscriber struct {
+	countingSubscriber
+	codes []string
+}
+
+func (s *sequenceSubscriber) Receive(e interface{}) error {
+	if err := s.countingSubscriber.Receive(e); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.codes = append(s.codes, e.(VerificationEmail).VerificationCode)
+	return nil
+}
+
+func TestOutboxPublisherDeliversEachKeyInOrder(t *testing.T) {
+	repo := NewInMemoryOutboxRepository()
+	subscriber := &sequenceSubscriber{countingSubscriber: countingSubscriber{failures: 1}}
+	publisher := NewOutboxPublisher(repo, OutboxOptions{Workers: 4})
+	publisher.Add(subscriber)
+
+	for _, code := range []string{"1", "2", "3"} {
+		message, _ := NewOutboxMessage(NewVerificationEmail(Recipient{Email: "test@spearwind.io"}, code))
+		message.Key = "site:1"
+		repo.Add(message)
+	}
+
+	if dispatched := publisher.DispatchPending(); dispatched != 0 {
+		t.Fatalf("Expected the messages after a failed one to wait for it, got %d dispatched", dispatched)
+	}
+
+	if pending := repo.ListPending(time.Now(), 10); len(pending) != 0 {
+		t.Fatalf("Expected the failed message to hold back the rest of its key, got %d pending", len(pending))
+	}
+
+	publisher.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if dispatched := publisher.DispatchPending(); dispatched != 3 {
+		t.Fatalf("Expected all three messages to be dispatched after the retry, got %d", dispatched)
+	}
+
+	if codes := subscriber.codes; len(codes) != 3 || codes[0] != "1" || codes[1] != "2" || codes[2] != "3" {
+		t.Errorf("Expected the messages to be delivered in the order they were saved, got %v", codes)
+	}
+}
+
+func TestOutboxPublisherKeysEnvelopesByAggregate(t *testing.T) {
+	envelope, _ := NewEnvelope(testSiteEvent{SiteID: "site-1"}, nil)
+	if key := outboxKey(envelope); key != "site:site-1" {
+		t.Errorf("Expected a site event to be keyed by its site, got %q", key)
+	}
+
+	envelope, _ = NewEnvelope(testUserEvent{UserID: 7}, nil)
+	if key := outboxKey(envelope); key != "user:7" {
+		t.Errorf("Expected a user event to be keyed by its user, got %q", key)
+	}
+}
+
+func TestOutboxPublisherRejectsDuplicateNames(t *testing.T) {
+	publisher := NewOutboxPublisher(NewInMemoryOutboxRepository(), OutboxOptions{})
+	publisher.Add(&countingSubscriber{})
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a second subscriber with the same name to be rejected")
+		}
+	}()
+
+	publisher.Add(&countingSubscriber{})
+}
@@ -1,22 +1,26 @@
 package events
 
+import "fmt"
+
 type synchronousEventPublisher struct {
 	subscribers []EventSubscriber
 }
 
-func (p synchronousEventPublisher) Publish(e interface{}) {
+func (p *synchronousEventPublisher) Publish(e interface{}) {
 	for _, subscriber := range p.subscribers {
-		subscriber.Receive(e)
+		if err := subscriber.Receive(e); err != nil {
+			fmt.Printf("Event subscriber %T failed to receive %T: %v\n", subscriber, e, err)
+		}
 	}
 }
 
-func (p synchronousEventPublisher) Add(s EventSubscriber) {
+func (p *synchronousEventPublisher) Add(s EventSubscriber) {
 	p.subscribers = append(p.subscribers, s)
 }
 
-//NewSynchEventPublisher returns a simple, synchronous EventPublisher
+// NewSynchEventPublisher returns a simple, synchronous EventPublisher
 func NewSynchEventPublisher() EventPublisher {
-	return synchronousEventPublisher{
+	return &synchronousEventPublisher{
 		subscribers: []EventSubscriber{},
 	}
 }
@@ -0,0 +1,54 @@
+package events
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingSubscriber struct {
+	mutex    sync.Mutex
+	received []interface{}
+	failures int
+	panics   bool
+	delay    time.Duration
+}
+
+func (s *countingSubscriber) Receive(e interface{}) error {
+	time.Sleep(s.delay)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.panics {
+		panic("subscriber exploded")
+	}
+
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("temporarily unavailable")
+	}
+
+	s.received = append(s.received, e)
+	return nil
+}
+
+func (s *countingSubscriber) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.received)
+}
+
+func TestSynchEventPublisherKeepsSubscribers(t *testing.T) {
+	subscriber := &countingSubscriber{}
+	publisher := NewSynchEventPublisher()
+	publisher.Add(subscriber)
+
+	publisher.Publish("event")
+
+	if subscriber.count() != 1 {
+		t.Errorf("Expected the added subscriber to receive the event, got %d events", subscriber.count())
+	}
+}
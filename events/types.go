@@ -1,10 +1,44 @@
 package events
 
+import "fmt"
+
 type EventPublisher interface {
 	Publish(event interface{})
 	Add(s EventSubscriber)
 }
 
+// EventSaver is implemented by publishers that store events before
+// delivering them, such as OutboxPublisher. Save reports whether the event
+// was stored, where Publish only logs a failure.
+type EventSaver interface {
+	Save(event interface{}) error
+}
+
+// Save publishes event, returning the error if publisher is an EventSaver
+// that failed to store it. Use it for events the request can't do without,
+// such as the email with a verification code.
+func Save(publisher EventPublisher, event interface{}) error {
+	if saver, ok := publisher.(EventSaver); ok {
+		return saver.Save(event)
+	}
+
+	publisher.Publish(event)
+	return nil
+}
+
+// EventSubscriber receives published events. Publishers that retry treat a
+// returned error as a failed delivery.
 type EventSubscriber interface {
-	Receive(event interface{})
+	Receive(event interface{}) error
+}
+
+// receive converts a panicking subscriber into a failed delivery.
+func receive(subscriber EventSubscriber, e interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return subscriber.Receive(e)
 }
@@ -0,0 +1,173 @@
+package facebook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// GraphURL is the Graph API that access tokens are checked against.
+const GraphURL = "https://graph.facebook.com"
+
+// SignedRequestMaxAge is how old a signed_request can be, so that one that
+// has leaked can't be replayed later. The SDK issues a fresh one with each
+// login.
+const SignedRequestMaxAge = 5 * time.Minute
+
+var (
+	ErrNotConfigured        = errors.New("Facebook login is not configured")
+	ErrInvalidSignedRequest = errors.New("Invalid Facebook signed request")
+	ErrInvalidAccessToken   = errors.New("Invalid Facebook access token")
+	ErrUserMismatch         = errors.New("Facebook credentials are for a different user")
+)
+
+// signedRequest is the payload of the signed_request that Facebook's SDK
+// hands the client with the access token.
+type signedRequest struct {
+	Algorithm string `json:"algorithm"`
+	IssuedAt  int64  `json:"issued_at"`
+	UserID    string `json:"user_id"`
+}
+
+type debugTokenResponse struct {
+	Data struct {
+		AppID     string `json:"app_id"`
+		IsValid   bool   `json:"is_valid"`
+		ExpiresAt int64  `json:"expires_at"`
+		UserID    string `json:"user_id"`
+	} `json:"data"`
+}
+
+type meResponse struct {
+	ID        string `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Verified  bool   `json:"verified"`
+}
+
+// parseSignedRequest checks the HMAC-SHA256 signature of a signed_request,
+// which is "<signature>.<payload>", both base64url encoded, and that it was
+// issued within SignedRequestMaxAge either way of now, allowing for clock
+// skew. It returns its payload.
+func parseSignedRequest(value string, appSecret string) (*signedRequest, error) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	signature, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	mac := hmac.New(sha256.New, []byte(appSecret))
+	mac.Write([]byte(parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	var request signedRequest
+	if err := json.Unmarshal(payload, &request); err != nil || !strings.EqualFold(request.Algorithm, "HMAC-SHA256") {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	if age := time.Since(time.Unix(request.IssuedAt, 0)); age > SignedRequestMaxAge || age < -SignedRequestMaxAge {
+		return nil, ErrInvalidSignedRequest
+	}
+
+	return &request, nil
+}
+
+// decodeSegment decodes base64url with or without padding.
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+}
+
+// debugToken asks Facebook whether accessToken is a valid, unexpired token
+// issued to our app for userID, so that a token from another app can't be
+// used to log in here.
+func (c *facebookClient) debugToken(accessToken string, userID string) error {
+	query := url.Values{}
+	query.Set("input_token", accessToken)
+	query.Set("access_token", c.clientID+"|"+c.clientSecret)
+
+	var res debugTokenResponse
+	if err := c.get("/debug_token", query, &res); err != nil {
+		return err
+	}
+
+	token := res.Data
+	if !token.IsValid || token.AppID != c.clientID {
+		return ErrInvalidAccessToken
+	}
+
+	// Tokens that never expire have an expires_at of 0.
+	if token.ExpiresAt != 0 && time.Now().Unix() >= token.ExpiresAt {
+		return ErrInvalidAccessToken
+	}
+
+	if token.UserID != userID {
+		return ErrUserMismatch
+	}
+
+	return nil
+}
+
+// me fetches the profile of the user accessToken belongs to. The
+// appsecret_proof shows the request comes from our server.
+func (c *facebookClient) me(accessToken string) (*meResponse, error) {
+	mac := hmac.New(sha256.New, []byte(c.clientSecret))
+	mac.Write([]byte(accessToken))
+
+	query := url.Values{}
+	query.Set("fields", "id,first_name,last_name,email,verified")
+	query.Set("access_token", accessToken)
+	query.Set("appsecret_proof", hex.EncodeToString(mac.Sum(nil)))
+
+	var res meResponse
+	if err := c.get("/me", query, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+func (c *facebookClient) get(path string, query url.Values, v interface{}) error {
+	res, err := c.httpClient.Get(c.graphURL + path + "?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("Failed to reach Facebook: %v", err)
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("Failed to read Facebook response: %v", err)
+	}
+
+	// Graph API errors are 4xx responses; the details are not passed on
+	// to clients.
+	if res.StatusCode != http.StatusOK {
+		return ErrInvalidAccessToken
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Failed to parse Facebook response: %v", err)
+	}
+
+	return nil
+}
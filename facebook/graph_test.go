@@ -0,0 +1,186 @@
+package facebook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testAppID     = "app"
+	testAppSecret = "secret"
+)
+
+// fakeGraph stands in for the Graph API's debug_token and /me endpoints.
+type fakeGraph struct {
+	appID     string
+	userID    string
+	valid     bool
+	expiresAt int64
+	me        meResponse
+}
+
+func newFakeGraph() *fakeGraph {
+	return &fakeGraph{
+		appID:     testAppID,
+		userID:    "987",
+		valid:     true,
+		expiresAt: time.Now().Add(time.Hour).Unix(),
+		me:        meResponse{ID: "987", FirstName: "Test", LastName: "User", Email: "testuser@spearwind.io", Verified: true},
+	}
+}
+
+func (graph *fakeGraph) serve(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+
+		switch req.URL.Path {
+		case "/debug_token":
+			if query.Get("access_token") != testAppID+"|"+testAppSecret {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var res debugTokenResponse
+			res.Data.AppID = graph.appID
+			res.Data.IsValid = graph.valid
+			res.Data.ExpiresAt = graph.expiresAt
+			res.Data.UserID = graph.userID
+			json.NewEncoder(w).Encode(res)
+		case "/me":
+			mac := hmac.New(sha256.New, []byte(testAppSecret))
+			mac.Write([]byte(query.Get("access_token")))
+			if query.Get("appsecret_proof") != hex.EncodeToString(mac.Sum(nil)) {
+				t.Errorf("Expected an appsecret_proof for the access token")
+			}
+
+			json.NewEncoder(w).Encode(graph.me)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func signRequest(secret string, payload signedRequest) string {
+	body, _ := json.Marshal(payload)
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)) + "." + encoded
+}
+
+func validCommand() loginCommand {
+	return loginCommand{
+		AccessToken:   "abc123",
+		ExpiresIn:     123,
+		SignedRequest: signRequest(testAppSecret, signedRequest{Algorithm: "HMAC-SHA256", UserID: "987", IssuedAt: time.Now().Unix()}),
+		UserID:        "987",
+	}
+}
+
+func TestGetProfileChecksCredentialsWithGraphAPI(t *testing.T) {
+	graph := newFakeGraph()
+	server := graph.serve(t)
+	defer server.Close()
+
+	profile, err := newClient(testAppID, testAppSecret, server.URL).getProfile(validCommand())
+	if err != nil {
+		t.Fatalf("Unexpected error getting profile: %v", err)
+	}
+
+	if profile.Subject != "987" || profile.Email != "testuser@spearwind.io" || !profile.EmailVerified || profile.FirstName != "Test" {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+}
+
+func TestGetProfileRejectsBadCredentials(t *testing.T) {
+	testCases := []struct {
+		name   string
+		graph  func(graph *fakeGraph)
+		cmd    func(cmd *loginCommand)
+		expect error
+	}{
+		{name: "forged signed request", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = signRequest("another secret", signedRequest{Algorithm: "HMAC-SHA256", UserID: "987"})
+		}, expect: ErrInvalidSignedRequest},
+		{name: "malformed signed request", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = "abc123"
+		}, expect: ErrInvalidSignedRequest},
+		{name: "signed request for another user", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = signRequest(testAppSecret, signedRequest{Algorithm: "HMAC-SHA256", UserID: "123", IssuedAt: time.Now().Unix()})
+		}, expect: ErrUserMismatch},
+		{name: "stale signed request", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = signRequest(testAppSecret, signedRequest{Algorithm: "HMAC-SHA256", UserID: "987", IssuedAt: time.Now().Add(-SignedRequestMaxAge - time.Minute).Unix()})
+		}, expect: ErrInvalidSignedRequest},
+		{name: "signed request from the future", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = signRequest(testAppSecret, signedRequest{Algorithm: "HMAC-SHA256", UserID: "987", IssuedAt: time.Now().Add(SignedRequestMaxAge + time.Minute).Unix()})
+		}, expect: ErrInvalidSignedRequest},
+		{name: "signed request without issued_at", cmd: func(cmd *loginCommand) {
+			cmd.SignedRequest = signRequest(testAppSecret, signedRequest{Algorithm: "HMAC-SHA256", UserID: "987"})
+		}, expect: ErrInvalidSignedRequest},
+		{name: "token from another app", graph: func(graph *fakeGraph) {
+			graph.appID = "another app"
+		}, expect: ErrInvalidAccessToken},
+		{name: "invalid token", graph: func(graph *fakeGraph) {
+			graph.valid = false
+		}, expect: ErrInvalidAccessToken},
+		{name: "expired token", graph: func(graph *fakeGraph) {
+			graph.expiresAt = time.Now().Add(-time.Minute).Unix()
+		}, expect: ErrInvalidAccessToken},
+		{name: "token for another user", graph: func(graph *fakeGraph) {
+			graph.userID = "123"
+		}, expect: ErrUserMismatch},
+		{name: "profile of another user", graph: func(graph *fakeGraph) {
+			graph.me.ID = "123"
+		}, expect: ErrUserMismatch},
+	}
+
+	for _, testCase := range testCases {
+		graph := newFakeGraph()
+		if testCase.graph != nil {
+			testCase.graph(graph)
+		}
+
+		cmd := validCommand()
+		if testCase.cmd != nil {
+			testCase.cmd(&cmd)
+		}
+
+		server := graph.serve(t)
+		_, err := newClient(testAppID, testAppSecret, server.URL).getProfile(cmd)
+		server.Close()
+
+		if err != testCase.expect {
+			t.Errorf("Expected %v for a %s, received %v", testCase.expect, testCase.name, err)
+		}
+	}
+}
+
+func TestGetProfileOnlyTrustsConfirmedEmail(t *testing.T) {
+	graph := newFakeGraph()
+	graph.me.Verified = false
+	server := graph.serve(t)
+	defer server.Close()
+
+	profile, err := newClient(testAppID, testAppSecret, server.URL).getProfile(validCommand())
+	if err != nil {
+		t.Fatalf("Unexpected error getting profile: %v", err)
+	}
+
+	if profile.EmailVerified {
+		t.Error("Expected an unconfirmed Facebook email not to be treated as verified")
+	}
+}
+
+func TestGetProfileRequiresAppSecret(t *testing.T) {
+	if _, err := newClient(testAppID, "", GraphURL).getProfile(validCommand()); err != ErrNotConfigured {
+		t.Errorf("Expected ErrNotConfigured, received %v", err)
+	}
+}
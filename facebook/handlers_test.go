@@ -8,21 +8,24 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/spear-wind/cms/auth"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/identity"
 	"github.com/spear-wind/cms/user"
 	"github.com/unrolled/render"
 )
 
 type fakeClient struct {
-	user *user.User
-	err  error
+	profile *identity.Profile
+	err     error
 }
 
-func (c *fakeClient) getUser(cmd loginCommand) (*user.User, error) {
-	return c.user, c.err
+func (c *fakeClient) getProfile(cmd loginCommand) (*identity.Profile, error) {
+	return c.profile, c.err
 }
 
-func (c *fakeClient) getUserReturns(user *user.User, error error) {
-	c.user = user
+func (c *fakeClient) getProfileReturns(profile *identity.Profile, error error) {
+	c.profile = profile
 	c.err = error
 }
 
@@ -37,7 +40,7 @@ func TestLoginHandlerResponseToInvalidData(t *testing.T) {
 	userRepository := user.NewInMemoryRepository()
 	fbClient := new(fakeClient)
 
-	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, fbClient)))
+	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), fbClient, events.NewSynchEventPublisher())))
 	defer server.Close()
 
 	invalidBody := []byte("not even json")
@@ -65,7 +68,7 @@ func TestLoginHandlerResponseToBadJson(t *testing.T) {
 	userRepository := user.NewInMemoryRepository()
 	fbClient := new(fakeClient)
 
-	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, fbClient)))
+	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), fbClient, events.NewSynchEventPublisher())))
 	defer server.Close()
 
 	badJSON := []byte("{\"test\":\"bad json! bad!\"}")
@@ -91,16 +94,17 @@ func TestLoginHandlerHappyPath(t *testing.T) {
 	userRepository := user.NewInMemoryRepository()
 	fbClient := new(fakeClient)
 
-	fakeUser := &user.User{
-		FirstName:  "Test",
-		LastName:   "User",
-		Email:      "testuser@spearwind.io",
-		FacebookID: "987",
+	fakeProfile := &identity.Profile{
+		Subject:       "987",
+		FirstName:     "Test",
+		LastName:      "User",
+		Email:         "testuser@spearwind.io",
+		EmailVerified: true,
 	}
 
-	fbClient.getUserReturns(fakeUser, nil)
+	fbClient.getProfileReturns(fakeProfile, nil)
 
-	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, fbClient)))
+	server := httptest.NewServer(http.HandlerFunc(facebookLoginHandler(formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), fbClient, events.NewSynchEventPublisher())))
 	defer server.Close()
 
 	validJSON := []byte("{\"id\":\"987\",\"access_token\":\"abc123\",\"signed_request\":\"abc123\",\"expires_in\":123}")
@@ -126,8 +130,8 @@ func TestLoginHandlerHappyPath(t *testing.T) {
 		t.Errorf("Expected http.StatusOK, but got %v; response body: %v", res.StatusCode, string(payload))
 	}
 
-	if userRepository.FindByFacebookID(fakeUser.FacebookID) == nil {
-		t.Error("Expected to find a user in the user repository with FacebookID of " + fakeUser.FacebookID)
+	if account := userRepository.FindByIdentity(user.LoginMethodFacebook, fakeProfile.Subject); account == nil || account.FacebookID != fakeProfile.Subject {
+		t.Error("Expected to find a user in the user repository with FacebookID of " + fakeProfile.Subject)
 	}
 
 	var responseObject map[string]interface{}
@@ -141,3 +145,52 @@ func TestLoginHandlerHappyPath(t *testing.T) {
 		t.Error("Response object should contain Token")
 	}
 }
+
+func TestLoginHandlerChallengesUsersWithMFA(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	fbClient := new(fakeClient)
+
+	existing := user.NewUser(0, "Test", "User", "testuser@spearwind.io")
+	existing.FacebookID = "987"
+	existing.MFA.Enabled = true
+	userRepository.Add(existing)
+
+	fbClient.getProfileReturns(&identity.Profile{Subject: "987", FirstName: "Test", LastName: "User", Email: "testuser@spearwind.io", EmailVerified: true}, nil)
+
+	handler := facebookLoginHandler(formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), fbClient, events.NewSynchEventPublisher())
+
+	validJSON := "{\"id\":\"987\",\"access_token\":\"abc123\",\"signed_request\":\"abc123\",\"expires_in\":123}"
+	req := httptest.NewRequest("POST", "/facebook/login", bytes.NewBufferString(validJSON))
+	req.Header.Add("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	handler(res, req)
+
+	var responseObject map[string]interface{}
+	json.Unmarshal(res.Body.Bytes(), &responseObject)
+
+	if res.Code != http.StatusOK || responseObject["MFAToken"] == nil || responseObject["Token"] != nil {
+		t.Errorf("Expected an MFA challenge instead of tokens, received %d: %s", res.Code, res.Body)
+	}
+}
+
+func TestLoginHandlerRejectsUnverifiedCredentials(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	fbClient := new(fakeClient)
+	fbClient.getProfileReturns(nil, ErrInvalidSignedRequest)
+
+	handler := facebookLoginHandler(formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), fbClient, events.NewSynchEventPublisher())
+
+	validJSON := "{\"id\":\"987\",\"access_token\":\"abc123\",\"signed_request\":\"abc123\",\"expires_in\":123}"
+	req := httptest.NewRequest("POST", "/facebook/login", bytes.NewBufferString(validJSON))
+	req.Header.Add("Content-Type", "application/json")
+	res := httptest.NewRecorder()
+	handler(res, req)
+
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected http.StatusUnauthorized, received %d: %s", res.Code, res.Body)
+	}
+
+	if userRepository.FindByIdentity(user.LoginMethodFacebook, "987") != nil {
+		t.Error("Expected no user to be created for credentials Facebook doesn't vouch for")
+	}
+}
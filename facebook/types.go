@@ -1,9 +1,12 @@
 package facebook
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"time"
 
-	fb "github.com/huandu/facebook"
+	"github.com/spear-wind/cms/identity"
 	"github.com/spear-wind/cms/user"
 	"github.com/spear-wind/cms/validator"
 )
@@ -49,50 +52,98 @@ func (cmd loginCommand) String() string {
 }
 
 type Client interface {
-	getUser(cmd loginCommand) (*user.User, error)
+	getProfile(cmd loginCommand) (*identity.Profile, error)
 }
 
 type facebookClient struct {
 	clientID     string
 	clientSecret string
+	graphURL     string
+	httpClient   *http.Client
 }
 
 func NewClient(clientID string, clientSecret string) Client {
+	return newClient(clientID, clientSecret, GraphURL)
+}
+
+func newClient(clientID string, clientSecret string, graphURL string) *facebookClient {
 	return &facebookClient{
 		clientID:     clientID,
 		clientSecret: clientSecret,
+		graphURL:     graphURL,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
 	}
 }
 
-func (c *facebookClient) getUser(cmd loginCommand) (*user.User, error) {
-	user := &user.User{
-		FacebookID: cmd.UserID,
+// getProfile checks everything the client sent before trusting any of it:
+// the signed request must be signed with our app secret, and the access
+// token must be a live token for our app, both for the user ID the client
+// claims to be.
+func (c *facebookClient) getProfile(cmd loginCommand) (*identity.Profile, error) {
+	if len(c.clientID) == 0 || len(c.clientSecret) == 0 {
+		return nil, ErrNotConfigured
 	}
 
-	//call /me to ensure that we can grab first name, last name, and email address
-	res, err := fb.Get("/me", fb.Params{
-		"fields":       "first_name,last_name,email",
-		"access_token": cmd.AccessToken,
-	})
-
+	request, err := parseSignedRequest(cmd.SignedRequest, c.clientSecret)
 	if err != nil {
 		return nil, err
 	}
 
-	//assumes that we were able to retrieve info from Facebook API calls using the given access token
-	user.Verified = true
+	if request.UserID != cmd.UserID {
+		return nil, ErrUserMismatch
+	}
+
+	if err := c.debugToken(cmd.AccessToken, cmd.UserID); err != nil {
+		return nil, err
+	}
+
+	me, err := c.me(cmd.AccessToken)
+	if err != nil {
+		return nil, err
+	}
 
-	if firstName, ok := res["first_name"].(string); ok {
-		user.FirstName = firstName
+	if me.ID != cmd.UserID {
+		return nil, ErrUserMismatch
 	}
 
-	if lastName, ok := res["last_name"].(string); ok {
-		user.LastName = lastName
+	return &identity.Profile{
+		Provider:  user.LoginMethodFacebook,
+		Subject:   me.ID,
+		Email:     me.Email,
+		FirstName: me.FirstName,
+		LastName:  me.LastName,
+		// Facebook can return an email the user never confirmed, which
+		// must not be used to link them to someone else's account.
+		EmailVerified: len(me.Email) != 0 && me.Verified,
+	}, nil
+}
+
+// provider is the identity.TokenProvider for the credentials Facebook's
+// JavaScript SDK hands the client.
+type provider struct {
+	client Client
+}
+
+func NewProvider(client Client) identity.TokenProvider {
+	return &provider{client: client}
+}
+
+func (p *provider) Name() string {
+	return user.LoginMethodFacebook
+}
+
+func (p *provider) Authenticate(payload []byte) (*identity.Profile, error) {
+	var cmd loginCommand
+
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, &identity.InvalidRequestError{Errors: []validator.ValidationError{
+			validator.NewValidationError("body", "Failed to parse fb auth response: "+err.Error()),
+		}}
 	}
 
-	if email, ok := res["email"].(string); ok {
-		user.Email = email
+	if result := cmd.validate(); result.HasErrors() {
+		return nil, &identity.InvalidRequestError{Errors: result.Errors}
 	}
 
-	return user, nil
+	return p.client.getProfile(cmd)
 }
@@ -0,0 +1,279 @@
+package identity
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/auth"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/security"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+type callbackCommand struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+func InitRoutes(router *mux.Router, formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository auth.RefreshTokenRepository, providers []Provider, stateStore StateStore, eventPublisher events.EventPublisher) {
+	byName := make(map[string]Provider)
+	for _, provider := range providers {
+		byName[provider.Name()] = provider
+	}
+
+	router.HandleFunc("/identity/providers", providersHandler(formatter, providers)).Methods("GET")
+	router.HandleFunc("/identity/callback", callbackHandler(formatter, userRepository, refreshTokenRepository, byName, stateStore, eventPublisher)).Methods("POST")
+	router.HandleFunc("/identity/{provider}/authorize", authorizeHandler(formatter, byName, stateStore)).Methods("POST")
+	router.HandleFunc("/identity/{provider}/token", tokenHandler(formatter, userRepository, refreshTokenRepository, byName, eventPublisher)).Methods("POST")
+}
+
+// providersHandler lists the providers users can log in with, and which
+// flow each uses: "code" for a redirect to the provider, or "token" for
+// credentials from the provider's own SDK.
+func providersHandler(formatter *render.Render, providers []Provider) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		type providerInfo struct {
+			Name string `json:"name"`
+			Flow string `json:"flow"`
+		}
+
+		list := []providerInfo{}
+		for _, provider := range providers {
+			if _, ok := provider.(CodeProvider); ok {
+				list = append(list, providerInfo{provider.Name(), "code"})
+			} else if _, ok := provider.(TokenProvider); ok {
+				list = append(list, providerInfo{provider.Name(), "token"})
+			}
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"providers": list,
+		})
+	}
+}
+
+// authorizeHandler starts a login with a CodeProvider. The client sends the
+// user to the returned authorization_url, and posts the code and state the
+// provider sends them back with to /identity/callback, along with the
+// BindingCookie set here.
+func authorizeHandler(formatter *render.Render, providers map[string]Provider, stateStore StateStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		provider, ok := providers[mux.Vars(req)["provider"]].(CodeProvider)
+		if !ok {
+			formatter.JSON(w, http.StatusNotFound, struct{ Message string }{ErrUnknownProvider.Error()})
+			return
+		}
+
+		// 48 random bytes encode to 64 characters without padding, which
+		// PKCE doesn't allow in verifiers.
+		verifier, err := security.GenerateRandomString(48)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		state, err := security.GenerateRandomString(24)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		nonce, err := security.GenerateRandomString(24)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		binding, err := security.GenerateRandomString(24)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		authorizationURL, err := provider.AuthorizationURL(state, nonce, codeChallenge(verifier))
+		if err != nil {
+			formatter.JSON(w, http.StatusBadGateway, struct{ Message string }{err.Error()})
+			return
+		}
+
+		pending := &PendingLogin{
+			Provider:     provider.Name(),
+			CodeVerifier: verifier,
+			Nonce:        nonce,
+			BindingHash:  hashBinding(binding),
+			Expires:      time.Now().Add(StateTTL),
+		}
+
+		if err := stateStore.Add(state, pending); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     BindingCookie,
+			Value:    binding,
+			Path:     "/identity/callback",
+			MaxAge:   int(StateTTL.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"authorization_url": authorizationURL,
+			"state":             state,
+		})
+	}
+}
+
+func callbackHandler(formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository auth.RefreshTokenRepository, providers map[string]Provider, stateStore StateStore, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+
+		var cmd callbackCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil || len(cmd.Code) == 0 || len(cmd.State) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A code and state are required")
+			return
+		}
+
+		pending, err := stateStore.Take(cmd.State)
+		if err == ErrInvalidState {
+			formatter.JSON(w, http.StatusBadRequest, struct{ Message string }{err.Error()})
+			return
+		} else if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{Name: BindingCookie, Path: "/identity/callback", MaxAge: -1})
+
+		// A state posted from another browser than the one that started the
+		// login is used up all the same, so it can't be tried again.
+		cookie, err := req.Cookie(BindingCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(hashBinding(cookie.Value)), []byte(pending.BindingHash)) != 1 {
+			fmt.Printf("Security: %s login state presented without its binding cookie\n", pending.Provider)
+			formatter.JSON(w, http.StatusBadRequest, struct{ Message string }{ErrInvalidState.Error()})
+			return
+		}
+
+		provider, ok := providers[pending.Provider].(CodeProvider)
+		if !ok {
+			formatter.JSON(w, http.StatusBadRequest, struct{ Message string }{ErrUnknownProvider.Error()})
+			return
+		}
+
+		profile, err := provider.Exchange(cmd.Code, pending.CodeVerifier, pending.Nonce)
+		if err != nil {
+			fmt.Printf("Security: %s login failed: %v\n", provider.Name(), err)
+			formatter.Text(w, http.StatusUnauthorized, "Unauthorized.")
+			return
+		}
+
+		logIn(w, formatter, userRepository, refreshTokenRepository, profile, eventPublisher)
+	}
+}
+
+// tokenHandler logs in with a TokenProvider.
+func tokenHandler(formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository auth.RefreshTokenRepository, providers map[string]Provider, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		provider, ok := providers[mux.Vars(req)["provider"]].(TokenProvider)
+		if !ok {
+			formatter.JSON(w, http.StatusNotFound, struct{ Message string }{ErrUnknownProvider.Error()})
+			return
+		}
+
+		TokenLoginHandler(formatter, userRepository, refreshTokenRepository, provider, eventPublisher)(w, req)
+	}
+}
+
+// TokenLoginHandler logs users in with provider's credentials, which are the
+// request body. Credentials the provider doesn't vouch for get the same 401
+// as a wrong password.
+func TokenLoginHandler(formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository auth.RefreshTokenRepository, provider TokenProvider, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+
+		profile, err := provider.Authenticate(payload)
+		if invalid, ok := err.(*InvalidRequestError); ok {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": invalid.Errors,
+			})
+			return
+		} else if err != nil {
+			fmt.Printf("Security: %s login failed: %v\n", provider.Name(), err)
+			formatter.Text(w, http.StatusUnauthorized, "Unauthorized.")
+			return
+		}
+
+		profile.Provider = provider.Name()
+		logIn(w, formatter, userRepository, refreshTokenRepository, profile, eventPublisher)
+	}
+}
+
+// logIn links profile to an account and issues tokens for it, or an MFA
+// challenge if the user has two-factor authentication on: the provider
+// stands in for the password, not the second factor.
+func logIn(w http.ResponseWriter, formatter *render.Render, userRepository user.UserRepository, refreshTokenRepository auth.RefreshTokenRepository, profile *Profile, eventPublisher events.EventPublisher) {
+	account, created, err := Link(userRepository, profile)
+	if err == ErrEmailNotVerified {
+		formatter.JSON(w, http.StatusConflict, struct{ Message string }{err.Error()})
+		return
+	} else if err != nil {
+		formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+		return
+	}
+
+	if created {
+		events.Publish(eventPublisher, account.Actor(), user.NewRegisteredEvent(account))
+	}
+
+	if account.MFAEnabled() {
+		challenge, err := auth.IssueMFAChallenge(account.ID, profile.Provider)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, challenge)
+		return
+	}
+
+	tokens, err := auth.IssueTokens(refreshTokenRepository, account.ID)
+	if err != nil {
+		formatter.JSON(w, http.StatusInternalServerError, struct{ Message string }{err.Error()})
+		return
+	}
+
+	events.Publish(eventPublisher, account.Actor(), user.NewLoggedInEvent(account, profile.Provider))
+
+	data := struct {
+		User *user.User
+		auth.TokenPair
+	}{
+		account,
+		tokens,
+	}
+
+	formatter.JSON(w, http.StatusOK, data)
+}
+
+// hashBinding is what is stored of a BindingCookie's value, so that the
+// state store alone can't be used to finish a login.
+func hashBinding(binding string) string {
+	sum := sha256.Sum256([]byte(binding))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// codeChallenge is the S256 PKCE challenge for verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,177 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/auth"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+func TestLinkPrefersProviderIDThenVerifiedEmail(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+
+	linked := user.NewUser(0, "Ada", "Lovelace", "ada@spearwind.io")
+	linked.LinkIdentity("google", "123")
+	userRepository.Add(linked)
+
+	byEmail := user.NewUser(0, "Grace", "Hopper", "grace@spearwind.io")
+	byEmail.Credential = user.Credential{Hash: "unverified password"}
+	userRepository.Add(byEmail)
+
+	// The provider ID wins even when the email has changed at the provider.
+	account, created, err := Link(userRepository, &Profile{Provider: "google", Subject: "123", Email: "ada@elsewhere.io", EmailVerified: true})
+	if err != nil || created || account.ID != linked.ID {
+		t.Errorf("Expected the account linked to the provider ID, received %v created %v: %v", account, created, err)
+	}
+
+	account, created, err = Link(userRepository, &Profile{Provider: "google", Subject: "456", Email: "grace@spearwind.io", EmailVerified: true})
+	if err != nil || created || account.ID != byEmail.ID {
+		t.Fatalf("Expected the account with the verified email, received %v created %v: %v", account, created, err)
+	}
+
+	if account.IdentitySubject("google") != "456" || !account.Verified || len(account.Credential.Hash) != 0 {
+		t.Errorf("Expected the account to be linked, verified and to lose its unverified password, received %+v", account)
+	}
+
+	account, created, err = Link(userRepository, &Profile{Provider: "google", Subject: "789", Email: "new@spearwind.io", EmailVerified: true, FirstName: "New"})
+	if err != nil || !created || account.IdentitySubject("google") != "789" || !account.Verified || account.FirstName != "New" {
+		t.Errorf("Expected a new verified account, received %+v created %v: %v", account, created, err)
+	}
+}
+
+func TestLinkRefusesUnverifiedEmailOfExistingAccount(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	userRepository.Add(user.NewUser(0, "Ada", "Lovelace", "ada@spearwind.io"))
+
+	if _, _, err := Link(userRepository, &Profile{Provider: "github", Subject: "1", Email: "ada@spearwind.io"}); err != ErrEmailNotVerified {
+		t.Errorf("Expected ErrEmailNotVerified, received %v", err)
+	}
+
+	if account, created, err := Link(userRepository, &Profile{Provider: "github", Subject: "2", Email: "someone@spearwind.io"}); err != nil || !created || account.Verified {
+		t.Errorf("Expected a new unverified account, received %+v created %v: %v", account, created, err)
+	}
+}
+
+func TestCodeLoginRoundTrip(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	userRepository := user.NewInMemoryRepository()
+	stateStore := NewInMemoryStateStore()
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), []Provider{issuer.provider()}, stateStore, events.NewSynchEventPublisher())
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", "/identity/fake/authorize", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected http.StatusOK starting a login, received %d: %s", res.Code, res.Body)
+	}
+
+	var started struct {
+		AuthorizationURL string `json:"authorization_url"`
+		State            string `json:"state"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &started)
+
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != BindingCookie || !cookies[0].HttpOnly {
+		t.Fatalf("Expected an HttpOnly binding cookie, received %v", cookies)
+	}
+
+	code := issuer.authorize(t, started.AuthorizationURL, "123", "ada@spearwind.io", true)
+	callback := func() *httptest.ResponseRecorder {
+		body, _ := json.Marshal(callbackCommand{Code: code, State: started.State})
+		req := httptest.NewRequest("POST", "/identity/callback", bytes.NewBuffer(body))
+		req.AddCookie(cookies[0])
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+
+	res = callback()
+	var responseObject map[string]interface{}
+	json.Unmarshal(res.Body.Bytes(), &responseObject)
+
+	if res.Code != http.StatusOK || responseObject["Token"] == nil {
+		t.Fatalf("Expected tokens, received %d: %s", res.Code, res.Body)
+	}
+
+	if userRepository.FindByIdentity("fake", "123") == nil {
+		t.Error("Expected a user linked to the provider")
+	}
+
+	if res = callback(); res.Code != http.StatusBadRequest {
+		t.Errorf("Expected a state to only be usable once, received %d: %s", res.Code, res.Body)
+	}
+}
+
+func TestCallbackRequiresTheBrowserThatStartedTheLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	userRepository := user.NewInMemoryRepository()
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, userRepository, auth.NewInMemoryRefreshTokenRepository(), []Provider{issuer.provider()}, NewInMemoryStateStore(), events.NewSynchEventPublisher())
+
+	start := func() (state string, cookie *http.Cookie, code string) {
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, httptest.NewRequest("POST", "/identity/fake/authorize", nil))
+
+		var started struct {
+			AuthorizationURL string `json:"authorization_url"`
+			State            string `json:"state"`
+		}
+		json.Unmarshal(res.Body.Bytes(), &started)
+
+		return started.State, res.Result().Cookies()[0], issuer.authorize(t, started.AuthorizationURL, "666", "mallory@spearwind.io", true)
+	}
+
+	// Mallory starts a login and gets Ada's browser to post her code and
+	// state, with no cookie or with the cookie of Ada's own login.
+	state, _, code := start()
+	_, victimCookie, _ := start()
+
+	for _, cookie := range []*http.Cookie{nil, victimCookie} {
+		body, _ := json.Marshal(callbackCommand{Code: code, State: state})
+		req := httptest.NewRequest("POST", "/identity/callback", bytes.NewBuffer(body))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected http.StatusBadRequest with cookie %v, received %d: %s", cookie, res.Code, res.Body)
+		}
+
+		state, _, code = start()
+	}
+
+	if userRepository.FindByIdentity("fake", "666") != nil {
+		t.Error("Expected no login to be completed")
+	}
+}
+
+func TestAuthorizeRejectsUnknownProvider(t *testing.T) {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, user.NewInMemoryRepository(), auth.NewInMemoryRefreshTokenRepository(), nil, NewInMemoryStateStore(), events.NewSynchEventPublisher())
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", "/identity/nowhere/authorize", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected http.StatusNotFound, received %d", res.Code)
+	}
+}
@@ -0,0 +1,47 @@
+package identity
+
+import (
+	"sync"
+	"time"
+)
+
+type inMemoryStateStore struct {
+	mutex   sync.Mutex
+	pending map[string]*PendingLogin
+}
+
+func NewInMemoryStateStore() *inMemoryStateStore {
+	store := &inMemoryStateStore{}
+	store.pending = make(map[string]*PendingLogin)
+	return store
+}
+
+func (store *inMemoryStateStore) Add(state string, pending *PendingLogin) (err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Pending logins are only needed until they expire.
+	now := time.Now()
+	for id, login := range store.pending {
+		if now.After(login.Expires) {
+			delete(store.pending, id)
+		}
+	}
+
+	store.pending[state] = pending
+	return err
+}
+
+func (store *inMemoryStateStore) Take(state string) (pending *PendingLogin, err error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	pending, ok := store.pending[state]
+	delete(store.pending, state)
+
+	if !ok || time.Now().After(pending.Expires) {
+		return nil, ErrInvalidState
+	}
+
+	return pending, nil
+}
@@ -0,0 +1,178 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// keyRefreshInterval limits how often the JWKS is fetched again when a token
+// names a key that isn't in it, so bad tokens can't make us hammer the issuer.
+const keyRefreshInterval = time.Minute
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// keyCache holds an issuer's signing keys, fetched from its JWKS endpoint and
+// fetched again when it starts signing with a new key.
+type keyCache struct {
+	client  *http.Client
+	url     string
+	mutex   sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+func newKeyCache(client *http.Client, url string) *keyCache {
+	return &keyCache{
+		client: client,
+		url:    url,
+	}
+}
+
+// verificationKey is a jwt.Keyfunc. Only RSA and ECDSA signatures are
+// accepted, so a token can't choose HMAC or none.
+func (cache *keyCache) verificationKey(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+	default:
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	key, ok := cache.find(kid)
+	if !ok && time.Since(cache.fetched) > keyRefreshInterval {
+		if err := cache.fetch(); err != nil {
+			return nil, err
+		}
+
+		key, ok = cache.find(kid)
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key %q", kid)
+	}
+
+	return key, nil
+}
+
+// find returns the key with kid, or the only key if the token doesn't name
+// one.
+func (cache *keyCache) find(kid string) (interface{}, bool) {
+	if len(kid) == 0 && len(cache.keys) == 1 {
+		for _, key := range cache.keys {
+			return key, true
+		}
+	}
+
+	key, ok := cache.keys[kid]
+	return key, ok
+}
+
+func (cache *keyCache) fetch() error {
+	req, err := http.NewRequest("GET", cache.url, nil)
+	if err != nil {
+		return err
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := do(cache.client, req, &set); err != nil {
+		return fmt.Errorf("Failed to fetch signing keys: %v", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) != 0 && jwk.Use != "sig" {
+			continue
+		}
+
+		// Keys of types we can't use are skipped rather than failing the
+		// whole set.
+		if key, err := jwk.publicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	cache.keys = keys
+	cache.fetched = time.Now()
+	return nil
+}
+
+func (jwk jsonWebKey) publicKey() (interface{}, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve %q", jwk.Curve)
+		}
+
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("Key %q is not on its curve", jwk.KeyID)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("Unsupported key type %q", jwk.KeyType)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
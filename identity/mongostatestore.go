@@ -0,0 +1,74 @@
+package identity
+
+import (
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoStateStore struct {
+	Collection cfmgo.Collection
+}
+
+// stateRecord keeps the expiry so a TTL index on "expires" can remove
+// abandoned logins.
+type stateRecord struct {
+	State        string    `bson:"_id" json:"state"`
+	Provider     string    `bson:"provider" json:"provider"`
+	CodeVerifier string    `bson:"code_verifier" json:"code_verifier"`
+	Nonce        string    `bson:"nonce" json:"nonce"`
+	BindingHash  string    `bson:"binding_hash" json:"binding_hash"`
+	Expires      time.Time `bson:"expires" json:"expires"`
+}
+
+func NewMongoStateStore(col cfmgo.Collection) *mongoStateStore {
+	return &mongoStateStore{
+		Collection: col,
+	}
+}
+
+func (store *mongoStateStore) Add(state string, pending *PendingLogin) (err error) {
+	store.Collection.Wake()
+	record := stateRecord{
+		State:        state,
+		Provider:     pending.Provider,
+		CodeVerifier: pending.CodeVerifier,
+		Nonce:        pending.Nonce,
+		BindingHash:  pending.BindingHash,
+		Expires:      pending.Expires,
+	}
+	_, err = store.Collection.UpsertID(record.State, record)
+	return
+}
+
+func (store *mongoStateStore) Take(state string) (pending *PendingLogin, err error) {
+	store.Collection.Wake()
+	var records []stateRecord
+	params := &params.RequestParams{
+		Q: bson.M{"_id": state},
+	}
+
+	count, err := store.Collection.Find(params, &records)
+	if err != nil || count == 0 {
+		return nil, ErrInvalidState
+	}
+
+	if err := store.Collection.Delete(bson.M{"_id": state}); err != nil {
+		return nil, err
+	}
+
+	record := records[0]
+	if time.Now().After(record.Expires) {
+		return nil, ErrInvalidState
+	}
+
+	return &PendingLogin{
+		Provider:     record.Provider,
+		CodeVerifier: record.CodeVerifier,
+		Nonce:        record.Nonce,
+		BindingHash:  record.BindingHash,
+		Expires:      record.Expires,
+	}, nil
+}
@@ -0,0 +1,415 @@
+package identity
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var ErrInvalidIDToken = errors.New("Invalid ID token")
+
+// OIDCOptions configures an OIDCProvider. Endpoints that are left empty are
+// discovered from the issuer's /.well-known/openid-configuration.
+type OIDCOptions struct {
+	// Name identifies the provider in routes and on linked accounts.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the page the provider sends users back to, which posts
+	// the code and state it receives to /identity/callback.
+	RedirectURL      string
+	Scopes           []string
+	AuthorizationURL string
+	TokenURL         string
+	UserInfoURL      string
+	JWKSURL          string
+	// FetchProfile loads the user's profile with an access token, for
+	// providers that don't issue ID tokens. It defaults to reading the OIDC
+	// userinfo endpoint.
+	FetchProfile func(client *http.Client, userInfoURL string, accessToken string) (*Profile, error)
+	HTTPClient   *http.Client
+}
+
+// Google returns the options for logging in with Google.
+func Google(clientID string, clientSecret string, redirectURL string) OIDCOptions {
+	return OIDCOptions{
+		Name:         "google",
+		Issuer:       "https://accounts.google.com",
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// GitHub returns the options for logging in with GitHub, which speaks OAuth
+// 2.0 but not OIDC, so the profile comes from its REST API.
+func GitHub(clientID string, clientSecret string, redirectURL string) OIDCOptions {
+	return OIDCOptions{
+		Name:             "github",
+		Issuer:           "https://github.com",
+		ClientID:         clientID,
+		ClientSecret:     clientSecret,
+		RedirectURL:      redirectURL,
+		Scopes:           []string{"read:user", "user:email"},
+		AuthorizationURL: "https://github.com/login/oauth/authorize",
+		TokenURL:         "https://github.com/login/oauth/access_token",
+		UserInfoURL:      "https://api.github.com/user",
+		FetchProfile:     fetchGitHubProfile,
+	}
+}
+
+// OIDCProvider is a CodeProvider for any OpenID Connect issuer, or any
+// OAuth 2.0 server given a FetchProfile.
+type OIDCProvider struct {
+	options OIDCOptions
+	client  *http.Client
+
+	mutex      sync.Mutex
+	discovered bool
+	keys       *keyCache
+}
+
+type discoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func NewOIDCProvider(options OIDCOptions) *OIDCProvider {
+	if options.HTTPClient == nil {
+		options.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if options.FetchProfile == nil {
+		options.FetchProfile = fetchUserInfo
+	}
+
+	if len(options.Scopes) == 0 {
+		options.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &OIDCProvider{
+		options: options,
+		client:  options.HTTPClient,
+	}
+}
+
+func (provider *OIDCProvider) Name() string {
+	return provider.options.Name
+}
+
+func (provider *OIDCProvider) AuthorizationURL(state string, nonce string, codeChallenge string) (string, error) {
+	options, err := provider.endpoints()
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", options.ClientID)
+	query.Set("redirect_uri", options.RedirectURL)
+	query.Set("scope", strings.Join(options.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(options.AuthorizationURL, "?") {
+		separator = "&"
+	}
+
+	return options.AuthorizationURL + separator + query.Encode(), nil
+}
+
+func (provider *OIDCProvider) Exchange(code string, codeVerifier string, nonce string) (*Profile, error) {
+	options, err := provider.endpoints()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", options.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", options.ClientID)
+	form.Set("client_secret", options.ClientSecret)
+
+	req, err := http.NewRequest("POST", options.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var tokens tokenResponse
+	if err := provider.do(req, &tokens); err != nil {
+		return nil, fmt.Errorf("Failed to exchange code with %s: %v", options.Name, err)
+	}
+
+	if len(tokens.Error) != 0 {
+		return nil, fmt.Errorf("%s refused the code: %s %s", options.Name, tokens.Error, tokens.ErrorDescription)
+	}
+
+	var profile *Profile
+	if len(tokens.IDToken) != 0 {
+		profile, err = provider.verifyIDToken(options, tokens.IDToken, nonce)
+	} else if len(tokens.AccessToken) != 0 {
+		profile, err = options.FetchProfile(provider.client, options.UserInfoURL, tokens.AccessToken)
+	} else {
+		err = fmt.Errorf("%s returned neither an ID token nor an access token", options.Name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	profile.Provider = options.Name
+	if len(profile.Subject) == 0 {
+		return nil, fmt.Errorf("%s did not say who the user is", options.Name)
+	}
+
+	return profile, nil
+}
+
+// endpoints returns the options with endpoints filled in from the issuer's
+// discovery document, which is fetched the first time it is needed.
+func (provider *OIDCProvider) endpoints() (OIDCOptions, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	options := provider.options
+	complete := len(options.AuthorizationURL) != 0 && len(options.TokenURL) != 0 &&
+		(len(options.JWKSURL) != 0 || len(options.UserInfoURL) != 0)
+
+	if !provider.discovered && !complete {
+		var document discoveryDocument
+		req, err := http.NewRequest("GET", strings.TrimSuffix(options.Issuer, "/")+"/.well-known/openid-configuration", nil)
+		if err != nil {
+			return options, err
+		}
+
+		if err := provider.do(req, &document); err != nil {
+			return options, fmt.Errorf("Failed to discover %s endpoints: %v", options.Name, err)
+		}
+
+		if document.Issuer != options.Issuer {
+			return options, fmt.Errorf("%s discovery document is for issuer %q", options.Name, document.Issuer)
+		}
+
+		fill(&provider.options.AuthorizationURL, document.AuthorizationEndpoint)
+		fill(&provider.options.TokenURL, document.TokenEndpoint)
+		fill(&provider.options.UserInfoURL, document.UserInfoEndpoint)
+		fill(&provider.options.JWKSURL, document.JWKSURI)
+		provider.discovered = true
+	}
+
+	if provider.keys == nil && len(provider.options.JWKSURL) != 0 {
+		provider.keys = newKeyCache(provider.client, provider.options.JWKSURL)
+	}
+
+	return provider.options, nil
+}
+
+func fill(field *string, value string) {
+	if len(*field) == 0 {
+		*field = value
+	}
+}
+
+// verifyIDToken checks the ID token's signature against the issuer's keys,
+// and that it was issued by the issuer, to this client, for this login.
+func (provider *OIDCProvider) verifyIDToken(options OIDCOptions, idToken string, nonce string) (*Profile, error) {
+	if provider.keys == nil {
+		return nil, fmt.Errorf("%s has no JWKS endpoint to verify ID tokens with", options.Name)
+	}
+
+	token, err := jwt.Parse(idToken, provider.keys.verificationKey)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if !claims.VerifyIssuer(options.Issuer, true) || !audienceIncludes(claims, options.ClientID) {
+		return nil, ErrInvalidIDToken
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return nil, ErrInvalidIDToken
+	}
+
+	if value, _ := claims["nonce"].(string); len(nonce) == 0 || value != nonce {
+		return nil, ErrInvalidIDToken
+	}
+
+	return profileFromClaims(claims), nil
+}
+
+// audienceIncludes checks the aud claim, which may be a string or a list,
+// and the azp claim that must name the client when there are several.
+func audienceIncludes(claims jwt.MapClaims, clientID string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		found := false
+		for _, value := range aud {
+			if value == clientID {
+				found = true
+			}
+		}
+
+		if azp, ok := claims["azp"]; ok || len(aud) > 1 {
+			return found && azp == clientID
+		}
+
+		return found
+	}
+
+	return false
+}
+
+// profileFromClaims reads the standard OIDC claims, from an ID token or the
+// userinfo endpoint.
+func profileFromClaims(claims map[string]interface{}) *Profile {
+	profile := &Profile{}
+	profile.Subject, _ = claims["sub"].(string)
+	profile.Email, _ = claims["email"].(string)
+	profile.FirstName, _ = claims["given_name"].(string)
+	profile.LastName, _ = claims["family_name"].(string)
+	profile.Locale, _ = claims["locale"].(string)
+
+	// Some issuers send email_verified as a string.
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		profile.EmailVerified = verified
+	case string:
+		profile.EmailVerified = verified == "true"
+	}
+
+	return profile
+}
+
+func fetchUserInfo(client *http.Client, userInfoURL string, accessToken string) (*Profile, error) {
+	var claims map[string]interface{}
+	if err := getJSON(client, userInfoURL, accessToken, &claims); err != nil {
+		return nil, fmt.Errorf("Failed to fetch user info: %v", err)
+	}
+
+	return profileFromClaims(claims), nil
+}
+
+type gitHubUser struct {
+	ID    json.Number `json:"id"`
+	Name  string      `json:"name"`
+	Login string      `json:"login"`
+}
+
+type gitHubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// fetchGitHubProfile reads /user, and /user/emails for the primary email
+// and whether GitHub has verified it.
+func fetchGitHubProfile(client *http.Client, userInfoURL string, accessToken string) (*Profile, error) {
+	var account gitHubUser
+	if err := getJSON(client, userInfoURL, accessToken, &account); err != nil {
+		return nil, fmt.Errorf("Failed to fetch GitHub user: %v", err)
+	}
+
+	var emails []gitHubEmail
+	if err := getJSON(client, strings.TrimSuffix(userInfoURL, "/")+"/emails", accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("Failed to fetch GitHub emails: %v", err)
+	}
+
+	profile := &Profile{Subject: account.ID.String()}
+
+	name := account.Name
+	if len(name) == 0 {
+		name = account.Login
+	}
+
+	names := strings.SplitN(name, " ", 2)
+	profile.FirstName = names[0]
+	if len(names) == 2 {
+		profile.LastName = names[1]
+	}
+
+	for _, email := range emails {
+		if email.Primary {
+			profile.Email = email.Email
+			profile.EmailVerified = email.Verified
+		}
+	}
+
+	return profile, nil
+}
+
+func getJSON(client *http.Client, target string, accessToken string, v interface{}) error {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return do(client, req, v)
+}
+
+func (provider *OIDCProvider) do(req *http.Request, v interface{}) error {
+	return do(provider.client, req, v)
+}
+
+// do sends req and decodes the JSON response into v. Token endpoints report
+// errors in the body, so 400 responses are decoded as well.
+func do(client *http.Client, req *http.Request, v interface{}) error {
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("%s returned %s", req.URL.Host, res.Status)
+	}
+
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%s returned a response that isn't JSON: %v", req.URL.Host, err)
+	}
+
+	if res.StatusCode == http.StatusBadRequest {
+		if tokens, ok := v.(*tokenResponse); !ok || len(tokens.Error) == 0 {
+			return fmt.Errorf("%s returned %s", req.URL.Host, res.Status)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package identity
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// fakeIssuer is a minimal OIDC issuer: discovery, JWKS, and a token endpoint
+// that checks the PKCE verifier for codes handed out with authorize.
+type fakeIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	codes  map[string]fakeGrant
+	// claims, when set, replace the ID token's claims.
+	claims func(grant fakeGrant) jwt.MapClaims
+}
+
+type fakeGrant struct {
+	challenge string
+	nonce     string
+	subject   string
+	email     string
+	verified  bool
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate issuer key: %v", err)
+	}
+
+	issuer := &fakeIssuer{key: key, codes: make(map[string]fakeGrant)}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"userinfo_endpoint":      issuer.server.URL + "/userinfo",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		grant, ok := issuer.codes[req.Form.Get("code")]
+		delete(issuer.codes, req.Form.Get("code"))
+
+		sum := sha256.Sum256([]byte(req.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge || req.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":            issuer.server.URL,
+			"aud":            "client",
+			"sub":            grant.subject,
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          grant.nonce,
+			"email":          grant.email,
+			"email_verified": grant.verified,
+			"given_name":     "Ada",
+			"family_name":    "Lovelace",
+		}
+
+		if issuer.claims != nil {
+			claims = issuer.claims(grant)
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"id_token":     issuer.sign(claims),
+		})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+func (issuer *fakeIssuer) sign(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test"
+	signed, _ := token.SignedString(issuer.key)
+	return signed
+}
+
+// authorize plays the user logging in at the issuer, returning the code the
+// issuer redirects them back with.
+func (issuer *fakeIssuer) authorize(t *testing.T, authorizationURL string, subject string, email string, verified bool) string {
+	parsed, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization URL %q: %v", authorizationURL, err)
+	}
+
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || len(query.Get("code_challenge")) == 0 {
+		t.Fatalf("Expected a PKCE challenge in %q", authorizationURL)
+	}
+
+	code := "code-" + subject
+	issuer.codes[code] = fakeGrant{query.Get("code_challenge"), query.Get("nonce"), subject, email, verified}
+	return code
+}
+
+func (issuer *fakeIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCOptions{
+		Name:         "fake",
+		Issuer:       issuer.server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "https://cms.spearwind.io/login/callback",
+	})
+}
+
+func TestOIDCProviderExchangesCodeForVerifiedProfile(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	provider := issuer.provider()
+
+	verifier := "a-verifier-that-is-long-enough-for-pkce-0123456789"
+	authorizationURL, err := provider.AuthorizationURL("state", "nonce", codeChallenge(verifier))
+	if err != nil {
+		t.Fatalf("Unexpected error discovering endpoints: %v", err)
+	}
+
+	if !strings.HasPrefix(authorizationURL, issuer.server.URL+"/authorize?") {
+		t.Errorf("Expected the discovered authorization endpoint, received %q", authorizationURL)
+	}
+
+	code := issuer.authorize(t, authorizationURL, "123", "ada@spearwind.io", true)
+	profile, err := provider.Exchange(code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Unexpected error exchanging code: %v", err)
+	}
+
+	if profile.Provider != "fake" || profile.Subject != "123" || profile.Email != "ada@spearwind.io" || !profile.EmailVerified || profile.FirstName != "Ada" {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+}
+
+func TestOIDCProviderRejectsWrongVerifier(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	provider := issuer.provider()
+
+	authorizationURL, _ := provider.AuthorizationURL("state", "nonce", codeChallenge("the-real-verifier"))
+	code := issuer.authorize(t, authorizationURL, "123", "ada@spearwind.io", true)
+
+	if _, err := provider.Exchange(code, "someone-elses-verifier", "nonce"); err == nil {
+		t.Error("Expected a code to be useless without its PKCE verifier")
+	}
+}
+
+func TestOIDCProviderRejectsBadIDTokens(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	realKey := issuer.key
+	forger, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	testCases := []struct {
+		name   string
+		claims func(grant fakeGrant) jwt.MapClaims
+		nonce  string
+		forge  bool
+	}{
+		{name: "wrong nonce", nonce: "another-nonce"},
+		{name: "wrong audience", claims: func(grant fakeGrant) jwt.MapClaims {
+			return jwt.MapClaims{"iss": issuer.server.URL, "aud": "another-client", "sub": "123", "exp": time.Now().Add(time.Hour).Unix(), "nonce": grant.nonce}
+		}},
+		{name: "wrong issuer", claims: func(grant fakeGrant) jwt.MapClaims {
+			return jwt.MapClaims{"iss": "https://evil.example", "aud": "client", "sub": "123", "exp": time.Now().Add(time.Hour).Unix(), "nonce": grant.nonce}
+		}},
+		{name: "expired", claims: func(grant fakeGrant) jwt.MapClaims {
+			return jwt.MapClaims{"iss": issuer.server.URL, "aud": "client", "sub": "123", "exp": time.Now().Add(-time.Hour).Unix(), "nonce": grant.nonce}
+		}},
+		{name: "no expiry", claims: func(grant fakeGrant) jwt.MapClaims {
+			return jwt.MapClaims{"iss": issuer.server.URL, "aud": "client", "sub": "123", "nonce": grant.nonce}
+		}},
+		{name: "signed by another key", forge: true},
+	}
+
+	for _, testCase := range testCases {
+		issuer.claims = testCase.claims
+		issuer.key = realKey
+		if testCase.forge {
+			issuer.key = forger
+		}
+
+		provider := issuer.provider()
+		verifier := "a-verifier-that-is-long-enough-for-pkce-0123456789"
+		authorizationURL, _ := provider.AuthorizationURL("state", "nonce", codeChallenge(verifier))
+		code := issuer.authorize(t, authorizationURL, "123", "ada@spearwind.io", true)
+
+		nonce := "nonce"
+		if len(testCase.nonce) != 0 {
+			nonce = testCase.nonce
+		}
+
+		if _, err := provider.Exchange(code, verifier, nonce); err == nil {
+			t.Errorf("Expected an ID token with %s to be rejected", testCase.name)
+		}
+	}
+}
+
+func TestGitHubProfileUsesPrimaryEmail(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/user":
+			w.Write([]byte(`{"id": 42, "login": "ada", "name": "Ada King Lovelace"}`))
+		case "/user/emails":
+			w.Write([]byte(`[{"email": "old@spearwind.io", "primary": false, "verified": true}, {"email": "ada@spearwind.io", "primary": true, "verified": false}]`))
+		}
+	}))
+	defer api.Close()
+
+	profile, err := fetchGitHubProfile(api.Client(), api.URL+"/user", "access")
+	if err != nil {
+		t.Fatalf("Unexpected error fetching profile: %v", err)
+	}
+
+	if profile.Subject != "42" || profile.FirstName != "Ada" || profile.LastName != "King Lovelace" {
+		t.Errorf("Unexpected profile %+v", profile)
+	}
+
+	if profile.Email != "ada@spearwind.io" || profile.EmailVerified {
+		t.Errorf("Expected the unverified primary email, received %q verified %v", profile.Email, profile.EmailVerified)
+	}
+}
@@ -0,0 +1,136 @@
+package identity
+
+import (
+	"errors"
+	"time"
+
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/validator"
+)
+
+// StateTTL is how long a user has to come back from a provider after
+// starting to log in with it.
+const StateTTL = 10 * time.Minute
+
+// BindingCookie is the cookie that ties a pending login to the browser that
+// started it.
+const BindingCookie = "identity_login"
+
+var (
+	ErrUnknownProvider  = errors.New("Unknown identity provider")
+	ErrInvalidState     = errors.New("Invalid or expired login state; please try again")
+	ErrEmailNotVerified = errors.New("An account already uses this email, and the provider has not verified that it is yours")
+)
+
+// Provider is an external identity provider that users can log in with.
+// Implementations also implement TokenProvider or CodeProvider.
+type Provider interface {
+	// Name identifies the provider in routes and on linked accounts, e.g.
+	// "google".
+	Name() string
+}
+
+// TokenProvider checks credentials that the client got from the provider
+// itself, such as the access token from Facebook's JavaScript SDK.
+type TokenProvider interface {
+	Provider
+	// Authenticate returns the profile of whoever the credentials in
+	// payload belong to. It returns an *InvalidRequestError when payload
+	// can't be parsed or fields are missing, and any other error when the
+	// provider doesn't vouch for the credentials.
+	Authenticate(payload []byte) (*Profile, error)
+}
+
+// CodeProvider logs users in with the OAuth 2.0 authorization code flow,
+// protected with PKCE.
+type CodeProvider interface {
+	Provider
+	// AuthorizationURL is where to send the user to log in.
+	AuthorizationURL(state string, nonce string, codeChallenge string) (string, error)
+	// Exchange swaps the code the provider sent the user back with for
+	// their profile.
+	Exchange(code string, codeVerifier string, nonce string) (*Profile, error)
+}
+
+// Profile is what a provider says about the person who logged in.
+type Profile struct {
+	Provider string
+	// Subject is the provider's ID for the user, which never changes.
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+	Locale        string
+}
+
+// PendingLogin is what is remembered between sending a user to a
+// CodeProvider and them coming back.
+type PendingLogin struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	// BindingHash is the SHA-256 of the secret in the BindingCookie of
+	// whoever started the login. Without it, anyone could start a login,
+	// and get someone else's browser to finish it and be logged in as them.
+	BindingHash string
+	Expires     time.Time
+}
+
+// StateStore keeps pending logins by their state parameter.
+type StateStore interface {
+	Add(state string, pending *PendingLogin) (err error)
+	// Take returns the pending login for state and forgets it, so that each
+	// state can only be used once. Expired states return ErrInvalidState.
+	Take(state string) (pending *PendingLogin, err error)
+}
+
+// InvalidRequestError reports credentials that a TokenProvider can't parse,
+// or the fields missing from them.
+type InvalidRequestError struct {
+	Errors []validator.ValidationError
+}
+
+func (err *InvalidRequestError) Error() string {
+	return "Invalid login request"
+}
+
+// Link returns the account that profile belongs to. It looks for an account
+// already linked to the provider's ID, then for one with the same email if
+// the provider has verified it, which is then linked. If there is neither, a
+// new account is created, and created is true.
+func Link(userRepository user.UserRepository, profile *Profile) (account *user.User, created bool, err error) {
+	if account = userRepository.FindByIdentity(profile.Provider, profile.Subject); account != nil {
+		return account, false, nil
+	}
+
+	if len(profile.Email) != 0 {
+		if account = userRepository.FindByEmail(profile.Email); account != nil {
+			// Linking on an unverified email would let anyone who can
+			// create an account at the provider take over this one.
+			if !profile.EmailVerified {
+				return nil, false, ErrEmailNotVerified
+			}
+
+			account.LinkIdentity(profile.Provider, profile.Subject)
+			account.ConfirmEmailByIdentity()
+
+			if err := userRepository.Update(account); err != nil {
+				return nil, false, err
+			}
+
+			return account, false, nil
+		}
+	}
+
+	account = user.NewUser(0, profile.FirstName, profile.LastName, profile.Email)
+	account.Locale = profile.Locale
+	account.Verified = profile.EmailVerified && len(profile.Email) != 0
+	account.LinkIdentity(profile.Provider, profile.Subject)
+
+	if err := userRepository.Add(account); err != nil {
+		return nil, false, err
+	}
+
+	return account, true, nil
+}
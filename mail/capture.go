@@ -0,0 +1,218 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dave-malone/email"
+	"github.com/spear-wind/cms/security"
+)
+
+const capturedExtension = ".eml"
+
+var capturedIDPattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+
+// CaptureSender is an email.Sender for development that writes each message
+// to a .eml file in a directory instead of sending it.
+type CaptureSender struct {
+	dir string
+}
+
+// CapturedMessage is a message read back from a CaptureSender.
+type CapturedMessage struct {
+	ID      string    `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Subject string    `json:"subject"`
+	Date    time.Time `json:"date"`
+	Text    string    `json:"text,omitempty"`
+	HTML    string    `json:"html,omitempty"`
+}
+
+// NewCaptureSender returns a CaptureSender that writes to dir, creating it
+// if it doesn't exist.
+func NewCaptureSender(dir string) (*CaptureSender, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &CaptureSender{dir: dir}, nil
+}
+
+func (s *CaptureSender) Send(m *email.Message) error {
+	now := time.Now()
+	message, err := formatMessage(m, now)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := security.GenerateRandomString(6)
+	if err != nil {
+		return err
+	}
+
+	// IDs start with the time so that they sort in the order they were sent.
+	id := fmt.Sprintf("%d-%s", now.UnixNano(), suffix)
+
+	tmp, err := ioutil.TempFile(s.dir, ".capture")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(message); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(id))
+}
+
+// List returns every captured message, newest first.
+func (s *CaptureSender) List() ([]*CapturedMessage, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), capturedExtension) {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), capturedExtension))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	messages := []*CapturedMessage{}
+	for _, id := range ids {
+		message, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+// Get returns the captured message with id.
+func (s *CaptureSender) Get(id string) (*CapturedMessage, error) {
+	raw, err := s.Raw(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseCaptured(id, raw)
+}
+
+// Raw returns the captured message with id as it would have been sent.
+func (s *CaptureSender) Raw(id string) ([]byte, error) {
+	if !capturedIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("Captured email %s not found", id)
+	}
+
+	raw, err := ioutil.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("Captured email %s not found", id)
+	}
+
+	return raw, err
+}
+
+// Clear deletes every captured message.
+func (s *CaptureSender) Clear() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*"+capturedExtension))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *CaptureSender) path(id string) string {
+	return filepath.Join(s.dir, id+capturedExtension)
+}
+
+func parseCaptured(id string, raw []byte) (*CapturedMessage, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	captured := &CapturedMessage{
+		ID:      id,
+		From:    msg.Header.Get("From"),
+		To:      msg.Header.Get("To"),
+		Subject: subject,
+	}
+	captured.Date, _ = msg.Header.Date()
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			return nil, err
+		}
+
+		captured.HTML = string(body)
+		return captured, nil
+	}
+
+	// NextPart decodes quoted-printable parts itself.
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return captured, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		switch partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); partType {
+		case "text/plain":
+			captured.Text = string(body)
+		case "text/html":
+			captured.HTML = string(body)
+		}
+	}
+}
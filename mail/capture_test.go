@@ -0,0 +1,117 @@
+package mail
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/dave-malone/email"
+)
+
+func newRendered() *Rendered {
+	return &Rendered{
+		Subject: "Bienvenido, José",
+		HTML:    `<p>Your code is <b>ABC123</b></p>`,
+		Text:    "Your code is ABC123",
+	}
+}
+
+func TestCaptureSenderRoundTripsMessages(t *testing.T) {
+	mailbox, err := NewCaptureSender(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mailbox.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "First", newRendered())); err != nil {
+		t.Fatalf("Failed to capture: %v", err)
+	}
+
+	if err := mailbox.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", newRendered().Subject, newRendered())); err != nil {
+		t.Fatalf("Failed to capture: %v", err)
+	}
+
+	messages, err := mailbox.List()
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 captured messages, got %d", len(messages))
+	}
+
+	latest := messages[0]
+	if latest.Subject != "Bienvenido, José" {
+		t.Errorf("Expected the newest message first with a decoded subject, got %q", latest.Subject)
+	}
+
+	if latest.From != "no-reply@spearwind.io" || latest.To != "test@spearwind.io" {
+		t.Errorf("Unexpected addresses: %s to %s", latest.From, latest.To)
+	}
+
+	if latest.Text != "Your code is ABC123" || latest.HTML != `<p>Your code is <b>ABC123</b></p>` {
+		t.Errorf("Unexpected parts: %q and %q", latest.Text, latest.HTML)
+	}
+
+	if latest.Date.IsZero() {
+		t.Errorf("Expected the message to be dated")
+	}
+
+	raw, err := mailbox.Raw(latest.ID)
+	if err != nil {
+		t.Fatalf("Failed to read raw message: %v", err)
+	}
+
+	if !bytes.Contains(raw, []byte("Content-Type: multipart/alternative")) {
+		t.Errorf("Expected a multipart message, got:\n%s", raw)
+	}
+
+	if err := mailbox.Clear(); err != nil {
+		t.Fatalf("Failed to clear: %v", err)
+	}
+
+	if messages, _ := mailbox.List(); len(messages) != 0 {
+		t.Errorf("Expected no messages after clearing, got %d", len(messages))
+	}
+}
+
+func TestCaptureSenderSendsHTMLOnlyBodies(t *testing.T) {
+	mailbox, _ := NewCaptureSender(t.TempDir())
+
+	body := email.NewFileBasedHTMLTemplateMessageBody("testdata/missing.tpl", nil)
+	if err := mailbox.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Hi", body)); err == nil {
+		t.Errorf("Expected a body that fails to render to fail")
+	}
+
+	if err := mailbox.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Hi", htmlBody("<p>Hi</p>"))); err != nil {
+		t.Fatalf("Failed to capture: %v", err)
+	}
+
+	messages, _ := mailbox.List()
+	if len(messages) != 1 || messages[0].HTML != "<p>Hi</p>" || messages[0].Text != "" {
+		t.Errorf("Expected one HTML-only message, got %+v", messages)
+	}
+}
+
+func TestCaptureSenderRejectsHeaderInjection(t *testing.T) {
+	mailbox, _ := NewCaptureSender(t.TempDir())
+
+	message := email.NewMessage("no-reply@spearwind.io", "test@spearwind.io\r\nBcc: everyone@example.com", "Hi", newRendered())
+	if err := mailbox.Send(message); err == nil {
+		t.Errorf("Expected a header with a line break to be rejected")
+	}
+}
+
+func TestCaptureSenderRejectsUnknownIDs(t *testing.T) {
+	mailbox, _ := NewCaptureSender(t.TempDir())
+
+	for _, id := range []string{"missing", "../secrets", ""} {
+		if _, err := mailbox.Get(id); err == nil {
+			t.Errorf("Expected %q not to be found", id)
+		}
+	}
+}
+
+type htmlBody string
+
+func (b htmlBody) String() (string, error) {
+	return string(b), nil
+}
@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+// InitMailboxRoutes serves the messages captured by mailbox at
+// /dev/mailbox. The routes are unauthenticated, so they must only be added in
+// dev mode.
+func InitMailboxRoutes(router *mux.Router, formatter *render.Render, mailbox *CaptureSender) {
+	router.HandleFunc("/dev/mailbox", listMailboxHandler(formatter, mailbox)).Methods("GET")
+	router.HandleFunc("/dev/mailbox", clearMailboxHandler(formatter, mailbox)).Methods("DELETE")
+	router.HandleFunc("/dev/mailbox/{id}", getCapturedHandler(formatter, mailbox)).Methods("GET")
+	router.HandleFunc("/dev/mailbox/{id}/raw", getRawCapturedHandler(formatter, mailbox)).Methods("GET")
+}
+
+// InitNotificationRoutes accepts SES bounce and complaint notifications,
+// delivered by Amazon SNS, at /email/notifications.
+func InitNotificationRoutes(router *mux.Router, formatter *render.Render, queueRepository QueueRepository, suppressionRepository SuppressionRepository, options NotificationOptions) {
+	router.HandleFunc("/email/notifications", notificationHandler(formatter, queueRepository, suppressionRepository, newNotificationOptions(options))).Methods("POST")
+}
+
+func notificationHandler(formatter *render.Render, queueRepository QueueRepository, suppressionRepository SuppressionRepository, options NotificationOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+
+		message, notification, err := parseNotification(payload)
+		if err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse notification")
+			return
+		}
+
+		if err := message.verify(options); err != nil {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		switch message.Type {
+		case "SubscriptionConfirmation":
+			err = options.ConfirmSubscription(message.SubscribeURL)
+		case "Notification":
+			err = applyNotification(notification, queueRepository, suppressionRepository)
+		}
+
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func listMailboxHandler(formatter *render.Render, mailbox *CaptureSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		messages, err := mailbox.List()
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"messages": messages,
+			"total":    len(messages),
+		})
+	}
+}
+
+func clearMailboxHandler(formatter *render.Render, mailbox *CaptureSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if err := mailbox.Clear(); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getCapturedHandler(formatter *render.Render, mailbox *CaptureSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		message, err := mailbox.Get(mux.Vars(req)["id"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, message)
+	}
+}
+
+func getRawCapturedHandler(formatter *render.Render, mailbox *CaptureSender) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		raw, err := mailbox.Raw(mux.Vars(req)["id"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "message/rfc822")
+		w.WriteHeader(http.StatusOK)
+		w.Write(raw)
+	}
+}
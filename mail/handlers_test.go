@@ -0,0 +1,82 @@
+package mail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dave-malone/email"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+func newMailboxRouter(t *testing.T) (*mux.Router, *CaptureSender) {
+	mailbox, err := NewCaptureSender(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	InitMailboxRoutes(router, formatter, mailbox)
+	return router, mailbox
+}
+
+func serve(router *mux.Router, method string, url string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, nil)
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMailboxRoutes(t *testing.T) {
+	router, mailbox := newMailboxRouter(t)
+	mailbox.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+
+	recorder := serve(router, "GET", "/dev/mailbox")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v; received %v", http.StatusOK, recorder.Code)
+	}
+
+	var list struct {
+		Messages []CapturedMessage `json:"messages"`
+		Total    int               `json:"total"`
+	}
+	json.Unmarshal(recorder.Body.Bytes(), &list)
+
+	if list.Total != 1 || list.Messages[0].Subject != "Welcome" {
+		t.Fatalf("Expected the captured message to be listed, got %s", recorder.Body.String())
+	}
+
+	id := list.Messages[0].ID
+
+	recorder = serve(router, "GET", "/dev/mailbox/"+id)
+	var message CapturedMessage
+	json.Unmarshal(recorder.Body.Bytes(), &message)
+	if recorder.Code != http.StatusOK || message.Text != "Your code is ABC123" {
+		t.Errorf("Expected the message's parts, got %v: %s", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = serve(router, "GET", "/dev/mailbox/"+id+"/raw")
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "message/rfc822" {
+		t.Errorf("Expected the raw message, got %v with %s", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+
+	if recorder := serve(router, "GET", "/dev/mailbox/missing"); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected %v for an unknown message; received %v", http.StatusNotFound, recorder.Code)
+	}
+
+	if recorder := serve(router, "DELETE", "/dev/mailbox"); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected %v; received %v", http.StatusNoContent, recorder.Code)
+	}
+
+	if messages, _ := mailbox.List(); len(messages) != 0 {
+		t.Errorf("Expected the mailbox to be cleared, got %d messages", len(messages))
+	}
+}
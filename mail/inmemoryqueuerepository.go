@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+type inMemoryQueueRepository struct {
+	mutex  sync.RWMutex
+	emails []QueuedEmail
+}
+
+func NewInMemoryQueueRepository() *inMemoryQueueRepository {
+	return &inMemoryQueueRepository{}
+}
+
+func (repo *inMemoryQueueRepository) Add(queued *QueuedEmail) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.emails = append(repo.emails, *queued)
+	return err
+}
+
+func (repo *inMemoryQueueRepository) Update(queued *QueuedEmail) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for i := range repo.emails {
+		if repo.emails[i].ID == queued.ID {
+			repo.emails[i] = *queued
+			return nil
+		}
+	}
+
+	return errors.New("Could not find queued email in repository")
+}
+
+func (repo *inMemoryQueueRepository) ListDue(now time.Time, limit int) (emails []*QueuedEmail) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, queued := range repo.emails {
+		if queued.Status == StatusQueued && !queued.NextAttempt.After(now) {
+			copied := queued
+			emails = append(emails, &copied)
+		}
+	}
+
+	sort.SliceStable(emails, func(i, j int) bool {
+		return emails[i].Created.Before(emails[j].Created)
+	})
+
+	if len(emails) > limit {
+		emails = emails[:limit]
+	}
+
+	return emails
+}
+
+func (repo *inMemoryQueueRepository) ListByRecipient(address string, limit int) (emails []*QueuedEmail) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	address = NormalizeAddress(address)
+	for i := len(repo.emails) - 1; i >= 0 && len(emails) < limit; i-- {
+		if repo.emails[i].Recipient == address {
+			queued := repo.emails[i]
+			emails = append(emails, &queued)
+		}
+	}
+
+	return emails
+}
+
+func (repo *inMemoryQueueRepository) ListSentTo(address string, since time.Time) (emails []*QueuedEmail) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	address = NormalizeAddress(address)
+	for _, queued := range repo.emails {
+		if queued.Recipient == address && queued.Sent != nil && !queued.Sent.Before(since) {
+			copied := queued
+			emails = append(emails, &copied)
+		}
+	}
+
+	return emails
+}
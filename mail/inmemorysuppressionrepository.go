@@ -0,0 +1,37 @@
+package mail
+
+import (
+	"errors"
+	"sync"
+)
+
+type inMemorySuppressionRepository struct {
+	mutex        sync.RWMutex
+	suppressions map[string]Suppression
+}
+
+func NewInMemorySuppressionRepository() *inMemorySuppressionRepository {
+	return &inMemorySuppressionRepository{
+		suppressions: map[string]Suppression{},
+	}
+}
+
+func (repo *inMemorySuppressionRepository) Add(suppression *Suppression) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.suppressions[NormalizeAddress(suppression.Email)] = *suppression
+	return err
+}
+
+func (repo *inMemorySuppressionRepository) Get(address string) (suppression *Suppression, err error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	found, ok := repo.suppressions[NormalizeAddress(address)]
+	if !ok {
+		return nil, errors.New("Could not find suppression in repository")
+	}
+
+	return &found, err
+}
@@ -0,0 +1,114 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/dave-malone/email"
+	"github.com/spear-wind/cms/security"
+)
+
+// plainTexter is implemented by message bodies, like Rendered, that have a
+// plain-text alternative to their HTML.
+type plainTexter interface {
+	PlainText() string
+}
+
+// formatMessage encodes m as an RFC 5322 message. Bodies with a plain-text
+// alternative are sent as multipart/alternative, others as text/html.
+func formatMessage(m *email.Message, date time.Time) ([]byte, error) {
+	for name, value := range map[string]string{"From": m.From, "To": m.To, "Subject": m.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, Permanent(fmt.Errorf("Email %s header contains a line break", name))
+		}
+	}
+
+	html, err := m.Body.String()
+	if err != nil {
+		return nil, err
+	}
+
+	messageID, err := newMessageID(m.From)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: %s\r\n", messageID)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	text, ok := m.Body.(plainTexter)
+	if !ok {
+		buf.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&buf, html); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", text.PlainText()},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(w, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// newMessageID returns a unique Message-ID in the sender's domain.
+func newMessageID(from string) (string, error) {
+	id, err := security.GenerateRandomString(18)
+	if err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = strings.TrimRight(from[i+1:], ">")
+	}
+
+	return fmt.Sprintf("<%s@%s>", id, domain), nil
+}
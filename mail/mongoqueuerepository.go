@@ -0,0 +1,147 @@
+package mail
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoQueueRepository struct {
+	Collection cfmgo.Collection
+}
+
+type queuedEmailRecord struct {
+	ID          string     `bson:"_id" json:"id"`
+	From        string     `bson:"from" json:"from"`
+	To          string     `bson:"to" json:"to"`
+	Recipient   string     `bson:"recipient" json:"recipient"`
+	Subject     string     `bson:"subject" json:"subject"`
+	HTML        string     `bson:"html" json:"html"`
+	Text        string     `bson:"text,omitempty" json:"text"`
+	Status      string     `bson:"status" json:"status"`
+	Attempts    int        `bson:"attempts" json:"attempts"`
+	LastError   string     `bson:"last_error,omitempty" json:"last_error"`
+	NextAttempt time.Time  `bson:"next_attempt" json:"next_attempt"`
+	Created     time.Time  `bson:"date_created" json:"date_created"`
+	Sent        *time.Time `bson:"date_sent,omitempty" json:"date_sent"`
+}
+
+func NewMongoQueueRepository(col cfmgo.Collection) *mongoQueueRepository {
+	return &mongoQueueRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoQueueRepository) Add(queued *QueuedEmail) (err error) {
+	repo.Collection.Wake()
+	record := toQueuedEmailRecord(queued)
+	_, err = repo.Collection.UpsertID(record.ID, record)
+	return
+}
+
+func (repo *mongoQueueRepository) Update(queued *QueuedEmail) (err error) {
+	repo.Collection.Wake()
+	var records []queuedEmailRecord
+	params := &params.RequestParams{
+		Q: bson.M{"_id": queued.ID},
+	}
+
+	count, err := repo.Collection.Find(params, &records)
+	if count == 0 {
+		err = errors.New("Could not find queued email in repository")
+	}
+	if err == nil {
+		record := toQueuedEmailRecord(queued)
+		_, err = repo.Collection.UpsertID(record.ID, record)
+	}
+
+	return
+}
+
+func (repo *mongoQueueRepository) ListDue(now time.Time, limit int) (emails []*QueuedEmail) {
+	records := repo.find(bson.M{"status": StatusQueued, "next_attempt": bson.M{"$lte": now}})
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+
+	for i := 0; i < len(records) && len(emails) < limit; i++ {
+		emails = append(emails, toQueuedEmail(&records[i]))
+	}
+
+	return emails
+}
+
+func (repo *mongoQueueRepository) ListByRecipient(address string, limit int) (emails []*QueuedEmail) {
+	records := repo.find(bson.M{"recipient": NormalizeAddress(address)})
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.After(records[j].Created)
+	})
+
+	for i := 0; i < len(records) && len(emails) < limit; i++ {
+		emails = append(emails, toQueuedEmail(&records[i]))
+	}
+
+	return emails
+}
+
+func (repo *mongoQueueRepository) ListSentTo(address string, since time.Time) (emails []*QueuedEmail) {
+	records := repo.find(bson.M{"recipient": NormalizeAddress(address), "date_sent": bson.M{"$gte": since}})
+
+	for i := range records {
+		emails = append(emails, toQueuedEmail(&records[i]))
+	}
+
+	return emails
+}
+
+func (repo *mongoQueueRepository) find(query bson.M) (records []queuedEmailRecord) {
+	repo.Collection.Wake()
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	repo.Collection.Find(params, &records)
+	return records
+}
+
+func toQueuedEmailRecord(queued *QueuedEmail) *queuedEmailRecord {
+	return &queuedEmailRecord{
+		ID:          queued.ID,
+		From:        queued.From,
+		To:          queued.To,
+		Recipient:   queued.Recipient,
+		Subject:     queued.Subject,
+		HTML:        queued.HTML,
+		Text:        queued.Text,
+		Status:      queued.Status,
+		Attempts:    queued.Attempts,
+		LastError:   queued.LastError,
+		NextAttempt: queued.NextAttempt,
+		Created:     queued.Created,
+		Sent:        queued.Sent,
+	}
+}
+
+func toQueuedEmail(record *queuedEmailRecord) *QueuedEmail {
+	return &QueuedEmail{
+		ID:          record.ID,
+		From:        record.From,
+		To:          record.To,
+		Recipient:   record.Recipient,
+		Subject:     record.Subject,
+		HTML:        record.HTML,
+		Text:        record.Text,
+		Status:      record.Status,
+		Attempts:    record.Attempts,
+		LastError:   record.LastError,
+		NextAttempt: record.NextAttempt,
+		Created:     record.Created,
+		Sent:        record.Sent,
+	}
+}
@@ -0,0 +1,64 @@
+package mail
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoSuppressionRepository struct {
+	Collection cfmgo.Collection
+}
+
+type suppressionRecord struct {
+	Email   string    `bson:"_id" json:"email"`
+	Reason  string    `bson:"reason" json:"reason"`
+	Detail  string    `bson:"detail,omitempty" json:"detail"`
+	Created time.Time `bson:"date_created" json:"date_created"`
+}
+
+func NewMongoSuppressionRepository(col cfmgo.Collection) *mongoSuppressionRepository {
+	return &mongoSuppressionRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoSuppressionRepository) Add(suppression *Suppression) (err error) {
+	repo.Collection.Wake()
+	record := suppressionRecord{
+		Email:   NormalizeAddress(suppression.Email),
+		Reason:  suppression.Reason,
+		Detail:  suppression.Detail,
+		Created: suppression.Created,
+	}
+
+	_, err = repo.Collection.UpsertID(record.Email, record)
+	return
+}
+
+func (repo *mongoSuppressionRepository) Get(address string) (suppression *Suppression, err error) {
+	repo.Collection.Wake()
+	var records []suppressionRecord
+	params := &params.RequestParams{
+		Q: bson.M{"_id": NormalizeAddress(address)},
+	}
+
+	count, err := repo.Collection.Find(params, &records)
+	if count == 0 {
+		err = errors.New("Could not find suppression in repository")
+	}
+	if err == nil {
+		record := records[0]
+		suppression = &Suppression{
+			Email:   record.Email,
+			Reason:  record.Reason,
+			Detail:  record.Detail,
+			Created: record.Created,
+		}
+	}
+
+	return
+}
@@ -0,0 +1,312 @@
+package mail
+
+import (
+	"crypto"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// snsHostPattern matches the hosts that Amazon SNS signing certificates and
+// subscription confirmations are served from.
+var snsHostPattern = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// NotificationOptions configures the SES bounce and complaint endpoint.
+type NotificationOptions struct {
+	// TopicARNs are the SNS topics that notifications are accepted from.
+	// Anyone can create an SNS topic, so a valid signature alone doesn't
+	// prove a notification is about our emails.
+	TopicARNs []string
+	// FetchCertificate downloads the certificate a message was signed with.
+	FetchCertificate func(certURL string) (*x509.Certificate, error)
+	// ConfirmSubscription visits the URL that confirms an SNS subscription.
+	ConfirmSubscription func(subscribeURL string) error
+}
+
+// snsMessage is an Amazon SNS HTTP(S) message.
+type snsMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicARN         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+}
+
+// sesNotification is the bounce or complaint notification that SES sends
+// as the Message of an SNS notification.
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	Bounce           *struct {
+		BounceType        string `json:"bounceType"`
+		BouncedRecipients []struct {
+			EmailAddress   string `json:"emailAddress"`
+			DiagnosticCode string `json:"diagnosticCode"`
+		} `json:"bouncedRecipients"`
+	} `json:"bounce"`
+	Complaint *struct {
+		ComplaintFeedbackType string `json:"complaintFeedbackType"`
+		ComplainedRecipients  []struct {
+			EmailAddress string `json:"emailAddress"`
+		} `json:"complainedRecipients"`
+	} `json:"complaint"`
+}
+
+func newNotificationOptions(options NotificationOptions) NotificationOptions {
+	if options.FetchCertificate == nil {
+		options.FetchCertificate = newCertificateCache().fetch
+	}
+	if options.ConfirmSubscription == nil {
+		options.ConfirmSubscription = confirmSubscription
+	}
+
+	return options
+}
+
+// verify checks that message was signed by SNS for one of the allowed
+// topics.
+func (message *snsMessage) verify(options NotificationOptions) error {
+	allowed := false
+	for _, topicARN := range options.TopicARNs {
+		allowed = allowed || topicARN == message.TopicARN
+	}
+
+	if !allowed {
+		return fmt.Errorf("Notifications from topic %s are not accepted", message.TopicARN)
+	}
+
+	var hash crypto.Hash
+	switch message.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("Unsupported SNS signature version %q", message.SignatureVersion)
+	}
+
+	if err := checkSNSURL(message.SigningCertURL); err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(message.Signature)
+	if err != nil {
+		return err
+	}
+
+	cert, err := options.FetchCertificate(message.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("SNS signing certificate does not have an RSA key")
+	}
+
+	digest := hash.New()
+	digest.Write([]byte(message.stringToSign()))
+
+	return rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature)
+}
+
+// stringToSign builds the string SNS signs, which depends on the message
+// type.
+func (message *snsMessage) stringToSign() string {
+	keys := []string{"Message", "MessageId", "SubscribeURL", "Timestamp", "Token", "TopicArn", "Type"}
+	if message.Type == "Notification" {
+		keys = []string{"Message", "MessageId", "Subject", "Timestamp", "TopicArn", "Type"}
+	}
+
+	values := map[string]string{
+		"Message":      message.Message,
+		"MessageId":    message.MessageID,
+		"Subject":      message.Subject,
+		"SubscribeURL": message.SubscribeURL,
+		"Timestamp":    message.Timestamp,
+		"Token":        message.Token,
+		"TopicArn":     message.TopicARN,
+		"Type":         message.Type,
+	}
+
+	var builder strings.Builder
+	for _, key := range keys {
+		// A notification without a subject leaves it out entirely.
+		if key == "Subject" && len(values[key]) == 0 {
+			continue
+		}
+
+		builder.WriteString(key + "\n" + values[key] + "\n")
+	}
+
+	return builder.String()
+}
+
+// checkSNSURL rejects URLs that aren't served by SNS over HTTPS, so that a
+// forged message can't make us fetch an arbitrary URL.
+func checkSNSURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	if parsed.Scheme != "https" || !snsHostPattern.MatchString(parsed.Host) {
+		return fmt.Errorf("%s is not an Amazon SNS URL", rawURL)
+	}
+
+	return nil
+}
+
+var snsClient = &http.Client{Timeout: 10 * time.Second}
+
+// certificateCache keeps downloaded signing certificates, since SNS signs
+// every message with the same few.
+type certificateCache struct {
+	mutex sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+func newCertificateCache() *certificateCache {
+	return &certificateCache{certs: map[string]*x509.Certificate{}}
+}
+
+func (cache *certificateCache) fetch(certURL string) (*x509.Certificate, error) {
+	cache.mutex.Lock()
+	cert, ok := cache.certs[certURL]
+	cache.mutex.Unlock()
+
+	if ok {
+		return cert, nil
+	}
+
+	res, err := snsClient.Get(certURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to download SNS signing certificate: %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("SNS signing certificate is not PEM encoded")
+	}
+
+	if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	cache.certs[certURL] = cert
+	cache.mutex.Unlock()
+
+	return cert, nil
+}
+
+func confirmSubscription(subscribeURL string) error {
+	if err := checkSNSURL(subscribeURL); err != nil {
+		return err
+	}
+
+	res, err := snsClient.Get(subscribeURL)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to confirm SNS subscription: %s", res.Status)
+	}
+
+	return nil
+}
+
+// applyNotification suppresses the addresses that permanently bounced or
+// complained, and marks the most recent email sent to each bounced address.
+func applyNotification(notification *sesNotification, queueRepository QueueRepository, suppressionRepository SuppressionRepository) error {
+	switch {
+	case notification.NotificationType == "Bounce" && notification.Bounce != nil:
+		permanent := notification.Bounce.BounceType == "Permanent"
+
+		for _, recipient := range notification.Bounce.BouncedRecipients {
+			if permanent {
+				suppression := NewSuppression(recipient.EmailAddress, SuppressionReasonBounce, recipient.DiagnosticCode)
+				if err := suppressionRepository.Add(suppression); err != nil {
+					return err
+				}
+			}
+
+			if err := markBounced(queueRepository, recipient.EmailAddress, recipient.DiagnosticCode); err != nil {
+				return err
+			}
+		}
+	case notification.NotificationType == "Complaint" && notification.Complaint != nil:
+		for _, recipient := range notification.Complaint.ComplainedRecipients {
+			suppression := NewSuppression(recipient.EmailAddress, SuppressionReasonComplaint, notification.Complaint.ComplaintFeedbackType)
+			if err := suppressionRepository.Add(suppression); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func markBounced(queueRepository QueueRepository, address string, detail string) error {
+	for _, queued := range queueRepository.ListByRecipient(address, 1) {
+		if queued.Status != StatusSent {
+			continue
+		}
+
+		queued.Status = StatusBounced
+		queued.LastError = detail
+		return queueRepository.Update(queued)
+	}
+
+	return nil
+}
+
+// parseNotification decodes an SNS message, and the SES notification it
+// carries if it is one.
+func parseNotification(payload []byte) (*snsMessage, *sesNotification, error) {
+	var message snsMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return nil, nil, err
+	}
+
+	if message.Type != "Notification" {
+		return &message, nil, nil
+	}
+
+	var notification sesNotification
+	if err := json.Unmarshal([]byte(message.Message), &notification); err != nil {
+		return nil, nil, err
+	}
+
+	return &message, &notification, nil
+}
@@ -0,0 +1,221 @@
+package mail
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	testTopicARN = "arn:aws:sns:us-east-1:123456789012:ses-notifications"
+	testCertURL  = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+type notificationFixture struct {
+	router       *mux.Router
+	key          *rsa.PrivateKey
+	repository   *inMemoryQueueRepository
+	suppressions *inMemorySuppressionRepository
+	confirmed    []string
+}
+
+func newNotificationFixture(t *testing.T) *notificationFixture {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+
+	fixture := &notificationFixture{
+		router:       mux.NewRouter(),
+		key:          key,
+		repository:   NewInMemoryQueueRepository(),
+		suppressions: NewInMemorySuppressionRepository(),
+	}
+
+	InitNotificationRoutes(fixture.router, formatter, fixture.repository, fixture.suppressions, NotificationOptions{
+		TopicARNs: []string{testTopicARN},
+		FetchCertificate: func(certURL string) (*x509.Certificate, error) {
+			return cert, nil
+		},
+		ConfirmSubscription: func(subscribeURL string) error {
+			fixture.confirmed = append(fixture.confirmed, subscribeURL)
+			return nil
+		},
+	})
+
+	return fixture
+}
+
+// post signs message, as SNS would, and posts it to the endpoint.
+func (fixture *notificationFixture) post(t *testing.T, message snsMessage) *httptest.ResponseRecorder {
+	if len(message.TopicARN) == 0 {
+		message.TopicARN = testTopicARN
+	}
+	message.MessageID = "message-1"
+	message.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	message.SignatureVersion = "2"
+	message.SigningCertURL = testCertURL
+
+	digest := sha256.Sum256([]byte(message.stringToSign()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, fixture.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	message.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	return fixture.postRaw(message)
+}
+
+func (fixture *notificationFixture) postRaw(message snsMessage) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(message)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/email/notifications", bytes.NewReader(body))
+	fixture.router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func notificationMessage(notification string) snsMessage {
+	return snsMessage{Type: "Notification", Message: notification}
+}
+
+func TestPermanentBounceSuppressesAddress(t *testing.T) {
+	fixture := newNotificationFixture(t)
+
+	sent := time.Now()
+	fixture.repository.Add(&QueuedEmail{ID: "1", Recipient: "bounced@spearwind.io", Status: StatusSent, Sent: &sent, Created: sent})
+
+	recorder := fixture.post(t, notificationMessage(`{
+		"notificationType": "Bounce",
+		"bounce": {
+			"bounceType": "Permanent",
+			"bouncedRecipients": [{"emailAddress": "Bounced@Spearwind.io", "diagnosticCode": "smtp; 550 5.1.1 user unknown"}]
+		}
+	}`))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v; received %v: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	suppression, err := fixture.suppressions.Get("bounced@spearwind.io")
+	if err != nil {
+		t.Fatalf("Expected the address to be suppressed")
+	}
+
+	if suppression.Reason != SuppressionReasonBounce || suppression.Detail != "smtp; 550 5.1.1 user unknown" {
+		t.Errorf("Unexpected suppression: %+v", suppression)
+	}
+
+	if queued := onlyEmail(t, fixture.repository, "bounced@spearwind.io"); queued.Status != StatusBounced {
+		t.Errorf("Expected the sent email to be marked bounced, got %s", queued.Status)
+	}
+}
+
+func TestTransientBounceDoesNotSuppress(t *testing.T) {
+	fixture := newNotificationFixture(t)
+
+	fixture.post(t, notificationMessage(`{
+		"notificationType": "Bounce",
+		"bounce": {"bounceType": "Transient", "bouncedRecipients": [{"emailAddress": "full@spearwind.io"}]}
+	}`))
+
+	if _, err := fixture.suppressions.Get("full@spearwind.io"); err == nil {
+		t.Errorf("Expected a transient bounce not to suppress the address")
+	}
+}
+
+func TestComplaintSuppressesAddress(t *testing.T) {
+	fixture := newNotificationFixture(t)
+
+	fixture.post(t, notificationMessage(`{
+		"notificationType": "Complaint",
+		"complaint": {"complaintFeedbackType": "abuse", "complainedRecipients": [{"emailAddress": "annoyed@spearwind.io"}]}
+	}`))
+
+	if suppression, err := fixture.suppressions.Get("annoyed@spearwind.io"); err != nil || suppression.Reason != SuppressionReasonComplaint {
+		t.Errorf("Expected the address to be suppressed for complaining, got %+v", suppression)
+	}
+}
+
+func TestSubscriptionIsConfirmed(t *testing.T) {
+	fixture := newNotificationFixture(t)
+	subscribeURL := "https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription&Token=abc"
+
+	recorder := fixture.post(t, snsMessage{Type: "SubscriptionConfirmation", Message: "Confirm", Token: "abc", SubscribeURL: subscribeURL})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected %v; received %v", http.StatusOK, recorder.Code)
+	}
+
+	if len(fixture.confirmed) != 1 || fixture.confirmed[0] != subscribeURL {
+		t.Errorf("Expected the subscription to be confirmed, got %v", fixture.confirmed)
+	}
+}
+
+func TestUnverifiedNotificationsAreRejected(t *testing.T) {
+	fixture := newNotificationFixture(t)
+	complaint := `{"notificationType": "Complaint", "complaint": {"complainedRecipients": [{"emailAddress": "victim@spearwind.io"}]}}`
+
+	forged := notificationMessage(complaint)
+	forged.TopicARN = testTopicARN
+	forged.SignatureVersion = "2"
+	forged.SigningCertURL = testCertURL
+	forged.Signature = base64.StdEncoding.EncodeToString([]byte("forged"))
+
+	otherTopic := notificationMessage(complaint)
+	otherTopic.TopicARN = "arn:aws:sns:us-east-1:999999999999:someone-elses-topic"
+
+	if recorder := fixture.postRaw(forged); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected a bad signature to be rejected with %v; received %v", http.StatusForbidden, recorder.Code)
+	}
+
+	if recorder := fixture.post(t, otherTopic); recorder.Code != http.StatusForbidden {
+		t.Errorf("Expected another topic to be rejected with %v; received %v", http.StatusForbidden, recorder.Code)
+	}
+
+	if _, err := fixture.suppressions.Get("victim@spearwind.io"); err == nil {
+		t.Errorf("Expected unverified notifications to be ignored")
+	}
+}
+
+func TestCheckSNSURL(t *testing.T) {
+	for _, rawURL := range []string{
+		"https://sns.us-east-1.amazonaws.com/cert.pem",
+		"https://sns.cn-north-1.amazonaws.com.cn/cert.pem",
+	} {
+		if err := checkSNSURL(rawURL); err != nil {
+			t.Errorf("Expected %s to be allowed, got %v", rawURL, err)
+		}
+	}
+
+	for _, rawURL := range []string{
+		"http://sns.us-east-1.amazonaws.com/cert.pem",
+		"https://sns.us-east-1.amazonaws.com.evil.example/cert.pem",
+		"https://example.com/sns.us-east-1.amazonaws.com/cert.pem",
+		"https://169.254.169.254/latest/meta-data",
+	} {
+		if err := checkSNSURL(rawURL); err == nil {
+			t.Errorf("Expected %s to be rejected", rawURL)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dave-malone/email"
+	"github.com/spear-wind/cms/security"
+)
+
+const (
+	StatusQueued     = "queued"
+	StatusSent       = "sent"
+	StatusFailed     = "failed"
+	StatusBounced    = "bounced"
+	StatusSuppressed = "suppressed"
+)
+
+type QueueRepository interface {
+	Add(queued *QueuedEmail) (err error)
+	Update(queued *QueuedEmail) (err error)
+	// ListDue returns queued emails whose next attempt is at or before now,
+	// oldest first.
+	ListDue(now time.Time, limit int) (emails []*QueuedEmail)
+	// ListByRecipient returns the most recent emails to address first.
+	ListByRecipient(address string, limit int) (emails []*QueuedEmail)
+	// ListSentTo returns the emails sent to address since the given time.
+	ListSentTo(address string, since time.Time) (emails []*QueuedEmail)
+}
+
+// QueuedEmail is a rendered email waiting to be sent, or the record of one
+// that has been.
+type QueuedEmail struct {
+	ID          string     `json:"id"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Recipient   string     `json:"recipient"`
+	Subject     string     `json:"subject"`
+	HTML        string     `json:"html"`
+	Text        string     `json:"text,omitempty"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	Created     time.Time  `json:"date_created"`
+	Sent        *time.Time `json:"date_sent,omitempty"`
+}
+
+// String and PlainText let a QueuedEmail be sent as an email.MessageBody.
+func (queued *QueuedEmail) String() (string, error) {
+	return queued.HTML, nil
+}
+
+func (queued *QueuedEmail) PlainText() string {
+	return queued.Text
+}
+
+// QueueOptions configures a Queue.
+type QueueOptions struct {
+	// PollInterval is how often the queue is checked for due emails.
+	PollInterval time.Duration
+	// BatchSize is how many emails are sent per poll.
+	BatchSize int
+	// MaxAttempts is how many times an email is tried before it is failed.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles on each retry.
+	Backoff time.Duration
+	// RatePerSecond is how many emails each process sends per second.
+	RatePerSecond int
+	// RecipientLimit is how many emails one address is sent per
+	// RecipientWindow. Emails over the limit wait until it allows them.
+	RecipientLimit  int
+	RecipientWindow time.Duration
+}
+
+// DefaultQueueOptions are used for any QueueOptions field left at zero.
+var DefaultQueueOptions = QueueOptions{
+	PollInterval:    time.Second,
+	BatchSize:       50,
+	MaxAttempts:     5,
+	Backoff:         time.Minute,
+	RatePerSecond:   10,
+	RecipientLimit:  5,
+	RecipientWindow: time.Hour,
+}
+
+// Queue is an email.Sender that saves each message to a QueueRepository and
+// sends it with another Sender in the background, so that failed sends are
+// retried and every email's status can be looked up later. Addresses in the
+// suppression list, because they bounced or complained, are skipped.
+type Queue struct {
+	sender       email.Sender
+	repository   QueueRepository
+	suppressions SuppressionRepository
+	options      QueueOptions
+	interval     time.Duration
+	lastSent     time.Time
+
+	dispatchMutex sync.Mutex
+
+	stateMutex sync.Mutex
+	running    bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+// permanentError marks a send failure that retrying won't fix.
+type permanentError struct {
+	error
+}
+
+// Permanent marks err as a failure that the Queue shouldn't retry.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// isPermanent reports whether err was marked Permanent, or is an SMTP
+// 5xx reply.
+func isPermanent(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return true
+	}
+
+	var reply *textproto.Error
+	return errors.As(err, &reply) && reply.Code >= 500
+}
+
+func NewQueue(sender email.Sender, repository QueueRepository, suppressions SuppressionRepository, options QueueOptions) *Queue {
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultQueueOptions.PollInterval
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultQueueOptions.BatchSize
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultQueueOptions.MaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultQueueOptions.Backoff
+	}
+	if options.RatePerSecond <= 0 {
+		options.RatePerSecond = DefaultQueueOptions.RatePerSecond
+	}
+	if options.RecipientLimit <= 0 {
+		options.RecipientLimit = DefaultQueueOptions.RecipientLimit
+	}
+	if options.RecipientWindow <= 0 {
+		options.RecipientWindow = DefaultQueueOptions.RecipientWindow
+	}
+
+	return &Queue{
+		sender:       sender,
+		repository:   repository,
+		suppressions: suppressions,
+		options:      options,
+		interval:     time.Second / time.Duration(options.RatePerSecond),
+	}
+}
+
+// Send renders m and adds it to the queue. Emails to suppressed or invalid
+// addresses are saved with their status rather than returning an error, so
+// that they aren't retried.
+func (q *Queue) Send(m *email.Message) error {
+	html, err := m.Body.String()
+	if err != nil {
+		return err
+	}
+
+	id, err := security.GenerateRandomString(16)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	queued := &QueuedEmail{
+		ID:          id,
+		From:        m.From,
+		To:          m.To,
+		Subject:     m.Subject,
+		HTML:        html,
+		Status:      StatusQueued,
+		NextAttempt: now,
+		Created:     now,
+	}
+
+	if text, ok := m.Body.(plainTexter); ok {
+		queued.Text = text.PlainText()
+	}
+
+	if address, err := mail.ParseAddress(m.To); err != nil {
+		queued.Status = StatusFailed
+		queued.LastError = fmt.Sprintf("Invalid to address: %v", err)
+	} else {
+		queued.Recipient = NormalizeAddress(address.Address)
+		if q.suppressed(queued.Recipient) {
+			queued.Status = StatusSuppressed
+		}
+	}
+
+	return q.repository.Add(queued)
+}
+
+// Start begins sending queued emails, starting with any left over from a
+// previous run.
+func (q *Queue) Start() {
+	q.stateMutex.Lock()
+	defer q.stateMutex.Unlock()
+
+	if q.running {
+		return
+	}
+
+	q.running = true
+	q.stop = make(chan struct{})
+	q.stopped = make(chan struct{})
+	stop, stopped := q.stop, q.stopped
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(q.options.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			q.DispatchPending()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// DispatchPending sends one batch of due emails and returns how many it
+// processed, including those it failed, skipped or postponed.
+func (q *Queue) DispatchPending() (processed int) {
+	q.dispatchMutex.Lock()
+	defer q.dispatchMutex.Unlock()
+
+	for _, queued := range q.repository.ListDue(time.Now(), q.options.BatchSize) {
+		q.dispatch(queued)
+
+		if err := q.repository.Update(queued); err != nil {
+			fmt.Printf("Failed to update queued email %s: %v\n", queued.ID, err)
+		}
+
+		processed++
+	}
+
+	return processed
+}
+
+func (q *Queue) dispatch(queued *QueuedEmail) {
+	if q.suppressed(queued.Recipient) {
+		queued.Status = StatusSuppressed
+		return
+	}
+
+	if next, ok := q.recipientAllows(queued.Recipient); !ok {
+		queued.NextAttempt = next
+		return
+	}
+
+	q.throttle()
+
+	err := q.sender.Send(email.NewMessage(queued.From, queued.To, queued.Subject, queued))
+	now := time.Now()
+	queued.Attempts++
+
+	if err == nil {
+		queued.Status = StatusSent
+		queued.Sent = &now
+		queued.LastError = ""
+		return
+	}
+
+	fmt.Printf("Failed to send queued email %s (attempt %d): %v\n", queued.ID, queued.Attempts, err)
+	queued.LastError = err.Error()
+
+	if isPermanent(err) || queued.Attempts >= q.options.MaxAttempts {
+		queued.Status = StatusFailed
+		return
+	}
+
+	queued.NextAttempt = now.Add(q.options.Backoff << uint(queued.Attempts-1))
+}
+
+// recipientAllows reports whether address may be sent another email now, and
+// if not, when it may.
+func (q *Queue) recipientAllows(address string) (time.Time, bool) {
+	now := time.Now()
+	sent := q.repository.ListSentTo(address, now.Add(-q.options.RecipientWindow))
+	if len(sent) < q.options.RecipientLimit {
+		return now, true
+	}
+
+	// The window allows another email once enough of the sent emails have
+	// fallen out of it.
+	times := make([]time.Time, 0, len(sent))
+	for _, queued := range sent {
+		times = append(times, *queued.Sent)
+	}
+
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	return times[len(times)-q.options.RecipientLimit].Add(q.options.RecipientWindow), false
+}
+
+// throttle waits until sending another email keeps to RatePerSecond.
+func (q *Queue) throttle() {
+	if wait := q.interval - time.Since(q.lastSent); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	q.lastSent = time.Now()
+}
+
+func (q *Queue) suppressed(address string) bool {
+	_, err := q.suppressions.Get(address)
+	return err == nil
+}
+
+// Shutdown stops sending after the emails that are already due have been
+// sent or postponed, or when ctx is done. Anything left over is sent after the next Start.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.stateMutex.Lock()
+	running, stopped := q.running, q.stopped
+	if running {
+		q.running = false
+		close(q.stop)
+	}
+	q.stateMutex.Unlock()
+
+	if running {
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		if q.DispatchPending() == 0 {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// NormalizeAddress returns address in the form used to look up the
+// suppression list.
+func NormalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
@@ -0,0 +1,223 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dave-malone/email"
+)
+
+// scriptedSender fails with the queued errors, in order, then succeeds.
+type scriptedSender struct {
+	mutex    sync.Mutex
+	failures []error
+	sent     []*email.Message
+}
+
+func (s *scriptedSender) Send(m *email.Message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.failures) != 0 {
+		err := s.failures[0]
+		s.failures = s.failures[1:]
+		return err
+	}
+
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func newTestQueue(sender email.Sender, options QueueOptions) (*Queue, *inMemoryQueueRepository, *inMemorySuppressionRepository) {
+	if options.RatePerSecond == 0 {
+		options.RatePerSecond = 1000
+	}
+
+	repository := NewInMemoryQueueRepository()
+	suppressions := NewInMemorySuppressionRepository()
+	return NewQueue(sender, repository, suppressions, options), repository, suppressions
+}
+
+func onlyEmail(t *testing.T, repository *inMemoryQueueRepository, address string) *QueuedEmail {
+	emails := repository.ListByRecipient(address, 10)
+	if len(emails) != 1 {
+		t.Fatalf("Expected one email to %s, got %d", address, len(emails))
+	}
+
+	return emails[0]
+}
+
+func TestQueueSendsEmails(t *testing.T) {
+	sender := &scriptedSender{}
+	queue, repository, _ := newTestQueue(sender, QueueOptions{})
+
+	if err := queue.Send(email.NewMessage("no-reply@spearwind.io", "Test <Test@Spearwind.io>", "Welcome", newRendered())); err != nil {
+		t.Fatalf("Failed to queue: %v", err)
+	}
+
+	if len(sender.sent) != 0 {
+		t.Fatalf("Expected the email to wait in the queue")
+	}
+
+	if processed := queue.DispatchPending(); processed != 1 {
+		t.Fatalf("Expected 1 email to be processed, got %d", processed)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Fatalf("Expected the email to be sent")
+	}
+
+	if text, ok := sender.sent[0].Body.(plainTexter); !ok || text.PlainText() != "Your code is ABC123" {
+		t.Errorf("Expected the plain-text part to be kept")
+	}
+
+	queued := onlyEmail(t, repository, "test@spearwind.io")
+	if queued.Status != StatusSent || queued.Sent == nil || queued.Attempts != 1 {
+		t.Errorf("Expected the email to be recorded as sent, got %+v", queued)
+	}
+}
+
+func TestQueueRetriesTemporaryFailures(t *testing.T) {
+	sender := &scriptedSender{failures: []error{errors.New("connection refused")}}
+	queue, repository, _ := newTestQueue(sender, QueueOptions{Backoff: time.Hour})
+
+	queue.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+	queue.DispatchPending()
+
+	queued := onlyEmail(t, repository, "test@spearwind.io")
+	if queued.Status != StatusQueued || queued.Attempts != 1 || queued.LastError != "connection refused" {
+		t.Fatalf("Expected the email to stay queued after a temporary failure, got %+v", queued)
+	}
+
+	if !queued.NextAttempt.After(time.Now().Add(50 * time.Minute)) {
+		t.Errorf("Expected the retry to back off, got %v", queued.NextAttempt)
+	}
+
+	if processed := queue.DispatchPending(); processed != 0 {
+		t.Errorf("Expected the email not to be retried before its backoff, got %d", processed)
+	}
+
+	queued.NextAttempt = time.Now()
+	repository.Update(queued)
+	queue.DispatchPending()
+
+	if queued := onlyEmail(t, repository, "test@spearwind.io"); queued.Status != StatusSent || queued.Attempts != 2 {
+		t.Errorf("Expected the retry to send the email, got %+v", queued)
+	}
+}
+
+func TestQueueFailsPermanentFailures(t *testing.T) {
+	tests := []error{
+		&textproto.Error{Code: 550, Msg: "mailbox unavailable"},
+		Permanent(errors.New("rejected")),
+	}
+
+	for _, failure := range tests {
+		sender := &scriptedSender{failures: []error{failure}}
+		queue, repository, _ := newTestQueue(sender, QueueOptions{})
+
+		queue.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+		queue.DispatchPending()
+
+		if queued := onlyEmail(t, repository, "test@spearwind.io"); queued.Status != StatusFailed || queued.Attempts != 1 {
+			t.Errorf("%v: expected the email to fail without retrying, got %+v", failure, queued)
+		}
+	}
+}
+
+func TestQueueFailsAfterMaxAttempts(t *testing.T) {
+	failure := &textproto.Error{Code: 451, Msg: "try again later"}
+	sender := &scriptedSender{failures: []error{failure, failure}}
+	queue, repository, _ := newTestQueue(sender, QueueOptions{MaxAttempts: 2, Backoff: time.Nanosecond})
+
+	queue.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+	queue.DispatchPending()
+	time.Sleep(time.Millisecond)
+	queue.DispatchPending()
+
+	if queued := onlyEmail(t, repository, "test@spearwind.io"); queued.Status != StatusFailed || queued.Attempts != 2 {
+		t.Errorf("Expected the email to fail after 2 attempts, got %+v", queued)
+	}
+}
+
+func TestQueueSkipsSuppressedAddresses(t *testing.T) {
+	sender := &scriptedSender{}
+	queue, repository, suppressions := newTestQueue(sender, QueueOptions{})
+	suppressions.Add(NewSuppression("Bounced@Spearwind.io", SuppressionReasonBounce, ""))
+
+	queue.Send(email.NewMessage("no-reply@spearwind.io", "bounced@spearwind.io", "Welcome", newRendered()))
+	queue.Send(email.NewMessage("no-reply@spearwind.io", "later@spearwind.io", "Welcome", newRendered()))
+	suppressions.Add(NewSuppression("later@spearwind.io", SuppressionReasonComplaint, ""))
+	queue.DispatchPending()
+
+	if len(sender.sent) != 0 {
+		t.Errorf("Expected no emails to suppressed addresses, got %d", len(sender.sent))
+	}
+
+	for _, address := range []string{"bounced@spearwind.io", "later@spearwind.io"} {
+		if queued := onlyEmail(t, repository, address); queued.Status != StatusSuppressed {
+			t.Errorf("Expected the email to %s to be suppressed, got %s", address, queued.Status)
+		}
+	}
+}
+
+func TestQueueRecordsInvalidAddresses(t *testing.T) {
+	queue, repository, _ := newTestQueue(&scriptedSender{}, QueueOptions{})
+
+	if err := queue.Send(email.NewMessage("no-reply@spearwind.io", "not an address", "Welcome", newRendered())); err != nil {
+		t.Fatalf("Expected the email to be recorded rather than fail, got %v", err)
+	}
+
+	if emails := repository.ListDue(time.Now(), 10); len(emails) != 0 {
+		t.Errorf("Expected the email not to be sent")
+	}
+}
+
+func TestQueueLimitsEmailsPerRecipient(t *testing.T) {
+	sender := &scriptedSender{}
+	queue, repository, _ := newTestQueue(sender, QueueOptions{RecipientLimit: 2, RecipientWindow: time.Hour})
+
+	for i := 0; i < 3; i++ {
+		queue.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+	}
+	queue.Send(email.NewMessage("no-reply@spearwind.io", "other@spearwind.io", "Welcome", newRendered()))
+
+	if processed := queue.DispatchPending(); processed != 4 {
+		t.Fatalf("Expected 4 emails to be processed, got %d", processed)
+	}
+
+	if len(sender.sent) != 3 {
+		t.Fatalf("Expected 2 emails to the first address and 1 to the other, got %d", len(sender.sent))
+	}
+
+	emails := repository.ListByRecipient("test@spearwind.io", 10)
+	postponed := emails[0]
+	if postponed.Status != StatusQueued || postponed.Attempts != 0 || !postponed.NextAttempt.After(time.Now().Add(50*time.Minute)) {
+		t.Errorf("Expected the third email to wait for the window, got %+v", postponed)
+	}
+}
+
+func TestQueueShutdownSendsDueEmails(t *testing.T) {
+	sender := &scriptedSender{}
+	queue, _, _ := newTestQueue(sender, QueueOptions{PollInterval: time.Hour, RecipientLimit: 1})
+
+	queue.Start()
+	for i := 0; i < 2; i++ {
+		queue.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	if err := queue.Shutdown(ctx); err != nil {
+		t.Fatalf("Expected shutdown to finish, got %v", err)
+	}
+
+	if len(sender.sent) != 1 {
+		t.Errorf("Expected only the email the recipient limit allows to be sent, got %d", len(sender.sent))
+	}
+}
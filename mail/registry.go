@@ -0,0 +1,413 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+const (
+	templateExtension = ".tpl"
+	layoutsDir        = "layouts"
+	partialsDir       = "partials"
+	defaultLayout     = "default"
+
+	partSubject = "subject"
+	partHTML    = "html"
+	partText    = "text"
+)
+
+// Registry holds every email template, parsed once at startup.
+//
+// A template is a set of files in the template directory named
+// <name>[.<locale>].<part>.tpl, where part is subject, html or text. The
+// default variant, without a locale, needs all three parts; each localized
+// variant needs a matching html and text part and may leave out the subject
+// to reuse the default one.
+//
+// The html and text parts are rendered inside layouts/<name>.<part>.tpl, or
+// layouts/default.<part>.tpl if there is no layout named after the template,
+// which includes the part with {{template "content" .}}. Files in partials/
+// are available to every html or text part, and layout, by their name, so
+// partials/footer.html.tpl is included with {{template "footer" .}}.
+type Registry struct {
+	templates map[string]map[string]*variant
+}
+
+// variant is one localized version of a template.
+type variant struct {
+	subject *texttemplate.Template
+	html    *htmltemplate.Template
+	text    *texttemplate.Template
+}
+
+// executor is implemented by both html/template and text/template.
+type executor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// Rendered is an email produced by a template. It implements the
+// email.MessageBody interface with its HTML part.
+type Rendered struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+func (r *Rendered) String() (string, error) {
+	return r.HTML, nil
+}
+
+// PlainText returns the plain-text alternative of the HTML body.
+func (r *Rendered) PlainText() string {
+	return r.Text
+}
+
+type templateFile struct {
+	name   string
+	locale string
+	part   string
+	source string
+}
+
+// LoadRegistry parses every template in dir. It fails if any template
+// doesn't parse or is missing a part, so that mistakes are found at startup
+// rather than when an email is sent.
+func LoadRegistry(dir string) (*Registry, error) {
+	layouts, err := readParts(filepath.Join(dir, layoutsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	partials, err := readParts(filepath.Join(dir, partialsDir))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := readTemplateFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No email templates found in %s", dir)
+	}
+
+	registry := &Registry{templates: map[string]map[string]*variant{}}
+	sources := map[string]map[string]map[string]string{}
+
+	for _, file := range files {
+		if sources[file.name] == nil {
+			sources[file.name] = map[string]map[string]string{}
+		}
+		if sources[file.name][file.locale] == nil {
+			sources[file.name][file.locale] = map[string]string{}
+		}
+
+		sources[file.name][file.locale][file.part] = file.source
+	}
+
+	for name, locales := range sources {
+		if err := checkParts(name, locales); err != nil {
+			return nil, err
+		}
+
+		registry.templates[name] = map[string]*variant{}
+		for locale, parts := range locales {
+			v, err := parseVariant(name, parts, layouts, partials)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to parse email template %s: %v", variantName(name, locale), err)
+			}
+
+			registry.templates[name][locale] = v
+		}
+	}
+
+	return registry, nil
+}
+
+// Names returns the name of every template in the registry.
+func (registry *Registry) Names() (names []string) {
+	for name := range registry.templates {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Render renders the named template with data, using the variant that best
+// matches locale: an exact match such as "pt-br", then the language alone
+// such as "pt", then the default.
+func (registry *Registry) Render(name string, locale string, data interface{}) (*Rendered, error) {
+	variants, ok := registry.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown email template %s", name)
+	}
+
+	var body, subject *variant
+	for _, candidate := range localeCandidates(locale) {
+		if v, ok := variants[candidate]; ok {
+			if body == nil && v.html != nil {
+				body = v
+			}
+			if subject == nil && v.subject != nil {
+				subject = v
+			}
+		}
+	}
+
+	rendered := &Rendered{}
+	var err error
+
+	if rendered.Subject, err = execute(subject.subject, partSubject, data); err != nil {
+		return nil, err
+	}
+
+	rendered.Subject = strings.TrimSpace(rendered.Subject)
+	if strings.ContainsAny(rendered.Subject, "\r\n") {
+		return nil, fmt.Errorf("Email template %s rendered a subject with a line break", name)
+	}
+
+	if rendered.HTML, err = execute(body.html, partHTML, data); err != nil {
+		return nil, err
+	}
+
+	if rendered.Text, err = execute(body.text, partText, data); err != nil {
+		return nil, err
+	}
+
+	return rendered, nil
+}
+
+func execute(t executor, entry string, data interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, entry, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+func parseVariant(name string, parts map[string]string, layouts map[string]map[string]string, partials map[string]map[string]string) (*variant, error) {
+	v := &variant{}
+	var err error
+
+	if source, ok := parts[partSubject]; ok {
+		if v.subject, err = texttemplate.New(partSubject).Option("missingkey=error").Parse(source); err != nil {
+			return nil, err
+		}
+	}
+
+	if source, ok := parts[partHTML]; ok {
+		t := htmltemplate.New(partHTML).Option("missingkey=error")
+		if err = parseHTML(t, source, layoutFor(name, partHTML, layouts), partials[partHTML]); err != nil {
+			return nil, err
+		}
+		v.html = t
+	}
+
+	if source, ok := parts[partText]; ok {
+		t := texttemplate.New(partText).Option("missingkey=error")
+		if err = parseText(t, source, layoutFor(name, partText, layouts), partials[partText]); err != nil {
+			return nil, err
+		}
+		v.text = t
+	}
+
+	return v, nil
+}
+
+// parseHTML adds the partials and content to t, and makes t itself the
+// layout, or just the content if there is no layout.
+func parseHTML(t *htmltemplate.Template, content string, layout string, partials map[string]string) error {
+	for name, source := range partials {
+		if _, err := t.New(name).Parse(source); err != nil {
+			return err
+		}
+	}
+
+	if len(layout) == 0 {
+		_, err := t.Parse(content)
+		return err
+	}
+
+	if _, err := t.New("content").Parse(content); err != nil {
+		return err
+	}
+
+	_, err := t.Parse(layout)
+	return err
+}
+
+// parseText is parseHTML for text/template.
+func parseText(t *texttemplate.Template, content string, layout string, partials map[string]string) error {
+	for name, source := range partials {
+		if _, err := t.New(name).Parse(source); err != nil {
+			return err
+		}
+	}
+
+	if len(layout) == 0 {
+		_, err := t.Parse(content)
+		return err
+	}
+
+	if _, err := t.New("content").Parse(content); err != nil {
+		return err
+	}
+
+	_, err := t.Parse(layout)
+	return err
+}
+
+func layoutFor(name string, part string, layouts map[string]map[string]string) string {
+	if layout, ok := layouts[part][name]; ok {
+		return layout
+	}
+
+	return layouts[part][defaultLayout]
+}
+
+func checkParts(name string, locales map[string]map[string]string) error {
+	defaults, ok := locales[""]
+	if !ok {
+		return fmt.Errorf("Email template %s has no default variant", name)
+	}
+
+	if _, ok := defaults[partSubject]; !ok {
+		return fmt.Errorf("Email template %s has no subject", name)
+	}
+
+	for locale, parts := range locales {
+		_, hasHTML := parts[partHTML]
+		_, hasText := parts[partText]
+
+		if hasHTML != hasText || (len(locale) == 0 && !hasHTML) {
+			return fmt.Errorf("Email template %s needs both an html and a text part", variantName(name, locale))
+		}
+	}
+
+	return nil
+}
+
+// readTemplateFiles reads the templates at the top level of dir.
+func readTemplateFiles(dir string) (files []templateFile, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), templateExtension) {
+			continue
+		}
+
+		file, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		source, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		file.source = string(source)
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// readParts reads the layouts or partials in dir, keyed by part and then by
+// name. A missing dir has none.
+func readParts(dir string) (map[string]map[string]string, error) {
+	parts := map[string]map[string]string{}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return parts, nil
+		}
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), templateExtension) {
+			continue
+		}
+
+		file, err := parseFileName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		if file.part == partSubject || len(file.locale) != 0 {
+			return nil, fmt.Errorf("%s must be named <name>.html.tpl or <name>.text.tpl", filepath.Join(dir, entry.Name()))
+		}
+
+		source, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		if parts[file.part] == nil {
+			parts[file.part] = map[string]string{}
+		}
+		parts[file.part][file.name] = string(source)
+	}
+
+	return parts, nil
+}
+
+func parseFileName(fileName string) (file templateFile, err error) {
+	fields := strings.Split(strings.TrimSuffix(fileName, templateExtension), ".")
+
+	switch len(fields) {
+	case 2:
+		file.name, file.part = fields[0], fields[1]
+	case 3:
+		file.name, file.locale, file.part = fields[0], normalizeLocale(fields[1]), fields[2]
+	default:
+		return file, fmt.Errorf("Email template %s must be named <name>[.<locale>].<part>.tpl", fileName)
+	}
+
+	if file.part != partSubject && file.part != partHTML && file.part != partText {
+		return file, fmt.Errorf("Email template %s has unknown part %q; expected subject, html or text", fileName, file.part)
+	}
+
+	return file, nil
+}
+
+func normalizeLocale(locale string) string {
+	return strings.ToLower(strings.Replace(locale, "_", "-", -1))
+}
+
+// localeCandidates lists the variants to try for locale, best match first.
+func localeCandidates(locale string) (candidates []string) {
+	locale = normalizeLocale(locale)
+	if len(locale) != 0 {
+		candidates = append(candidates, locale)
+		if i := strings.Index(locale, "-"); i > 0 {
+			candidates = append(candidates, locale[:i])
+		}
+	}
+
+	return append(candidates, "")
+}
+
+func variantName(name string, locale string) string {
+	if len(locale) == 0 {
+		return name
+	}
+
+	return name + "." + locale
+}
@@ -0,0 +1,188 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type greeting struct {
+	Name string
+}
+
+var baseTemplates = map[string]string{
+	"layouts/default.html.tpl":   `<html>{{template "content" .}}{{template "footer"}}</html>`,
+	"layouts/default.text.tpl":   `{{template "content" .}} {{template "footer"}}`,
+	"partials/footer.html.tpl":   `<footer>bye</footer>`,
+	"partials/footer.text.tpl":   `-- bye`,
+	"welcome.subject.tpl":        "Welcome {{.Name}}\n",
+	"welcome.html.tpl":           `<p>Hello {{.Name}}</p>`,
+	"welcome.text.tpl":           `Hello {{.Name}}`,
+	"welcome.es.subject.tpl":     `Bienvenido {{.Name}}`,
+	"welcome.es.html.tpl":        `<p>Hola {{.Name}}</p>`,
+	"welcome.es.text.tpl":        `Hola {{.Name}}`,
+	"welcome.pt-br.html.tpl":     `<p>Olá {{.Name}}</p>`,
+	"welcome.pt-br.text.tpl":     `Olá {{.Name}}`,
+	"layouts/receipt.text.tpl":   `RECEIPT {{template "content" .}}`,
+	"receipt.subject.tpl":        `Receipt`,
+	"receipt.html.tpl":           `<p>Paid</p>`,
+	"receipt.text.tpl":           `Paid`,
+	"partials/ignored-dir/.keep": ``,
+}
+
+func writeTemplates(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func withFiles(changes map[string]string) map[string]string {
+	files := map[string]string{}
+	for name, source := range baseTemplates {
+		files[name] = source
+	}
+	for name, source := range changes {
+		if len(source) == 0 {
+			delete(files, name)
+		} else {
+			files[name] = source
+		}
+	}
+
+	return files
+}
+
+func loadTestRegistry(t *testing.T) *Registry {
+	registry, err := LoadRegistry(writeTemplates(t, baseTemplates))
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	return registry
+}
+
+func TestRenderUsesLayoutsAndPartials(t *testing.T) {
+	rendered, err := loadTestRegistry(t).Render("welcome", "", greeting{Name: "<Ann>"})
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+
+	if rendered.Subject != "Welcome <Ann>" {
+		t.Errorf("Expected a trimmed subject, got %q", rendered.Subject)
+	}
+
+	if rendered.HTML != "<html><p>Hello &lt;Ann&gt;</p><footer>bye</footer></html>" {
+		t.Errorf("Expected escaped HTML inside the layout, got %q", rendered.HTML)
+	}
+
+	if rendered.Text != "Hello <Ann> -- bye" {
+		t.Errorf("Expected unescaped text inside the layout, got %q", rendered.Text)
+	}
+}
+
+func TestRenderUsesTemplateSpecificLayout(t *testing.T) {
+	rendered, err := loadTestRegistry(t).Render("receipt", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to render: %v", err)
+	}
+
+	if rendered.Text != "RECEIPT Paid" {
+		t.Errorf("Expected the receipt layout, got %q", rendered.Text)
+	}
+}
+
+func TestRenderChoosesLocale(t *testing.T) {
+	registry := loadTestRegistry(t)
+
+	tests := []struct {
+		locale  string
+		subject string
+		text    string
+	}{
+		{"", "Welcome Ann", "Hello Ann -- bye"},
+		{"es", "Bienvenido Ann", "Hola Ann -- bye"},
+		{"es-MX", "Bienvenido Ann", "Hola Ann -- bye"},
+		{"pt_BR", "Welcome Ann", "Olá Ann -- bye"},
+		{"fr", "Welcome Ann", "Hello Ann -- bye"},
+	}
+
+	for _, test := range tests {
+		rendered, err := registry.Render("welcome", test.locale, greeting{Name: "Ann"})
+		if err != nil {
+			t.Errorf("%q: failed to render: %v", test.locale, err)
+			continue
+		}
+
+		if rendered.Subject != test.subject || rendered.Text != test.text {
+			t.Errorf("%q: expected %q and %q, got %q and %q", test.locale, test.subject, test.text, rendered.Subject, rendered.Text)
+		}
+	}
+}
+
+func TestRenderFailures(t *testing.T) {
+	registry := loadTestRegistry(t)
+
+	if _, err := registry.Render("missing", "", nil); err == nil {
+		t.Errorf("Expected an unknown template to fail")
+	}
+
+	if _, err := registry.Render("welcome", "", struct{}{}); err == nil {
+		t.Errorf("Expected data without the fields the template uses to fail")
+	}
+
+	if _, err := registry.Render("welcome", "", greeting{Name: "Ann\r\nBcc: everyone@example.com"}); err == nil {
+		t.Errorf("Expected a subject with a line break to fail")
+	}
+}
+
+func TestLoadRegistryFailsFast(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes map[string]string
+	}{
+		{"parse error", map[string]string{"welcome.html.tpl": `{{.Name`}},
+		{"missing plain-text part", map[string]string{"welcome.text.tpl": ""}},
+		{"missing localized plain-text part", map[string]string{"welcome.es.text.tpl": ""}},
+		{"missing subject", map[string]string{"receipt.subject.tpl": ""}},
+		{"unknown part", map[string]string{"welcome.body.tpl": `hi`}},
+		{"badly named file", map[string]string{"welcome.tpl": `hi`}},
+		{"localized variant only", map[string]string{"farewell.es.html.tpl": `adios`, "farewell.es.text.tpl": `adios`}},
+		{"subject layout", map[string]string{"layouts/default.subject.tpl": `x`}},
+	}
+
+	for _, test := range tests {
+		if _, err := LoadRegistry(writeTemplates(t, withFiles(test.changes))); err == nil {
+			t.Errorf("%s: expected loading to fail", test.name)
+		}
+	}
+
+	if _, err := LoadRegistry(t.TempDir()); err == nil {
+		t.Errorf("Expected an empty template directory to fail")
+	}
+
+	if _, err := LoadRegistry(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected a missing template directory to fail")
+	}
+}
+
+func TestShippedTemplatesLoad(t *testing.T) {
+	registry, err := LoadRegistry("../email-templates")
+	if err != nil {
+		t.Fatalf("Failed to load the shipped email templates: %v", err)
+	}
+
+	if names := strings.Join(registry.Names(), ","); names != "password-reset,user-registration" {
+		t.Errorf("Unexpected templates: %s", names)
+	}
+}
@@ -0,0 +1,118 @@
+package mail
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/dave-malone/email"
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPOptions configures an SMTP sender.
+type SMTPOptions struct {
+	Host string
+	Port int
+	// StartTLS requires the server to support STARTTLS, and fails rather
+	// than sending the message in the clear if it doesn't.
+	StartTLS bool
+	// Username and Password authenticate with PLAIN auth, which is only
+	// allowed over TLS or to localhost. Leave Username empty to skip auth.
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+type smtpSender struct {
+	options SMTPOptions
+}
+
+// NewSMTPSender returns an email.Sender that delivers each message to an
+// SMTP server.
+func NewSMTPSender(options SMTPOptions) email.Sender {
+	if options.Port <= 0 {
+		options.Port = 587
+	}
+
+	if options.Timeout <= 0 {
+		options.Timeout = defaultSMTPTimeout
+	}
+
+	return smtpSender{options: options}
+}
+
+func (s smtpSender) Send(m *email.Message) error {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return Permanent(fmt.Errorf("Invalid from address %q: %v", m.From, err))
+	}
+
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return Permanent(fmt.Errorf("Invalid to address %q: %v", m.To, err))
+	}
+
+	message, err := formatMessage(m, time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.options.Host, strconv.Itoa(s.options.Port))
+	conn, err := net.DialTimeout("tcp", addr, s.options.Timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(s.options.Timeout))
+
+	client, err := smtp.NewClient(conn, s.options.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.options.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+
+		if err := client.StartTLS(&tls.Config{ServerName: s.options.Host}); err != nil {
+			return err
+		}
+	}
+
+	if len(s.options.Username) != 0 {
+		if err := client.Auth(smtp.PlainAuth("", s.options.Username, s.options.Password, s.options.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(message); err != nil {
+		w.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
@@ -0,0 +1,108 @@
+package mail
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/dave-malone/email"
+)
+
+// fakeSMTPServer accepts one message, without STARTTLS or auth, like a
+// local test server, and sends what it received on the returned channel.
+func fakeSMTPServer(t *testing.T) (host string, port int, received <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var transcript []string
+		text.PrintfLine("220 localhost ready")
+
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO":
+				text.PrintfLine("250-localhost")
+				text.PrintfLine("250 8BITMIME")
+			case "DATA":
+				text.PrintfLine("354 go ahead")
+				data, err := text.ReadDotLines()
+				if err != nil {
+					return
+				}
+				transcript = append(transcript, data...)
+				text.PrintfLine("250 queued")
+				continue
+			case "QUIT":
+				text.PrintfLine("221 bye")
+				messages <- strings.Join(transcript, "\n")
+				return
+			default:
+				text.PrintfLine("250 ok")
+			}
+
+			transcript = append(transcript, line)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, messages
+}
+
+func TestSMTPSenderDeliversMessages(t *testing.T) {
+	host, port, received := fakeSMTPServer(t)
+	sender := NewSMTPSender(SMTPOptions{Host: host, Port: port})
+
+	message := email.NewMessage("Spearwind <no-reply@spearwind.io>", "test@spearwind.io", "Welcome", newRendered())
+	if err := sender.Send(message); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	transcript := <-received
+	for _, expected := range []string{
+		"MAIL FROM:<no-reply@spearwind.io>",
+		"RCPT TO:<test@spearwind.io>",
+		"Subject: Welcome",
+		"Content-Type: multipart/alternative",
+		"Your code is ABC123",
+	} {
+		if !strings.Contains(transcript, expected) {
+			t.Errorf("Expected the server to receive %q, got:\n%s", expected, transcript)
+		}
+	}
+}
+
+func TestSMTPSenderRequiresStartTLS(t *testing.T) {
+	host, port, _ := fakeSMTPServer(t)
+	sender := NewSMTPSender(SMTPOptions{Host: host, Port: port, StartTLS: true})
+
+	err := sender.Send(email.NewMessage("no-reply@spearwind.io", "test@spearwind.io", "Welcome", newRendered()))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected sending without STARTTLS to fail, got %v", err)
+	}
+}
+
+func TestSMTPSenderRejectsInvalidAddresses(t *testing.T) {
+	sender := NewSMTPSender(SMTPOptions{Host: "127.0.0.1", Port: 1})
+
+	if err := sender.Send(email.NewMessage("not an address", "test@spearwind.io", "Welcome", newRendered())); err == nil {
+		t.Errorf("Expected an invalid from address to fail")
+	}
+}
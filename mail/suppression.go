@@ -0,0 +1,32 @@
+package mail
+
+import "time"
+
+const (
+	SuppressionReasonBounce    = "bounce"
+	SuppressionReasonComplaint = "complaint"
+)
+
+type SuppressionRepository interface {
+	// Add suppresses the address, replacing any earlier suppression of it.
+	Add(suppression *Suppression) (err error)
+	Get(address string) (suppression *Suppression, err error)
+}
+
+// Suppression stops emails being sent to an address that bounced or
+// complained.
+type Suppression struct {
+	Email   string    `json:"email"`
+	Reason  string    `json:"reason"`
+	Detail  string    `json:"detail,omitempty"`
+	Created time.Time `json:"date_created"`
+}
+
+func NewSuppression(address string, reason string, detail string) *Suppression {
+	return &Suppression{
+		Email:   NormalizeAddress(address),
+		Reason:  reason,
+		Detail:  detail,
+		Created: time.Now(),
+	}
+}
@@ -1,17 +1,53 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spear-wind/cms/user"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "credential-report" {
+		fmt.Println(user.NewCredentialReport(newUserRepository(), newCredentialPolicy()))
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if len(port) == 0 {
 		port = "3000"
 	}
 
-	server := NewServer()
-	server.Run(":" + port)
-	fmt.Printf("Running server on port %v\n", port)
+	handler, closeStreams, drain := NewServer()
+	server := &http.Server{Addr: ":" + port, Handler: handler}
+	server.RegisterOnShutdown(closeStreams)
+
+	go func() {
+		fmt.Printf("Running server on port %v\n", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("Server stopped: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	fmt.Println("Shutting down")
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("Failed to stop the server gracefully: %v\n", err)
+	}
+
+	if err := drain(ctx); err != nil {
+		fmt.Printf("Failed to deliver queued events: %v\n", err)
+	}
 }
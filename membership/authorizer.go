@@ -0,0 +1,176 @@
+package membership
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+// Authorizer answers permission questions for the caller stored in the
+// request context by auth.ResolveCaller. It also implements
+// user.AccessPolicy.
+type Authorizer struct {
+	formatter            *render.Render
+	membershipRepository MembershipRepository
+	mfaPolicy            MFAPolicy
+	admins               map[string]bool
+}
+
+// MFAPolicy reports which sites require their members to use two-factor
+// authentication.
+type MFAPolicy interface {
+	RequiresMFA(siteID string) bool
+}
+
+// NewAuthorizer returns an Authorizer that enforces mfaPolicy, which may be
+// nil if no site requires two-factor authentication.
+func NewAuthorizer(formatter *render.Render, membershipRepository MembershipRepository, mfaPolicy MFAPolicy) *Authorizer {
+	return &Authorizer{
+		formatter:            formatter,
+		membershipRepository: membershipRepository,
+		mfaPolicy:            mfaPolicy,
+	}
+}
+
+func (a *Authorizer) siteRequiresMFA(siteID string) bool {
+	return a.mfaPolicy != nil && a.mfaPolicy.RequiresMFA(siteID)
+}
+
+// Can reports whether caller holds permission on the site. Members of a
+// site that requires two-factor authentication hold no permissions on it
+// until they turn it on.
+func (a *Authorizer) Can(caller *user.User, siteID string, permission Permission) bool {
+	if caller == nil {
+		return false
+	}
+
+	membership, err := a.membershipRepository.Get(siteID, caller.ID)
+	if err != nil || !membership.Role.Can(permission) {
+		return false
+	}
+
+	return caller.MFAEnabled() || !a.siteRequiresMFA(siteID)
+}
+
+// Require wraps a handler for a route with an {id} site variable so that it
+// only runs when the caller holds permission on that site.
+func (a *Authorizer) Require(permission Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			caller := user.FromContext(req.Context())
+			if caller == nil {
+				a.formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+				return
+			}
+
+			siteID := mux.Vars(req)["id"]
+			if a.needsMFA(caller, siteID) {
+				a.formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+					"error": "This site requires two-factor authentication; turn it on at /user/me/mfa/totp",
+				})
+				return
+			}
+
+			if !a.Can(caller, siteID, permission) {
+				a.formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+					"error": "You do not have permission to perform this action",
+				})
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+// needsMFA reports whether caller is a member of the site who is only
+// refused because the site requires two-factor authentication.
+func (a *Authorizer) needsMFA(caller *user.User, siteID string) bool {
+	if caller.MFAEnabled() || !a.siteRequiresMFA(siteID) {
+		return false
+	}
+
+	_, err := a.membershipRepository.Get(siteID, caller.ID)
+	return err == nil
+}
+
+// SiteIDs returns the IDs of every site the caller is a member of, leaving
+// out sites that require two-factor authentication unless the caller has it
+// on.
+func (a *Authorizer) SiteIDs(caller *user.User) (siteIDs []string) {
+	if caller == nil {
+		return siteIDs
+	}
+
+	for _, membership := range a.membershipRepository.ListByUser(caller.ID) {
+		if caller.MFAEnabled() || !a.siteRequiresMFA(membership.SiteID) {
+			siteIDs = append(siteIDs, membership.SiteID)
+		}
+	}
+
+	return siteIDs
+}
+
+// CanViewUser allows callers to see themselves and anyone they share a
+// site with.
+func (a *Authorizer) CanViewUser(caller *user.User, target *user.User) bool {
+	if caller == nil || target == nil {
+		return false
+	}
+
+	if caller.ID == target.ID {
+		return true
+	}
+
+	for _, membership := range a.membershipRepository.ListByUser(target.ID) {
+		if _, err := a.membershipRepository.Get(membership.SiteID, caller.ID); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequiresMFA reports whether any site the user is a member of requires
+// two-factor authentication.
+func (a *Authorizer) RequiresMFA(target *user.User) bool {
+	if target == nil {
+		return false
+	}
+
+	for _, membership := range a.membershipRepository.ListByUser(target.ID) {
+		if a.siteRequiresMFA(membership.SiteID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetAdmins makes the users with the given email addresses administrators
+// of the whole CMS, rather than of a site. Only they may create user
+// accounts and query or replay the event store.
+func (a *Authorizer) SetAdmins(emails ...string) {
+	a.admins = make(map[string]bool)
+	for _, email := range emails {
+		if email = strings.TrimSpace(email); len(email) != 0 {
+			a.admins[strings.ToLower(email)] = true
+		}
+	}
+}
+
+// CanCreateUsers allows only administrators to create user accounts, once
+// they have verified their email address. Site roles grant nothing here, as
+// anyone can create a site.
+func (a *Authorizer) CanCreateUsers(caller *user.User) bool {
+	return a.IsAdmin(caller)
+}
+
+// IsAdmin reports whether caller is one of the administrators set with
+// SetAdmins and has verified their email address.
+func (a *Authorizer) IsAdmin(caller *user.User) bool {
+	return caller != nil && caller.Verified && a.admins[strings.ToLower(caller.Email)]
+}
@@ -0,0 +1,35 @@
+package membership
+
+import (
+	"testing"
+
+	"github.com/spear-wind/cms/user"
+)
+
+func TestOnlyVerifiedAdminsCanCreateUsers(t *testing.T) {
+	membershipRepository := NewInMemoryRepository()
+	authorizer := NewAuthorizer(formatter, membershipRepository, nil)
+	authorizer.SetAdmins("Root@spearwind.io", " ")
+
+	owner := user.NewUser(1, "Site", "Owner", "owner@spearwind.io")
+	owner.Verified = true
+	membershipRepository.Add(NewMembership("1", owner.ID, RoleOwner))
+
+	if authorizer.CanCreateUsers(owner) {
+		t.Error("Expected owning a site not to allow creating users")
+	}
+
+	admin := user.NewUser(2, "CMS", "Admin", "root@spearwind.io")
+	if authorizer.CanCreateUsers(admin) {
+		t.Error("Expected an admin whose email isn't verified not to be allowed to create users")
+	}
+
+	admin.Verified = true
+	if !authorizer.CanCreateUsers(admin) {
+		t.Error("Expected a verified admin to be allowed to create users")
+	}
+
+	if authorizer.CanCreateUsers(user.NewUser(3, "", "", "")) {
+		t.Error("Expected a blank admin email not to match users without one")
+	}
+}
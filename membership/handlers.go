@@ -0,0 +1,207 @@
+package membership
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+func InitRoutes(router *mux.Router, formatter *render.Render, membershipRepository MembershipRepository, authorizer *Authorizer, userRepository user.UserRepository) {
+	authorize := authorizer.Require
+
+	router.HandleFunc("/site/{id}/members", authorize(PermissionViewSite)(getMemberListHandler(formatter, membershipRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}/members", authorize(PermissionManageMembers)(addMemberHandler(formatter, membershipRepository, userRepository))).Methods("POST")
+	router.HandleFunc("/site/{id}/members/{userID}", authorize(PermissionManageMembers)(updateMemberHandler(formatter, membershipRepository))).Methods("PUT")
+	router.HandleFunc("/site/{id}/members/{userID}", authorize(PermissionManageMembers)(removeMemberHandler(formatter, membershipRepository))).Methods("DELETE")
+}
+
+func getMemberListHandler(formatter *render.Render, membershipRepository MembershipRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		members := membershipRepository.ListBySite(mux.Vars(req)["id"])
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"members": members,
+			"total":   len(members),
+		})
+	}
+}
+
+func addMemberHandler(formatter *render.Render, membershipRepository MembershipRepository, userRepository user.UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		siteID := mux.Vars(req)["id"]
+		payload, _ := ioutil.ReadAll(req.Body)
+		var membership Membership
+
+		if err := json.Unmarshal(payload, &membership); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse add member request")
+			return
+		}
+
+		added := NewMembership(siteID, membership.UserID, membership.Role)
+
+		result := added.validate()
+		if added.UserID > 0 && userRepository.FindByID(added.UserID) == nil {
+			result.AddError("user_id", "User does not exist")
+		}
+
+		if result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if !canAssign(req, membershipRepository, added.Role) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": "Only owners can add other owners",
+			})
+			return
+		}
+
+		if err := membershipRepository.Add(added); err != nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Add("Location", fmt.Sprintf("/site/%v/members/%d", siteID, added.UserID))
+		formatter.JSON(w, http.StatusCreated, added)
+	}
+}
+
+func updateMemberHandler(formatter *render.Render, membershipRepository MembershipRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		existing, ok := findMember(w, req, formatter, membershipRepository)
+		if !ok {
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var update Membership
+
+		if err := json.Unmarshal(payload, &update); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse update member request")
+			return
+		}
+
+		membership := *existing
+		membership.Role = update.Role
+
+		if result := membership.validate(); result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if !canAssign(req, membershipRepository, existing.Role) || !canAssign(req, membershipRepository, membership.Role) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": "Only owners can change the role of owners",
+			})
+			return
+		}
+
+		if existing.Role == RoleOwner && membership.Role != RoleOwner && isLastOwner(membershipRepository, existing) {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": "A site must always have at least one owner",
+			})
+			return
+		}
+
+		if err := membershipRepository.Update(&membership); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, membership)
+	}
+}
+
+func removeMemberHandler(formatter *render.Render, membershipRepository MembershipRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		existing, ok := findMember(w, req, formatter, membershipRepository)
+		if !ok {
+			return
+		}
+
+		if !canAssign(req, membershipRepository, existing.Role) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": "Only owners can remove owners",
+			})
+			return
+		}
+
+		if existing.Role == RoleOwner && isLastOwner(membershipRepository, existing) {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": "A site must always have at least one owner",
+			})
+			return
+		}
+
+		if err := membershipRepository.Remove(existing); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func findMember(w http.ResponseWriter, req *http.Request, formatter *render.Render, membershipRepository MembershipRepository) (*Membership, bool) {
+	vars := mux.Vars(req)
+
+	userID, err := strconv.ParseInt(vars["userID"], 10, 64)
+	if err != nil {
+		formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": "Invalid User ID",
+		})
+		return nil, false
+	}
+
+	membership, err := membershipRepository.Get(vars["id"], userID)
+	if err != nil {
+		formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+
+	return membership, true
+}
+
+// canAssign reports whether the caller may grant or revoke role on the site
+// in the request. Owner memberships can only be managed by other owners.
+func canAssign(req *http.Request, membershipRepository MembershipRepository, role Role) bool {
+	if role != RoleOwner {
+		return true
+	}
+
+	caller := user.FromContext(req.Context())
+	if caller == nil {
+		return false
+	}
+
+	membership, err := membershipRepository.Get(mux.Vars(req)["id"], caller.ID)
+	return err == nil && membership.Role.Can(PermissionManageOwners)
+}
+
+func isLastOwner(membershipRepository MembershipRepository, owner *Membership) bool {
+	for _, membership := range membershipRepository.ListBySite(owner.SiteID) {
+		if membership.Role == RoleOwner && membership.UserID != owner.UserID {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,124 @@
+package membership
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+// newTestServer serves the membership routes with caller injected into every
+// request context, standing in for auth.ResolveCaller.
+func newTestServer(membershipRepository MembershipRepository, userRepository user.UserRepository, caller *user.User) *httptest.Server {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, membershipRepository, NewAuthorizer(formatter, membershipRepository, nil), userRepository)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}))
+}
+
+func newTestUsers() (user.UserRepository, *user.User, *user.User, *user.User) {
+	userRepository := user.NewInMemoryRepository()
+	owner := user.NewUser(0, "Site", "Owner", "owner@spearwind.io")
+	admin := user.NewUser(0, "Site", "Admin", "admin@spearwind.io")
+	editor := user.NewUser(0, "Site", "Editor", "editor@spearwind.io")
+	userRepository.Add(owner)
+	userRepository.Add(admin)
+	userRepository.Add(editor)
+	return userRepository, owner, admin, editor
+}
+
+func doRequest(t *testing.T, method string, url string, body string) *http.Response {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error in %s to %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+	ioutil.ReadAll(res.Body)
+	return res
+}
+
+func TestAddMemberHandler(t *testing.T) {
+	userRepository, owner, admin, editor := newTestUsers()
+	membershipRepository := NewInMemoryRepository()
+	membershipRepository.Add(NewMembership("1", owner.ID, RoleOwner))
+	membershipRepository.Add(NewMembership("1", admin.ID, RoleAdmin))
+
+	server := newTestServer(membershipRepository, userRepository, admin)
+	defer server.Close()
+
+	if res := doRequest(t, "POST", server.URL+"/site/1/members", `{"user_id":3,"role":"owner"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an admin to be forbidden from adding an owner, received %s", res.Status)
+	}
+
+	if res := doRequest(t, "POST", server.URL+"/site/1/members", `{"user_id":42,"role":"editor"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown user to be rejected, received %s", res.Status)
+	}
+
+	res := doRequest(t, "POST", server.URL+"/site/1/members", `{"user_id":3,"role":"editor"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s", res.Status)
+	}
+
+	if m, err := membershipRepository.Get("1", editor.ID); err != nil || m.Role != RoleEditor {
+		t.Errorf("Expected the user to be added as an editor, got %v, %v", m, err)
+	}
+}
+
+func TestMembersRequireManagePermission(t *testing.T) {
+	userRepository, owner, _, editor := newTestUsers()
+	membershipRepository := NewInMemoryRepository()
+	membershipRepository.Add(NewMembership("1", owner.ID, RoleOwner))
+	membershipRepository.Add(NewMembership("1", editor.ID, RoleEditor))
+
+	server := newTestServer(membershipRepository, userRepository, editor)
+	defer server.Close()
+
+	if res := doRequest(t, "GET", server.URL+"/site/1/members", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected an editor to list members, received %s", res.Status)
+	}
+
+	if res := doRequest(t, "PUT", server.URL+"/site/1/members/3", `{"role":"admin"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an editor to be forbidden from promoting themselves, received %s", res.Status)
+	}
+
+	if res := doRequest(t, "GET", server.URL+"/site/2/members", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a non-member to be forbidden from listing members, received %s", res.Status)
+	}
+}
+
+func TestLastOwnerCannotBeRemovedOrDemoted(t *testing.T) {
+	userRepository, owner, _, _ := newTestUsers()
+	membershipRepository := NewInMemoryRepository()
+	membershipRepository.Add(NewMembership("1", owner.ID, RoleOwner))
+
+	server := newTestServer(membershipRepository, userRepository, owner)
+	defer server.Close()
+
+	if res := doRequest(t, "PUT", server.URL+"/site/1/members/1", `{"role":"admin"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected demoting the last owner to be rejected, received %s", res.Status)
+	}
+
+	if res := doRequest(t, "DELETE", server.URL+"/site/1/members/1", ""); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected removing the last owner to be rejected, received %s", res.Status)
+	}
+
+	membershipRepository.Add(NewMembership("1", 2, RoleOwner))
+
+	if res := doRequest(t, "DELETE", server.URL+"/site/1/members/1", ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected an owner to leave once another owner exists, received %s", res.Status)
+	}
+}
@@ -0,0 +1,78 @@
+package membership
+
+import (
+	"errors"
+	"fmt"
+)
+
+type inMemoryRepository struct {
+	memberships map[string]*Membership
+}
+
+func NewInMemoryRepository() *inMemoryRepository {
+	repo := &inMemoryRepository{}
+	repo.memberships = make(map[string]*Membership)
+	return repo
+}
+
+func (repo *inMemoryRepository) Add(membership *Membership) (err error) {
+	key := membershipKey(membership.SiteID, membership.UserID)
+	if _, ok := repo.memberships[key]; ok {
+		return errors.New("This user is already a member of the site")
+	}
+
+	repo.memberships[key] = membership
+	return err
+}
+
+func (repo *inMemoryRepository) Update(membership *Membership) (err error) {
+	key := membershipKey(membership.SiteID, membership.UserID)
+	if _, ok := repo.memberships[key]; !ok {
+		return errors.New("Could not find membership in repository")
+	}
+
+	repo.memberships[key] = membership
+	return err
+}
+
+func (repo *inMemoryRepository) Remove(membership *Membership) (err error) {
+	key := membershipKey(membership.SiteID, membership.UserID)
+	if _, ok := repo.memberships[key]; !ok {
+		return errors.New("Could not find membership in repository")
+	}
+
+	delete(repo.memberships, key)
+	return err
+}
+
+func (repo *inMemoryRepository) Get(siteID string, userID int64) (membership *Membership, err error) {
+	if membership, ok := repo.memberships[membershipKey(siteID, userID)]; ok {
+		return membership, nil
+	}
+
+	return nil, errors.New("Could not find membership in repository")
+}
+
+func (repo *inMemoryRepository) ListBySite(siteID string) (memberships []*Membership) {
+	for _, membership := range repo.memberships {
+		if membership.SiteID == siteID {
+			memberships = append(memberships, membership)
+		}
+	}
+
+	return memberships
+}
+
+func (repo *inMemoryRepository) ListByUser(userID int64) (memberships []*Membership) {
+	for _, membership := range repo.memberships {
+		if membership.UserID == userID {
+			memberships = append(memberships, membership)
+		}
+	}
+
+	return memberships
+}
+
+func membershipKey(siteID string, userID int64) string {
+	return fmt.Sprintf("%s/%d", siteID, userID)
+}
@@ -0,0 +1,135 @@
+package membership
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoMembershipRepository struct {
+	Collection cfmgo.Collection
+}
+
+type membershipRecord struct {
+	RecordID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	SiteID   string        `bson:"site_id" json:"site_id"`
+	UserID   int64         `bson:"user_id" json:"user_id"`
+	Role     string        `bson:"role" json:"role"`
+	Created  time.Time     `bson:"date_created" json:"date_created"`
+}
+
+func NewMongoMembershipRepository(col cfmgo.Collection) *mongoMembershipRepository {
+	return &mongoMembershipRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoMembershipRepository) Add(membership *Membership) (err error) {
+	repo.Collection.Wake()
+	if _, err := repo.getMongoMembership(membership.SiteID, membership.UserID); err == nil {
+		return errors.New("This user is already a member of the site")
+	}
+
+	mr := toMembershipRecord(membership)
+	_, err = repo.Collection.UpsertID(mr.RecordID, mr)
+	return
+}
+
+func (repo *mongoMembershipRepository) Update(membership *Membership) (err error) {
+	repo.Collection.Wake()
+	found, err := repo.getMongoMembership(membership.SiteID, membership.UserID)
+	if err == nil {
+		mr := toMembershipRecord(membership)
+		mr.RecordID = found.RecordID
+		_, err = repo.Collection.UpsertID(mr.RecordID, mr)
+	}
+
+	return
+}
+
+func (repo *mongoMembershipRepository) Remove(membership *Membership) (err error) {
+	repo.Collection.Wake()
+	found, err := repo.getMongoMembership(membership.SiteID, membership.UserID)
+	if err == nil {
+		err = repo.Collection.Delete(bson.M{"_id": found.RecordID})
+	}
+
+	return
+}
+
+func (repo *mongoMembershipRepository) Get(siteID string, userID int64) (membership *Membership, err error) {
+	var mr *membershipRecord
+	mr, err = repo.getMongoMembership(siteID, userID)
+	if mr != nil && err == nil {
+		membership = toMembership(mr)
+	}
+
+	return
+}
+
+func (repo *mongoMembershipRepository) ListBySite(siteID string) (memberships []*Membership) {
+	return repo.list(bson.M{"site_id": siteID})
+}
+
+func (repo *mongoMembershipRepository) ListByUser(userID int64) (memberships []*Membership) {
+	return repo.list(bson.M{"user_id": userID})
+}
+
+func (repo *mongoMembershipRepository) list(query bson.M) (memberships []*Membership) {
+	repo.Collection.Wake()
+	var mr []membershipRecord
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	if _, err := repo.Collection.Find(params, &mr); err == nil {
+		memberships = make([]*Membership, len(mr))
+		for k, v := range mr {
+			memberships[k] = toMembership(&v)
+		}
+	}
+
+	return
+}
+
+func (repo *mongoMembershipRepository) getMongoMembership(siteID string, userID int64) (membership *membershipRecord, err error) {
+	var memberships []membershipRecord
+	query := bson.M{"site_id": siteID, "user_id": userID}
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	count, err := repo.Collection.Find(params, &memberships)
+	if count == 0 {
+		err = errors.New("Membership not found")
+	}
+	if err == nil {
+		membership = &memberships[0]
+	}
+
+	return
+}
+
+func toMembershipRecord(m *Membership) (mr *membershipRecord) {
+	mr = &membershipRecord{
+		RecordID: bson.NewObjectId(),
+		SiteID:   m.SiteID,
+		UserID:   m.UserID,
+		Role:     string(m.Role),
+		Created:  m.Created,
+	}
+	return
+}
+
+func toMembership(mr *membershipRecord) (m *Membership) {
+	m = &Membership{
+		SiteID:  mr.SiteID,
+		UserID:  mr.UserID,
+		Role:    Role(mr.Role),
+		Created: mr.Created,
+	}
+	return
+}
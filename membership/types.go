@@ -0,0 +1,99 @@
+package membership
+
+import (
+	"time"
+
+	"github.com/spear-wind/cms/validator"
+)
+
+type Role string
+
+type Permission string
+
+const (
+	RoleOwner  Role = "owner"
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleAuthor Role = "author"
+	RoleViewer Role = "viewer"
+)
+
+const (
+	PermissionViewSite       Permission = "site:view"
+	PermissionManageSite     Permission = "site:manage"
+	PermissionManageMembers  Permission = "members:manage"
+	PermissionManageOwners   Permission = "owners:manage"
+	PermissionViewContent    Permission = "content:view"
+	PermissionWriteContent   Permission = "content:write"
+	PermissionPublishContent Permission = "content:publish"
+	PermissionDeleteContent  Permission = "content:delete"
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleViewer: {PermissionViewSite, PermissionViewContent},
+	RoleAuthor: {PermissionViewSite, PermissionViewContent, PermissionWriteContent},
+	RoleEditor: {PermissionViewSite, PermissionViewContent, PermissionWriteContent, PermissionPublishContent, PermissionDeleteContent},
+	RoleAdmin: {PermissionViewSite, PermissionViewContent, PermissionWriteContent, PermissionPublishContent, PermissionDeleteContent,
+		PermissionManageSite, PermissionManageMembers},
+	RoleOwner: {PermissionViewSite, PermissionViewContent, PermissionWriteContent, PermissionPublishContent, PermissionDeleteContent,
+		PermissionManageSite, PermissionManageMembers, PermissionManageOwners},
+}
+
+type MembershipRepository interface {
+	Add(membership *Membership) (err error)
+	Update(membership *Membership) (err error)
+	Remove(membership *Membership) (err error)
+	Get(siteID string, userID int64) (membership *Membership, err error)
+	ListBySite(siteID string) (memberships []*Membership)
+	ListByUser(userID int64) (memberships []*Membership)
+}
+
+type Membership struct {
+	SiteID  string    `json:"site_id"`
+	UserID  int64     `json:"user_id"`
+	Role    Role      `json:"role"`
+	Created time.Time `json:"date_created"`
+}
+
+func NewMembership(siteID string, userID int64, role Role) *Membership {
+	return &Membership{
+		SiteID:  siteID,
+		UserID:  userID,
+		Role:    role,
+		Created: time.Now(),
+	}
+}
+
+func IsValidRole(role Role) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Can reports whether role grants permission.
+func (role Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *Membership) validate() (result validator.ValidationResult) {
+	result = validator.NewValidationResult()
+
+	if len(m.SiteID) == 0 {
+		result.AddError("site_id", "Site ID is required")
+	}
+
+	if m.UserID <= 0 {
+		result.AddError("user_id", "User ID is required")
+	}
+
+	if !IsValidRole(m.Role) {
+		result.AddError("role", "Role must be one of owner, admin, editor, author or viewer")
+	}
+
+	return result
+}
@@ -0,0 +1,42 @@
+package membership
+
+import "testing"
+
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{RoleViewer, PermissionViewContent, true},
+		{RoleViewer, PermissionWriteContent, false},
+		{RoleAuthor, PermissionWriteContent, true},
+		{RoleAuthor, PermissionPublishContent, false},
+		{RoleEditor, PermissionPublishContent, true},
+		{RoleEditor, PermissionManageMembers, false},
+		{RoleAdmin, PermissionManageMembers, true},
+		{RoleAdmin, PermissionManageOwners, false},
+		{RoleOwner, PermissionManageOwners, true},
+		{Role("guest"), PermissionViewSite, false},
+	}
+
+	for _, c := range cases {
+		if actual := c.role.Can(c.permission); actual != c.expected {
+			t.Errorf("Expected %s.Can(%s) to be %v", c.role, c.permission, c.expected)
+		}
+	}
+}
+
+func TestMembershipValidation(t *testing.T) {
+	if result := NewMembership("1", 1, RoleEditor).validate(); result.HasErrors() {
+		t.Errorf("Expected a valid membership, but got %v", result.Errors)
+	}
+
+	if result := NewMembership("1", 1, Role("guest")).validate(); !result.HasErrors() {
+		t.Error("Expected an unknown role to be rejected")
+	}
+
+	if result := NewMembership("1", 0, RoleViewer).validate(); !result.HasErrors() {
+		t.Error("Expected a missing user to be rejected")
+	}
+}
@@ -0,0 +1,23 @@
+package page
+
+import "github.com/spear-wind/cms/events"
+
+// CreatedEvent is published when a draft page is created.
+type CreatedEvent struct {
+	PageID string `json:"page_id"`
+	SiteID string `json:"site_id"`
+	Slug   string `json:"slug"`
+	Title  string `json:"title"`
+}
+
+func init() {
+	events.RegisterDomainEvent(CreatedEvent{})
+}
+
+func NewCreatedEvent(page *Page) CreatedEvent {
+	return CreatedEvent{PageID: page.ID, SiteID: page.SiteID, Slug: page.Slug, Title: page.Title}
+}
+
+func (e CreatedEvent) EventType() string   { return "page.created" }
+func (e CreatedEvent) EventVersion() int   { return 1 }
+func (e CreatedEvent) EventSiteID() string { return e.SiteID }
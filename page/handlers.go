@@ -0,0 +1,368 @@
+package page
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/revision"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/workflow"
+	"github.com/unrolled/render"
+)
+
+func InitRoutes(router *mux.Router, formatter *render.Render, pageRepository PageRepository, siteRepository site.SiteRepository, authorizer *membership.Authorizer, revisionRepository revision.RevisionRepository, eventPublisher events.EventPublisher) {
+	engine := workflow.NewEngine(eventPublisher)
+	authorize := authorizer.Require
+
+	router.HandleFunc("/site/{id}/pages", authorize(membership.PermissionWriteContent)(createPageHandler(formatter, pageRepository, siteRepository, revisionRepository, eventPublisher))).Methods("POST")
+	router.HandleFunc("/site/{id}/pages", authorize(membership.PermissionViewContent)(getPageListHandler(formatter, pageRepository, siteRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}/pages/{pageID}", authorize(membership.PermissionViewContent)(getPageHandler(formatter, pageRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}/pages/{pageID}", authorize(membership.PermissionWriteContent)(updatePageHandler(formatter, pageRepository, revisionRepository, authorizer))).Methods("PUT")
+	router.HandleFunc("/site/{id}/pages/{pageID}", authorize(membership.PermissionDeleteContent)(deletePageHandler(formatter, pageRepository))).Methods("DELETE")
+	router.HandleFunc("/site/{id}/pages/{pageID}/transitions", authorize(membership.PermissionWriteContent)(transitionPageHandler(formatter, pageRepository, revisionRepository, engine, authorizer))).Methods("POST")
+	router.HandleFunc("/site/{id}/pages/{pageID}/transitions", authorize(membership.PermissionViewContent)(getPageTransitionsHandler(formatter, pageRepository, engine))).Methods("GET")
+
+	revision.InitRoutes(router, formatter, revisionRepository, "/site/{id}/pages/{pageID}", contentType, lookupPage(pageRepository), restorePage(pageRepository, authorizer),
+		authorize(membership.PermissionViewContent), authorize(membership.PermissionWriteContent))
+}
+
+func createPageHandler(formatter *render.Render, pageRepository PageRepository, siteRepository site.SiteRepository, revisionRepository revision.RevisionRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		siteID := mux.Vars(req)["id"]
+
+		if _, err := siteRepository.GetByID(siteID); err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		page := NewPage(siteID, "", "", "", nil)
+
+		if err := json.Unmarshal(payload, page); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse create page request")
+			return
+		}
+
+		if caller := user.FromContext(req.Context()); caller != nil {
+			page.Author = caller
+		}
+
+		page.ID = ""
+		page.SiteID = siteID
+		page.Status = workflow.StateDraft
+		page.History = nil
+		page.Published = nil
+
+		if result := page.validate(pageRepository); result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if err := pageRepository.Add(page); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"page":  page,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := revision.Record(revisionRepository, contentType, page.ID, page.Author, page); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"page":  page,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, page.Author.Actor(), NewCreatedEvent(page))
+
+		w.Header().Add("Location", fmt.Sprintf("/site/%v/pages/%v", siteID, page.ID))
+		formatter.JSON(w, http.StatusCreated, page)
+	}
+}
+
+func getPageListHandler(formatter *render.Render, pageRepository PageRepository, siteRepository site.SiteRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		siteID := mux.Vars(req)["id"]
+
+		if _, err := siteRepository.GetByID(siteID); err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		pages := pageRepository.ListBySite(siteID)
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"pages": pages,
+			"total": len(pages),
+		})
+	}
+}
+
+func getPageHandler(formatter *render.Render, pageRepository PageRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		if page, err := pageRepository.GetByID(vars["id"], vars["pageID"]); err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			formatter.JSON(w, http.StatusOK, page)
+		}
+	}
+}
+
+func updatePageHandler(formatter *render.Render, pageRepository PageRepository, revisionRepository revision.RevisionRepository, authorizer *membership.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		existing, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if isLive(existing.Status) && !authorizer.Can(user.FromContext(req.Context()), existing.SiteID, membership.PermissionPublishContent) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": "You do not have permission to change published content on this site",
+			})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		page := *existing
+		// Decode into fresh users, so the body can't write through to the
+		// stored page's author.
+		page.Author = nil
+		page.UpdatedBy = nil
+
+		if err := json.Unmarshal(payload, &page); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse update page request")
+			return
+		}
+
+		if caller := user.FromContext(req.Context()); caller != nil {
+			page.UpdatedBy = caller
+		}
+
+		page.ID = existing.ID
+		page.SiteID = existing.SiteID
+		page.Author = existing.Author
+		page.Status = existing.Status
+		page.History = existing.History
+		page.Published = existing.Published
+		page.Created = existing.Created
+		page.Updated = time.Now()
+
+		result := page.validate(pageRepository)
+		if page.UpdatedBy == nil {
+			result.AddError("updated_by", "Updated by is required")
+		}
+
+		if result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if err := savePage(pageRepository, revisionRepository, &page, page.UpdatedBy); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"page":  page,
+				"error": err.Error(),
+			})
+		} else {
+			formatter.JSON(w, http.StatusOK, page)
+		}
+	}
+}
+
+func deletePageHandler(formatter *render.Render, pageRepository PageRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		page, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := pageRepository.Delete(page); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func transitionPageHandler(formatter *render.Render, pageRepository PageRepository, revisionRepository revision.RevisionRepository, engine *workflow.Engine, authorizer *membership.Authorizer) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		existing, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var transitionRequest workflow.TransitionRequest
+
+		if err := json.Unmarshal(payload, &transitionRequest); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse page transition request")
+			return
+		}
+
+		if caller := user.FromContext(req.Context()); caller != nil {
+			transitionRequest.Actor = caller
+
+			if requiresPublishPermission(existing.Status, transitionRequest.To) && !authorizer.Can(caller, existing.SiteID, membership.PermissionPublishContent) {
+				formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+					"error": "You do not have permission to publish content on this site",
+				})
+				return
+			}
+		}
+
+		page := *existing
+		page.UpdatedBy = transitionRequest.Actor
+		page.History = append([]workflow.Transition(nil), existing.History...)
+
+		result, err := engine.Transition(&page, transitionRequest, func() error {
+			return savePage(pageRepository, revisionRepository, &page, transitionRequest.Actor)
+		})
+
+		if result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+		} else if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"page":  page,
+				"error": err.Error(),
+			})
+		} else {
+			formatter.JSON(w, http.StatusOK, page)
+		}
+	}
+}
+
+func getPageTransitionsHandler(formatter *render.Render, pageRepository PageRepository, engine *workflow.Engine) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		page, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"transitions": page.History,
+			"allowed":     engine.AllowedTransitions(page.Status),
+			"total":       len(page.History),
+		})
+	}
+}
+
+// requiresPublishPermission reports whether a transition goes beyond moving
+// content between draft and review, which authors are allowed to do.
+func requiresPublishPermission(from workflow.State, to workflow.State) bool {
+	isDraftOrReview := func(s workflow.State) bool {
+		return s == workflow.StateDraft || s == workflow.StateInReview
+	}
+
+	return !isDraftOrReview(from) || !isDraftOrReview(to)
+}
+
+// isLive reports whether content in state s is, or is about to be, served
+// to readers, so that changing it takes the same permission as publishing.
+func isLive(s workflow.State) bool {
+	return s == workflow.StatePublished || s == workflow.StateScheduled
+}
+
+func savePage(pageRepository PageRepository, revisionRepository revision.RevisionRepository, page *Page, author *user.User) error {
+	if err := pageRepository.Update(page); err != nil {
+		return err
+	}
+
+	return revision.Record(revisionRepository, contentType, page.ID, author, page)
+}
+
+func lookupPage(pageRepository PageRepository) revision.LookupFunc {
+	return func(req *http.Request) (string, error) {
+		vars := mux.Vars(req)
+
+		page, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			return "", err
+		}
+
+		return page.ID, nil
+	}
+}
+
+func restorePage(pageRepository PageRepository, authorizer *membership.Authorizer) revision.RestoreFunc {
+	return func(req *http.Request, rev *revision.Revision, author *user.User) (interface{}, error) {
+		vars := mux.Vars(req)
+
+		existing, err := pageRepository.GetByID(vars["id"], vars["pageID"])
+		if err != nil {
+			return nil, err
+		}
+
+		if isLive(existing.Status) && !authorizer.Can(author, existing.SiteID, membership.PermissionPublishContent) {
+			return nil, revision.ErrForbidden
+		}
+
+		var page Page
+		if err := rev.Restore(&page); err != nil {
+			return nil, err
+		}
+
+		page.ID = existing.ID
+		page.SiteID = existing.SiteID
+		page.Author = existing.Author
+		page.Status = existing.Status
+		page.History = existing.History
+		page.Published = existing.Published
+		page.UpdatedBy = author
+		page.Updated = time.Now()
+
+		if existing := pageRepository.FindBySlug(page.SiteID, page.Slug); existing != nil && existing.ID != page.ID {
+			return nil, errors.New("Slug is already in use on this site")
+		}
+
+		if err := pageRepository.Update(&page); err != nil {
+			return nil, err
+		}
+
+		return page, nil
+	}
+}
@@ -0,0 +1,307 @@
+package page
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/revision"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/workflow"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+	owner = user.NewUser(1, "Spearwind", "Creator", "creator@spearwind.io")
+)
+
+// newTestServer serves the page routes to the owner of site 1.
+func newTestServer(pageRepository PageRepository, siteRepository site.SiteRepository) *httptest.Server {
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", owner.ID, membership.RoleOwner))
+	return newTestServerAs(pageRepository, siteRepository, membershipRepository, owner)
+}
+
+// newTestServerAs serves the page routes with caller injected into every
+// request context, standing in for auth.ResolveCaller.
+func newTestServerAs(pageRepository PageRepository, siteRepository site.SiteRepository, membershipRepository membership.MembershipRepository, caller *user.User) *httptest.Server {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, pageRepository, siteRepository, membership.NewAuthorizer(formatter, membershipRepository, site.NewMFAPolicy(siteRepository)), revision.NewInMemoryRepository(), events.NewSynchEventPublisher())
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}))
+}
+
+func newTestSiteRepository() site.SiteRepository {
+	siteRepository := site.NewInMemoryRepository()
+	siteRepository.Add(site.NewSite("Spearwind", "spearwind.io", owner))
+	return siteRepository
+}
+
+func TestCreatePageHandler(t *testing.T) {
+	client := &http.Client{}
+	pageRepository := NewInMemoryRepository()
+	server := newTestServer(pageRepository, newTestSiteRepository())
+	defer server.Close()
+
+	body := []byte(`{"slug":"about-us","title":"About Us","body":"<p>Hi</p>","author":{"id":1,"email":"creator@spearwind.io"}}`)
+
+	req, err := http.NewRequest("POST", server.URL+"/site/1/pages", bytes.NewBuffer(body))
+	if err != nil {
+		t.Errorf("Error in creating POST request for createPageHandler: %v", err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to createPageHandler: %v", err)
+	}
+
+	defer res.Body.Close()
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("Error parsing response body: %v", err)
+	}
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s: %s", res.Status, payload)
+	}
+
+	if loc := res.Header.Get("Location"); loc != "/site/1/pages/1" {
+		t.Errorf("Expected '/site/1/pages/1' but was %v", loc)
+	}
+
+	var page Page
+	if err := json.Unmarshal(payload, &page); err != nil {
+		t.Errorf("Could not unmarshal payload into Page object")
+	}
+
+	if page.SiteID != "1" || page.Status != workflow.StateDraft {
+		t.Errorf("Expected a draft page on site 1, but got %+v", page)
+	}
+
+	if len(pageRepository.ListBySite("1")) != 1 {
+		t.Error("Expected page repo to have exactly 1 page for site 1")
+	}
+}
+
+func TestCreatePageHandlerRejectsDuplicateSlug(t *testing.T) {
+	client := &http.Client{}
+	pageRepository := NewInMemoryRepository()
+	pageRepository.Add(NewPage("1", "about-us", "About Us", "", user.NewUser(1, "", "", "")))
+	server := newTestServer(pageRepository, newTestSiteRepository())
+	defer server.Close()
+
+	body := []byte(`{"slug":"about-us","title":"About Us","author":{"id":1}}`)
+
+	req, _ := http.NewRequest("POST", server.URL+"/site/1/pages", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to createPageHandler: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a duplicate slug to result in a bad request, received %s", res.Status)
+	}
+}
+
+func TestCreatePageHandlerForNonMemberSiteIsForbidden(t *testing.T) {
+	client := &http.Client{}
+	server := newTestServer(NewInMemoryRepository(), newTestSiteRepository())
+	defer server.Close()
+
+	body := []byte(`{"slug":"about-us","title":"About Us","author":{"id":1}}`)
+
+	req, _ := http.NewRequest("POST", server.URL+"/site/42/pages", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to createPageHandler: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a site the caller is not a member of to be forbidden, received %s", res.Status)
+	}
+}
+
+func TestUpdateAndDeletePageHandlers(t *testing.T) {
+	client := &http.Client{}
+	pageRepository := NewInMemoryRepository()
+	pageRepository.Add(NewPage("1", "about-us", "About Us", "", user.NewUser(1, "", "", "")))
+	server := newTestServer(pageRepository, newTestSiteRepository())
+	defer server.Close()
+
+	body := []byte(`{"title":"About Spearwind","status":"published","author":{"id":2},"updated_by":{"id":1}}`)
+	req, _ := http.NewRequest("PUT", server.URL+"/site/1/pages/1", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in PUT to updatePageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected response status 200, received %s", res.Status)
+	}
+
+	if page, _ := pageRepository.GetByID("1", "1"); page.Title != "About Spearwind" || page.Status != workflow.StateDraft {
+		t.Errorf("Expected the title to be updated without bypassing the workflow, but got %+v", page)
+	}
+
+	if page, _ := pageRepository.GetByID("1", "1"); page.Author == nil || page.Author.ID != 1 {
+		t.Errorf("Expected the author to be kept, but got %+v", page.Author)
+	}
+
+	req, _ = http.NewRequest("DELETE", server.URL+"/site/1/pages/1", nil)
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in DELETE to deletePageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected response status 204, received %s", res.Status)
+	}
+
+	if _, err := pageRepository.GetByID("1", "1"); err == nil {
+		t.Error("Expected the page to be removed from the repository")
+	}
+}
+
+func TestTransitionPageHandler(t *testing.T) {
+	client := &http.Client{}
+	pageRepository := NewInMemoryRepository()
+	pageRepository.Add(NewPage("1", "about-us", "About Us", "", user.NewUser(1, "", "", "")))
+	server := newTestServer(pageRepository, newTestSiteRepository())
+	defer server.Close()
+
+	body := []byte(`{"to":"published"}`)
+	req, _ := http.NewRequest("POST", server.URL+"/site/1/pages/1/transitions", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to transitionPageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected draft -> published to be rejected, received %s", res.Status)
+	}
+
+	body = []byte(`{"to":"in_review","actor":{"id":2,"email":"someone@else.io"},"comment":"Ready for review"}`)
+	req, _ = http.NewRequest("POST", server.URL+"/site/1/pages/1/transitions", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to transitionPageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("Expected draft -> in_review to succeed, received %s", res.Status)
+	}
+
+	page, _ := pageRepository.GetByID("1", "1")
+	if page.Status != workflow.StateInReview || len(page.History) != 1 {
+		t.Fatalf("Expected the page to be in review with one recorded transition, but got %+v", page)
+	}
+
+	if actor := page.History[0].Actor; actor == nil || actor.UserID != owner.ID || actor.Email != owner.Email {
+		t.Errorf("Expected the transition to be recorded against the caller, not the body, but got %+v", actor)
+	}
+}
+
+func TestAuthorCannotPublishPage(t *testing.T) {
+	client := &http.Client{}
+	author := user.NewUser(2, "Page", "Author", "author@spearwind.io")
+	pageRepository := NewInMemoryRepository()
+	page := NewPage("1", "about-us", "About Us", "", author)
+	page.Status = workflow.StateInReview
+	pageRepository.Add(page)
+
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", author.ID, membership.RoleAuthor))
+	server := newTestServerAs(pageRepository, newTestSiteRepository(), membershipRepository, author)
+	defer server.Close()
+
+	body := []byte(`{"to":"published"}`)
+	req, _ := http.NewRequest("POST", server.URL+"/site/1/pages/1/transitions", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to transitionPageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an author to be forbidden from publishing, received %s", res.Status)
+	}
+
+	if page, _ := pageRepository.GetByID("1", "1"); page.Status != workflow.StateInReview {
+		t.Errorf("Expected the page to remain in review, but got %s", page.Status)
+	}
+}
+
+func TestAuthorCannotChangePublishedPage(t *testing.T) {
+	client := &http.Client{}
+	author := user.NewUser(2, "Page", "Author", "author@spearwind.io")
+	pageRepository := NewInMemoryRepository()
+	page := NewPage("1", "about-us", "About Us", "", author)
+	page.Status = workflow.StatePublished
+	pageRepository.Add(page)
+
+	revisionRepository := revision.NewInMemoryRepository()
+	revision.Record(revisionRepository, contentType, page.ID, author, page)
+
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", author.ID, membership.RoleAuthor))
+	siteRepository := newTestSiteRepository()
+
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, pageRepository, siteRepository, membership.NewAuthorizer(formatter, membershipRepository, site.NewMFAPolicy(siteRepository)), revisionRepository, events.NewSynchEventPublisher())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), author)))
+	}))
+	defer server.Close()
+
+	body := []byte(`{"title":"Unreviewed Title"}`)
+	req, _ := http.NewRequest("PUT", server.URL+"/site/1/pages/1", bytes.NewBuffer(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in PUT to updatePageHandler: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an author to be forbidden from editing a published page, received %s", res.Status)
+	}
+
+	req, _ = http.NewRequest("POST", server.URL+"/site/1/pages/1/revisions/1/restore", nil)
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Error in POST to restore a page revision: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an author to be forbidden from restoring a published page, received %s", res.Status)
+	}
+
+	if page, _ := pageRepository.GetByID("1", "1"); page.Title != "About Us" {
+		t.Errorf("Expected the published page to be unchanged, but got %+v", page)
+	}
+}
@@ -0,0 +1,71 @@
+package page
+
+import (
+	"errors"
+	"fmt"
+)
+
+type inMemoryRepository struct {
+	pages  map[string]*Page
+	nextID int
+}
+
+func NewInMemoryRepository() *inMemoryRepository {
+	repo := &inMemoryRepository{}
+	repo.pages = make(map[string]*Page)
+	return repo
+}
+
+func (repo *inMemoryRepository) Add(page *Page) (err error) {
+	repo.nextID++
+	page.ID = fmt.Sprintf("%d", repo.nextID)
+	repo.pages[page.ID] = page
+	return err
+}
+
+func (repo *inMemoryRepository) Update(page *Page) (err error) {
+	if _, ok := repo.pages[page.ID]; !ok {
+		return errors.New("Could not find page in repository")
+	}
+
+	repo.pages[page.ID] = page
+	return err
+}
+
+func (repo *inMemoryRepository) Delete(page *Page) (err error) {
+	if _, ok := repo.pages[page.ID]; !ok {
+		return errors.New("Could not find page in repository")
+	}
+
+	delete(repo.pages, page.ID)
+	return err
+}
+
+func (repo *inMemoryRepository) ListBySite(siteID string) (pages []*Page) {
+	for _, page := range repo.pages {
+		if page.SiteID == siteID {
+			pages = append(pages, page)
+		}
+	}
+
+	return pages
+}
+
+func (repo *inMemoryRepository) GetByID(siteID string, pageID string) (page *Page, err error) {
+	if target, ok := repo.pages[pageID]; ok && target.SiteID == siteID {
+		return target, nil
+	}
+
+	return nil, errors.New("Could not find page in repository")
+}
+
+func (repo *inMemoryRepository) FindBySlug(siteID string, slug string) (page *Page) {
+	for _, target := range repo.pages {
+		if target.SiteID == siteID && target.Slug == slug {
+			page = target
+			break
+		}
+	}
+
+	return page
+}
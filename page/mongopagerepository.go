@@ -0,0 +1,244 @@
+package page
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/workflow"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoPageRepository struct {
+	Collection cfmgo.Collection
+}
+
+type pageRecord struct {
+	RecordID           bson.ObjectId      `bson:"_id,omitempty" json:"id"`
+	PageID             string             `bson:"page_id" json:"page_id"`
+	SiteID             string             `bson:"site_id" json:"site_id"`
+	Slug               string             `bson:"slug" json:"slug"`
+	Title              string             `bson:"title" json:"title"`
+	Body               string             `bson:"body" json:"body"`
+	Status             string             `bson:"status" json:"status"`
+	AuthorID           int64              `bson:"author_id" json:"author_id"`
+	AuthorEmail        string             `bson:"author_email" json:"author_email"`
+	AuthorFirstName    string             `bson:"author_first_name" json:"author_first_name"`
+	AuthorLastName     string             `bson:"author_last_name" json:"author_last_name"`
+	History            []transitionRecord `bson:"history" json:"history"`
+	Created            time.Time          `bson:"date_created" json:"date_created"`
+	UpdatedByID        int64              `bson:"updated_by_id" json:"updated_by_id"`
+	UpdatedByEmail     string             `bson:"updated_by_email" json:"updated_by_email"`
+	UpdatedByFirstName string             `bson:"updated_by_first_name" json:"updated_by_first_name"`
+	UpdatedByLastName  string             `bson:"updated_by_last_name" json:"updated_by_last_name"`
+	Updated            time.Time          `bson:"date_updated" json:"date_updated"`
+	Published          time.Time          `bson:"date_published,omitempty" json:"date_published"`
+}
+
+type transitionRecord struct {
+	From         string    `bson:"from" json:"from"`
+	To           string    `bson:"to" json:"to"`
+	ActorID      int64     `bson:"actor_id" json:"actor_id"`
+	ActorEmail   string    `bson:"actor_email" json:"actor_email"`
+	Comment      string    `bson:"comment" json:"comment"`
+	ScheduledFor time.Time `bson:"scheduled_for" json:"scheduled_for"`
+	When         time.Time `bson:"when" json:"when"`
+}
+
+func NewMongoPageRepository(col cfmgo.Collection) *mongoPageRepository {
+	return &mongoPageRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoPageRepository) Add(page *Page) (err error) {
+	repo.Collection.Wake()
+	pr := toPageRecord(page)
+	pr.PageID = pr.RecordID.Hex()
+	if _, err = repo.Collection.UpsertID(pr.RecordID, pr); err == nil {
+		page.ID = pr.PageID
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) Update(page *Page) (err error) {
+	repo.Collection.Wake()
+	foundPage, err := repo.getMongoPage(page.SiteID, page.ID)
+	if err == nil {
+		pr := toPageRecord(page)
+		pr.RecordID = foundPage.RecordID
+		_, err = repo.Collection.UpsertID(pr.RecordID, pr)
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) Delete(page *Page) (err error) {
+	repo.Collection.Wake()
+	foundPage, err := repo.getMongoPage(page.SiteID, page.ID)
+	if err == nil {
+		err = repo.Collection.Delete(bson.M{"_id": foundPage.RecordID})
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) ListBySite(siteID string) (pages []*Page) {
+	repo.Collection.Wake()
+	var pr []pageRecord
+	params := &params.RequestParams{
+		Q: bson.M{"site_id": siteID},
+	}
+
+	if _, err := repo.Collection.Find(params, &pr); err == nil {
+		pages = make([]*Page, len(pr))
+		for k, v := range pr {
+			pages[k] = toPage(&v)
+		}
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) GetByID(siteID string, pageID string) (page *Page, err error) {
+	var pr *pageRecord
+	pr, err = repo.getMongoPage(siteID, pageID)
+	if pr != nil && err == nil {
+		page = toPage(pr)
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) FindBySlug(siteID string, slug string) (page *Page) {
+	pr, err := repo.findOne(bson.M{"site_id": siteID, "slug": slug})
+	if err == nil {
+		page = toPage(pr)
+	}
+
+	return
+}
+
+func (repo *mongoPageRepository) getMongoPage(siteID string, pageID string) (*pageRecord, error) {
+	return repo.findOne(bson.M{"site_id": siteID, "page_id": pageID})
+}
+
+func (repo *mongoPageRepository) findOne(query bson.M) (page *pageRecord, err error) {
+	var pages []pageRecord
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	count, err := repo.Collection.Find(params, &pages)
+	if count == 0 {
+		err = errors.New("Page not found")
+	}
+	if err == nil {
+		page = &pages[0]
+	}
+
+	return
+}
+
+func toPageRecord(p *Page) (pr *pageRecord) {
+	pr = &pageRecord{
+		RecordID: bson.NewObjectId(),
+		PageID:   p.ID,
+		SiteID:   p.SiteID,
+		Slug:     p.Slug,
+		Title:    p.Title,
+		Body:     p.Body,
+		Status:   string(p.Status),
+		Created:  p.Created,
+		Updated:  p.Updated,
+	}
+
+	for _, t := range p.History {
+		tr := transitionRecord{
+			From:         string(t.From),
+			To:           string(t.To),
+			Comment:      t.Comment,
+			ScheduledFor: t.ScheduledFor,
+			When:         t.When,
+		}
+
+		if t.Actor != nil {
+			tr.ActorID = t.Actor.UserID
+			tr.ActorEmail = t.Actor.Email
+		}
+
+		pr.History = append(pr.History, tr)
+	}
+
+	if p.Author != nil {
+		pr.AuthorID = p.Author.ID
+		pr.AuthorEmail = p.Author.Email
+		pr.AuthorFirstName = p.Author.FirstName
+		pr.AuthorLastName = p.Author.LastName
+	}
+
+	if p.Published != nil {
+		pr.Published = *p.Published
+	}
+
+	if p.UpdatedBy != nil {
+		pr.UpdatedByID = p.UpdatedBy.ID
+		pr.UpdatedByEmail = p.UpdatedBy.Email
+		pr.UpdatedByFirstName = p.UpdatedBy.FirstName
+		pr.UpdatedByLastName = p.UpdatedBy.LastName
+	}
+
+	return
+}
+
+func toPage(pr *pageRecord) (p *Page) {
+	p = &Page{
+		ID:      pr.PageID,
+		SiteID:  pr.SiteID,
+		Slug:    pr.Slug,
+		Title:   pr.Title,
+		Body:    pr.Body,
+		Status:  workflow.State(pr.Status),
+		Author:  user.NewUser(pr.AuthorID, pr.AuthorFirstName, pr.AuthorLastName, pr.AuthorEmail),
+		Created: pr.Created,
+		Updated: pr.Updated,
+	}
+
+	if pr.UpdatedByID != 0 {
+		p.UpdatedBy = user.NewUser(pr.UpdatedByID, pr.UpdatedByFirstName, pr.UpdatedByLastName, pr.UpdatedByEmail)
+	}
+
+	for _, tr := range pr.History {
+		t := workflow.Transition{
+			From:         workflow.State(tr.From),
+			To:           workflow.State(tr.To),
+			Comment:      tr.Comment,
+			ScheduledFor: tr.ScheduledFor,
+			When:         tr.When,
+		}
+
+		if tr.ActorID != 0 {
+			t.Actor = &events.Actor{UserID: tr.ActorID, Email: tr.ActorEmail}
+		}
+
+		p.History = append(p.History, t)
+
+		// Pages published before the date was stored take it from their
+		// history.
+		if pr.Published.IsZero() && t.To == workflow.StatePublished {
+			published := t.When
+			p.Published = &published
+		}
+	}
+
+	if !pr.Published.IsZero() {
+		published := pr.Published
+		p.Published = &published
+	}
+
+	return
+}
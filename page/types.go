@@ -0,0 +1,112 @@
+package page
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/validator"
+	"github.com/spear-wind/cms/workflow"
+)
+
+const contentType = "page"
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+
+type PageRepository interface {
+	Add(page *Page) (err error)
+	Update(page *Page) (err error)
+	Delete(page *Page) (err error)
+	ListBySite(siteID string) (pages []*Page)
+	GetByID(siteID string, id string) (page *Page, err error)
+	FindBySlug(siteID string, slug string) (page *Page)
+}
+
+type Page struct {
+	ID        string                `json:"id"`
+	SiteID    string                `json:"site_id"`
+	Slug      string                `json:"slug"`
+	Title     string                `json:"title"`
+	Body      string                `json:"body"`
+	Status    workflow.State        `json:"status"`
+	Author    *user.User            `json:"author"`
+	History   []workflow.Transition `json:"history"`
+	Created   time.Time             `json:"date_created"`
+	UpdatedBy *user.User            `json:"updated_by,omitempty"`
+	Updated   time.Time             `json:"date_updated"`
+	Published *time.Time            `json:"date_published,omitempty"`
+}
+
+func NewPage(siteID string, slug string, title string, body string, author *user.User) *Page {
+	now := time.Now()
+
+	return &Page{
+		SiteID:  siteID,
+		Slug:    slug,
+		Title:   title,
+		Body:    body,
+		Status:  workflow.StateDraft,
+		Author:  author,
+		Created: now,
+		Updated: now,
+	}
+}
+
+func (p *Page) validate(pageRepository PageRepository) (result validator.ValidationResult) {
+	result = validator.NewValidationResult()
+
+	if len(p.SiteID) == 0 {
+		result.AddError("site_id", "Site ID is required")
+	}
+
+	if len(p.Slug) == 0 {
+		result.AddError("slug", "Slug is required")
+	} else if !slugPattern.MatchString(p.Slug) {
+		result.AddError("slug", "Slug may only contain lowercase letters, numbers and hyphens")
+	} else if existing := pageRepository.FindBySlug(p.SiteID, p.Slug); existing != nil && existing.ID != p.ID {
+		result.AddError("slug", "Slug is already in use on this site")
+	}
+
+	if len(p.Title) == 0 {
+		result.AddError("title", "Title is required")
+	}
+
+	if !workflow.IsValidState(p.Status) {
+		result.AddError("status", "Status is not a valid workflow state")
+	}
+
+	if p.Author == nil {
+		result.AddError("author", "Author is required")
+	}
+
+	return result
+}
+
+func (p *Page) ContentType() string {
+	return contentType
+}
+
+func (p *Page) ContentID() string {
+	return p.ID
+}
+
+func (p *Page) ContentSiteID() string {
+	return p.SiteID
+}
+
+func (p *Page) WorkflowState() workflow.State {
+	return p.Status
+}
+
+// ApplyTransition moves the page to the transition's state. Published
+// records when the page last moved to published, and is only set here.
+func (p *Page) ApplyTransition(t workflow.Transition) {
+	p.Status = t.To
+	p.Updated = t.When
+	p.History = append(p.History, t)
+
+	if t.To == workflow.StatePublished {
+		published := t.When
+		p.Published = &published
+	}
+}
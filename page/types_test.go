@@ -0,0 +1,92 @@
+package page
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/workflow"
+)
+
+func TestValidateWithEmptyRequiredFieldsFailsWithErrors(t *testing.T) {
+	page := NewPage("", "", "", "", nil)
+
+	result := page.validate(NewInMemoryRepository())
+
+	if len(result.Errors) != 4 {
+		t.Errorf("Expected exactly four errors, but there were %d errors: %v", len(result.Errors), result.Errors)
+	}
+}
+
+func TestValidateHappyPath(t *testing.T) {
+	author := user.NewUser(1, "Spearwind", "Author", "author@spearwind.io")
+	page := NewPage("1", "about-us", "About Us", "<p>Hello</p>", author)
+
+	result := page.validate(NewInMemoryRepository())
+
+	if result.HasErrors() {
+		t.Errorf("Expected validation to pass with all required fields, but got: %v", result.Errors)
+	}
+}
+
+func TestValidateRejectsMalformedSlug(t *testing.T) {
+	author := user.NewUser(1, "Spearwind", "Author", "author@spearwind.io")
+	page := NewPage("1", "About Us!", "About Us", "", author)
+
+	result := page.validate(NewInMemoryRepository())
+
+	if len(result.Errors) != 1 || result.Errors[0].FieldName != "slug" {
+		t.Errorf("Expected a single slug error, but got: %v", result.Errors)
+	}
+}
+
+func TestValidateRejectsDuplicateSlugWithinSite(t *testing.T) {
+	author := user.NewUser(1, "Spearwind", "Author", "author@spearwind.io")
+	repo := NewInMemoryRepository()
+	repo.Add(NewPage("1", "about-us", "About Us", "", author))
+
+	duplicate := NewPage("1", "about-us", "About Us Again", "", author)
+	if result := duplicate.validate(repo); len(result.Errors) != 1 || result.Errors[0].FieldName != "slug" {
+		t.Errorf("Expected a single slug error for a duplicate slug, but got: %v", result.Errors)
+	}
+
+	otherSite := NewPage("2", "about-us", "About Us", "", author)
+	if result := otherSite.validate(repo); result.HasErrors() {
+		t.Errorf("The same slug should be allowed on a different site, but got: %v", result.Errors)
+	}
+}
+
+func TestValidateAllowsPageToKeepItsOwnSlug(t *testing.T) {
+	author := user.NewUser(1, "Spearwind", "Author", "author@spearwind.io")
+	repo := NewInMemoryRepository()
+	page := NewPage("1", "about-us", "About Us", "", author)
+	repo.Add(page)
+
+	if result := page.validate(repo); result.HasErrors() {
+		t.Errorf("A page should not conflict with its own slug, but got: %v", result.Errors)
+	}
+}
+
+func TestApplyTransitionRecordsWhenPageWasPublished(t *testing.T) {
+	page := NewPage("1", "about-us", "About Us", "", nil)
+	published := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	page.ApplyTransition(workflow.Transition{From: workflow.StateDraft, To: workflow.StateInReview, When: published.Add(-time.Hour)})
+	if page.Published != nil {
+		t.Errorf("Expected an unpublished page to have no publish date, got %v", page.Published)
+	}
+
+	page.ApplyTransition(workflow.Transition{From: workflow.StateInReview, To: workflow.StatePublished, When: published})
+	page.ApplyTransition(workflow.Transition{From: workflow.StatePublished, To: workflow.StateDraft, When: published.Add(time.Hour)})
+	if page.Published == nil || !page.Published.Equal(published) {
+		t.Errorf("Expected the page to keep the time it was published, got %v", page.Published)
+	}
+
+	// Records saved before the publish date was stored take it from the
+	// page's history.
+	record := toPageRecord(page)
+	record.Published = time.Time{}
+	if restored := toPage(record); restored.Published == nil || !restored.Published.Equal(published) {
+		t.Errorf("Expected the publish date to be read from the history, got %v", restored.Published)
+	}
+}
@@ -4,37 +4,57 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/auth"
 	"github.com/spear-wind/cms/events"
 	"github.com/spear-wind/cms/user"
 	"github.com/unrolled/render"
 )
 
-func InitRoutes(router *mux.Router, formatter *render.Render, userRepository user.UserRepository, eventPublisher events.EventPublisher) {
+// ResetThrottleOptions limit password reset requests: each email can ask
+// for a few an hour, waiting longer before each, and each address for a few
+// more, so that the endpoint can't be used to flood someone's inbox.
+var ResetThrottleOptions = auth.ThrottleOptions{
+	AccountLimit: 3,
+	IPLimit:      20,
+	Lockout:      time.Hour,
+	Delay:        time.Minute,
+	MaxDelay:     15 * time.Minute,
+}
+
+func InitRoutes(router *mux.Router, formatter *render.Render, userRepository user.UserRepository, resetThrottle *auth.LoginThrottle, eventPublisher events.EventPublisher) {
 	router.HandleFunc("/register", userRegistrationHandler(formatter, userRepository, eventPublisher)).Methods("POST")
-	router.HandleFunc("/verify/{verificationCode}", userVerificationHandler(formatter, userRepository)).Methods("POST")
+	router.HandleFunc("/verify/resend", resendVerificationHandler(formatter, userRepository, eventPublisher)).Methods("POST")
+	router.HandleFunc("/verify/{verificationCode}", userVerificationHandler(formatter, userRepository, eventPublisher)).Methods("POST")
+	router.HandleFunc("/password/forgot", forgotPasswordHandler(formatter, userRepository, resetThrottle, eventPublisher)).Methods("POST")
+	router.HandleFunc("/password/reset/{token}", resetPasswordHandler(formatter, userRepository, eventPublisher)).Methods("POST")
 }
 
 func userRegistrationHandler(formatter *render.Render, userRepository user.UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		payload, _ := ioutil.ReadAll(req.Body)
-		var user user.User
+		var registrationRequest user.PasswordRequest
 
-		if err := json.Unmarshal(payload, &user); err != nil {
+		if err := json.Unmarshal(payload, &registrationRequest); err != nil {
 			formatter.Text(w, http.StatusBadRequest, "Failed to parse create user request")
 			return
 		}
 
-		if userRepository.Exists(&user) {
+		account := registrationRequest.NewUser()
+
+		if userRepository.Exists(&account) {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": "This user already exists",
 			})
 			return
 		}
 
-		if result, err := user.Register(); result.HasErrors() {
+		if result, err := account.Register(); result.HasErrors() {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": result.Errors,
 			})
@@ -46,52 +66,218 @@ func userRegistrationHandler(formatter *render.Render, userRepository user.UserR
 			return
 		}
 
-		if err := userRepository.Add(&user); err != nil {
+		if err := userRepository.Add(&account); err != nil {
 			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
-				"user":  user,
+				"user":  account,
 				"error": err.Error(),
 			})
 
 			return
 		}
 
-		w.Header().Add("Location", fmt.Sprintf("/user/%d", user.ID))
-		formatter.JSON(w, http.StatusCreated, user)
+		// The account is stored with its verification email marked pending,
+		// as the repositories share no transaction. If the email can't be
+		// saved here, a VerificationSweeper saves it later.
+		if err := sendVerification(&account, userRepository, eventPublisher); err != nil {
+			fmt.Printf("Failed to save the verification email for user %d: %v\n", account.ID, err)
+		}
+
+		events.Publish(eventPublisher, account.Actor(), user.NewRegisteredEvent(&account))
 
-		eventPublisher.Publish(events.NewUserRegistrationEvent(user.Email, user.VerificationCode))
-		fmt.Printf("New user registration event published; verification code: %s\n", user.VerificationCode)
+		w.Header().Add("Location", fmt.Sprintf("/user/%d", account.ID))
+		formatter.JSON(w, http.StatusCreated, account)
 	}
 }
 
-func userVerificationHandler(formatter *render.Render, userRepository user.UserRepository) http.HandlerFunc {
+func userVerificationHandler(formatter *render.Render, userRepository user.UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		vars := mux.Vars(req)
 		verificationCode := vars["verificationCode"]
-		user := userRepository.FindByVerificationCode(verificationCode)
+		account := userRepository.FindByVerificationCode(verificationCode)
 
-		if len(verificationCode) == 0 || user == nil {
+		if len(verificationCode) == 0 || account == nil {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": "Invalid Verification Code",
 			})
 			return
 		}
 
-		if err := user.Verify(verificationCode); err != nil {
+		if err := account.Verify(verificationCode); err != nil {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": err.Error(),
 			})
 			return
 		}
 
-		if err := userRepository.Update(user); err != nil {
+		if err := userRepository.Update(account); err != nil {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": err.Error(),
 			})
 			return
 		}
 
+		events.Publish(eventPublisher, account.Actor(), user.NewVerifiedEvent(account))
+
 		formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 			"success": "Your account is now verified",
 		})
 	}
 }
+
+// resendVerificationHandler emails a new verification code to the address in
+// the request if it belongs to an unverified user, replacing the old code.
+// Like forgotPasswordHandler, the response is the same whether or not it
+// does, and also when the user has to wait before another email is sent.
+func resendVerificationHandler(formatter *render.Render, userRepository user.UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+		var resendRequest struct {
+			Email string `json:"email"`
+		}
+
+		if err := json.Unmarshal(payload, &resendRequest); err != nil || len(resendRequest.Email) == 0 {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": "Email is required",
+			})
+			return
+		}
+
+		if account := userRepository.FindByEmail(resendRequest.Email); account != nil && account.CanResendVerification() {
+			if err := resendVerification(account, userRepository, eventPublisher); err != nil {
+				fmt.Printf("Failed to resend verification email to user %d: %v\n", account.ID, err)
+			}
+		}
+
+		formatter.JSON(w, http.StatusAccepted, map[string]interface{}{
+			"success": "If an unverified account exists for that email address, a new verification email has been sent to it",
+		})
+	}
+}
+
+func resendVerification(account *user.User, userRepository user.UserRepository, eventPublisher events.EventPublisher) error {
+	if _, err := account.RotateVerificationCode(); err != nil {
+		return err
+	}
+
+	if err := userRepository.Update(account); err != nil {
+		return err
+	}
+
+	return sendVerification(account, userRepository, eventPublisher)
+}
+
+// sendVerification saves the email carrying the account's current
+// verification code and clears VerificationPending. If the account can't be
+// updated afterwards, the email may be sent twice.
+func sendVerification(account *user.User, userRepository user.UserRepository, eventPublisher events.EventPublisher) error {
+	if err := events.Save(eventPublisher, events.NewVerificationEmail(account.Recipient(), account.VerificationCode)); err != nil {
+		return err
+	}
+
+	account.VerificationPending = false
+	return userRepository.Update(account)
+}
+
+// forgotPasswordHandler emails a reset link to the address in the request if
+// it belongs to a user. The response is the same either way so the endpoint
+// cannot be used to discover which email addresses have accounts. Requests
+// are counted against the email whether or not it has an account, for the
+// same reason.
+func forgotPasswordHandler(formatter *render.Render, userRepository user.UserRepository, throttle *auth.LoginThrottle, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		payload, _ := ioutil.ReadAll(req.Body)
+		var forgotRequest struct {
+			Email string `json:"email"`
+		}
+
+		if err := json.Unmarshal(payload, &forgotRequest); err != nil || len(forgotRequest.Email) == 0 {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": "Email is required",
+			})
+			return
+		}
+
+		ip := throttle.ClientIP(req)
+
+		wait, err := throttle.Attempt(forgotRequest.Email, ip)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		if wait > 0 {
+			fmt.Printf("Security: throttled password reset for %s from %s\n", forgotRequest.Email, ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			formatter.Text(w, http.StatusTooManyRequests, "Too many password reset requests. Try again later.")
+			return
+		}
+
+		if account := userRepository.FindByEmail(forgotRequest.Email); account != nil {
+			if err := sendPasswordReset(account, userRepository, eventPublisher); err != nil {
+				fmt.Printf("Failed to start password reset for user %d: %v\n", account.ID, err)
+			}
+		}
+
+		formatter.JSON(w, http.StatusAccepted, map[string]interface{}{
+			"success": "If an account exists for that email address, a password reset link has been sent to it",
+		})
+	}
+}
+
+func sendPasswordReset(account *user.User, userRepository user.UserRepository, eventPublisher events.EventPublisher) error {
+	token, err := account.StartPasswordReset()
+	if err != nil {
+		return err
+	}
+
+	if err := userRepository.Update(account); err != nil {
+		return err
+	}
+
+	return events.Save(eventPublisher, events.NewPasswordResetEmail(account.Recipient(), token))
+}
+
+func resetPasswordHandler(formatter *render.Render, userRepository user.UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := mux.Vars(req)["token"]
+		payload, _ := ioutil.ReadAll(req.Body)
+		var resetRequest struct {
+			Password string `json:"password"`
+		}
+
+		if err := json.Unmarshal(payload, &resetRequest); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse password reset request")
+			return
+		}
+
+		account := userRepository.FindByPasswordResetToken(token)
+		if account == nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": user.ErrInvalidPasswordResetToken.Error(),
+			})
+			return
+		}
+
+		if err := account.ResetPassword(token, resetRequest.Password); err != nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		if err := userRepository.Update(account); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"errors": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, account.Actor(), user.NewPasswordResetEvent(account))
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"success": "Your password has been reset",
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package registration
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/auth"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+type recordingPublisher struct {
+	published []interface{}
+}
+
+func (p *recordingPublisher) Publish(e interface{}) {
+	p.published = append(p.published, e)
+}
+
+func (p *recordingPublisher) Add(s events.EventSubscriber) {}
+
+// failingPublisher is an events.EventSaver whose store is unavailable.
+type failingPublisher struct {
+	recordingPublisher
+}
+
+func (p *failingPublisher) Save(e interface{}) error {
+	return errors.New("outbox unavailable")
+}
+
+func newTestServer(userRepository user.UserRepository, eventPublisher events.EventPublisher) *httptest.Server {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, userRepository, auth.NewLoginThrottle(auth.NewInMemoryLoginAttemptStore(), ResetThrottleOptions), eventPublisher)
+	return httptest.NewServer(router)
+}
+
+func post(t *testing.T, url string, body string) *http.Response {
+	res, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error in POST to %s: %v", url, err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func TestRegistrationSweepsVerificationEmailThatWasNotSaved(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	server := newTestServer(userRepository, &failingPublisher{})
+	defer server.Close()
+
+	res := post(t, server.URL+"/register", `{"first_name":"John","last_name":"Doe","email":"john@tld.com","password":"p@$$w0rd"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected the account to be created when the verification email can't be saved, received %s", res.Status)
+	}
+
+	account := userRepository.FindByEmail("john@tld.com")
+	if account == nil || !account.VerificationPending {
+		t.Fatalf("Expected the account to be stored with its verification email pending, got %v", account)
+	}
+
+	publisher := &recordingPublisher{}
+	sweeper := NewVerificationSweeper(userRepository, publisher)
+
+	if sent := sweeper.SendPending(); sent != 0 {
+		t.Fatalf("Expected the sweeper to leave a code that was just issued, sent %d", sent)
+	}
+
+	sweeper.now = func() time.Time { return time.Now().Add(2 * verificationSweepDelay) }
+	if sent := sweeper.SendPending(); sent != 1 || len(publisher.published) != 1 {
+		t.Fatalf("Expected the sweeper to send the pending email, sent %d", sent)
+	}
+
+	if email, ok := publisher.published[0].(events.VerificationEmail); !ok || email.VerificationCode != account.VerificationCode {
+		t.Errorf("Expected the pending email to carry the account's code, got %#v", publisher.published[0])
+	}
+
+	if sent := sweeper.SendPending(); sent != 0 || account.VerificationPending {
+		t.Errorf("Expected the email to be sent only once, sent %d more", sent)
+	}
+}
+
+func TestForgotPasswordDoesNotRevealUnknownEmail(t *testing.T) {
+	publisher := &recordingPublisher{}
+	server := newTestServer(user.NewInMemoryRepository(), publisher)
+	defer server.Close()
+
+	res := post(t, server.URL+"/password/forgot", `{"email":"nobody@spearwind.io"}`)
+	if res.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected response status 202 for an unknown email, received %s", res.Status)
+	}
+
+	if len(publisher.published) != 0 {
+		t.Errorf("Expected no email to be sent for an unknown email, got %d", len(publisher.published))
+	}
+}
+
+func TestForgotPasswordIsThrottled(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	account := &user.User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	account.Register()
+	userRepository.Add(account)
+
+	publisher := &recordingPublisher{}
+	server := newTestServer(userRepository, publisher)
+	defer server.Close()
+
+	post(t, server.URL+"/password/forgot", `{"email":"john@tld.com"}`)
+
+	res := post(t, server.URL+"/password/forgot", `{"email":"John@TLD.com"}`)
+	if res.StatusCode != http.StatusTooManyRequests || len(res.Header.Get("Retry-After")) == 0 {
+		t.Errorf("Expected a second request for the same email to be throttled, received %s", res.Status)
+	}
+
+	if len(publisher.published) != 1 {
+		t.Errorf("Expected only one password reset email, got %d", len(publisher.published))
+	}
+
+	for i := 0; i < ResetThrottleOptions.IPLimit; i++ {
+		post(t, server.URL+"/password/forgot", fmt.Sprintf(`{"email":"user%d@tld.com"}`, i))
+	}
+
+	if res := post(t, server.URL+"/password/forgot", `{"email":"another@tld.com"}`); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected requests from one address to be limited, received %s", res.Status)
+	}
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	account := &user.User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	account.Register()
+	userRepository.Add(account)
+
+	publisher := &recordingPublisher{}
+	server := newTestServer(userRepository, publisher)
+	defer server.Close()
+
+	res := post(t, server.URL+"/password/forgot", `{"email":"john@tld.com"}`)
+	if res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected response status 202, received %s", res.Status)
+	}
+
+	if len(publisher.published) != 1 {
+		t.Fatalf("Expected exactly one password reset email, got %d", len(publisher.published))
+	}
+
+	event, ok := publisher.published[0].(events.PasswordResetEmail)
+	if !ok {
+		t.Fatalf("Expected an events.PasswordResetEmail, got %T", publisher.published[0])
+	}
+
+	if event.Email != "john@tld.com" || event.Name != "John" {
+		t.Errorf("Expected the email to be addressed to the user, got %+v", event.Recipient)
+	}
+	token := event.ResetToken
+
+	if res := post(t, server.URL+"/password/reset/"+token, `{"password":"n3w-p@$$w0rd"}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s", res.Status)
+	}
+
+	if ok, _ := userRepository.FindByEmail("john@tld.com").Authenticate("n3w-p@$$w0rd"); !ok {
+		t.Error("Expected the new password to authenticate")
+	}
+
+	if len(publisher.published) != 2 {
+		t.Fatalf("Expected a password reset event to be published, got %d events", len(publisher.published))
+	}
+
+	if envelope, ok := publisher.published[1].(events.Envelope); !ok || envelope.Type != "user.password_reset" || envelope.Actor.UserID != account.ID {
+		t.Errorf("Expected a user.password_reset envelope, got %#v", publisher.published[1])
+	}
+
+	if res := post(t, server.URL+"/password/reset/"+token, `{"password":"an0ther-p@$$w0rd"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a used token to be rejected, received %s", res.Status)
+	}
+}
+
+func TestResendVerificationRotatesCode(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	account := &user.User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	account.Register()
+	userRepository.Add(account)
+	firstCode := account.VerificationCode
+
+	publisher := &recordingPublisher{}
+	server := newTestServer(userRepository, publisher)
+	defer server.Close()
+
+	if res := post(t, server.URL+"/verify/resend", `{"email":"john@tld.com"}`); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected response status 202, received %s", res.Status)
+	}
+
+	if len(publisher.published) != 0 {
+		t.Fatalf("Expected a resend straight after registering to be throttled, got %d emails", len(publisher.published))
+	}
+
+	account.VerificationIssued = time.Now().Add(-user.VerificationResendInterval)
+
+	if res := post(t, server.URL+"/verify/resend", `{"email":"john@tld.com"}`); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected response status 202, received %s", res.Status)
+	}
+
+	if len(publisher.published) != 1 {
+		t.Fatalf("Expected exactly one verification email, got %d", len(publisher.published))
+	}
+
+	event, ok := publisher.published[0].(events.VerificationEmail)
+	if !ok {
+		t.Fatalf("Expected a verification email, got %T", publisher.published[0])
+	}
+
+	if event.VerificationCode == firstCode || event.VerificationCode != account.VerificationCode {
+		t.Fatalf("Expected the resent email to carry a new code")
+	}
+
+	if res := post(t, server.URL+"/verify/"+firstCode, ``); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the old code to be rejected, received %s", res.Status)
+	}
+
+	post(t, server.URL+"/verify/"+event.VerificationCode, ``)
+	if !account.Verified {
+		t.Errorf("Expected the new code to verify the account")
+	}
+}
+
+func TestResendVerificationDoesNotRevealUnknownOrVerifiedEmail(t *testing.T) {
+	userRepository := user.NewInMemoryRepository()
+	verified := user.NewUser(0, "Jane", "Doe", "jane@tld.com")
+	verified.Verified = true
+	userRepository.Add(verified)
+
+	publisher := &recordingPublisher{}
+	server := newTestServer(userRepository, publisher)
+	defer server.Close()
+
+	for _, address := range []string{"nobody@tld.com", "jane@tld.com"} {
+		if res := post(t, server.URL+"/verify/resend", `{"email":"`+address+`"}`); res.StatusCode != http.StatusAccepted {
+			t.Errorf("Expected response status 202 for %s, received %s", address, res.Status)
+		}
+	}
+
+	if len(publisher.published) != 0 {
+		t.Errorf("Expected no verification emails, got %d", len(publisher.published))
+	}
+}
@@ -0,0 +1,112 @@
+package registration
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+)
+
+// VerificationSweepInterval is how often a VerificationSweeper looks for
+// verification emails that were never saved.
+const VerificationSweepInterval = time.Minute
+
+// verificationSweepDelay gives the request that issued a code time to save
+// its email before the sweeper sends it instead.
+const verificationSweepDelay = time.Minute
+
+// VerificationSweeper saves the verification email of any unverified user
+// whose email wasn't saved when their code was issued, e.g. because the
+// outbox was unavailable or the process stopped between the two writes.
+type VerificationSweeper struct {
+	userRepository user.UserRepository
+	eventPublisher events.EventPublisher
+	now            func() time.Time
+
+	stateMutex sync.Mutex
+	running    bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+func NewVerificationSweeper(userRepository user.UserRepository, eventPublisher events.EventPublisher) *VerificationSweeper {
+	return &VerificationSweeper{
+		userRepository: userRepository,
+		eventPublisher: eventPublisher,
+		now:            time.Now,
+	}
+}
+
+// SendPending saves the verification emails that are still pending and
+// returns how many it saved.
+func (s *VerificationSweeper) SendPending() (sent int) {
+	for _, account := range s.userRepository.FindPendingVerifications(s.now().Add(-verificationSweepDelay)) {
+		if err := sendVerification(account, s.userRepository, s.eventPublisher); err != nil {
+			fmt.Printf("Failed to save the verification email for user %d: %v\n", account.ID, err)
+			continue
+		}
+
+		sent++
+	}
+
+	return sent
+}
+
+// Start begins sweeping, starting with any emails left over from a previous
+// run.
+func (s *VerificationSweeper) Start() {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	stop, stopped := s.stop, s.stopped
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(VerificationSweepInterval)
+		defer ticker.Stop()
+
+		for {
+			s.SendPending()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown stops sweeping once the sweep in progress is done, or when ctx is
+// done.
+func (s *VerificationSweeper) Shutdown(ctx context.Context) error {
+	s.stateMutex.Lock()
+	running, stopped := s.running, s.stopped
+	if running {
+		s.running = false
+		close(s.stop)
+	}
+	s.stateMutex.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,182 @@
+package revision
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+// LookupFunc resolves the ID of the resource addressed by req, returning an
+// error if it does not exist.
+type LookupFunc func(req *http.Request) (resourceID string, err error)
+
+// RestoreFunc applies revision to the live resource on behalf of author,
+// persists it and returns the restored resource.
+type RestoreFunc func(req *http.Request, revision *Revision, author *user.User) (restored interface{}, err error)
+
+// ErrForbidden is returned by a RestoreFunc when the author may not change
+// the resource in its current state.
+var ErrForbidden = errors.New("You do not have permission to restore this revision")
+
+// Guard wraps a handler with an access check.
+type Guard func(next http.HandlerFunc) http.HandlerFunc
+
+type restoreCommand struct {
+	UpdatedBy *user.User `json:"updated_by"`
+}
+
+// InitRoutes registers the revision endpoints for the resource found at path,
+// e.g. path "/site/{id}" registers "/site/{id}/revisions" and friends. Reads
+// are wrapped with canView and restores with canRestore.
+func InitRoutes(router *mux.Router, formatter *render.Render, revisionRepository RevisionRepository, path string, resourceType string, lookup LookupFunc, restore RestoreFunc, canView Guard, canRestore Guard) {
+	router.HandleFunc(path+"/revisions", canView(getRevisionListHandler(formatter, revisionRepository, resourceType, lookup))).Methods("GET")
+	router.HandleFunc(path+"/revisions/{revision}", canView(getRevisionHandler(formatter, revisionRepository, resourceType, lookup))).Methods("GET")
+	router.HandleFunc(path+"/revisions/{revision}/diff/{other}", canView(diffRevisionsHandler(formatter, revisionRepository, resourceType, lookup))).Methods("GET")
+	router.HandleFunc(path+"/revisions/{revision}/restore", canRestore(restoreRevisionHandler(formatter, revisionRepository, resourceType, lookup, restore))).Methods("POST")
+}
+
+func getRevisionListHandler(formatter *render.Render, revisionRepository RevisionRepository, resourceType string, lookup LookupFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		resourceID, err := lookup(req)
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		revisions := revisionRepository.List(resourceType, resourceID)
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"revisions": revisions,
+			"total":     len(revisions),
+		})
+	}
+}
+
+func getRevisionHandler(formatter *render.Render, revisionRepository RevisionRepository, resourceType string, lookup LookupFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		revision, err := findRevision(req, revisionRepository, resourceType, lookup, "revision")
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, revision)
+	}
+}
+
+func diffRevisionsHandler(formatter *render.Render, revisionRepository RevisionRepository, resourceType string, lookup LookupFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		from, err := findRevision(req, revisionRepository, resourceType, lookup, "revision")
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		to, err := findRevision(req, revisionRepository, resourceType, lookup, "other")
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		changes, err := Diff(from, to)
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"from":    from.Number,
+			"to":      to.Number,
+			"changes": changes,
+		})
+	}
+}
+
+func restoreRevisionHandler(formatter *render.Render, revisionRepository RevisionRepository, resourceType string, lookup LookupFunc, restore RestoreFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		revision, err := findRevision(req, revisionRepository, resourceType, lookup, "revision")
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var cmd restoreCommand
+
+		if err := json.Unmarshal(payload, &cmd); err != nil && len(payload) != 0 {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse restore revision request")
+			return
+		}
+
+		if caller := user.FromContext(req.Context()); caller != nil {
+			cmd.UpdatedBy = caller
+		}
+
+		if cmd.UpdatedBy == nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": "Updated by is required",
+			})
+			return
+		}
+
+		restored, err := restore(req, revision, cmd.UpdatedBy)
+		if err == ErrForbidden {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		} else if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		head, err := NewRevision(resourceType, revision.ResourceID, cmd.UpdatedBy, restored)
+		if err == nil {
+			head.RestoredFrom = revision.Number
+			err = revisionRepository.Add(head)
+		}
+
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, head)
+	}
+}
+
+func findRevision(req *http.Request, revisionRepository RevisionRepository, resourceType string, lookup LookupFunc, numberVar string) (*Revision, error) {
+	resourceID, err := lookup(req)
+	if err != nil {
+		return nil, err
+	}
+
+	number, err := strconv.Atoi(mux.Vars(req)[numberVar])
+	if err != nil {
+		return nil, errInvalidRevisionNumber
+	}
+
+	return revisionRepository.Get(resourceType, resourceID, number)
+}
@@ -0,0 +1,61 @@
+package revision
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type inMemoryRepository struct {
+	mutex     sync.Mutex
+	revisions map[string][]Revision
+	nextID    int
+}
+
+func NewInMemoryRepository() *inMemoryRepository {
+	repo := &inMemoryRepository{}
+	repo.revisions = make(map[string][]Revision)
+	return repo
+}
+
+func (repo *inMemoryRepository) Add(revision *Revision) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	key := resourceKey(revision.ResourceType, revision.ResourceID)
+
+	repo.nextID++
+	revision.ID = fmt.Sprintf("%d", repo.nextID)
+	revision.Number = len(repo.revisions[key]) + 1
+	repo.revisions[key] = append(repo.revisions[key], *revision)
+	return err
+}
+
+func (repo *inMemoryRepository) List(resourceType string, resourceID string) (revisions []*Revision) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, stored := range repo.revisions[resourceKey(resourceType, resourceID)] {
+		revision := stored
+		revisions = append(revisions, &revision)
+	}
+
+	return revisions
+}
+
+func (repo *inMemoryRepository) Get(resourceType string, resourceID string, number int) (revision *Revision, err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	stored := repo.revisions[resourceKey(resourceType, resourceID)]
+	if number < 1 || number > len(stored) {
+		return nil, errors.New("Could not find revision in repository")
+	}
+
+	found := stored[number-1]
+	return &found, nil
+}
+
+func resourceKey(resourceType string, resourceID string) string {
+	return resourceType + "/" + resourceID
+}
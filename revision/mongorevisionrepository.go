@@ -0,0 +1,177 @@
+package revision
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"github.com/spear-wind/cms/user"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// mongoRevisionRepository keeps revisions in Collection and numbers them
+// with a counter per resource in Counters. Counters is used directly, rather
+// than through cfmgo, for its find-and-modify upsert.
+type mongoRevisionRepository struct {
+	Collection cfmgo.Collection
+	Counters   *mgo.Collection
+}
+
+type revisionRecord struct {
+	RecordID        bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	ResourceType    string        `bson:"resource_type" json:"resource_type"`
+	ResourceID      string        `bson:"resource_id" json:"resource_id"`
+	Number          int           `bson:"number" json:"number"`
+	AuthorID        int64         `bson:"author_id" json:"author_id"`
+	AuthorEmail     string        `bson:"author_email" json:"author_email"`
+	AuthorFirstName string        `bson:"author_first_name" json:"author_first_name"`
+	AuthorLastName  string        `bson:"author_last_name" json:"author_last_name"`
+	Created         time.Time     `bson:"date_created" json:"date_created"`
+	RestoredFrom    int           `bson:"restored_from" json:"restored_from"`
+	Snapshot        string        `bson:"snapshot" json:"snapshot"`
+}
+
+// counterRecord numbers the revisions of one resource, which it is keyed by.
+type counterRecord struct {
+	ID       string `bson:"_id"`
+	Sequence int    `bson:"seq"`
+}
+
+func NewMongoRevisionRepository(col cfmgo.Collection, counters *mgo.Collection) *mongoRevisionRepository {
+	return &mongoRevisionRepository{
+		Collection: col,
+		Counters:   counters,
+	}
+}
+
+func (repo *mongoRevisionRepository) Add(revision *Revision) (err error) {
+	repo.Collection.Wake()
+	if revision.Number, err = repo.nextNumber(revision.ResourceType, revision.ResourceID); err != nil {
+		return err
+	}
+
+	rr := toRevisionRecord(revision)
+	if _, err = repo.Collection.UpsertID(rr.RecordID, rr); err == nil {
+		revision.ID = rr.RecordID.Hex()
+	}
+
+	return
+}
+
+func (repo *mongoRevisionRepository) List(resourceType string, resourceID string) (revisions []*Revision) {
+	repo.Collection.Wake()
+	records := repo.find(bson.M{
+		"resource_type": resourceType,
+		"resource_id":   resourceID,
+	})
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Number < records[j].Number
+	})
+
+	revisions = make([]*Revision, len(records))
+	for k, v := range records {
+		revisions[k] = toRevision(&v)
+	}
+
+	return
+}
+
+func (repo *mongoRevisionRepository) Get(resourceType string, resourceID string, number int) (revision *Revision, err error) {
+	repo.Collection.Wake()
+	records := repo.find(bson.M{
+		"resource_type": resourceType,
+		"resource_id":   resourceID,
+		"number":        number,
+	})
+
+	if len(records) == 0 {
+		return nil, errors.New("Revision not found")
+	}
+
+	return toRevision(&records[0]), nil
+}
+
+// nextNumber increments the resource's counter in one atomic update, so
+// concurrent saves never get the same number.
+func (repo *mongoRevisionRepository) nextNumber(resourceType string, resourceID string) (int, error) {
+	session := repo.Counters.Database.Session.Copy()
+	defer session.Close()
+
+	counters := repo.Counters.With(session)
+	id := resourceKey(resourceType, resourceID)
+
+	var counter counterRecord
+	err := counters.FindId(id).One(&counter)
+	if err == mgo.ErrNotFound {
+		// Resources whose revisions predate the counters start from the
+		// number already stored. $setOnInsert leaves alone a counter that
+		// another save created in the meantime.
+		existing := len(repo.find(bson.M{
+			"resource_type": resourceType,
+			"resource_id":   resourceID,
+		}))
+
+		if _, err := counters.UpsertId(id, bson.M{"$setOnInsert": bson.M{"seq": existing}}); err != nil && !mgo.IsDup(err) {
+			return 0, err
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	change := mgo.Change{
+		Update:    bson.M{"$inc": bson.M{"seq": 1}},
+		Upsert:    true,
+		ReturnNew: true,
+	}
+
+	_, err = counters.FindId(id).Apply(change, &counter)
+	return counter.Sequence, err
+}
+
+func (repo *mongoRevisionRepository) find(query bson.M) (records []revisionRecord) {
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	repo.Collection.Find(params, &records)
+	return
+}
+
+func toRevisionRecord(r *Revision) (rr *revisionRecord) {
+	rr = &revisionRecord{
+		RecordID:     bson.NewObjectId(),
+		ResourceType: r.ResourceType,
+		ResourceID:   r.ResourceID,
+		Number:       r.Number,
+		Created:      r.Created,
+		RestoredFrom: r.RestoredFrom,
+		Snapshot:     string(r.Snapshot),
+	}
+
+	if r.Author != nil {
+		rr.AuthorID = r.Author.ID
+		rr.AuthorEmail = r.Author.Email
+		rr.AuthorFirstName = r.Author.FirstName
+		rr.AuthorLastName = r.Author.LastName
+	}
+
+	return
+}
+
+func toRevision(rr *revisionRecord) (r *Revision) {
+	r = &Revision{
+		ID:           rr.RecordID.Hex(),
+		ResourceType: rr.ResourceType,
+		ResourceID:   rr.ResourceID,
+		Number:       rr.Number,
+		Author:       user.NewUser(rr.AuthorID, rr.AuthorFirstName, rr.AuthorLastName, rr.AuthorEmail),
+		Created:      rr.Created,
+		RestoredFrom: rr.RestoredFrom,
+		Snapshot:     []byte(rr.Snapshot),
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package revision
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/spear-wind/cms/user"
+)
+
+var errInvalidRevisionNumber = errors.New("Invalid revision number")
+
+// RevisionRepository stores immutable revisions. There is intentionally no
+// way to update or delete a revision once it has been added.
+type RevisionRepository interface {
+	Add(revision *Revision) (err error)
+	List(resourceType string, resourceID string) (revisions []*Revision)
+	Get(resourceType string, resourceID string, number int) (revision *Revision, err error)
+}
+
+type Revision struct {
+	ID           string          `json:"id"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Number       int             `json:"number"`
+	Author       *user.User      `json:"author"`
+	Created      time.Time       `json:"date_created"`
+	RestoredFrom int             `json:"restored_from,omitempty"`
+	Snapshot     json.RawMessage `json:"snapshot"`
+}
+
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+func NewRevision(resourceType string, resourceID string, author *user.User, snapshot interface{}) (*Revision, error) {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Revision{
+		ResourceType: resourceType,
+		ResourceID:   resourceID,
+		Author:       author,
+		Created:      time.Now(),
+		Snapshot:     b,
+	}, nil
+}
+
+// Record snapshots resource and adds it to the repository as the new head
+// revision.
+func Record(revisionRepository RevisionRepository, resourceType string, resourceID string, author *user.User, resource interface{}) error {
+	revision, err := NewRevision(resourceType, resourceID, author, resource)
+	if err != nil {
+		return err
+	}
+
+	return revisionRepository.Add(revision)
+}
+
+// Restore unmarshals the revision snapshot into target.
+func (r *Revision) Restore(target interface{}) error {
+	return json.Unmarshal(r.Snapshot, target)
+}
+
+// Diff compares the top-level fields of two revision snapshots and returns
+// the fields that differ, sorted by field name.
+func Diff(from *Revision, to *Revision) ([]FieldChange, error) {
+	var a, b map[string]interface{}
+
+	if err := json.Unmarshal(from.Snapshot, &a); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(to.Snapshot, &b); err != nil {
+		return nil, err
+	}
+
+	changes := []FieldChange{}
+
+	for field, value := range a {
+		if other, ok := b[field]; !ok || !reflect.DeepEqual(value, other) {
+			changes = append(changes, FieldChange{Field: field, From: value, To: b[field]})
+		}
+	}
+
+	for field, value := range b {
+		if _, ok := a[field]; !ok {
+			changes = append(changes, FieldChange{Field: field, To: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes, nil
+}
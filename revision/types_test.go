@@ -0,0 +1,107 @@
+package revision
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/spear-wind/cms/user"
+)
+
+type snapshot struct {
+	Name       string `json:"name"`
+	DomainName string `json:"domain_name"`
+}
+
+var author = user.NewUser(1, "Spearwind", "Author", "author@spearwind.io")
+
+func TestRepositoryNumbersRevisionsPerResource(t *testing.T) {
+	repo := NewInMemoryRepository()
+
+	Record(repo, "site", "1", author, snapshot{Name: "One"})
+	Record(repo, "site", "1", author, snapshot{Name: "Two"})
+	Record(repo, "site", "2", author, snapshot{Name: "Other"})
+
+	revisions := repo.List("site", "1")
+	if len(revisions) != 2 {
+		t.Fatalf("Expected two revisions for site 1, got %d", len(revisions))
+	}
+
+	if revisions[0].Number != 1 || revisions[1].Number != 2 {
+		t.Errorf("Expected revisions to be numbered 1 and 2, got %d and %d", revisions[0].Number, revisions[1].Number)
+	}
+
+	if other := repo.List("site", "2"); len(other) != 1 || other[0].Number != 1 {
+		t.Errorf("Expected site 2 to have its own numbering, got %v", other)
+	}
+}
+
+func TestConcurrentSavesGetDistinctNumbers(t *testing.T) {
+	repo := NewInMemoryRepository()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Record(repo, "site", "1", author, snapshot{Name: "Concurrent"})
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int]bool)
+	for _, revision := range repo.List("site", "1") {
+		if seen[revision.Number] {
+			t.Errorf("Expected each revision to get its own number, but %d was used twice", revision.Number)
+		}
+		seen[revision.Number] = true
+	}
+
+	if len(seen) != 20 {
+		t.Errorf("Expected 20 revisions, got %d", len(seen))
+	}
+}
+
+func TestStoredRevisionsAreImmutable(t *testing.T) {
+	repo := NewInMemoryRepository()
+	Record(repo, "site", "1", author, snapshot{Name: "One"})
+
+	revision, _ := repo.Get("site", "1", 1)
+	revision.Snapshot = []byte(`{"name":"Tampered"}`)
+
+	again, _ := repo.Get("site", "1", 1)
+
+	var restored snapshot
+	if err := again.Restore(&restored); err != nil {
+		t.Fatalf("Unexpected error restoring revision: %v", err)
+	}
+
+	if restored.Name != "One" {
+		t.Errorf("Modifying a returned revision should not change the stored revision, got %q", restored.Name)
+	}
+}
+
+func TestGetUnknownRevisionReturnsError(t *testing.T) {
+	repo := NewInMemoryRepository()
+
+	if _, err := repo.Get("site", "1", 1); err == nil {
+		t.Error("Expected an error getting a revision that does not exist")
+	}
+}
+
+func TestDiffReportsChangedFields(t *testing.T) {
+	from, _ := NewRevision("site", "1", author, snapshot{Name: "One", DomainName: "spearwind.io"})
+	to, _ := NewRevision("site", "1", author, snapshot{Name: "Two", DomainName: "spearwind.io"})
+
+	changes, err := Diff(from, to)
+	if err != nil {
+		t.Fatalf("Unexpected error diffing revisions: %v", err)
+	}
+
+	if len(changes) != 1 {
+		t.Fatalf("Expected exactly one change, got %v", changes)
+	}
+
+	if changes[0].Field != "name" || changes[0].From != "One" || changes[0].To != "Two" {
+		t.Errorf("Unexpected change: %+v", changes[0])
+	}
+}
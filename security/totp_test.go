@@ -0,0 +1,73 @@
+package security
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238, "12345678901234567890",
+// base32 encoded.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	// The RFC lists 8 digit codes; these are their last 6 digits.
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, test := range tests {
+		code, err := TOTPCode(rfc6238Secret, time.Unix(test.unix, 0))
+		if err != nil || code != test.code {
+			t.Errorf("At %d: expected %s, got %s (%v)", test.unix, test.code, code, err)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsOnePeriodOfDrift(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	code, _ := TOTPCode(rfc6238Secret, at)
+
+	tests := []struct {
+		at time.Time
+		ok bool
+	}{
+		{at, true},
+		{at.Add(-TOTPPeriod), true},
+		{at.Add(TOTPPeriod), true},
+		{at.Add(3 * TOTPPeriod), false},
+	}
+
+	for _, test := range tests {
+		if _, ok := ValidateTOTP(rfc6238Secret, code, test.at); ok != test.ok {
+			t.Errorf("At %s: expected ok to be %v", test.at.Sub(at), test.ok)
+		}
+	}
+
+	if step, _ := ValidateTOTP(rfc6238Secret, code, at.Add(TOTPPeriod)); step != totpStep(at) {
+		t.Errorf("Expected the step the code was made for, got %d", step)
+	}
+
+	if _, ok := ValidateTOTP(rfc6238Secret, "12345", at); ok {
+		t.Errorf("Expected a short code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil || len(secret) != 32 {
+		t.Fatalf("Expected a 32 character secret, got %q (%v)", secret, err)
+	}
+
+	uri := TOTPURI("Spearwind", "john@tld.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Spearwind:john@tld.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Unexpected URI %s", uri)
+	}
+}
@@ -1,54 +1,147 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cloudnativego/cfmgo"
 	"github.com/codegangsta/negroni"
 	"github.com/dave-malone/email"
 	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/activity"
 	"github.com/spear-wind/cms/auth"
+	"github.com/spear-wind/cms/delivery"
+	"github.com/spear-wind/cms/eventlog"
 	"github.com/spear-wind/cms/events"
 	"github.com/spear-wind/cms/facebook"
+	"github.com/spear-wind/cms/identity"
+	"github.com/spear-wind/cms/mail"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/page"
 	"github.com/spear-wind/cms/registration"
+	"github.com/spear-wind/cms/revision"
 	"github.com/spear-wind/cms/site"
 	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/webhook"
 	"github.com/unrolled/render"
+	mgo "gopkg.in/mgo.v2"
 )
 
-// NewServer configures and returns a Server.
-func NewServer() *negroni.Negroni {
+// NewServer configures and returns a Server, along with a function that ends
+// open event streams, which should run when the server starts shutting down,
+// and a function that drains background work once the server has stopped
+// accepting requests.
+func NewServer() (*negroni.Negroni, func(), func(context.Context) error) {
 	formatter := newFormatter()
-	emailSender := newEmailSender()
-	eventPublisher := newEventPublisher(emailSender)
+	auth.UseKeySet(newKeySet())
+	user.UseCredentialPolicy(newCredentialPolicy())
+
+	if ttl, err := time.ParseDuration(os.Getenv("VERIFICATION_CODE_TTL")); err == nil && ttl > 0 {
+		user.VerificationCodeTTL = ttl
+	}
+
+	emailSender, mailbox := newEmailSender()
+	emailQueueRepository := newEmailQueueRepository()
+	suppressionRepository := newSuppressionRepository()
+	emailQueue := mail.NewQueue(emailSender, emailQueueRepository, suppressionRepository, newEmailQueueOptions())
+	emailQueue.Start()
+	eventStore := newEventStore()
+	eventPublisher := newEventPublisher(emailQueue, eventStore)
 	userRepository := newUserRepository()
 	facebookClient := newFacebookClient()
 	siteRepository := newSiteRepository()
+	pageRepository := newPageRepository()
+	revisionRepository := newRevisionRepository()
+	membershipRepository := newMembershipRepository()
+	refreshTokenRepository := newRefreshTokenRepository()
+	tokenDenylist := newTokenDenylist()
+	loginThrottle := newLoginThrottle()
+	webhookRepository := newWebhookRepository()
+	deliveryRepository := newDeliveryRepository()
+	authorizer := membership.NewAuthorizer(formatter, membershipRepository, site.NewMFAPolicy(siteRepository))
+	authorizer.SetAdmins(strings.Split(os.Getenv("ADMIN_EMAILS"), ",")...)
+
+	webhookSubscriber := webhook.NewSubscriber(webhookRepository, deliveryRepository, membershipRepository, newWebhookOptions())
+	webhookSubscriber.Start()
+	eventPublisher.AddNamed("webhooks", webhookSubscriber)
+
+	verificationSweeper := registration.NewVerificationSweeper(userRepository, eventPublisher)
+	verificationSweeper.Start()
+
+	activityFeed := activity.NewFeed(envInt("ACTIVITY_BUFFER_SIZE"))
+	eventPublisher.AddNamed("activity", activityFeed)
 
 	n := negroni.Classic()
 	router := mux.NewRouter()
 
-	auth.InitRoutes(router, formatter, userRepository)
-	registration.InitRoutes(router, formatter, userRepository, eventPublisher)
-	facebook.InitRoutes(router, formatter, userRepository, facebookClient)
+	auth.InitRoutes(router, formatter, userRepository, refreshTokenRepository, tokenDenylist, loginThrottle, eventPublisher)
+	registration.InitRoutes(router, formatter, userRepository, newPasswordResetThrottle(), eventPublisher)
+	facebook.InitRoutes(router, formatter, userRepository, refreshTokenRepository, facebookClient, eventPublisher)
+	identity.InitRoutes(router, formatter, userRepository, refreshTokenRepository, newIdentityProviders(facebookClient), newStateStore(), eventPublisher)
+	delivery.InitRoutes(router, formatter, siteRepository, pageRepository, newContentCacheTTL())
+
+	if topicARNs := os.Getenv("SES_NOTIFICATION_TOPIC_ARNS"); len(topicARNs) != 0 {
+		mail.InitNotificationRoutes(router, formatter, emailQueueRepository, suppressionRepository, mail.NotificationOptions{
+			TopicARNs: strings.Split(topicARNs, ","),
+		})
+	}
+
+	if mailbox != nil && devMode() {
+		fmt.Println("Serving captured emails at /dev/mailbox")
+		mail.InitMailboxRoutes(router, formatter, mailbox)
+	}
 
 	userRouter := mux.NewRouter()
-	user.InitRoutes(userRouter, formatter, userRepository, eventPublisher)
+	user.InitRoutes(userRouter, formatter, userRepository, authorizer, loginThrottle, eventPublisher)
 	router.PathPrefix("/user").Handler(negroni.New(
-		negroni.HandlerFunc(auth.IsAuthorized(formatter)),
+		negroni.HandlerFunc(auth.IsAuthorized(formatter, tokenDenylist)),
+		negroni.HandlerFunc(auth.ResolveCaller(formatter, userRepository)),
 		negroni.Wrap(userRouter),
 	))
 
 	siteRouter := mux.NewRouter()
-	site.InitRoutes(siteRouter, formatter, siteRepository, eventPublisher)
+	site.InitRoutes(siteRouter, formatter, siteRepository, membershipRepository, authorizer, revisionRepository, site.NewDNSResolver(), eventPublisher)
+	page.InitRoutes(siteRouter, formatter, pageRepository, siteRepository, authorizer, revisionRepository, eventPublisher)
+	membership.InitRoutes(siteRouter, formatter, membershipRepository, authorizer, userRepository)
+	webhook.InitRoutes(siteRouter, formatter, webhookRepository, deliveryRepository, authorizer)
 	router.PathPrefix("/site").Handler(negroni.New(
-		negroni.HandlerFunc(auth.IsAuthorized(formatter)),
+		negroni.HandlerFunc(auth.IsAuthorized(formatter, tokenDenylist)),
+		negroni.HandlerFunc(auth.ResolveCaller(formatter, userRepository)),
 		negroni.Wrap(siteRouter),
 	))
 
+	eventsRouter := mux.NewRouter()
+	activity.InitRoutes(eventsRouter, formatter, activityFeed, authorizer, activity.DefaultHeartbeat)
+	eventlog.InitRoutes(eventsRouter, formatter, eventStore, eventPublisher, authorizer)
+	router.PathPrefix("/events").Handler(negroni.New(
+		negroni.HandlerFunc(auth.IsAuthorized(formatter, tokenDenylist)),
+		negroni.HandlerFunc(auth.ResolveCaller(formatter, userRepository)),
+		negroni.Wrap(eventsRouter),
+	))
+
 	n.UseHandler(router)
-	return n
+	drain := func(ctx context.Context) error {
+		if err := verificationSweeper.Shutdown(ctx); err != nil {
+			return err
+		}
+
+		if err := eventPublisher.Shutdown(ctx); err != nil {
+			return err
+		}
+
+		if err := webhookSubscriber.Shutdown(ctx); err != nil {
+			return err
+		}
+
+		return emailQueue.Shutdown(ctx)
+	}
+
+	return n, activityFeed.Close, drain
 }
 
 func newFormatter() *render.Render {
@@ -59,27 +152,237 @@ func newFormatter() *render.Render {
 	return formatter
 }
 
-func newEmailSender() email.Sender {
+func newKeySet() *auth.KeySet {
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+
+	if len(keysDir) == 0 {
+		fmt.Println("Using an ephemeral JWT signing key; set JWT_KEYS_DIR and JWT_SIGNING_KEY_ID to keep tokens valid across restarts")
+		keySet, err := auth.NewEphemeralKeySet()
+		if err != nil {
+			log.Fatalf("Failed to generate JWT signing key: %v", err)
+		}
+
+		return keySet
+	}
+
+	keySet, err := auth.LoadKeySet(keysDir, os.Getenv("JWT_SIGNING_KEY_ID"))
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys from %s: %v", keysDir, err)
+	}
+
+	fmt.Printf("Using JWT signing keys from %s\n", keysDir)
+	return keySet
+}
+
+func newContentCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("CONTENT_CACHE_TTL"))
+	if err != nil {
+		ttl = time.Minute
+	}
+
+	return ttl
+}
+
+// newCredentialPolicy returns the policy that new password hashes are made
+// with, from PASSWORD_HASH_DEFAULTS.
+func newCredentialPolicy() *user.CredentialPolicy {
+	defaults := os.Getenv("PASSWORD_HASH_DEFAULTS")
+	if len(defaults) == 0 {
+		defaults = "20160922"
+	}
+
+	policy, err := user.NewCredentialPolicy(defaults)
+	if err != nil {
+		log.Fatalf("Failed to configure password hashing: %v", err)
+	}
+
+	fmt.Printf("Hashing passwords with %s (passlib defaults %s)\n", policy.Scheme(), defaults)
+	return policy
+}
+
+// newEmailSender returns the configured email sender. When it captures
+// emails to a directory, that sender is also returned so that the captured
+// emails can be served in dev mode.
+func newEmailSender() (email.Sender, *mail.CaptureSender) {
 	awsEndpoint := os.Getenv("AWS_ENDPOINT")
 	awsAccessKeyID := os.Getenv("AWS_ACCESS_KEY_ID")
 	awsSecretAccessKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	smtpHost := os.Getenv("SMTP_HOST")
+	captureDir := os.Getenv("EMAIL_CAPTURE_DIR")
 
 	if awsEndpoint != "" && awsAccessKeyID != "" && awsSecretAccessKey != "" {
 		fmt.Println("Using Amazon SES Email Sender")
 		email.NewSender = email.NewAmazonSESSender(awsEndpoint, awsAccessKeyID, awsSecretAccessKey)
+		return email.NewSender(), nil
+	}
+
+	if smtpHost != "" {
+		fmt.Printf("Using SMTP Email Sender with %s\n", smtpHost)
+		return mail.NewSMTPSender(mail.SMTPOptions{
+			Host:     smtpHost,
+			Port:     envInt("SMTP_PORT"),
+			StartTLS: os.Getenv("SMTP_STARTTLS") != "false",
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}), nil
+	}
+
+	if captureDir == "" && devMode() {
+		captureDir = "mailbox"
+	}
+
+	if captureDir != "" {
+		mailbox, err := mail.NewCaptureSender(captureDir)
+		if err != nil {
+			log.Fatalf("Failed to create the email capture directory: %v", err)
+		}
+
+		fmt.Printf("Capturing emails in %s\n", captureDir)
+		return mailbox, mailbox
+	}
+
+	email.NewSender = email.NewNoopSender
+	return email.NewSender(), nil
+}
+
+// devMode reports whether DEV_MODE is set to true, which enables routes that
+// are only safe to use locally.
+func devMode() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+	return enabled
+}
+
+func newEmailQueueRepository() mail.QueueRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo mail.QueueRepository
+
+	if len(mongoDBURL) != 0 {
+		queueCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "email_queue")
+		fmt.Println("Using MongoDB email queue")
+		repo = mail.NewMongoQueueRepository(queueCollection)
 	} else {
-		email.NewSender = email.NewNoopSender
+		fmt.Println("Using in-memory email queue")
+		repo = mail.NewInMemoryQueueRepository()
 	}
 
-	return email.NewSender()
+	return repo
+}
+
+func newSuppressionRepository() mail.SuppressionRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo mail.SuppressionRepository
+
+	if len(mongoDBURL) != 0 {
+		suppressionCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "email_suppressions")
+		fmt.Println("Using MongoDB email suppression list")
+		repo = mail.NewMongoSuppressionRepository(suppressionCollection)
+	} else {
+		fmt.Println("Using in-memory email suppression list")
+		repo = mail.NewInMemorySuppressionRepository()
+	}
+
+	return repo
+}
+
+func newEmailQueueOptions() mail.QueueOptions {
+	recipientWindow, _ := time.ParseDuration(os.Getenv("EMAIL_RECIPIENT_WINDOW"))
+
+	return mail.QueueOptions{
+		MaxAttempts:     envInt("EMAIL_MAX_ATTEMPTS"),
+		RatePerSecond:   envInt("EMAIL_RATE_PER_SECOND"),
+		RecipientLimit:  envInt("EMAIL_RECIPIENT_LIMIT"),
+		RecipientWindow: recipientWindow,
+	}
 }
 
-func newEventPublisher(emailSender email.Sender) events.EventPublisher {
-	eventPublisher := events.NewSynchEventPublisher()
-	eventPublisher.Add(events.NewEmailEventSubscriber(emailSender))
+func newEmailTemplates() *mail.Registry {
+	dir := os.Getenv("EMAIL_TEMPLATE_DIR")
+	if len(dir) == 0 {
+		dir = "email-templates"
+		fmt.Printf("EMAIL_TEMPLATE_DIR not set; using %s\n", dir)
+	}
+
+	templates, err := mail.LoadRegistry(dir)
+	if err != nil {
+		log.Fatalf("Failed to load email templates: %v", err)
+	}
+
+	return templates
+}
+
+func newEventPublisher(emailSender email.Sender, eventStore events.EventStore) *events.OutboxPublisher {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo events.OutboxRepository
+
+	if len(mongoDBURL) != 0 {
+		outboxCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "outbox")
+		fmt.Println("Using MongoDB event outbox")
+		repo = events.NewMongoOutboxRepository(outboxCollection)
+	} else {
+		fmt.Println("Using in-memory event outbox")
+		repo = events.NewInMemoryOutboxRepository()
+	}
+
+	pollInterval, _ := time.ParseDuration(os.Getenv("OUTBOX_POLL_INTERVAL"))
+	backoff, _ := time.ParseDuration(os.Getenv("OUTBOX_BACKOFF"))
+	options := events.OutboxOptions{
+		PollInterval: pollInterval,
+		BatchSize:    envInt("OUTBOX_BATCH_SIZE"),
+		Workers:      envInt("EVENT_WORKERS"),
+		MaxAttempts:  envInt("OUTBOX_MAX_ATTEMPTS"),
+		Backoff:      backoff,
+	}
+
+	eventPublisher := events.NewOutboxPublisher(repo, options)
+	eventPublisher.AddNamed("event-store", events.NewEventStoreSubscriber(eventStore))
+	emailFrom := os.Getenv("EMAIL_FROM")
+	if len(emailFrom) == 0 {
+		emailFrom = "no-reply@spearwind.io"
+	}
+
+	eventPublisher.AddNamed("email", events.NewEmailEventSubscriber(emailSender, newEmailTemplates(), emailFrom))
+	eventPublisher.Start()
 	return eventPublisher
 }
 
+func newEventStore() events.EventStore {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var store events.EventStore
+
+	if len(mongoDBURL) != 0 {
+		eventCollection := dialCollection(mongoDBURL, "events")
+		fmt.Println("Using MongoDB event store")
+		store = events.NewMongoEventStore(eventCollection)
+	} else {
+		fmt.Println("Using in-memory event store")
+		store = events.NewInMemoryEventStore()
+	}
+
+	return store
+}
+
+// dialCollection connects to the named collection without cfmgo, for
+// repositories that need queries cfmgo can't express.
+func dialCollection(mongoDBURL string, name string) *mgo.Collection {
+	session, err := mgo.Dial(mongoDBURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB for the %s collection: %v", name, err)
+	}
+
+	return session.DB("").C(name)
+}
+
+// envInt returns the integer value of the named environment variable, or 0
+// if it is unset or not a number.
+func envInt(name string) int {
+	value, _ := strconv.Atoi(os.Getenv(name))
+	return value
+}
+
 func newUserRepository() user.UserRepository {
 	mongoDBURL := os.Getenv("MONGO_URL")
 
@@ -104,22 +407,223 @@ func newFacebookClient() facebook.Client {
 	return facebook.NewClient(appID, appSecret)
 }
 
+// newIdentityProviders returns Facebook, plus whichever of Google, GitHub and
+// a generic OIDC issuer have client credentials set. They all send users back
+// to IDENTITY_REDIRECT_URL.
+func newIdentityProviders(facebookClient facebook.Client) []identity.Provider {
+	providers := []identity.Provider{facebook.NewProvider(facebookClient)}
+	redirectURL := os.Getenv("IDENTITY_REDIRECT_URL")
+
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); len(clientID) != 0 {
+		fmt.Println("Enabling Google login")
+		providers = append(providers, identity.NewOIDCProvider(identity.Google(clientID, os.Getenv("GOOGLE_CLIENT_SECRET"), redirectURL)))
+	}
+
+	if clientID := os.Getenv("GITHUB_CLIENT_ID"); len(clientID) != 0 {
+		fmt.Println("Enabling GitHub login")
+		providers = append(providers, identity.NewOIDCProvider(identity.GitHub(clientID, os.Getenv("GITHUB_CLIENT_SECRET"), redirectURL)))
+	}
+
+	if issuer := os.Getenv("OIDC_ISSUER"); len(issuer) != 0 {
+		name := os.Getenv("OIDC_PROVIDER_NAME")
+		if len(name) == 0 {
+			name = "oidc"
+		}
+
+		fmt.Printf("Enabling %s login with %s\n", name, issuer)
+		providers = append(providers, identity.NewOIDCProvider(identity.OIDCOptions{
+			Name:         name,
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		}))
+	}
+
+	return providers
+}
+
+func newStateStore() identity.StateStore {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var store identity.StateStore
+
+	if len(mongoDBURL) != 0 {
+		stateCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "identity_states")
+		fmt.Println("Using MongoDB identity state store")
+		store = identity.NewMongoStateStore(stateCollection)
+	} else {
+		fmt.Println("Using in-memory identity state store")
+		store = identity.NewInMemoryStateStore()
+	}
+
+	return store
+}
+
 func newSiteRepository() site.SiteRepository {
-	fmt.Println("Using in-memory site repository")
-	return site.NewInMemoryRepository()
-
-	// mongoDBURL := os.Getenv("MONGO_URL")
-	//
-	// var repo site.SiteRepository
-	//
-	// if len(mongoDBURL) != 0 {
-	// 	siteCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "sites")
-	// 	fmt.Println("Using to MongoDB site repository")
-	// 	repo = site.NewMongoSiteRepository(siteCollection)
-	// } else {
-	// 	fmt.Println("Using in-memory site repository")
-	// 	repo = site.NewInMemoryRepository()
-	// }
-	//
-	// return repo
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo site.SiteRepository
+
+	if len(mongoDBURL) != 0 {
+		siteCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "sites")
+		fmt.Println("Using MongoDB site repository")
+		repo = site.NewMongoSiteRepository(siteCollection)
+	} else {
+		fmt.Println("Using in-memory site repository")
+		repo = site.NewInMemoryRepository()
+	}
+
+	return repo
+}
+
+func newPageRepository() page.PageRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo page.PageRepository
+
+	if len(mongoDBURL) != 0 {
+		pageCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "pages")
+		fmt.Println("Using MongoDB page repository")
+		repo = page.NewMongoPageRepository(pageCollection)
+	} else {
+		fmt.Println("Using in-memory page repository")
+		repo = page.NewInMemoryRepository()
+	}
+
+	return repo
+}
+
+func newRevisionRepository() revision.RevisionRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo revision.RevisionRepository
+
+	if len(mongoDBURL) != 0 {
+		revisionCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "revisions")
+		fmt.Println("Using MongoDB revision repository")
+		repo = revision.NewMongoRevisionRepository(revisionCollection, dialCollection(mongoDBURL, "revision_counters"))
+	} else {
+		fmt.Println("Using in-memory revision repository")
+		repo = revision.NewInMemoryRepository()
+	}
+
+	return repo
+}
+
+func newMembershipRepository() membership.MembershipRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo membership.MembershipRepository
+
+	if len(mongoDBURL) != 0 {
+		membershipCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "memberships")
+		fmt.Println("Using MongoDB membership repository")
+		repo = membership.NewMongoMembershipRepository(membershipCollection)
+	} else {
+		fmt.Println("Using in-memory membership repository")
+		repo = membership.NewInMemoryRepository()
+	}
+
+	return repo
+}
+
+func newRefreshTokenRepository() auth.RefreshTokenRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo auth.RefreshTokenRepository
+
+	if len(mongoDBURL) != 0 {
+		refreshTokenCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "refresh_tokens")
+		fmt.Println("Using MongoDB refresh token repository")
+		repo = auth.NewMongoRefreshTokenRepository(refreshTokenCollection)
+	} else {
+		fmt.Println("Using in-memory refresh token repository")
+		repo = auth.NewInMemoryRefreshTokenRepository()
+	}
+
+	return repo
+}
+
+func newTokenDenylist() auth.TokenDenylist {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var denylist auth.TokenDenylist
+
+	if len(mongoDBURL) != 0 {
+		deniedTokenCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "denied_tokens")
+		fmt.Println("Using MongoDB token denylist")
+		denylist = auth.NewMongoTokenDenylist(deniedTokenCollection)
+	} else {
+		fmt.Println("Using in-memory token denylist")
+		denylist = auth.NewInMemoryTokenDenylist()
+	}
+
+	return denylist
+}
+
+// newLoginThrottle counts failed logins in memory, so each server throttles
+// the attempts it handles on its own.
+func newLoginThrottle() *auth.LoginThrottle {
+	lockout, _ := time.ParseDuration(os.Getenv("LOGIN_LOCKOUT"))
+	trustForwardedFor, _ := strconv.ParseBool(os.Getenv("LOGIN_TRUST_FORWARDED_FOR"))
+
+	return auth.NewLoginThrottle(auth.NewInMemoryLoginAttemptStore(), auth.ThrottleOptions{
+		AccountLimit:      envInt("LOGIN_ACCOUNT_LIMIT"),
+		IPLimit:           envInt("LOGIN_IP_LIMIT"),
+		Lockout:           lockout,
+		TrustForwardedFor: trustForwardedFor,
+	})
+}
+
+// newPasswordResetThrottle counts password reset requests in memory, like
+// newLoginThrottle.
+func newPasswordResetThrottle() *auth.LoginThrottle {
+	options := registration.ResetThrottleOptions
+	options.TrustForwardedFor, _ = strconv.ParseBool(os.Getenv("LOGIN_TRUST_FORWARDED_FOR"))
+
+	return auth.NewLoginThrottle(auth.NewInMemoryLoginAttemptStore(), options)
+}
+
+func newWebhookRepository() webhook.WebhookRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo webhook.WebhookRepository
+
+	if len(mongoDBURL) != 0 {
+		webhookCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "webhooks")
+		fmt.Println("Using MongoDB webhook repository")
+		repo = webhook.NewMongoWebhookRepository(webhookCollection)
+	} else {
+		fmt.Println("Using in-memory webhook repository")
+		repo = webhook.NewInMemoryWebhookRepository()
+	}
+
+	return repo
+}
+
+func newDeliveryRepository() webhook.DeliveryRepository {
+	mongoDBURL := os.Getenv("MONGO_URL")
+
+	var repo webhook.DeliveryRepository
+
+	if len(mongoDBURL) != 0 {
+		deliveryCollection := cfmgo.Connect(cfmgo.NewCollectionDialer, mongoDBURL, "webhook_deliveries")
+		fmt.Println("Using MongoDB webhook delivery repository")
+		repo = webhook.NewMongoDeliveryRepository(deliveryCollection)
+	} else {
+		fmt.Println("Using in-memory webhook delivery repository")
+		repo = webhook.NewInMemoryDeliveryRepository()
+	}
+
+	return repo
+}
+
+func newWebhookOptions() webhook.Options {
+	allowPrivateNetworks, _ := strconv.ParseBool(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS"))
+
+	return webhook.Options{
+		MaxAttempts:          envInt("WEBHOOK_MAX_ATTEMPTS"),
+		AllowPrivateNetworks: allowPrivateNetworks,
+	}
 }
@@ -0,0 +1,14 @@
+package site
+
+import "net"
+
+type dnsResolver struct{}
+
+// NewDNSResolver returns a DomainResolver backed by the system resolver
+func NewDNSResolver() DomainResolver {
+	return dnsResolver{}
+}
+
+func (r dnsResolver) LookupTXT(name string) ([]string, error) {
+	return net.LookupTXT(name)
+}
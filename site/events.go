@@ -0,0 +1,55 @@
+package site
+
+import "github.com/spear-wind/cms/events"
+
+// CreatedEvent is published when a site is created.
+type CreatedEvent struct {
+	SiteID     string `json:"site_id"`
+	Name       string `json:"name"`
+	DomainName string `json:"domain_name"`
+}
+
+// UpdatedEvent is published when a site's settings are changed.
+type UpdatedEvent struct {
+	SiteID     string `json:"site_id"`
+	Name       string `json:"name"`
+	DomainName string `json:"domain_name"`
+	Status     string `json:"status"`
+}
+
+// DomainVerifiedEvent is published when a site's domain is verified and its
+// content starts being served.
+type DomainVerifiedEvent struct {
+	SiteID     string `json:"site_id"`
+	DomainName string `json:"domain_name"`
+}
+
+func init() {
+	events.RegisterDomainEvent(CreatedEvent{})
+	events.RegisterDomainEvent(UpdatedEvent{})
+	events.RegisterDomainEvent(DomainVerifiedEvent{})
+}
+
+func NewCreatedEvent(site *Site) CreatedEvent {
+	return CreatedEvent{SiteID: site.ID, Name: site.Name, DomainName: site.DomainName}
+}
+
+func NewUpdatedEvent(site *Site) UpdatedEvent {
+	return UpdatedEvent{SiteID: site.ID, Name: site.Name, DomainName: site.DomainName, Status: site.Status}
+}
+
+func NewDomainVerifiedEvent(site *Site) DomainVerifiedEvent {
+	return DomainVerifiedEvent{SiteID: site.ID, DomainName: site.DomainName}
+}
+
+func (e CreatedEvent) EventType() string   { return "site.created" }
+func (e CreatedEvent) EventVersion() int   { return 1 }
+func (e CreatedEvent) EventSiteID() string { return e.SiteID }
+
+func (e UpdatedEvent) EventType() string   { return "site.updated" }
+func (e UpdatedEvent) EventVersion() int   { return 1 }
+func (e UpdatedEvent) EventSiteID() string { return e.SiteID }
+
+func (e DomainVerifiedEvent) EventType() string   { return "site.domain_verified" }
+func (e DomainVerifiedEvent) EventVersion() int   { return 1 }
+func (e DomainVerifiedEvent) EventSiteID() string { return e.SiteID }
@@ -2,22 +2,38 @@ package site
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/revision"
+	"github.com/spear-wind/cms/user"
+	"github.com/spear-wind/cms/validator"
 	"github.com/unrolled/render"
 )
 
-func InitRoutes(router *mux.Router, formatter *render.Render, siteRepository SiteRepository, eventPublisher events.EventPublisher) {
-	router.HandleFunc("/site", createSiteHandler(formatter, siteRepository, eventPublisher)).Methods("POST")
-	router.HandleFunc("/site", getSiteListHandler(formatter, siteRepository)).Methods("GET")
-	router.HandleFunc("/site/{id}", getSiteHandler(formatter, siteRepository)).Methods("GET")
+const revisionResourceType = "site"
+
+func InitRoutes(router *mux.Router, formatter *render.Render, siteRepository SiteRepository, membershipRepository membership.MembershipRepository, authorizer *membership.Authorizer, revisionRepository revision.RevisionRepository, resolver DomainResolver, eventPublisher events.EventPublisher) {
+	authorize := authorizer.Require
+
+	router.HandleFunc("/site", createSiteHandler(formatter, siteRepository, membershipRepository, revisionRepository, eventPublisher)).Methods("POST")
+	router.HandleFunc("/site", getSiteListHandler(formatter, siteRepository, authorizer)).Methods("GET")
+	router.HandleFunc("/site/{id}", authorize(membership.PermissionViewSite)(getSiteHandler(formatter, siteRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}", authorize(membership.PermissionManageSite)(updateSiteHandler(formatter, siteRepository, authorizer, revisionRepository, eventPublisher))).Methods("PUT")
+	router.HandleFunc("/site/{id}/verify-domain", authorize(membership.PermissionManageSite)(verifyDomainHandler(formatter, siteRepository, resolver, eventPublisher))).Methods("POST")
+
+	revision.InitRoutes(router, formatter, revisionRepository, "/site/{id}", revisionResourceType, lookupSite(siteRepository), restoreSite(siteRepository),
+		authorize(membership.PermissionViewSite), authorize(membership.PermissionManageSite))
 }
 
-func createSiteHandler(formatter *render.Render, siteRepository SiteRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+func createSiteHandler(formatter *render.Render, siteRepository SiteRepository, membershipRepository membership.MembershipRepository, revisionRepository revision.RevisionRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		payload, _ := ioutil.ReadAll(req.Body)
 		var site Site
@@ -28,29 +44,69 @@ func createSiteHandler(formatter *render.Render, siteRepository SiteRepository,
 			return
 		}
 
-		if result := site.validate(); result.HasErrors() {
+		caller := user.FromContext(req.Context())
+		if caller != nil {
+			site.CreatedBy = caller
+		}
+
+		result := site.validate()
+		validateDomainNameIsAvailable(&result, &site, siteRepository)
+		validateRequireMFA(&result, &site, caller)
+
+		if result.HasErrors() {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": result.Errors,
 			})
 			return
 		}
 
+		if err := site.resetDomainVerification(); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		if err := siteRepository.Add(&site); err != nil {
 			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
 				"site":  site,
 				"error": err.Error(),
 			})
-		} else {
-			w.Header().Add("Location", fmt.Sprintf("/site/%v", site.ID))
-			formatter.JSON(w, http.StatusCreated, site)
-			//TODO newSiteCreatedEvent(user)
+			return
 		}
+
+		if err := membershipRepository.Add(membership.NewMembership(site.ID, site.CreatedBy.ID, membership.RoleOwner)); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"site":  site,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := revision.Record(revisionRepository, revisionResourceType, site.ID, site.CreatedBy, site); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"site":  site,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, site.CreatedBy.Actor(), NewCreatedEvent(&site))
+
+		w.Header().Add("Location", fmt.Sprintf("/site/%v", site.ID))
+		formatter.JSON(w, http.StatusCreated, site)
 	}
 }
 
-func getSiteListHandler(formatter *render.Render, siteRepository SiteRepository) http.HandlerFunc {
+func getSiteListHandler(formatter *render.Render, siteRepository SiteRepository, authorizer *membership.Authorizer) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		sites := siteRepository.List()
+		sites := []*Site{}
+
+		for _, siteID := range authorizer.SiteIDs(user.FromContext(req.Context())) {
+			if site, err := siteRepository.GetByID(siteID); err == nil {
+				sites = append(sites, site)
+			}
+		}
 
 		formatter.JSON(w, http.StatusOK, map[string]interface{}{
 			"sites": sites,
@@ -73,3 +129,202 @@ func getSiteHandler(formatter *render.Render, siteRepository SiteRepository) htt
 		}
 	}
 }
+
+func updateSiteHandler(formatter *render.Render, siteRepository SiteRepository, authorizer *membership.Authorizer, revisionRepository revision.RevisionRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		vars := mux.Vars(req)
+
+		existing, err := siteRepository.GetByID(vars["id"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		site := *existing
+		site.UpdatedBy = nil
+
+		if err := json.Unmarshal(payload, &site); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse update site request")
+			return
+		}
+
+		if caller := user.FromContext(req.Context()); caller != nil {
+			site.UpdatedBy = caller
+		}
+
+		site.ID = existing.ID
+		site.Status = existing.Status
+		site.VerificationToken = existing.VerificationToken
+		site.CreatedBy = existing.CreatedBy
+		site.Created = existing.Created
+		site.Updated = time.Now()
+
+		if site.RequireMFA != existing.RequireMFA && !authorizer.Can(site.UpdatedBy, site.ID, membership.PermissionManageOwners) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": "Only owners can change whether the site requires two-factor authentication",
+			})
+			return
+		}
+
+		result := site.validate()
+		validateDomainNameIsAvailable(&result, &site, siteRepository)
+		if site.UpdatedBy == nil {
+			result.AddError("updated_by", "Updated by is required")
+		} else if site.RequireMFA != existing.RequireMFA {
+			validateRequireMFA(&result, &site, site.UpdatedBy)
+		}
+
+		if result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if !strings.EqualFold(site.DomainName, existing.DomainName) {
+			if err := site.resetDomainVerification(); err != nil {
+				formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+					"error": err.Error(),
+				})
+				return
+			}
+		}
+
+		if err := siteRepository.Update(&site); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"site":  site,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := revision.Record(revisionRepository, revisionResourceType, site.ID, site.UpdatedBy, site); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"site":  site,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, site.UpdatedBy.Actor(), NewUpdatedEvent(&site))
+
+		formatter.JSON(w, http.StatusOK, site)
+	}
+}
+
+func verifyDomainHandler(formatter *render.Render, siteRepository SiteRepository, resolver DomainResolver, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		site, err := siteRepository.GetByID(mux.Vars(req)["id"])
+		if err != nil {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// Competing claims to a domain are settled here: the first site to
+		// verify it owns it.
+		if owner := siteRepository.FindByDomainName(site.DomainName); owner != nil && owner.ID != site.ID {
+			formatter.JSON(w, http.StatusConflict, map[string]interface{}{
+				"error": "Domain Name has already been verified by another site",
+			})
+			return
+		}
+
+		if err := site.VerifyDomain(resolver); err != nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error":  err.Error(),
+				"record": site.VerificationRecord(),
+				"token":  site.VerificationToken,
+			})
+			return
+		}
+
+		if err := siteRepository.Update(site); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"site":  site,
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, user.FromContext(req.Context()).Actor(), NewDomainVerifiedEvent(site))
+
+		formatter.JSON(w, http.StatusOK, site)
+	}
+}
+
+// validateRequireMFA stops someone requiring two-factor authentication on a
+// site before they use it themselves, which would lock them out.
+func validateRequireMFA(result *validator.ValidationResult, site *Site, caller *user.User) {
+	if site.RequireMFA && (caller == nil || !caller.MFAEnabled()) {
+		result.AddError("require_mfa", "Turn on two-factor authentication for your own account first")
+	}
+}
+
+// validateDomainNameIsAvailable only refuses domains that another site has
+// verified, so that an unverified claim can't keep the domain's real owner
+// out.
+func validateDomainNameIsAvailable(result *validator.ValidationResult, site *Site, siteRepository SiteRepository) {
+	if len(site.DomainName) == 0 {
+		return
+	}
+
+	if existing := siteRepository.FindByDomainName(site.DomainName); existing != nil && existing.ID != site.ID {
+		result.AddError("domain_name", "Domain Name is already in use by another site")
+	}
+}
+
+func lookupSite(siteRepository SiteRepository) revision.LookupFunc {
+	return func(req *http.Request) (string, error) {
+		site, err := siteRepository.GetByID(mux.Vars(req)["id"])
+		if err != nil {
+			return "", err
+		}
+
+		return site.ID, nil
+	}
+}
+
+func restoreSite(siteRepository SiteRepository) revision.RestoreFunc {
+	return func(req *http.Request, rev *revision.Revision, author *user.User) (interface{}, error) {
+		existing, err := siteRepository.GetByID(mux.Vars(req)["id"])
+		if err != nil {
+			return nil, err
+		}
+
+		var site Site
+		if err := rev.Restore(&site); err != nil {
+			return nil, err
+		}
+
+		site.ID = existing.ID
+		site.Status = existing.Status
+		site.VerificationToken = existing.VerificationToken
+		// Restoring is open to admins, who can't change the requirement.
+		site.RequireMFA = existing.RequireMFA
+		site.UpdatedBy = author
+		site.Updated = time.Now()
+
+		if !strings.EqualFold(site.DomainName, existing.DomainName) {
+			result := validator.NewValidationResult()
+			validateDomainNameIsAvailable(&result, &site, siteRepository)
+			if result.HasErrors() {
+				return nil, errors.New(result.Errors[0].ErrorMessage)
+			}
+
+			if err := site.resetDomainVerification(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := siteRepository.Update(&site); err != nil {
+			return nil, err
+		}
+
+		return site, nil
+	}
+}
@@ -0,0 +1,355 @@
+package site
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/revision"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+	owner    = user.NewUser(1, "Site", "Owner", "owner@spearwind.io")
+	outsider = user.NewUser(2, "Some", "Outsider", "outsider@spearwind.io")
+)
+
+type fakeResolver struct {
+	records map[string][]string
+}
+
+func (r *fakeResolver) LookupTXT(name string) ([]string, error) {
+	if records, ok := r.records[name]; ok {
+		return records, nil
+	}
+
+	return nil, errors.New("no such host")
+}
+
+// testServer serves the site routes with caller injected into every request
+// context, standing in for auth.ResolveCaller.
+type testServer struct {
+	*httptest.Server
+	siteRepository       SiteRepository
+	membershipRepository membership.MembershipRepository
+	revisionRepository   revision.RevisionRepository
+	resolver             *fakeResolver
+	caller               *user.User
+}
+
+func newTestServer() *testServer {
+	ts := &testServer{
+		siteRepository:       NewInMemoryRepository(),
+		membershipRepository: membership.NewInMemoryRepository(),
+		revisionRepository:   revision.NewInMemoryRepository(),
+		resolver:             &fakeResolver{records: map[string][]string{}},
+		caller:               owner,
+	}
+
+	router := mux.NewRouter()
+	authorizer := membership.NewAuthorizer(formatter, ts.membershipRepository, NewMFAPolicy(ts.siteRepository))
+	InitRoutes(router, formatter, ts.siteRepository, ts.membershipRepository, authorizer, ts.revisionRepository, ts.resolver, events.NewSynchEventPublisher())
+
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), ts.caller)))
+	}))
+
+	return ts
+}
+
+func doRequest(t *testing.T, method string, url string, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error creating %s request: %v", method, err)
+	}
+
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error in %s to %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+
+	payload, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("Error parsing response body: %v", err)
+	}
+
+	return res, payload
+}
+
+func TestCreateSiteMakesCallerTheOwner(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io","created_by":{"id":42}}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s: %s", res.Status, payload)
+	}
+
+	site, _ := ts.siteRepository.GetByID("1")
+	if site.CreatedBy.ID != owner.ID {
+		t.Errorf("Expected the caller to be recorded as the creator, got %v", site.CreatedBy)
+	}
+
+	if m, err := ts.membershipRepository.Get("1", owner.ID); err != nil || m.Role != membership.RoleOwner {
+		t.Errorf("Expected the caller to become the site owner, got %v, %v", m, err)
+	}
+}
+
+func TestSitesAreOnlyVisibleToMembers(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+
+	ts.caller = outsider
+
+	res, payload := doRequest(t, "GET", ts.URL+"/site", "")
+	var list struct {
+		Total int `json:"total"`
+	}
+	json.Unmarshal(payload, &list)
+
+	if res.StatusCode != http.StatusOK || list.Total != 0 {
+		t.Errorf("Expected an outsider to see no sites, got %s: %s", res.Status, payload)
+	}
+
+	if res, _ := doRequest(t, "GET", ts.URL+"/site/1", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an outsider to be forbidden from reading the site, received %s", res.Status)
+	}
+
+	ts.membershipRepository.Add(membership.NewMembership("1", outsider.ID, membership.RoleViewer))
+
+	if res, _ := doRequest(t, "GET", ts.URL+"/site/1", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected a viewer to read the site, received %s", res.Status)
+	}
+
+	if res, _ := doRequest(t, "PUT", ts.URL+"/site/1", `{"name":"Mine now"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a viewer to be forbidden from updating the site, received %s", res.Status)
+	}
+}
+
+func TestUpdateSiteRecordsRevisions(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s: %s", res.Status, payload)
+	}
+
+	editor := user.NewUser(3, "Site", "Admin", "admin@spearwind.io")
+	ts.membershipRepository.Add(membership.NewMembership("1", editor.ID, membership.RoleAdmin))
+	ts.caller = editor
+
+	res, payload = doRequest(t, "PUT", ts.URL+"/site/1", `{"name":"Spearwind CMS"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s: %s", res.Status, payload)
+	}
+
+	revisions := ts.revisionRepository.List(revisionResourceType, "1")
+	if len(revisions) != 2 {
+		t.Fatalf("Expected two revisions after create and update, got %d", len(revisions))
+	}
+
+	if revisions[1].Author == nil || revisions[1].Author.ID != editor.ID {
+		t.Errorf("Expected the update revision to be authored by the caller, got %v", revisions[1].Author)
+	}
+
+	res, payload = doRequest(t, "GET", ts.URL+"/site/1/revisions/1/diff/2", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s: %s", res.Status, payload)
+	}
+
+	var diff struct {
+		Changes []revision.FieldChange `json:"changes"`
+	}
+	json.Unmarshal(payload, &diff)
+
+	changed := map[string]bool{}
+	for _, change := range diff.Changes {
+		changed[change.Field] = true
+	}
+
+	if !changed["name"] || changed["domain_name"] {
+		t.Errorf("Expected name and not domain_name to have changed, got %+v", diff.Changes)
+	}
+}
+
+func TestRestoreSiteRevisionCreatesNewHead(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+	doRequest(t, "PUT", ts.URL+"/site/1", `{"name":"Oops"}`)
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site/1/revisions/1/restore", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s: %s", res.Status, payload)
+	}
+
+	if site, _ := ts.siteRepository.GetByID("1"); site.Name != "Spearwind" {
+		t.Errorf("Expected the site name to be restored, got %q", site.Name)
+	}
+
+	revisions := ts.revisionRepository.List(revisionResourceType, "1")
+	if len(revisions) != 3 || revisions[2].RestoredFrom != 1 {
+		t.Errorf("Expected restore to add a third revision restored from 1, got %d revisions", len(revisions))
+	}
+}
+
+func TestRestoreSiteRevisionChecksDomainIsAvailable(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+	doRequest(t, "PUT", ts.URL+"/site/1", `{"domain_name":"spearwind.com"}`)
+
+	taken := NewSite("Taken", "spearwind.io", outsider)
+	taken.Status = StatusActive
+	ts.siteRepository.Add(taken)
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site/1/revisions/1/restore", "")
+	if res.StatusCode == http.StatusOK {
+		t.Fatalf("Expected restoring a domain another site owns to fail, received %s: %s", res.Status, payload)
+	}
+
+	if site, _ := ts.siteRepository.GetByID("1"); site.DomainName != "spearwind.com" {
+		t.Errorf("Expected the domain name to be left alone, got %q", site.DomainName)
+	}
+}
+
+func TestCreateSiteStartsUnverifiedUntilTXTRecordMatches(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io","status":"active"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s: %s", res.Status, payload)
+	}
+
+	site, _ := ts.siteRepository.GetByID("1")
+	if site.IsActive() || site.VerificationToken == "" {
+		t.Fatalf("Expected a new site to be unverified with a token, got %+v", site)
+	}
+
+	res, _ = doRequest(t, "POST", ts.URL+"/site/1/verify-domain", "")
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected verification without a TXT record to fail, received %s", res.Status)
+	}
+
+	ts.resolver.records["_spearwind-verify.spearwind.io"] = []string{"unrelated", site.VerificationToken}
+
+	res, payload = doRequest(t, "POST", ts.URL+"/site/1/verify-domain", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected verification to succeed, received %s: %s", res.Status, payload)
+	}
+
+	if site, _ := ts.siteRepository.GetByID("1"); !site.IsActive() {
+		t.Errorf("Expected the site to be active after verification, got %+v", site)
+	}
+}
+
+func TestChangingDomainNameRequiresVerificationAgain(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	active := NewSite("Spearwind", "spearwind.io", owner)
+	active.Status = StatusActive
+	ts.siteRepository.Add(active)
+	ts.membershipRepository.Add(membership.NewMembership(active.ID, owner.ID, membership.RoleOwner))
+
+	res, payload := doRequest(t, "PUT", ts.URL+"/site/1", `{"domain_name":"spearwind.com"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s: %s", res.Status, payload)
+	}
+
+	if site, _ := ts.siteRepository.GetByID("1"); site.IsActive() || site.VerificationToken == "" {
+		t.Errorf("Expected a new domain name to reset verification, got %+v", site)
+	}
+}
+
+func TestUnverifiedClaimsDoNotReserveDomain(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	squatter := NewSite("Squatter", "spearwind.io", outsider)
+	ts.siteRepository.Add(squatter)
+
+	res, payload := doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected an unverified claim not to block the domain, received %s: %s", res.Status, payload)
+	}
+
+	site, _ := ts.siteRepository.GetByID("2")
+	ts.resolver.records["_spearwind-verify.spearwind.io"] = []string{site.VerificationToken}
+
+	res, payload = doRequest(t, "POST", ts.URL+"/site/2/verify-domain", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the owner of the TXT record to verify the domain, received %s: %s", res.Status, payload)
+	}
+
+	if found := ts.siteRepository.FindByDomainName("spearwind.io"); found == nil || found.ID != "2" {
+		t.Errorf("Expected the verified site to own the domain, got %+v", found)
+	}
+
+	res, _ = doRequest(t, "POST", ts.URL+"/site", `{"name":"Another","domain_name":"spearwind.io"}`)
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a verified domain to be unavailable, received %s", res.Status)
+	}
+}
+
+func TestOnlyOwnersCanRequireMFA(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	doRequest(t, "POST", ts.URL+"/site", `{"name":"Spearwind","domain_name":"spearwind.io"}`)
+
+	if res, _ := doRequest(t, "PUT", ts.URL+"/site/1", `{"require_mfa":true}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an owner without MFA to be refused, received %s", res.Status)
+	}
+
+	admin := user.NewUser(3, "Site", "Admin", "admin@spearwind.io")
+	admin.MFA.Enabled = true
+	ts.membershipRepository.Add(membership.NewMembership("1", admin.ID, membership.RoleAdmin))
+	ts.caller = admin
+
+	if res, _ := doRequest(t, "PUT", ts.URL+"/site/1", `{"require_mfa":true}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an admin to be forbidden from requiring MFA, received %s", res.Status)
+	}
+
+	secured := *owner
+	secured.MFA.Enabled = true
+	ts.caller = &secured
+
+	if res, payload := doRequest(t, "PUT", ts.URL+"/site/1", `{"require_mfa":true}`); res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected an owner with MFA to require it, received %s: %s", res.Status, payload)
+	}
+
+	ts.caller = owner
+	if res, _ := doRequest(t, "GET", ts.URL+"/site/1", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected members without MFA to be forbidden, received %s", res.Status)
+	}
+
+	if _, payload := doRequest(t, "GET", ts.URL+"/site", ""); strings.Contains(string(payload), "Spearwind") {
+		t.Errorf("Expected members without MFA not to see the site in their list, received %s", payload)
+	}
+
+	ts.caller = admin
+	if res, _ := doRequest(t, "GET", ts.URL+"/site/1", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("Expected members with MFA to read the site, received %s", res.Status)
+	}
+}
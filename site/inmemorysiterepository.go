@@ -3,6 +3,7 @@ package site
 import (
 	"errors"
 	"fmt"
+	"strings"
 )
 
 type inMemoryRepository struct {
@@ -22,6 +23,10 @@ func (repo *inMemoryRepository) Add(site *Site) (err error) {
 }
 
 func (repo *inMemoryRepository) Update(site *Site) (err error) {
+	if _, ok := repo.sites[site.ID]; !ok {
+		return errors.New("Could not find site in repository")
+	}
+
 	repo.sites[site.ID] = site
 	return err
 }
@@ -48,3 +53,14 @@ func (repo *inMemoryRepository) GetByID(siteID string) (site *Site, err error) {
 	}
 	return site, err
 }
+
+func (repo *inMemoryRepository) FindByDomainName(domainName string) (site *Site) {
+	for _, target := range repo.sites {
+		if target.IsActive() && strings.EqualFold(target.DomainName, domainName) {
+			site = target
+			break
+		}
+	}
+
+	return site
+}
@@ -0,0 +1,175 @@
+package site
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"github.com/spear-wind/cms/user"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoSiteRepository struct {
+	Collection cfmgo.Collection
+}
+
+type siteRecord struct {
+	RecordID           bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	SiteID             string        `bson:"site_id" json:"site_id"`
+	Name               string        `bson:"name" json:"name"`
+	DomainName         string        `bson:"domain_name" json:"domain_name"`
+	Status             string        `bson:"status" json:"status"`
+	VerificationToken  string        `bson:"verification_token" json:"verification_token"`
+	RequireMFA         bool          `bson:"require_mfa" json:"require_mfa"`
+	CreatedByID        int64         `bson:"created_by_id" json:"created_by_id"`
+	CreatedByEmail     string        `bson:"created_by_email" json:"created_by_email"`
+	CreatedByFirstName string        `bson:"created_by_first_name" json:"created_by_first_name"`
+	CreatedByLastName  string        `bson:"created_by_last_name" json:"created_by_last_name"`
+	Created            time.Time     `bson:"date_created" json:"date_created"`
+	UpdatedByID        int64         `bson:"updated_by_id" json:"updated_by_id"`
+	UpdatedByEmail     string        `bson:"updated_by_email" json:"updated_by_email"`
+	UpdatedByFirstName string        `bson:"updated_by_first_name" json:"updated_by_first_name"`
+	UpdatedByLastName  string        `bson:"updated_by_last_name" json:"updated_by_last_name"`
+	Updated            time.Time     `bson:"date_updated" json:"date_updated"`
+}
+
+func NewMongoSiteRepository(col cfmgo.Collection) *mongoSiteRepository {
+	return &mongoSiteRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoSiteRepository) Add(site *Site) (err error) {
+	repo.Collection.Wake()
+	sr := toSiteRecord(site)
+	sr.SiteID = sr.RecordID.Hex()
+	if _, err = repo.Collection.UpsertID(sr.RecordID, sr); err == nil {
+		site.ID = sr.SiteID
+	}
+
+	return
+}
+
+func (repo *mongoSiteRepository) Update(site *Site) (err error) {
+	repo.Collection.Wake()
+	foundSite, err := repo.getMongoSite(site.ID)
+	if err == nil {
+		sr := toSiteRecord(site)
+		sr.RecordID = foundSite.RecordID
+		_, err = repo.Collection.UpsertID(sr.RecordID, sr)
+	}
+
+	return
+}
+
+func (repo *mongoSiteRepository) List() (sites []*Site) {
+	repo.Collection.Wake()
+	var sr []siteRecord
+	_, err := repo.Collection.Find(cfmgo.ParamsUnfiltered, &sr)
+	if err == nil {
+		sites = make([]*Site, len(sr))
+		for k, v := range sr {
+			sites[k] = toSite(&v)
+		}
+	}
+
+	return
+}
+
+func (repo *mongoSiteRepository) GetByID(id string) (site *Site, err error) {
+	var sr *siteRecord
+	sr, err = repo.getMongoSite(id)
+	if sr != nil && err == nil {
+		site = toSite(sr)
+	}
+
+	return
+}
+
+func (repo *mongoSiteRepository) FindByDomainName(domainName string) (site *Site) {
+	var sites []siteRecord
+	query := bson.M{"domain_name": strings.ToLower(domainName), "status": StatusActive}
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	count, err := repo.Collection.Find(params, &sites)
+	if count == 0 {
+		err = errors.New("Site not found")
+	}
+	if err == nil {
+		site = toSite(&sites[0])
+	}
+
+	return
+}
+
+func (repo *mongoSiteRepository) getMongoSite(id string) (site *siteRecord, err error) {
+	var sites []siteRecord
+	query := bson.M{"site_id": id}
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	count, err := repo.Collection.Find(params, &sites)
+	if count == 0 {
+		err = errors.New("Site not found")
+	}
+	if err == nil {
+		site = &sites[0]
+	}
+
+	return
+}
+
+func toSiteRecord(s *Site) (sr *siteRecord) {
+	sr = &siteRecord{
+		RecordID:          bson.NewObjectId(),
+		SiteID:            s.ID,
+		Name:              s.Name,
+		DomainName:        strings.ToLower(s.DomainName),
+		Status:            s.Status,
+		VerificationToken: s.VerificationToken,
+		RequireMFA:        s.RequireMFA,
+		Created:           s.Created,
+		Updated:           s.Updated,
+	}
+
+	if s.CreatedBy != nil {
+		sr.CreatedByID = s.CreatedBy.ID
+		sr.CreatedByEmail = s.CreatedBy.Email
+		sr.CreatedByFirstName = s.CreatedBy.FirstName
+		sr.CreatedByLastName = s.CreatedBy.LastName
+	}
+
+	if s.UpdatedBy != nil {
+		sr.UpdatedByID = s.UpdatedBy.ID
+		sr.UpdatedByEmail = s.UpdatedBy.Email
+		sr.UpdatedByFirstName = s.UpdatedBy.FirstName
+		sr.UpdatedByLastName = s.UpdatedBy.LastName
+	}
+
+	return
+}
+
+func toSite(sr *siteRecord) (s *Site) {
+	s = &Site{
+		ID:                sr.SiteID,
+		Name:              sr.Name,
+		DomainName:        sr.DomainName,
+		Status:            sr.Status,
+		VerificationToken: sr.VerificationToken,
+		RequireMFA:        sr.RequireMFA,
+		CreatedBy:         user.NewUser(sr.CreatedByID, sr.CreatedByFirstName, sr.CreatedByLastName, sr.CreatedByEmail),
+		Created:           sr.Created,
+		Updated:           sr.Updated,
+	}
+
+	if sr.UpdatedByID != 0 {
+		s.UpdatedBy = user.NewUser(sr.UpdatedByID, sr.UpdatedByFirstName, sr.UpdatedByLastName, sr.UpdatedByEmail)
+	}
+
+	return
+}
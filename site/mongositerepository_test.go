@@ -0,0 +1,100 @@
+package site
+
+import (
+	"reflect"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeCollection is an in-process stand-in for a Mongo collection. Records
+// are round-tripped through bson so that the repository's record mapping is
+// exercised the same way it would be against a real database. Only the
+// methods used by the repositories are implemented.
+type fakeCollection struct {
+	cfmgo.Collection
+	docs []bson.M
+}
+
+func newFakeCollection() *fakeCollection {
+	return &fakeCollection{docs: []bson.M{}}
+}
+
+func (c *fakeCollection) Wake() {}
+
+func (c *fakeCollection) Find(p cfmgo.Params, result interface{}) (count int, err error) {
+	var query bson.M
+	if rp, ok := p.(*params.RequestParams); ok {
+		query = rp.Q
+	}
+
+	slice := reflect.ValueOf(result).Elem()
+	slice.Set(reflect.MakeSlice(slice.Type(), 0, len(c.docs)))
+
+	for _, doc := range c.docs {
+		if !matches(doc, query) {
+			continue
+		}
+
+		raw, err := bson.Marshal(doc)
+		if err != nil {
+			return 0, err
+		}
+
+		elem := reflect.New(slice.Type().Elem())
+		if err := bson.Unmarshal(raw, elem.Interface()); err != nil {
+			return 0, err
+		}
+
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+
+	return slice.Len(), nil
+}
+
+func (c *fakeCollection) UpsertID(id interface{}, record interface{}) (*mgo.ChangeInfo, error) {
+	raw, err := bson.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	doc["_id"] = id
+
+	for i, existing := range c.docs {
+		if reflect.DeepEqual(existing["_id"], id) {
+			c.docs[i] = doc
+			return &mgo.ChangeInfo{Updated: 1}, nil
+		}
+	}
+
+	c.docs = append(c.docs, doc)
+	return &mgo.ChangeInfo{UpsertedId: id}, nil
+}
+
+func (c *fakeCollection) Delete(selector interface{}) error {
+	query, _ := selector.(bson.M)
+	remaining := c.docs[:0]
+	for _, doc := range c.docs {
+		if !matches(doc, query) {
+			remaining = append(remaining, doc)
+		}
+	}
+	c.docs = remaining
+	return nil
+}
+
+func matches(doc bson.M, query bson.M) bool {
+	for key, value := range query {
+		if !reflect.DeepEqual(doc[key], value) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,148 @@
+package site
+
+import (
+	"testing"
+
+	"github.com/spear-wind/cms/user"
+)
+
+// siteRepositoryContract runs the same set of expectations against any
+// SiteRepository implementation.
+func siteRepositoryContract(t *testing.T, newRepository func() SiteRepository) {
+	creator := user.NewUser(1, "Spearwind", "Creator", "creator@spearwind.io")
+
+	t.Run("AddAssignsID", func(t *testing.T) {
+		repo := newRepository()
+		site := NewSite("Spearwind", "spearwind.io", creator)
+
+		if err := repo.Add(site); err != nil {
+			t.Fatalf("Unexpected error adding site: %v", err)
+		}
+
+		if site.ID == "" {
+			t.Error("Expected Add to assign an ID to the site")
+		}
+	})
+
+	t.Run("GetByIDReturnsAddedSite", func(t *testing.T) {
+		repo := newRepository()
+		site := NewSite("Spearwind", "spearwind.io", creator)
+		repo.Add(site)
+
+		found, err := repo.GetByID(site.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error getting site: %v", err)
+		}
+
+		if found.ID != site.ID || found.Name != "Spearwind" || found.DomainName != "spearwind.io" {
+			t.Errorf("Expected to get back the site that was added, but got %+v", found)
+		}
+
+		if found.Status != StatusUnverified {
+			t.Errorf("Expected Status to survive a round trip, but got %q", found.Status)
+		}
+
+		if found.CreatedBy == nil || found.CreatedBy.ID != creator.ID || found.CreatedBy.Email != creator.Email {
+			t.Errorf("Expected CreatedBy to survive a round trip, but got %v", found.CreatedBy)
+		}
+	})
+
+	t.Run("GetByIDWithUnknownIDReturnsError", func(t *testing.T) {
+		repo := newRepository()
+
+		if _, err := repo.GetByID("does-not-exist"); err == nil {
+			t.Error("Expected an error getting a site that does not exist")
+		}
+	})
+
+	t.Run("UpdatePersistsChanges", func(t *testing.T) {
+		repo := newRepository()
+		site := NewSite("Spearwind", "spearwind.io", creator)
+		repo.Add(site)
+
+		site.Name = "Spearwind CMS"
+		if err := repo.Update(site); err != nil {
+			t.Fatalf("Unexpected error updating site: %v", err)
+		}
+
+		found, _ := repo.GetByID(site.ID)
+		if found == nil || found.Name != "Spearwind CMS" {
+			t.Errorf("Expected updated name to be persisted, but got %+v", found)
+		}
+
+		if sites := repo.List(); len(sites) != 1 {
+			t.Errorf("Expected update not to add a site, but there were %d", len(sites))
+		}
+	})
+
+	t.Run("UpdateWithUnknownIDReturnsError", func(t *testing.T) {
+		repo := newRepository()
+		site := NewSite("Spearwind", "spearwind.io", creator)
+		site.ID = "does-not-exist"
+
+		if err := repo.Update(site); err == nil {
+			t.Error("Expected an error updating a site that does not exist")
+		}
+	})
+
+	t.Run("FindByDomainNameIgnoresCase", func(t *testing.T) {
+		repo := newRepository()
+		site := NewSite("Spearwind", "spearwind.io", creator)
+		site.Status = StatusActive
+		repo.Add(site)
+		repo.Add(NewSite("Example", "example.com", creator))
+
+		found := repo.FindByDomainName("SpearWind.io")
+		if found == nil || found.ID != site.ID {
+			t.Errorf("Expected to find site %s by domain name, but got %v", site.ID, found)
+		}
+
+		if repo.FindByDomainName("unknown.io") != nil {
+			t.Error("Expected no site for an unknown domain name")
+		}
+	})
+
+	t.Run("FindByDomainNameOnlyReturnsActiveSite", func(t *testing.T) {
+		repo := newRepository()
+		repo.Add(NewSite("Squatter", "spearwind.io", creator))
+
+		if found := repo.FindByDomainName("spearwind.io"); found != nil {
+			t.Errorf("Expected an unverified site not to own its domain, but got %v", found)
+		}
+
+		site := NewSite("Spearwind", "spearwind.io", creator)
+		site.Status = StatusActive
+		repo.Add(site)
+
+		if found := repo.FindByDomainName("spearwind.io"); found == nil || found.ID != site.ID {
+			t.Errorf("Expected to find active site %s, but got %v", site.ID, found)
+		}
+	})
+
+	t.Run("ListReturnsAllSites", func(t *testing.T) {
+		repo := newRepository()
+
+		if sites := repo.List(); len(sites) != 0 {
+			t.Errorf("Expected an empty repository to list no sites, but got %d", len(sites))
+		}
+
+		repo.Add(NewSite("Spearwind", "spearwind.io", creator))
+		repo.Add(NewSite("Example", "example.com", creator))
+
+		if sites := repo.List(); len(sites) != 2 {
+			t.Errorf("Expected exactly two sites, but got %d", len(sites))
+		}
+	})
+}
+
+func TestInMemorySiteRepository(t *testing.T) {
+	siteRepositoryContract(t, func() SiteRepository {
+		return NewInMemoryRepository()
+	})
+}
+
+func TestMongoSiteRepository(t *testing.T) {
+	siteRepositoryContract(t, func() SiteRepository {
+		return NewMongoSiteRepository(newFakeCollection())
+	})
+}
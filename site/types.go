@@ -1,34 +1,80 @@
 package site
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/spear-wind/cms/security"
 	"github.com/spear-wind/cms/validator"
 
 	"github.com/spear-wind/cms/user"
 )
 
+const (
+	StatusUnverified = "unverified"
+	StatusActive     = "active"
+
+	verificationRecordPrefix = "_spearwind-verify."
+)
+
 type SiteRepository interface {
 	Add(site *Site) (err error)
 	Update(site *Site) (err error)
 	List() (sites []*Site)
 	GetByID(id string) (site *Site, err error)
+	// FindByDomainName returns the active site that owns domainName. Sites
+	// that haven't verified their domain don't own it, so several can claim
+	// the same one until one of them verifies it.
+	FindByDomainName(domainName string) (site *Site)
 }
 
 type Site struct {
-	ID         string     `json:"id"`
-	Name       string     `json:"name"`
-	DomainName string     `json:"domain_name"`
-	CreatedBy  *user.User `json:"created_by"`
-	Created    time.Time  `json:"date_created"`
+	ID                string     `json:"id"`
+	Name              string     `json:"name"`
+	DomainName        string     `json:"domain_name"`
+	Status            string     `json:"status"`
+	VerificationToken string     `json:"verification_token,omitempty"`
+	RequireMFA        bool       `json:"require_mfa"`
+	CreatedBy         *user.User `json:"created_by"`
+	Created           time.Time  `json:"date_created"`
+	UpdatedBy         *user.User `json:"updated_by,omitempty"`
+	Updated           time.Time  `json:"date_updated"`
+}
+
+// MFAPolicy tells membership.Authorizer which sites require two-factor
+// authentication: members of a site with RequireMFA set can't use it until
+// they turn it on. Only owners can change RequireMFA.
+type MFAPolicy struct {
+	siteRepository SiteRepository
+}
+
+func NewMFAPolicy(siteRepository SiteRepository) *MFAPolicy {
+	return &MFAPolicy{siteRepository: siteRepository}
+}
+
+func (policy *MFAPolicy) RequiresMFA(siteID string) bool {
+	site, err := policy.siteRepository.GetByID(siteID)
+	return err == nil && site.RequireMFA
+}
+
+// DomainResolver looks up DNS TXT records. It is satisfied by net.Resolver
+// and can be replaced with a fake in tests.
+type DomainResolver interface {
+	LookupTXT(name string) ([]string, error)
 }
 
 func NewSite(name string, domainName string, createdBy *user.User) *Site {
+	now := time.Now()
+
 	return &Site{
 		Name:       name,
 		DomainName: domainName,
+		Status:     StatusUnverified,
 		CreatedBy:  createdBy,
-		Created:    time.Now(),
+		Created:    now,
+		Updated:    now,
 	}
 }
 
@@ -53,3 +99,52 @@ func (s *Site) validate() (result validator.ValidationResult) {
 
 	return result
 }
+
+func (s *Site) IsActive() bool {
+	return s.Status == StatusActive
+}
+
+// VerificationRecord is the DNS name that must hold a TXT record containing
+// the site's verification token.
+func (s *Site) VerificationRecord() string {
+	return verificationRecordPrefix + s.DomainName
+}
+
+// resetDomainVerification marks the site unverified and issues a new token.
+func (s *Site) resetDomainVerification() error {
+	token, err := security.GenerateRandomString(24)
+	if err != nil {
+		return fmt.Errorf("Failed to generate domain verification token: %v", err)
+	}
+
+	s.Status = StatusUnverified
+	s.VerificationToken = token
+	return nil
+}
+
+// VerifyDomain activates the site if one of the TXT records published at
+// VerificationRecord matches its verification token.
+func (s *Site) VerifyDomain(resolver DomainResolver) error {
+	if s.IsActive() {
+		return nil
+	}
+
+	if len(s.VerificationToken) == 0 {
+		return errors.New("This site has no domain verification token")
+	}
+
+	records, err := resolver.LookupTXT(s.VerificationRecord())
+	if err != nil {
+		return fmt.Errorf("Failed to look up %s: %v", s.VerificationRecord(), err)
+	}
+
+	for _, record := range records {
+		if strings.TrimSpace(record) == s.VerificationToken {
+			s.Status = StatusActive
+			s.VerificationToken = ""
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No TXT record at %s matches the verification token", s.VerificationRecord())
+}
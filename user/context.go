@@ -0,0 +1,18 @@
+package user
+
+import "context"
+
+type contextKey int
+
+const callerKey contextKey = 0
+
+// NewContext returns a copy of ctx carrying the authenticated caller.
+func NewContext(ctx context.Context, caller *User) context.Context {
+	return context.WithValue(ctx, callerKey, caller)
+}
+
+// FromContext returns the authenticated caller stored in ctx, or nil.
+func FromContext(ctx context.Context) *User {
+	caller, _ := ctx.Value(callerKey).(*User)
+	return caller
+}
@@ -0,0 +1,215 @@
+package user
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/hlandau/passlib.v1"
+)
+
+// Credential is a user's password hash, in passlib's modular crypt format.
+// Users who only log in with Facebook have none.
+type Credential struct {
+	Hash    string
+	Updated time.Time
+}
+
+// CredentialPolicy decides which password hashes are current. New hashes are
+// made by passlib using its defaults for the Defaults version, e.g. "latest"
+// or "20160922"; a hash with a different scheme, or a lower cost than a new
+// hash would have, is replaced the next time its user logs in.
+type CredentialPolicy struct {
+	Defaults string
+	scheme   string
+	cost     int
+	sample   string
+}
+
+// CredentialReport counts how many accounts have current password hashes,
+// for tracking a migration to a new CredentialPolicy.
+type CredentialReport struct {
+	Defaults   string         `json:"defaults"`
+	Scheme     string         `json:"scheme"`
+	Total      int            `json:"total"`
+	Current    int            `json:"current"`
+	Legacy     int            `json:"legacy"`
+	NoPassword int            `json:"no_password"`
+	Schemes    map[string]int `json:"schemes"`
+}
+
+var (
+	credentialPolicy      *CredentialPolicy
+	credentialPolicyMutex sync.RWMutex
+)
+
+// schemeNames names the modular crypt identifiers passlib produces.
+var schemeNames = map[string]string{
+	"s2":            "scrypt-sha256",
+	"2a":            "bcrypt",
+	"2b":            "bcrypt",
+	"2y":            "bcrypt",
+	"bcrypt-sha256": "bcrypt-sha256",
+	"5":             "sha256-crypt",
+	"6":             "sha512-crypt",
+	"argon2i":       "argon2i",
+	"argon2id":      "argon2id",
+}
+
+// NewCredentialPolicy configures passlib with the defaults for the given
+// version, and works out what a current hash looks like from a sample.
+func NewCredentialPolicy(defaults string) (*CredentialPolicy, error) {
+	if len(defaults) != 0 {
+		if err := passlib.UseDefaults(defaults); err != nil {
+			return nil, fmt.Errorf("Failed to use passlib defaults %q: %v", defaults, err)
+		}
+	}
+
+	sample, err := passlib.Hash("credential-policy-sample")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to hash a sample password: %v", err)
+	}
+
+	return &CredentialPolicy{
+		Defaults: defaults,
+		scheme:   hashScheme(sample),
+		cost:     hashCost(sample),
+		sample:   sample,
+	}, nil
+}
+
+// UseCredentialPolicy sets the policy that Authenticate upgrades hashes to.
+func UseCredentialPolicy(policy *CredentialPolicy) {
+	credentialPolicyMutex.Lock()
+	defer credentialPolicyMutex.Unlock()
+
+	credentialPolicy = policy
+}
+
+// currentCredentialPolicy returns the policy set with UseCredentialPolicy, or
+// passlib's own defaults if none was.
+func currentCredentialPolicy() (*CredentialPolicy, error) {
+	credentialPolicyMutex.RLock()
+	policy := credentialPolicy
+	credentialPolicyMutex.RUnlock()
+
+	if policy != nil {
+		return policy, nil
+	}
+
+	policy, err := NewCredentialPolicy("")
+	if err != nil {
+		return nil, err
+	}
+
+	UseCredentialPolicy(policy)
+	return policy, nil
+}
+
+// Scheme is the name of the algorithm new hashes are made with.
+func (policy *CredentialPolicy) Scheme() string {
+	return policy.scheme
+}
+
+// NeedsUpgrade reports whether credential's hash should be replaced.
+func (policy *CredentialPolicy) NeedsUpgrade(credential Credential) bool {
+	if len(credential.Hash) == 0 {
+		return false
+	}
+
+	return hashScheme(credential.Hash) != policy.scheme || hashCost(credential.Hash) < policy.cost
+}
+
+// NewCredentialReport counts the password hashes of every user in
+// userRepository.
+func NewCredentialReport(userRepository UserRepository, policy *CredentialPolicy) CredentialReport {
+	report := CredentialReport{
+		Defaults: policy.Defaults,
+		Scheme:   policy.Scheme(),
+		Schemes:  map[string]int{},
+	}
+
+	for _, user := range userRepository.listUsers() {
+		report.Total++
+
+		switch {
+		case len(user.Credential.Hash) == 0:
+			report.NoPassword++
+		case policy.NeedsUpgrade(user.Credential):
+			report.Legacy++
+			report.Schemes[hashScheme(user.Credential.Hash)]++
+		default:
+			report.Current++
+			report.Schemes[hashScheme(user.Credential.Hash)]++
+		}
+	}
+
+	return report
+}
+
+func (report CredentialReport) String() string {
+	var schemes []string
+	for scheme, count := range report.Schemes {
+		schemes = append(schemes, fmt.Sprintf("%s: %d", scheme, count))
+	}
+	sort.Strings(schemes)
+
+	return fmt.Sprintf("%d accounts: %d current (%s), %d on legacy hashes, %d without a password. By scheme: %s",
+		report.Total, report.Current, report.Scheme, report.Legacy, report.NoPassword, strings.Join(schemes, ", "))
+}
+
+// VerifyDummy takes about as long to reject password as Authenticate does
+// for a user with a current hash, so that a login for an email nobody has
+// can't be told apart by how long it takes.
+func VerifyDummy(password string) {
+	if policy, err := currentCredentialPolicy(); err == nil {
+		passlib.Verify(password, policy.sample)
+	}
+}
+
+func newCredential(hash string) Credential {
+	return Credential{Hash: hash, Updated: time.Now()}
+}
+
+// hashScheme names the algorithm of a modular crypt format hash, e.g.
+// "scrypt-sha256" for "$s2$...". Identifiers passlib doesn't produce are
+// returned as they are.
+func hashScheme(hash string) string {
+	fields := strings.Split(hash, "$")
+	if len(fields) < 3 || len(fields[0]) != 0 {
+		return "unknown"
+	}
+
+	if name, ok := schemeNames[fields[1]]; ok {
+		return name
+	}
+
+	return fields[1]
+}
+
+// hashCost returns the work factor recorded in a hash: bcrypt's log rounds,
+// sha-crypt's rounds or scrypt's N. Hashes without one have a cost of 0.
+func hashCost(hash string) int {
+	fields := strings.Split(hash, "$")
+	if len(fields) < 3 {
+		return 0
+	}
+
+	switch hashScheme(hash) {
+	case "bcrypt", "scrypt-sha256":
+		cost, _ := strconv.Atoi(fields[2])
+		return cost
+	case "sha256-crypt", "sha512-crypt":
+		if strings.HasPrefix(fields[2], "rounds=") {
+			cost, _ := strconv.Atoi(strings.TrimPrefix(fields[2], "rounds="))
+			return cost
+		}
+		// sha-crypt hashes without a rounds field use the default 5000.
+		return 5000
+	}
+
+	return 0
+}
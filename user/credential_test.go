@@ -0,0 +1,115 @@
+package user
+
+import "testing"
+
+func TestHashSchemeAndCost(t *testing.T) {
+	tests := []struct {
+		hash   string
+		scheme string
+		cost   int
+	}{
+		{"$s2$16384$8$1$c2FsdA==$aGFzaA==", "scrypt-sha256", 16384},
+		{"$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", "bcrypt", 12},
+		{"$2a$10$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", "bcrypt", 10},
+		{"$5$rounds=80000$wnsT7Yr92oJoP28r$cKhJImk5mfuSKV9b3mumNzlbstFUplKtQXXMo4G6Ep5", "sha256-crypt", 80000},
+		{"$6$saltsalt$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1", "sha512-crypt", 5000},
+		{"5f4dcc3b5aa765d61d8327deb882cf99", "unknown", 0},
+	}
+
+	for _, test := range tests {
+		if scheme := hashScheme(test.hash); scheme != test.scheme {
+			t.Errorf("%s: expected scheme %s, got %s", test.hash, test.scheme, scheme)
+		}
+
+		if cost := hashCost(test.hash); cost != test.cost {
+			t.Errorf("%s: expected cost %d, got %d", test.hash, test.cost, cost)
+		}
+	}
+}
+
+func TestNeedsUpgrade(t *testing.T) {
+	policy := &CredentialPolicy{scheme: "bcrypt", cost: 12}
+
+	tests := []struct {
+		hash     string
+		upgraded bool
+	}{
+		{"$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", false},
+		{"$2b$14$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", false},
+		{"$2b$10$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW", true},
+		{"$5$rounds=80000$wnsT7Yr92oJoP28r$cKhJImk5mfuSKV9b3mumNzlbstFUplKtQXXMo4G6Ep5", true},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if upgraded := policy.NeedsUpgrade(Credential{Hash: test.hash}); upgraded != test.upgraded {
+			t.Errorf("%q: expected NeedsUpgrade to be %v", test.hash, test.upgraded)
+		}
+	}
+}
+
+func TestNewPasswordsMeetTheDefaultPolicy(t *testing.T) {
+	policy, err := NewCredentialPolicy("")
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+
+	user := User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	user.Register()
+
+	if policy.NeedsUpgrade(user.Credential) {
+		t.Errorf("Expected a new hash to be current, got %s", hashScheme(user.Credential.Hash))
+	}
+}
+
+func TestAuthenticateUpgradesOutdatedHashes(t *testing.T) {
+	user := User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	user.Register()
+
+	// A policy asking for a scheme passlib doesn't produce makes every
+	// existing hash outdated.
+	UseCredentialPolicy(&CredentialPolicy{scheme: "argon2id"})
+	defer UseCredentialPolicy(nil)
+
+	ok, rehashed := user.Authenticate("p@$$w0rd")
+	if !ok || !rehashed {
+		t.Fatalf("Expected authentication to succeed and upgrade the hash, got %v and %v", ok, rehashed)
+	}
+
+	if user.Credential.Updated.IsZero() || user.Password != "" {
+		t.Errorf("Expected the new hash to be stored in the credential only")
+	}
+
+	if ok, _ := user.Authenticate("p@$$w0rd"); !ok {
+		t.Errorf("Expected the upgraded hash to verify the password")
+	}
+
+	if ok, rehashed := user.Authenticate("wrong"); ok || rehashed {
+		t.Errorf("Expected a wrong password to fail without upgrading the hash")
+	}
+}
+
+func TestCredentialReport(t *testing.T) {
+	userRepository := NewInMemoryRepository()
+
+	current := &User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	current.Register()
+	userRepository.Add(current)
+
+	legacy := NewUser(0, "Jane", "Doe", "jane@tld.com")
+	legacy.Credential = Credential{Hash: "$5$rounds=5000$saltsalt$Gcm6FsVtF/Qa77ZKD.iwsJlCVPY0XSMgLJL0Hnww/c1"}
+	userRepository.Add(legacy)
+
+	userRepository.Add(NewUser(0, "Face", "Book", "facebook@tld.com"))
+
+	policy, _ := NewCredentialPolicy("")
+	report := NewCredentialReport(userRepository, policy)
+
+	if report.Total != 3 || report.Current != 1 || report.Legacy != 1 || report.NoPassword != 1 {
+		t.Errorf("Unexpected report: %s", report)
+	}
+
+	if report.Schemes["sha256-crypt"] != 1 {
+		t.Errorf("Expected the legacy hash to be counted by scheme, got %v", report.Schemes)
+	}
+}
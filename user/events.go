@@ -0,0 +1,171 @@
+package user
+
+import "github.com/spear-wind/cms/events"
+
+// Login methods recorded on LoggedInEvent.
+const (
+	LoginMethodPassword = "password"
+	LoginMethodFacebook = "facebook"
+)
+
+// RegisteredEvent is published when someone signs up for an account.
+type RegisteredEvent struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// CreatedEvent is published when an administrator creates a user account on
+// someone else's behalf.
+type CreatedEvent struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// VerifiedEvent is published when a user verifies their email address.
+type VerifiedEvent struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// LoggedInEvent is published each time a user logs in.
+type LoggedInEvent struct {
+	UserID int64  `json:"user_id"`
+	Method string `json:"method"`
+}
+
+// PasswordResetEvent is published when a user sets a new password with a
+// password reset token.
+type PasswordResetEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+// MFAEnabledEvent is published when a user turns on two-factor
+// authentication.
+type MFAEnabledEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+// MFADisabledEvent is published when a user turns off two-factor
+// authentication.
+type MFADisabledEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+// Reasons recorded on LoginFailedEvent.
+const (
+	LoginFailedUnknownUser   = "unknown_user"
+	LoginFailedWrongPassword = "wrong_password"
+	LoginFailedWrongMFACode  = "wrong_mfa_code"
+)
+
+// LoginFailedEvent is a security event published when a password login
+// fails. It deliberately isn't a UserEvent, so the client's address isn't
+// sent to the webhooks or activity streams of the user's sites.
+type LoginFailedEvent struct {
+	UserID int64  `json:"user_id,omitempty"`
+	Email  string `json:"email"`
+	IP     string `json:"ip"`
+	Reason string `json:"reason"`
+	// Locked is set when this failure locked the account or the address.
+	Locked bool `json:"locked"`
+}
+
+func init() {
+	events.RegisterDomainEvent(RegisteredEvent{})
+	events.RegisterDomainEvent(CreatedEvent{})
+	events.RegisterDomainEvent(VerifiedEvent{})
+	events.RegisterDomainEvent(LoggedInEvent{})
+	events.RegisterDomainEvent(PasswordResetEvent{})
+	events.RegisterDomainEvent(MFAEnabledEvent{})
+	events.RegisterDomainEvent(MFADisabledEvent{})
+	events.RegisterDomainEvent(LoginFailedEvent{})
+}
+
+func NewRegisteredEvent(user *User) RegisteredEvent {
+	return RegisteredEvent{UserID: user.ID, Email: user.Email}
+}
+
+func NewCreatedEvent(user *User) CreatedEvent {
+	return CreatedEvent{UserID: user.ID, Email: user.Email}
+}
+
+func NewVerifiedEvent(user *User) VerifiedEvent {
+	return VerifiedEvent{UserID: user.ID, Email: user.Email}
+}
+
+func NewLoggedInEvent(user *User, method string) LoggedInEvent {
+	return LoggedInEvent{UserID: user.ID, Method: method}
+}
+
+func NewPasswordResetEvent(user *User) PasswordResetEvent {
+	return PasswordResetEvent{UserID: user.ID}
+}
+
+func NewMFAEnabledEvent(user *User) MFAEnabledEvent {
+	return MFAEnabledEvent{UserID: user.ID}
+}
+
+func NewMFADisabledEvent(user *User) MFADisabledEvent {
+	return MFADisabledEvent{UserID: user.ID}
+}
+
+// NewLoginFailedEvent records a failed login for email from ip. account is
+// nil when no user has the email.
+func NewLoginFailedEvent(account *User, email string, ip string, locked bool) LoginFailedEvent {
+	if account == nil {
+		return LoginFailedEvent{Email: email, IP: ip, Reason: LoginFailedUnknownUser, Locked: locked}
+	}
+
+	return LoginFailedEvent{UserID: account.ID, Email: email, IP: ip, Reason: LoginFailedWrongPassword, Locked: locked}
+}
+
+// NewMFAFailedEvent records a wrong two-factor code for account from ip.
+func NewMFAFailedEvent(account *User, ip string, locked bool) LoginFailedEvent {
+	return LoginFailedEvent{UserID: account.ID, Email: account.Email, IP: ip, Reason: LoginFailedWrongMFACode, Locked: locked}
+}
+
+func (e RegisteredEvent) EventType() string  { return "user.registered" }
+func (e RegisteredEvent) EventVersion() int  { return 1 }
+func (e RegisteredEvent) EventUserID() int64 { return e.UserID }
+
+func (e CreatedEvent) EventType() string  { return "user.created" }
+func (e CreatedEvent) EventVersion() int  { return 1 }
+func (e CreatedEvent) EventUserID() int64 { return e.UserID }
+
+func (e VerifiedEvent) EventType() string  { return "user.verified" }
+func (e VerifiedEvent) EventVersion() int  { return 1 }
+func (e VerifiedEvent) EventUserID() int64 { return e.UserID }
+
+func (e LoggedInEvent) EventType() string  { return "user.logged_in" }
+func (e LoggedInEvent) EventVersion() int  { return 1 }
+func (e LoggedInEvent) EventUserID() int64 { return e.UserID }
+
+func (e PasswordResetEvent) EventType() string  { return "user.password_reset" }
+func (e PasswordResetEvent) EventVersion() int  { return 1 }
+func (e PasswordResetEvent) EventUserID() int64 { return e.UserID }
+
+func (e MFAEnabledEvent) EventType() string  { return "user.mfa_enabled" }
+func (e MFAEnabledEvent) EventVersion() int  { return 1 }
+func (e MFAEnabledEvent) EventUserID() int64 { return e.UserID }
+
+func (e MFADisabledEvent) EventType() string  { return "user.mfa_disabled" }
+func (e MFADisabledEvent) EventVersion() int  { return 1 }
+func (e MFADisabledEvent) EventUserID() int64 { return e.UserID }
+
+func (e LoginFailedEvent) EventType() string { return "user.login_failed" }
+func (e LoginFailedEvent) EventVersion() int { return 1 }
+
+// Recipient addresses an email to user, in their language.
+func (user *User) Recipient() events.Recipient {
+	return events.Recipient{Email: user.Email, Name: user.FirstName, Locale: user.Locale}
+}
+
+// Actor identifies user as the cause of an event. It returns nil for a nil
+// user, for events with no authenticated caller.
+func (user *User) Actor() *events.Actor {
+	if user == nil {
+		return nil
+	}
+
+	return &events.Actor{UserID: user.ID, Email: user.Email}
+}
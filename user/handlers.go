@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/spear-wind/cms/events"
@@ -16,25 +18,73 @@ import (
 var (
 	errInvalidUserID   = errors.New("Invalid user id")
 	errUserDoesntExist = errors.New("This user doesnt exist")
+	errForbidden       = errors.New("You do not have permission to perform this action")
 )
 
-func InitRoutes(router *mux.Router, formatter *render.Render, userRepository UserRepository, eventPublisher events.EventPublisher) {
-	router.HandleFunc("/user", createUserHandler(formatter, userRepository, eventPublisher)).Methods("POST")
-	router.HandleFunc("/user", getUserListHandler(formatter, userRepository)).Methods("GET")
-	router.HandleFunc("/user/{id}", getUserHandler(formatter, userRepository)).Methods("GET")
+// MFAThrottle limits wrong two-factor codes. auth.LoginThrottle satisfies
+// it, so codes entered here count towards the same per-account limits as
+// codes entered when logging in.
+type MFAThrottle interface {
+	Attempt(email string, ip string) (wait time.Duration, err error)
+	Failed(email string, ip string) (locked bool, err error)
+	Succeeded(email string, ip string) error
+	ClientIP(req *http.Request) string
 }
 
-func createUserHandler(formatter *render.Render, userRepository UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+func InitRoutes(router *mux.Router, formatter *render.Render, userRepository UserRepository, accessPolicy AccessPolicy, throttle MFAThrottle, eventPublisher events.EventPublisher) {
+	router.HandleFunc("/user", createUserHandler(formatter, userRepository, accessPolicy, eventPublisher)).Methods("POST")
+	router.HandleFunc("/user", getUserListHandler(formatter, userRepository, accessPolicy)).Methods("GET")
+	router.HandleFunc("/user/{id}", getUserHandler(formatter, userRepository, accessPolicy)).Methods("GET")
+	router.HandleFunc("/user/me/mfa", getMFAHandler(formatter, accessPolicy)).Methods("GET")
+	router.HandleFunc("/user/me/mfa", disableMFAHandler(formatter, userRepository, accessPolicy, throttle, eventPublisher)).Methods("DELETE")
+	router.HandleFunc("/user/me/mfa/totp", startTOTPEnrollmentHandler(formatter, userRepository)).Methods("POST")
+	router.HandleFunc("/user/me/mfa/totp/confirm", confirmTOTPHandler(formatter, userRepository, eventPublisher)).Methods("POST")
+	router.HandleFunc("/user/me/mfa/recovery-codes", regenerateRecoveryCodesHandler(formatter, userRepository, throttle, eventPublisher)).Methods("POST")
+}
+
+// createUserRequest is what POST /user reads. The account's ID, verification
+// and linked identities are never taken from the request.
+type createUserRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Locale    string `json:"locale"`
+	Password  string `json:"password"`
+}
+
+func (req createUserRequest) newUser() User {
+	return User{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Locale:    req.Locale,
+		Password:  req.Password,
+	}
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func createUserHandler(formatter *render.Render, userRepository UserRepository, accessPolicy AccessPolicy, eventPublisher events.EventPublisher) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
+		if caller := FromContext(req.Context()); caller == nil || !accessPolicy.CanCreateUsers(caller) {
+			formatter.JSON(w, http.StatusForbidden, map[string]interface{}{
+				"error": errForbidden.Error(),
+			})
+			return
+		}
+
 		payload, _ := ioutil.ReadAll(req.Body)
-		var user User
+		var createRequest createUserRequest
 
-		err := json.Unmarshal(payload, &user)
+		err := json.Unmarshal(payload, &createRequest)
 		if err != nil {
 			formatter.Text(w, http.StatusBadRequest, "Failed to parse create user request")
 			return
 		}
 
+		user := createRequest.newUser()
 		if result := user.validate(); result.HasErrors() {
 			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
 				"errors": result.Errors,
@@ -42,22 +92,36 @@ func createUserHandler(formatter *render.Render, userRepository UserRepository,
 			return
 		}
 
+		if err := user.setPassword(user.Password); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
 		if err := userRepository.Add(&user); err != nil {
 			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
 				"user":  user,
 				"error": err.Error(),
 			})
 		} else {
+			events.Publish(eventPublisher, FromContext(req.Context()).Actor(), NewCreatedEvent(&user))
 			w.Header().Add("Location", fmt.Sprintf("/user/%d", user.ID))
 			formatter.JSON(w, http.StatusCreated, user)
-			//TODO newUserCreatedEvent(user)
 		}
 	}
 }
 
-func getUserListHandler(formatter *render.Render, userRepository UserRepository) http.HandlerFunc {
+func getUserListHandler(formatter *render.Render, userRepository UserRepository, accessPolicy AccessPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		users := userRepository.listUsers()
+		caller := FromContext(req.Context())
+		users := []*User{}
+
+		for _, user := range userRepository.listUsers() {
+			if caller != nil && accessPolicy.CanViewUser(caller, user) {
+				users = append(users, user)
+			}
+		}
 
 		formatter.JSON(w, http.StatusOK, map[string]interface{}{
 			"users": users,
@@ -66,7 +130,7 @@ func getUserListHandler(formatter *render.Render, userRepository UserRepository)
 	}
 }
 
-func getUserHandler(formatter *render.Render, userRepository UserRepository) http.HandlerFunc {
+func getUserHandler(formatter *render.Render, userRepository UserRepository, accessPolicy AccessPolicy) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		vars := mux.Vars(req)
 
@@ -78,12 +142,243 @@ func getUserHandler(formatter *render.Render, userRepository UserRepository) htt
 			return
 		}
 
+		caller := FromContext(req.Context())
+
 		if user, err := userRepository.getUser(userID); err != nil {
 			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
 				"error": err.Error(),
 			})
+		} else if caller == nil || !accessPolicy.CanViewUser(caller, user) {
+			formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+				"error": errUserDoesntExist.Error(),
+			})
 		} else {
 			formatter.JSON(w, http.StatusOK, user)
 		}
 	}
 }
+
+func getMFAHandler(formatter *render.Render, accessPolicy AccessPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"enabled":                  caller.MFAEnabled(),
+			"required":                 accessPolicy.RequiresMFA(caller),
+			"recovery_codes_remaining": len(caller.MFA.RecoveryCodes),
+		})
+	}
+}
+
+// startTOTPEnrollmentHandler issues a TOTP secret for the caller to add to
+// an authenticator app. MFA isn't enabled until the secret is confirmed.
+func startTOTPEnrollmentHandler(formatter *render.Render, userRepository UserRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		secret, uri, err := caller.StartTOTPEnrollment()
+		if err == ErrMFAAlreadyEnabled {
+			formatter.JSON(w, http.StatusConflict, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		} else if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := userRepository.Update(caller); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"secret": secret,
+			"uri":    uri,
+		})
+	}
+}
+
+func confirmTOTPHandler(formatter *render.Render, userRepository UserRepository, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var codeRequest mfaCodeRequest
+
+		if err := json.Unmarshal(payload, &codeRequest); err != nil || len(codeRequest.Code) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A code is required")
+			return
+		}
+
+		recoveryCodes, err := caller.ConfirmTOTP(codeRequest.Code)
+		if err != nil {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := userRepository.Update(caller); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, caller.Actor(), NewMFAEnabledEvent(caller))
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+// regenerateRecoveryCodesHandler replaces the caller's recovery codes. It
+// takes a current code, so a stolen access token alone can't be used to
+// get codes that bypass the second factor.
+func regenerateRecoveryCodesHandler(formatter *render.Render, userRepository UserRepository, throttle MFAThrottle, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var codeRequest mfaCodeRequest
+
+		if err := json.Unmarshal(payload, &codeRequest); err != nil || len(codeRequest.Code) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A code is required")
+			return
+		}
+
+		if !verifyMFA(w, req, formatter, caller, codeRequest.Code, throttle, eventPublisher) {
+			return
+		}
+
+		recoveryCodes, err := caller.RegenerateRecoveryCodes()
+		if err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if err := userRepository.Update(caller); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"recovery_codes": recoveryCodes,
+		})
+	}
+}
+
+// disableMFAHandler turns off the caller's two-factor authentication, given
+// a current code, unless one of their sites requires it.
+func disableMFAHandler(formatter *render.Render, userRepository UserRepository, accessPolicy AccessPolicy, throttle MFAThrottle, eventPublisher events.EventPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		caller := FromContext(req.Context())
+		if caller == nil {
+			formatter.JSON(w, http.StatusUnauthorized, struct{ Error string }{"Unauthorized."})
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var codeRequest mfaCodeRequest
+
+		if err := json.Unmarshal(payload, &codeRequest); err != nil || len(codeRequest.Code) == 0 {
+			formatter.Text(w, http.StatusBadRequest, "A code is required")
+			return
+		}
+
+		if accessPolicy.RequiresMFA(caller) {
+			formatter.JSON(w, http.StatusConflict, map[string]interface{}{
+				"error": "A site you belong to requires two-factor authentication",
+			})
+			return
+		}
+
+		if !verifyMFA(w, req, formatter, caller, codeRequest.Code, throttle, eventPublisher) {
+			return
+		}
+
+		caller.DisableMFA()
+
+		if err := userRepository.Update(caller); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		events.Publish(eventPublisher, caller.Actor(), NewMFADisabledEvent(caller))
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// verifyMFA checks code against the caller's second factor, passing wrong
+// codes through throttle as mfaLoginHandler does, so that the account is
+// locked once the limit is reached. It writes the response and returns
+// false when the code is refused.
+func verifyMFA(w http.ResponseWriter, req *http.Request, formatter *render.Render, caller *User, code string, throttle MFAThrottle, eventPublisher events.EventPublisher) bool {
+	ip := throttle.ClientIP(req)
+
+	wait, err := throttle.Attempt(caller.Email, ip)
+	if err != nil {
+		formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	if wait > 0 {
+		fmt.Printf("Security: throttled two-factor code for %s from %s\n", caller.Email, ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		formatter.Text(w, http.StatusTooManyRequests, "Too many failed attempts. Try again later.")
+		return false
+	}
+
+	if err := caller.VerifyMFA(code); err != nil {
+		locked, throttleErr := throttle.Failed(caller.Email, ip)
+		if throttleErr != nil {
+			fmt.Printf("Failed to check the failed two-factor codes for %s from %s: %v\n", caller.Email, ip, throttleErr)
+		}
+
+		event := NewMFAFailedEvent(caller, ip, locked)
+		fmt.Printf("Security: wrong two-factor code for %s from %s (locked: %v)\n", caller.Email, ip, locked)
+		events.Publish(eventPublisher, caller.Actor(), event)
+
+		formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return false
+	}
+
+	if err := throttle.Succeeded(caller.Email, ip); err != nil {
+		fmt.Printf("Failed to clear failed two-factor codes for %s: %v\n", caller.Email, err)
+	}
+
+	return true
+}
@@ -9,9 +9,12 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dave-malone/email"
 	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/mail"
+	"github.com/spear-wind/cms/security"
 	"github.com/unrolled/render"
 )
 
@@ -19,17 +22,72 @@ var (
 	formatter = render.New(render.Options{
 		IndentJSON: true,
 	})
+	admin = NewUser(99, "Site", "Admin", "admin@spearwind.io")
 )
 
+type allowAll struct{}
+
+func (allowAll) CanViewUser(caller *User, target *User) bool { return true }
+func (allowAll) CanCreateUsers(caller *User) bool            { return true }
+func (allowAll) RequiresMFA(user *User) bool                 { return false }
+
+type selfOnly struct{}
+
+func (selfOnly) CanViewUser(caller *User, target *User) bool { return caller.ID == target.ID }
+func (selfOnly) CanCreateUsers(caller *User) bool            { return false }
+func (selfOnly) RequiresMFA(user *User) bool                 { return true }
+
+// lockingThrottle locks an account after limit wrong codes, like
+// auth.LoginThrottle.
+type lockingThrottle struct {
+	limit    int
+	failures map[string]int
+}
+
+func (t *lockingThrottle) Attempt(email string, ip string) (time.Duration, error) {
+	if t.failures[email] >= t.limit {
+		return time.Minute, nil
+	}
+
+	return 0, nil
+}
+
+func (t *lockingThrottle) Failed(email string, ip string) (bool, error) {
+	t.failures[email]++
+	return t.failures[email] >= t.limit, nil
+}
+
+func (t *lockingThrottle) Succeeded(email string, ip string) error {
+	delete(t.failures, email)
+	return nil
+}
+
+func (t *lockingThrottle) ClientIP(req *http.Request) string { return "127.0.0.1" }
+
+func newEmailSubscriber(t *testing.T) events.EventSubscriber {
+	templates, err := mail.LoadRegistry("../email-templates")
+	if err != nil {
+		t.Fatalf("Failed to load email templates: %v", err)
+	}
+
+	return events.NewEmailEventSubscriber(email.NewSender(), templates, "no-reply@spearwind.io")
+}
+
+func asCaller(caller *User, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		next(w, req.WithContext(NewContext(req.Context(), caller)))
+	}
+}
+
 func TestCreateUserHandlerResponseToInvalidData(t *testing.T) {
 	client := &http.Client{}
 	email.NewSender = email.NewNoopSender
 	repo := NewInMemoryRepository()
 
 	eventPublisher := events.NewSynchEventPublisher()
-	eventPublisher.Add(events.NewEmailEventSubscriber(email.NewSender()))
+	eventPublisher.Add(newEmailSubscriber(t))
 
-	server := httptest.NewServer(http.HandlerFunc(createUserHandler(formatter, repo, eventPublisher)))
+	server := httptest.NewServer(asCaller(admin, createUserHandler(formatter, repo, allowAll{}, eventPublisher)))
 	defer server.Close()
 
 	invalidBody := []byte("not even json")
@@ -56,8 +114,8 @@ func TestCreateUserHandlerResponseToBadJson(t *testing.T) {
 	email.NewSender = email.NewNoopSender
 	repo := NewInMemoryRepository()
 	eventPublisher := events.NewSynchEventPublisher()
-	eventPublisher.Add(events.NewEmailEventSubscriber(email.NewSender()))
-	server := httptest.NewServer(http.HandlerFunc(createUserHandler(formatter, repo, eventPublisher)))
+	eventPublisher.Add(newEmailSubscriber(t))
+	server := httptest.NewServer(asCaller(admin, createUserHandler(formatter, repo, allowAll{}, eventPublisher)))
 	defer server.Close()
 
 	badJSON := []byte("{\"test\":\"bad json! bad!\"}")
@@ -83,11 +141,11 @@ func TestCreateUserHandler(t *testing.T) {
 	email.NewSender = email.NewNoopSender
 	repo := NewInMemoryRepository()
 	eventPublisher := events.NewSynchEventPublisher()
-	eventPublisher.Add(events.NewEmailEventSubscriber(email.NewSender()))
-	server := httptest.NewServer(http.HandlerFunc(createUserHandler(formatter, repo, eventPublisher)))
+	eventPublisher.Add(newEmailSubscriber(t))
+	server := httptest.NewServer(asCaller(admin, createUserHandler(formatter, repo, allowAll{}, eventPublisher)))
 	defer server.Close()
 
-	body := []byte("{\"first_name\":\"john\", \"last_name\":\"doe\", \"email\":\"john@doe.com\", \"password\":\"p@$$w3Rd\"}")
+	body := []byte("{\"id\":7, \"first_name\":\"john\", \"last_name\":\"doe\", \"email\":\"john@doe.com\", \"password\":\"p@$$w3Rd\", \"verified\":true, \"fb_id\":\"12345\"}")
 
 	req, err := http.NewRequest("POST", server.URL, bytes.NewBuffer(body))
 	if err != nil {
@@ -158,13 +216,24 @@ func TestCreateUserHandler(t *testing.T) {
 		t.Errorf("Repo user email should be 'john@doe.com', but was %s", users[0].FirstName)
 	}
 
+	if users[0].Verified || len(users[0].FacebookID) != 0 {
+		t.Errorf("Expected verified and fb_id to be ignored, but got %v", users[0])
+	}
+
+	if ok, _ := users[0].Authenticate("p@$$w3Rd"); !ok || len(users[0].Password) != 0 {
+		t.Error("Expected the password to be stored only as a hash")
+	}
+
+	if strings.Contains(string(payload), "p@$$w3Rd") || strings.Contains(string(payload), `"password"`) {
+		t.Errorf("Expected the password to be left out of the response, got %s", payload)
+	}
 }
 
 func TestGetUserListReturnsEmptyArrayForNoUsers(t *testing.T) {
 	client := &http.Client{}
 	email.NewSender = email.NewNoopSender
 	repo := NewInMemoryRepository()
-	server := httptest.NewServer(http.HandlerFunc(getUserListHandler(formatter, repo)))
+	server := httptest.NewServer(asCaller(admin, getUserListHandler(formatter, repo, allowAll{})))
 	defer server.Close()
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
@@ -200,7 +269,7 @@ func TestGetUserListReturnsWhatsInRepository(t *testing.T) {
 	repo.Add(NewUser(-1, "John", "Doe", "john@doe.com"))
 	repo.Add(NewUser(-1, "Jane", "Doe", "jane@doe.com"))
 	repo.Add(NewUser(-1, "Baby", "Doe", "baby@doe.com"))
-	server := httptest.NewServer(http.HandlerFunc(getUserListHandler(formatter, repo)))
+	server := httptest.NewServer(asCaller(admin, getUserListHandler(formatter, repo, allowAll{})))
 	defer server.Close()
 
 	req, _ := http.NewRequest("GET", server.URL, nil)
@@ -228,3 +297,83 @@ func TestGetUserListReturnsWhatsInRepository(t *testing.T) {
 		t.Errorf("Expected exactly three users in the user response, but got %d", len(userListResponse.Users))
 	}
 }
+
+func TestCreateUserHandlerIsForbiddenWithoutPermission(t *testing.T) {
+	repo := NewInMemoryRepository()
+	server := httptest.NewServer(asCaller(admin, createUserHandler(formatter, repo, selfOnly{}, events.NewSynchEventPublisher())))
+	defer server.Close()
+
+	body := []byte("{\"first_name\":\"john\", \"last_name\":\"doe\", \"email\":\"john@doe.com\", \"password\":\"p@$$w3Rd\"}")
+
+	res, err := http.Post(server.URL, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		t.Fatalf("Error in POST to createUserHandler: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected response status 403, received %s", res.Status)
+	}
+
+	if len(repo.listUsers()) != 0 {
+		t.Error("No user should have been created")
+	}
+}
+
+func TestGetUserListOnlyReturnsVisibleUsers(t *testing.T) {
+	repo := NewInMemoryRepository()
+	john := NewUser(-1, "John", "Doe", "john@doe.com")
+	repo.Add(john)
+	repo.Add(NewUser(-1, "Jane", "Doe", "jane@doe.com"))
+	server := httptest.NewServer(asCaller(john, getUserListHandler(formatter, repo, selfOnly{})))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Errored when sending request to the server: %v", err)
+	}
+	defer res.Body.Close()
+
+	var userListResponse userListResponse
+	payload, _ := ioutil.ReadAll(res.Body)
+	if err := json.Unmarshal(payload, &userListResponse); err != nil {
+		t.Fatalf("Could not unmarshal payload into data struct: %v", err)
+	}
+
+	if len(userListResponse.Users) != 1 || userListResponse.Users[0].ID != john.ID {
+		t.Errorf("Expected only the caller to be listed, got %v", userListResponse.Users)
+	}
+}
+
+func TestMFAManagementLocksAccountAfterWrongCodes(t *testing.T) {
+	caller, secret, _ := enrolledUser(t)
+	throttle := &lockingThrottle{limit: 2, failures: map[string]int{}}
+	publisher := events.NewSynchEventPublisher()
+	handlers := []http.HandlerFunc{
+		disableMFAHandler(formatter, NewInMemoryRepository(), allowAll{}, throttle, publisher),
+		regenerateRecoveryCodesHandler(formatter, NewInMemoryRepository(), throttle, publisher),
+	}
+
+	for i, handler := range handlers {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/user/me/mfa", bytes.NewBufferString(`{"code":"000000"}`))
+		asCaller(caller, handler)(recorder, req)
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Fatalf("Expected wrong code %d to be refused, received %d", i+1, recorder.Code)
+		}
+	}
+
+	code, _ := security.TOTPCode(secret, time.Now())
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/user/me/mfa", bytes.NewBufferString(`{"code":"`+code+`"}`))
+	asCaller(caller, handlers[0])(recorder, req)
+
+	if recorder.Code != http.StatusTooManyRequests || len(recorder.Header().Get("Retry-After")) == 0 {
+		t.Errorf("Expected the account to be locked after two wrong codes, received %d", recorder.Code)
+	}
+
+	if !caller.MFAEnabled() {
+		t.Error("Expected MFA to stay on while the account is locked")
+	}
+}
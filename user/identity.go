@@ -0,0 +1,60 @@
+package user
+
+import "time"
+
+// Identity links a user to their account at an external identity provider,
+// such as Google or GitHub.
+type Identity struct {
+	Provider string
+	Subject  string
+}
+
+// IdentitySubject returns the user's ID at provider, or "" if they haven't
+// logged in with it. Facebook IDs are kept in FacebookID, where they were
+// stored before other providers were supported.
+func (user *User) IdentitySubject(provider string) string {
+	if provider == LoginMethodFacebook {
+		return user.FacebookID
+	}
+
+	for _, identity := range user.Identities {
+		if identity.Provider == provider {
+			return identity.Subject
+		}
+	}
+
+	return ""
+}
+
+// LinkIdentity records that subject is the user's ID at provider, replacing
+// any ID they had there before.
+func (user *User) LinkIdentity(provider string, subject string) {
+	if provider == LoginMethodFacebook {
+		user.FacebookID = subject
+		return
+	}
+
+	for i, identity := range user.Identities {
+		if identity.Provider == provider {
+			user.Identities[i].Subject = subject
+			return
+		}
+	}
+
+	user.Identities = append(user.Identities, Identity{Provider: provider, Subject: subject})
+}
+
+// ConfirmEmailByIdentity marks the user verified because an identity
+// provider vouched for their email. A password set before the email was
+// verified is dropped, since whoever chose it never proved they own the
+// address.
+func (user *User) ConfirmEmailByIdentity() {
+	if user.Verified {
+		return
+	}
+
+	user.Verified = true
+	user.VerificationCode = ""
+	user.VerificationIssued = time.Time{}
+	user.Credential = Credential{}
+}
@@ -1,6 +1,9 @@
 package user
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type inMemoryRepository struct {
 	users map[int64]*User
@@ -68,9 +71,9 @@ func (repo *inMemoryRepository) FindByEmail(email string) (user *User) {
 	return user
 }
 
-func (repo *inMemoryRepository) FindByFacebookID(facebookID string) (user *User) {
+func (repo *inMemoryRepository) FindByIdentity(provider string, subject string) (user *User) {
 	for _, target := range repo.users {
-		if target.FacebookID == facebookID {
+		if len(subject) != 0 && target.IdentitySubject(provider) == subject {
 			user = target
 			break
 		}
@@ -79,6 +82,32 @@ func (repo *inMemoryRepository) FindByFacebookID(facebookID string) (user *User)
 	return user
 }
 
+func (repo *inMemoryRepository) FindByPasswordResetToken(token string) (user *User) {
+	digest := passwordResetDigest(token)
+	for _, u := range repo.users {
+		if len(u.passwordResetDigest) != 0 && u.passwordResetDigest == digest {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func (repo *inMemoryRepository) FindPendingVerifications(issuedBefore time.Time) (users []*User) {
+	for _, target := range repo.users {
+		if !target.Verified && target.VerificationPending && target.VerificationIssued.Before(issuedBefore) {
+			users = append(users, target)
+		}
+	}
+
+	return users
+}
+
+func (repo *inMemoryRepository) FindByID(id int64) (user *User) {
+	user, _ = repo.getUser(id)
+	return user
+}
+
 func (repo *inMemoryRepository) Exists(user *User) bool {
 	for _, target := range repo.users {
 		if user.Email == target.Email {
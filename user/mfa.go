@@ -0,0 +1,156 @@
+package user
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spear-wind/cms/security"
+)
+
+const (
+	// TOTPIssuer names the account in authenticator apps.
+	TOTPIssuer = "Spearwind"
+	// RecoveryCodeCount is how many recovery codes are issued at a time.
+	RecoveryCodeCount = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled = errors.New("Two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("Two-factor authentication is not enabled")
+	ErrMFANotEnrolling   = errors.New("Start two-factor authentication enrollment first")
+	ErrInvalidMFACode    = errors.New("Invalid two-factor authentication code")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFA is a user's two-factor authentication setup. A TOTP secret is pending
+// until the user confirms it with a first code, which enables MFA.
+type MFA struct {
+	TOTPSecret string
+	Enabled    bool
+	// LastStep is the TOTP time step of the last code accepted, so that a
+	// code can't be used twice.
+	LastStep int64
+	// RecoveryCodes are SHA-256 digests of the unused recovery codes, which
+	// are shown to the user once and never stored.
+	RecoveryCodes []string
+}
+
+// MFAEnabled reports whether the user must enter a code to log in.
+func (user *User) MFAEnabled() bool {
+	return user.MFA.Enabled
+}
+
+// StartTOTPEnrollment issues a new TOTP secret, replacing any pending one,
+// and returns it along with the otpauth:// URI for authenticator apps.
+func (user *User) StartTOTPEnrollment() (secret string, uri string, err error) {
+	if user.MFA.Enabled {
+		return "", "", ErrMFAAlreadyEnabled
+	}
+
+	if secret, err = security.GenerateTOTPSecret(); err != nil {
+		return "", "", fmt.Errorf("Failed to generate TOTP secret: %v", err)
+	}
+
+	user.MFA = MFA{TOTPSecret: secret}
+	return secret, security.TOTPURI(TOTPIssuer, user.Email, secret), nil
+}
+
+// ConfirmTOTP enables MFA if code is valid for the pending secret, and
+// returns a new set of recovery codes.
+func (user *User) ConfirmTOTP(code string) ([]string, error) {
+	if user.MFA.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	if len(user.MFA.TOTPSecret) == 0 {
+		return nil, ErrMFANotEnrolling
+	}
+
+	step, ok := security.ValidateTOTP(user.MFA.TOTPSecret, normalizeMFACode(code), time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, err := user.RegenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	user.MFA.Enabled = true
+	user.MFA.LastStep = step
+	return codes, nil
+}
+
+// VerifyMFA accepts a TOTP code that hasn't been used before, or an unused
+// recovery code, which is used up. The user must be saved afterwards either
+// way.
+func (user *User) VerifyMFA(code string) error {
+	if !user.MFA.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	code = normalizeMFACode(code)
+
+	if step, ok := security.ValidateTOTP(user.MFA.TOTPSecret, code, time.Now()); ok {
+		if step <= user.MFA.LastStep {
+			return ErrInvalidMFACode
+		}
+
+		user.MFA.LastStep = step
+		return nil
+	}
+
+	digest := recoveryCodeDigest(code)
+	for i, recoveryCode := range user.MFA.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(digest), []byte(recoveryCode)) == 1 {
+			user.MFA.RecoveryCodes = append(user.MFA.RecoveryCodes[:i:i], user.MFA.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+
+	return ErrInvalidMFACode
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes, returning the
+// new ones. They can't be retrieved again.
+func (user *User) RegenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	digests := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b, err := security.GenerateRandomBytes(5)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate recovery codes: %v", err)
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		digests[i] = recoveryCodeDigest(code)
+	}
+
+	user.MFA.RecoveryCodes = digests
+	return codes, nil
+}
+
+// DisableMFA removes the user's TOTP secret and recovery codes.
+func (user *User) DisableMFA() {
+	user.MFA = MFA{}
+}
+
+// normalizeMFACode removes the spaces and dashes people type into codes,
+// and lower cases recovery codes.
+func normalizeMFACode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+func recoveryCodeDigest(code string) string {
+	sum := sha256.Sum256([]byte(normalizeMFACode(code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,88 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spear-wind/cms/security"
+)
+
+func enrolledUser(t *testing.T) (*User, string, []string) {
+	user := NewUser(1, "John", "Doe", "john@tld.com")
+
+	secret, uri, err := user.StartTOTPEnrollment()
+	if err != nil {
+		t.Fatalf("Failed to start enrollment: %v", err)
+	}
+
+	if !strings.HasPrefix(uri, "otpauth://totp/") || user.MFAEnabled() {
+		t.Fatalf("Expected a pending enrollment, got %s", uri)
+	}
+
+	// Confirm with the previous period's code, so that the current one is
+	// still unused.
+	code, _ := security.TOTPCode(secret, time.Now().Add(-security.TOTPPeriod))
+	recoveryCodes, err := user.ConfirmTOTP(code)
+	if err != nil {
+		t.Fatalf("Failed to confirm enrollment: %v", err)
+	}
+
+	return user, secret, recoveryCodes
+}
+
+func TestConfirmTOTPEnablesMFA(t *testing.T) {
+	user, _, recoveryCodes := enrolledUser(t)
+
+	if !user.MFAEnabled() || len(recoveryCodes) != RecoveryCodeCount || len(user.MFA.RecoveryCodes) != RecoveryCodeCount {
+		t.Errorf("Expected MFA to be enabled with %d recovery codes, got %v", RecoveryCodeCount, recoveryCodes)
+	}
+
+	for i, digest := range user.MFA.RecoveryCodes {
+		if digest == recoveryCodes[i] {
+			t.Errorf("Expected recovery codes to be stored as digests")
+		}
+	}
+
+	if _, _, err := user.StartTOTPEnrollment(); err != ErrMFAAlreadyEnabled {
+		t.Errorf("Expected enrolling again to fail, got %v", err)
+	}
+}
+
+func TestVerifyMFARejectsReusedCodes(t *testing.T) {
+	user, secret, _ := enrolledUser(t)
+
+	code, _ := security.TOTPCode(secret, time.Now())
+	if err := user.VerifyMFA(code); err != nil {
+		t.Fatalf("Expected the current code to be accepted, got %v", err)
+	}
+
+	if err := user.VerifyMFA(code); err != ErrInvalidMFACode {
+		t.Errorf("Expected a used code to be rejected, got %v", err)
+	}
+
+	if err := user.VerifyMFA("000000"); err != ErrInvalidMFACode {
+		t.Errorf("Expected a wrong code to be rejected, got %v", err)
+	}
+}
+
+func TestRecoveryCodesWorkOnce(t *testing.T) {
+	user, _, recoveryCodes := enrolledUser(t)
+
+	if err := user.VerifyMFA(strings.ToUpper(recoveryCodes[3])); err != nil {
+		t.Fatalf("Expected a recovery code to be accepted, got %v", err)
+	}
+
+	if err := user.VerifyMFA(recoveryCodes[3]); err != ErrInvalidMFACode {
+		t.Errorf("Expected a used recovery code to be rejected, got %v", err)
+	}
+
+	if len(user.MFA.RecoveryCodes) != RecoveryCodeCount-1 {
+		t.Errorf("Expected one recovery code to be used up, %d remain", len(user.MFA.RecoveryCodes))
+	}
+
+	user.DisableMFA()
+	if err := user.VerifyMFA(recoveryCodes[4]); err != ErrMFANotEnabled {
+		t.Errorf("Expected codes to stop working once MFA is disabled, got %v", err)
+	}
+}
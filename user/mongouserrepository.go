@@ -2,6 +2,7 @@ package user
 
 import (
 	"errors"
+	"time"
 
 	"github.com/cloudnativego/cfmgo"
 	"github.com/cloudnativego/cfmgo/params"
@@ -16,12 +17,28 @@ type userRecord struct {
 	RecordID         bson.ObjectId `bson:"_id,omitempty" json:"id"`
 	UserID           int64         `bson:"user_id",json:"match_id"`
 	FacebookID       string        `bson:"fb_id",json:"fb_id"`
+	Identities       []linkRecord  `bson:"identities,omitempty"`
 	Email            string        `bson:"email",json:"email"`
 	FirstName        string        `bson:"first_name",json:"first_name"`
 	LastName         string        `bson:"last_name",json:"last_name"`
+	Locale           string        `bson:"locale,omitempty"`
 	Hash             string        `bson:"hash",json:"hash"`
+	HashUpdated      time.Time     `bson:"hash_updated,omitempty"`
 	Verified         bool          `bson:"verified",json:"verified"`
 	VerificationCode string        `bson:"verification_code",json:"verification_code"`
+	CodeIssued       time.Time     `bson:"verification_issued,omitempty"`
+	CodePending      bool          `bson:"verification_pending,omitempty"`
+	ResetDigest      string        `bson:"password_reset_digest,omitempty"`
+	ResetExpires     time.Time     `bson:"password_reset_expires,omitempty"`
+	TOTPSecret       string        `bson:"totp_secret,omitempty"`
+	MFAEnabled       bool          `bson:"mfa_enabled,omitempty"`
+	TOTPLastStep     int64         `bson:"totp_last_step,omitempty"`
+	RecoveryCodes    []string      `bson:"recovery_codes,omitempty"`
+}
+
+type linkRecord struct {
+	Provider string `bson:"provider"`
+	Subject  string `bson:"subject"`
 }
 
 func NewMongoUserRepository(col cfmgo.Collection) *mongoUserRepository {
@@ -128,9 +145,12 @@ func (repo *mongoUserRepository) FindByEmail(email string) (user *User) {
 	return
 }
 
-func (repo *mongoUserRepository) FindByFacebookID(facebookID string) (user *User) {
+func (repo *mongoUserRepository) FindByIdentity(provider string, subject string) (user *User) {
 	var users []userRecord
-	query := bson.M{"facebook_id": facebookID}
+	query := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+	if provider == LoginMethodFacebook {
+		query = bson.M{"fb_id": subject}
+	}
 	params := &params.RequestParams{
 		Q: query,
 	}
@@ -146,6 +166,51 @@ func (repo *mongoUserRepository) FindByFacebookID(facebookID string) (user *User
 	return
 }
 
+func (repo *mongoUserRepository) FindByPasswordResetToken(token string) (user *User) {
+	var users []userRecord
+	query := bson.M{"password_reset_digest": passwordResetDigest(token)}
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	count, err := repo.Collection.Find(params, &users)
+	if count == 0 {
+		err = errors.New("User not found")
+	}
+	if err == nil {
+		user = toUser(&users[0])
+	}
+
+	return
+}
+
+func (repo *mongoUserRepository) FindPendingVerifications(issuedBefore time.Time) (users []*User) {
+	repo.Collection.Wake()
+	var records []userRecord
+	params := &params.RequestParams{
+		Q: bson.M{
+			"verified":             false,
+			"verification_pending": true,
+			"verification_issued":  bson.M{"$lt": issuedBefore},
+		},
+	}
+
+	if _, err := repo.Collection.Find(params, &records); err != nil {
+		return users
+	}
+
+	for i := range records {
+		users = append(users, toUser(&records[i]))
+	}
+
+	return users
+}
+
+func (repo *mongoUserRepository) FindByID(id int64) (user *User) {
+	user, _ = repo.getUser(id)
+	return user
+}
+
 func (repo *mongoUserRepository) Exists(user *User) bool {
 	ur, err := repo.getMongoUser(user.ID)
 	return err == nil && ur != nil
@@ -156,26 +221,65 @@ func toUserRecord(u *User) (ur *userRecord) {
 		RecordID:         bson.NewObjectId(),
 		UserID:           u.ID,
 		FacebookID:       u.FacebookID,
+		Identities:       toLinkRecords(u.Identities),
 		Email:            u.Email,
 		FirstName:        u.FirstName,
 		LastName:         u.LastName,
-		Hash:             u.hash,
+		Locale:           u.Locale,
+		Hash:             u.Credential.Hash,
+		HashUpdated:      u.Credential.Updated,
 		Verified:         u.Verified,
 		VerificationCode: u.VerificationCode,
+		CodeIssued:       u.VerificationIssued,
+		CodePending:      u.VerificationPending,
+		ResetDigest:      u.passwordResetDigest,
+		ResetExpires:     u.passwordResetExpires,
+		TOTPSecret:       u.MFA.TOTPSecret,
+		MFAEnabled:       u.MFA.Enabled,
+		TOTPLastStep:     u.MFA.LastStep,
+		RecoveryCodes:    u.MFA.RecoveryCodes,
 	}
 	return
 }
 
 func toUser(ur *userRecord) (u *User) {
 	u = &User{
-		ID:               ur.UserID,
-		FacebookID:       ur.FacebookID,
-		Email:            ur.Email,
-		FirstName:        ur.FirstName,
-		LastName:         ur.LastName,
-		hash:             ur.Hash,
-		Verified:         ur.Verified,
-		VerificationCode: ur.VerificationCode,
+		ID:                   ur.UserID,
+		FacebookID:           ur.FacebookID,
+		Identities:           toIdentities(ur.Identities),
+		Email:                ur.Email,
+		FirstName:            ur.FirstName,
+		LastName:             ur.LastName,
+		Locale:               ur.Locale,
+		Credential:           Credential{Hash: ur.Hash, Updated: ur.HashUpdated},
+		Verified:             ur.Verified,
+		VerificationCode:     ur.VerificationCode,
+		VerificationIssued:   ur.CodeIssued,
+		VerificationPending:  ur.CodePending,
+		passwordResetDigest:  ur.ResetDigest,
+		passwordResetExpires: ur.ResetExpires,
+		MFA: MFA{
+			TOTPSecret:    ur.TOTPSecret,
+			Enabled:       ur.MFAEnabled,
+			LastStep:      ur.TOTPLastStep,
+			RecoveryCodes: ur.RecoveryCodes,
+		},
 	}
 	return
 }
+
+func toLinkRecords(identities []Identity) (records []linkRecord) {
+	for _, identity := range identities {
+		records = append(records, linkRecord{Provider: identity.Provider, Subject: identity.Subject})
+	}
+
+	return
+}
+
+func toIdentities(records []linkRecord) (identities []Identity) {
+	for _, record := range records {
+		identities = append(identities, Identity{Provider: record.Provider, Subject: record.Subject})
+	}
+
+	return
+}
@@ -1,8 +1,12 @@
 package user
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/spear-wind/cms/security"
 	"github.com/spear-wind/cms/validator"
@@ -17,19 +21,85 @@ type UserRepository interface {
 	Exists(user *User) bool
 	FindByEmail(emailAddress string) (user *User)
 	FindByVerificationCode(verificationCode string) (user *User)
-	FindByFacebookID(facebookID string) (user *User)
+	FindByIdentity(provider string, subject string) (user *User)
+	FindByID(id int64) (user *User)
+	FindByPasswordResetToken(token string) (user *User)
+	// FindPendingVerifications returns unverified users whose verification
+	// code was issued before issuedBefore but whose email hasn't been saved.
+	FindPendingVerifications(issuedBefore time.Time) (users []*User)
+}
+
+// PasswordResetTokenTTL is how long a password reset token remains valid.
+const PasswordResetTokenTTL = time.Hour
+
+var ErrInvalidPasswordResetToken = errors.New("Invalid or expired password reset token")
+
+// VerificationCodeTTL is how long a verification code remains valid. It is
+// set from VERIFICATION_CODE_TTL at startup.
+var VerificationCodeTTL = 24 * time.Hour
+
+// VerificationResendInterval is how long a user must wait before another
+// verification email is sent to them.
+const VerificationResendInterval = time.Minute
+
+var (
+	ErrInvalidVerificationCode = errors.New("Invalid Verification Code")
+	ErrVerificationCodeExpired = errors.New("This verification code has expired; request a new one")
+	ErrAlreadyVerified         = errors.New("This user has already been verified")
+)
+
+// AccessPolicy decides which users a caller may see and create, and whether
+// their sites oblige them to keep two-factor authentication on.
+type AccessPolicy interface {
+	CanViewUser(caller *User, target *User) bool
+	CanCreateUsers(caller *User) bool
+	// RequiresMFA reports whether a site the user belongs to requires its
+	// members to use two-factor authentication.
+	RequiresMFA(user *User) bool
 }
 
 type User struct {
-	ID               int64  `json:"id"`
-	FacebookID       string `json:"fb_id"`
-	Email            string `json:"email"`
-	FirstName        string `json:"first_name"`
-	LastName         string `json:"last_name"`
-	Password         string `json:"password,omitempty"`
-	hash             string
-	Verified         bool   `json:"verified"`
-	VerificationCode string `json:"-"`
+	ID         int64      `json:"id"`
+	FacebookID string     `json:"fb_id"`
+	Identities []Identity `json:"-"`
+	Email      string     `json:"email"`
+	FirstName  string     `json:"first_name"`
+	LastName   string     `json:"last_name"`
+	Locale     string     `json:"locale,omitempty"`
+	// Password is the plaintext given when the user is created, until
+	// setPassword hashes it into Credential. It is never serialized; use
+	// PasswordRequest to read it from a request.
+	Password         string     `json:"-"`
+	Credential       Credential `json:"-"`
+	MFA              MFA        `json:"-"`
+	Verified         bool       `json:"verified"`
+	VerificationCode string     `json:"-"`
+	// VerificationIssued is when VerificationCode was issued; the code
+	// expires VerificationCodeTTL later.
+	VerificationIssued time.Time `json:"-"`
+	// VerificationPending is set until the email carrying VerificationCode
+	// has been saved. It is stored with the user, so an email that fails to
+	// save after the user does is still sent by a VerificationSweeper.
+	VerificationPending bool `json:"-"`
+	// passwordResetDigest is the SHA-256 of the outstanding reset token, so
+	// a leaked user record cannot be used to reset the password.
+	passwordResetDigest  string
+	passwordResetExpires time.Time
+}
+
+// PasswordRequest decodes a User from a request body along with the
+// password, which User itself never reads or writes.
+type PasswordRequest struct {
+	User
+	Password string `json:"password"`
+}
+
+// NewUser returns the decoded user with its password set, ready for
+// Register or setPassword.
+func (req PasswordRequest) NewUser() User {
+	user := req.User
+	user.Password = req.Password
+	return user
 }
 
 type userListResponse struct {
@@ -80,59 +150,146 @@ func (user *User) Register() (validator.ValidationResult, error) {
 	}
 
 	if user.Verified == true {
-		return result, errors.New("This user has already been verified")
+		return result, ErrAlreadyVerified
 	}
 
-	hash, err := passlib.Hash(user.Password)
-	if err != nil {
-		return result, fmt.Errorf("Failed to hash password: %v", err)
+	if _, err := user.RotateVerificationCode(); err != nil {
+		return result, err
+	}
+
+	if err := user.setPassword(user.Password); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// RotateVerificationCode issues a new verification code, replacing any code
+// issued before it.
+func (user *User) RotateVerificationCode() (string, error) {
+	if user.Verified == true {
+		return "", ErrAlreadyVerified
 	}
 
 	verificationCode, err := security.GenerateRandomString(24)
 	if err != nil {
-		return result, fmt.Errorf("Failed to generate verification code: %v", err)
+		return "", fmt.Errorf("Failed to generate verification code: %v", err)
 	}
 
-	user.hash = hash
-	user.Password = ""
 	user.VerificationCode = verificationCode
+	user.VerificationIssued = time.Now()
+	user.VerificationPending = true
 
-	return result, nil
+	return verificationCode, nil
 }
 
+// CanResendVerification reports whether enough time has passed since the
+// last verification code was issued to send another.
+func (user *User) CanResendVerification() bool {
+	return !user.Verified && time.Since(user.VerificationIssued) >= VerificationResendInterval
+}
+
+// Verify marks the user verified if verificationCode is their current code
+// and hasn't expired. Codes issued before they were given an issue time are
+// treated as expired.
 func (user *User) Verify(verificationCode string) error {
 	if user.Verified == true {
-		return errors.New("This user has already been verified")
+		return ErrAlreadyVerified
+	}
+
+	if len(user.VerificationCode) == 0 || user.VerificationCode != verificationCode {
+		return ErrInvalidVerificationCode
 	}
 
-	if user.VerificationCode != verificationCode {
-		return errors.New("Invalid Verification Code")
+	if time.Now().After(user.VerificationIssued.Add(VerificationCodeTTL)) {
+		return ErrVerificationCodeExpired
 	}
 
 	user.VerificationCode = ""
+	user.VerificationIssued = time.Time{}
 	user.Verified = true
 
 	return nil
 }
 
+// Authenticate reports whether password is the user's password, and whether
+// the user's hash was upgraded to the current CredentialPolicy while checking
+// it, in which case the user should be saved.
 func (user *User) Authenticate(password string) (bool, bool) {
-	newHash, err := passlib.Verify(password, user.hash)
+	newHash, err := passlib.Verify(password, user.Credential.Hash)
 	if err != nil {
-		fmt.Printf("passlib.Verify resulted in an error: %v", err)
 		// incorrect password, malformed hash, etc.
 		// either way, reject
 		return false, false
 	}
 
-	hashUpdated := false
-	// The context has decided, as per its policy, that
-	// the hash which was used to validate the password
-	// should be changed. It has upgraded the hash using
-	// the verified password.
-	if newHash != "" {
-		user.Password = newHash
-		hashUpdated = true
+	// passlib returns a new hash when its own defaults want the old one
+	// replaced; the policy may also ask for a stronger scheme or cost.
+	if len(newHash) == 0 {
+		policy, err := currentCredentialPolicy()
+		if err != nil || !policy.NeedsUpgrade(user.Credential) {
+			return true, false
+		}
+
+		if newHash, err = passlib.Hash(password); err != nil {
+			fmt.Printf("Failed to upgrade the password hash of user %d: %v\n", user.ID, err)
+			return true, false
+		}
+	}
+
+	user.Credential = newCredential(newHash)
+	return true, true
+}
+
+// StartPasswordReset issues a new single-use password reset token, replacing
+// any token issued before it. Only a digest of the token is kept on the user.
+func (user *User) StartPasswordReset() (string, error) {
+	token, err := security.GenerateRandomString(24)
+	if err != nil {
+		return "", fmt.Errorf("Failed to generate password reset token: %v", err)
+	}
+
+	user.passwordResetDigest = passwordResetDigest(token)
+	user.passwordResetExpires = time.Now().Add(PasswordResetTokenTTL)
+
+	return token, nil
+}
+
+// ResetPassword sets a new password if token is the user's outstanding,
+// unexpired reset token. The token cannot be used again afterwards.
+func (user *User) ResetPassword(token string, password string) error {
+	if len(user.passwordResetDigest) == 0 || time.Now().After(user.passwordResetExpires) {
+		return ErrInvalidPasswordResetToken
+	}
+
+	digest := passwordResetDigest(token)
+	if subtle.ConstantTimeCompare([]byte(digest), []byte(user.passwordResetDigest)) != 1 {
+		return ErrInvalidPasswordResetToken
+	}
+
+	if len(password) == 0 {
+		return errors.New("Password is required")
 	}
 
-	return true, hashUpdated
+	return user.setPassword(password)
+}
+
+// setPassword hashes password and invalidates any outstanding reset token.
+func (user *User) setPassword(password string) error {
+	hash, err := passlib.Hash(password)
+	if err != nil {
+		return fmt.Errorf("Failed to hash password: %v", err)
+	}
+
+	user.Credential = newCredential(hash)
+	user.Password = ""
+	user.passwordResetDigest = ""
+	user.passwordResetExpires = time.Time{}
+
+	return nil
+}
+
+func passwordResetDigest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
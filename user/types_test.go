@@ -1,6 +1,9 @@
 package user
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestValidateWithEmptyRequiredFieldsFailsWithErrors(t *testing.T) {
 	user := NewUser(-1, "", "", "")
@@ -53,8 +56,8 @@ func TestRegister(t *testing.T) {
 		t.Error("user.VerificationCode should not be blank")
 	}
 
-	if user.hash == "" {
-		t.Error("user.hash should not be blank")
+	if user.Credential.Hash == "" {
+		t.Error("user.Credential.Hash should not be blank")
 	}
 
 	if user.Verified {
@@ -72,8 +75,9 @@ func TestVerifyUnverifiedUser(t *testing.T) {
 		Password:         "",
 		VerificationCode: verificationCode,
 		Verified:         false,
-		hash:             "hashed-password",
+		Credential:       Credential{Hash: "hashed-password"},
 	}
+	user.VerificationIssued = time.Now()
 
 	err := user.Verify(verificationCode)
 
@@ -100,7 +104,7 @@ func TestVerifyVerifiedUserReturnsError(t *testing.T) {
 		Password:         "",
 		VerificationCode: verificationCode,
 		Verified:         true,
-		hash:             "hashed-password",
+		Credential:       Credential{Hash: "hashed-password"},
 	}
 
 	err := user.Verify(verificationCode)
@@ -118,7 +122,7 @@ func TestVerifyWithInvalidVerificationCodeReturnsError(t *testing.T) {
 		Password:         "",
 		VerificationCode: "ABC123",
 		Verified:         false,
-		hash:             "hashed-password",
+		Credential:       Credential{Hash: "hashed-password"},
 	}
 
 	err := user.Verify("321CBA")
@@ -164,3 +168,101 @@ func TestAuthenticate(t *testing.T) {
 		t.Errorf("Call to user.Authenticate resulted in newHash == true; we'll need to update this in the DB or next auth attempt will fail")
 	}
 }
+
+func TestResetPasswordIsSingleUse(t *testing.T) {
+	user := User{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john@tld.com",
+		Password:  "p@$$w0rd",
+	}
+	user.Register()
+
+	token, err := user.StartPasswordReset()
+	if err != nil {
+		t.Fatalf("user.StartPasswordReset returned an unexpected error: %v", err)
+	}
+
+	if user.passwordResetDigest == token {
+		t.Error("The password reset token should not be stored in plain text")
+	}
+
+	if err := user.ResetPassword("not-the-token", "n3w-p@$$w0rd"); err != ErrInvalidPasswordResetToken {
+		t.Errorf("Expected an invalid token to be rejected, got %v", err)
+	}
+
+	if err := user.ResetPassword(token, "n3w-p@$$w0rd"); err != nil {
+		t.Fatalf("user.ResetPassword returned an unexpected error: %v", err)
+	}
+
+	if ok, _ := user.Authenticate("n3w-p@$$w0rd"); !ok {
+		t.Error("Expected the new password to authenticate")
+	}
+
+	if err := user.ResetPassword(token, "an0ther-p@$$w0rd"); err != ErrInvalidPasswordResetToken {
+		t.Errorf("Expected a used token to be rejected, got %v", err)
+	}
+}
+
+func TestResetPasswordWithExpiredTokenReturnsError(t *testing.T) {
+	user := User{}
+
+	token, _ := user.StartPasswordReset()
+	user.passwordResetExpires = time.Now().Add(-time.Minute)
+
+	if err := user.ResetPassword(token, "n3w-p@$$w0rd"); err != ErrInvalidPasswordResetToken {
+		t.Errorf("Expected an expired token to be rejected, got %v", err)
+	}
+}
+
+func TestChangingPasswordInvalidatesResetToken(t *testing.T) {
+	user := User{}
+
+	token, _ := user.StartPasswordReset()
+	user.setPassword("n3w-p@$$w0rd")
+
+	if err := user.ResetPassword(token, "an0ther-p@$$w0rd"); err != ErrInvalidPasswordResetToken {
+		t.Errorf("Expected a password change to invalidate the reset token, got %v", err)
+	}
+}
+
+func TestVerifyWithExpiredVerificationCodeReturnsError(t *testing.T) {
+	user := User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	user.Register()
+	user.VerificationIssued = time.Now().Add(-VerificationCodeTTL - time.Minute)
+
+	if err := user.Verify(user.VerificationCode); err != ErrVerificationCodeExpired {
+		t.Errorf("Expected an expired code to be rejected, got %v", err)
+	}
+
+	if user.Verified {
+		t.Errorf("user.Verified should be false after a failed verification")
+	}
+}
+
+func TestRotateVerificationCodeReplacesOldCode(t *testing.T) {
+	user := User{FirstName: "John", LastName: "Doe", Email: "john@tld.com", Password: "p@$$w0rd"}
+	user.Register()
+	oldCode := user.VerificationCode
+
+	if user.CanResendVerification() {
+		t.Errorf("Expected a resend straight after registering to be throttled")
+	}
+
+	newCode, err := user.RotateVerificationCode()
+	if err != nil {
+		t.Fatalf("Failed to rotate verification code: %v", err)
+	}
+
+	if err := user.Verify(oldCode); err != ErrInvalidVerificationCode {
+		t.Errorf("Expected the old code to be rejected, got %v", err)
+	}
+
+	if err := user.Verify(newCode); err != nil {
+		t.Errorf("Expected the new code to verify the user, got %v", err)
+	}
+
+	if _, err := user.RotateVerificationCode(); err != ErrAlreadyVerified {
+		t.Errorf("Expected a verified user not to get a new code, got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/membership"
+	"github.com/unrolled/render"
+)
+
+// deliveryListLimit is the default number of deliveries returned per page of
+// the delivery log.
+const deliveryListLimit = 50
+
+type webhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func InitRoutes(router *mux.Router, formatter *render.Render, webhookRepository WebhookRepository, deliveryRepository DeliveryRepository, authorizer *membership.Authorizer) {
+	authorize := authorizer.Require(membership.PermissionManageSite)
+
+	router.HandleFunc("/site/{id}/webhooks", authorize(getWebhookListHandler(formatter, webhookRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}/webhooks", authorize(createWebhookHandler(formatter, webhookRepository))).Methods("POST")
+	router.HandleFunc("/site/{id}/webhooks/{webhookID}", authorize(getWebhookHandler(formatter, webhookRepository))).Methods("GET")
+	router.HandleFunc("/site/{id}/webhooks/{webhookID}", authorize(updateWebhookHandler(formatter, webhookRepository))).Methods("PUT")
+	router.HandleFunc("/site/{id}/webhooks/{webhookID}", authorize(deleteWebhookHandler(formatter, webhookRepository))).Methods("DELETE")
+	router.HandleFunc("/site/{id}/webhooks/{webhookID}/deliveries", authorize(getDeliveryListHandler(formatter, webhookRepository, deliveryRepository))).Methods("GET")
+}
+
+func getWebhookListHandler(formatter *render.Render, webhookRepository WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		webhooks := webhookRepository.ListBySite(mux.Vars(req)["id"])
+		for _, webhook := range webhooks {
+			webhook.Secret = ""
+		}
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"webhooks": webhooks,
+			"total":    len(webhooks),
+		})
+	}
+}
+
+// createWebhookHandler is the only handler that returns the webhook's secret,
+// so callers must store it when the webhook is created.
+func createWebhookHandler(formatter *render.Render, webhookRepository WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		siteID := mux.Vars(req)["id"]
+		payload, _ := ioutil.ReadAll(req.Body)
+		var request webhookRequest
+
+		if err := json.Unmarshal(payload, &request); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse create webhook request")
+			return
+		}
+
+		webhook := NewWebhook(siteID, request.URL, request.Events)
+		if request.Active != nil {
+			webhook.Active = *request.Active
+		}
+
+		if result := webhook.validate(); result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if err := webhook.resetSecret(); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": "Failed to generate webhook secret",
+			})
+			return
+		}
+
+		if err := webhookRepository.Add(webhook); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.Header().Add("Location", fmt.Sprintf("/site/%v/webhooks/%v", siteID, webhook.ID))
+		formatter.JSON(w, http.StatusCreated, webhook)
+	}
+}
+
+func getWebhookHandler(formatter *render.Render, webhookRepository WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		webhook, ok := findWebhook(w, req, formatter, webhookRepository)
+		if !ok {
+			return
+		}
+
+		webhook.Secret = ""
+		formatter.JSON(w, http.StatusOK, webhook)
+	}
+}
+
+func updateWebhookHandler(formatter *render.Render, webhookRepository WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		webhook, ok := findWebhook(w, req, formatter, webhookRepository)
+		if !ok {
+			return
+		}
+
+		payload, _ := ioutil.ReadAll(req.Body)
+		var request webhookRequest
+
+		if err := json.Unmarshal(payload, &request); err != nil {
+			formatter.Text(w, http.StatusBadRequest, "Failed to parse update webhook request")
+			return
+		}
+
+		webhook.URL = request.URL
+		webhook.Events = request.Events
+		if request.Active != nil {
+			webhook.Active = *request.Active
+		}
+
+		if result := webhook.validate(); result.HasErrors() {
+			formatter.JSON(w, http.StatusBadRequest, map[string]interface{}{
+				"errors": result.Errors,
+			})
+			return
+		}
+
+		if err := webhookRepository.Update(webhook); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		webhook.Secret = ""
+		formatter.JSON(w, http.StatusOK, webhook)
+	}
+}
+
+func deleteWebhookHandler(formatter *render.Render, webhookRepository WebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		webhook, ok := findWebhook(w, req, formatter, webhookRepository)
+		if !ok {
+			return
+		}
+
+		if err := webhookRepository.Delete(webhook); err != nil {
+			formatter.JSON(w, http.StatusInternalServerError, map[string]interface{}{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func getDeliveryListHandler(formatter *render.Render, webhookRepository WebhookRepository, deliveryRepository DeliveryRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		webhook, ok := findWebhook(w, req, formatter, webhookRepository)
+		if !ok {
+			return
+		}
+
+		limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 || limit > deliveryListLimit {
+			limit = deliveryListLimit
+		}
+
+		deliveries := deliveryRepository.ListByWebhook(webhook.ID, limit)
+
+		formatter.JSON(w, http.StatusOK, map[string]interface{}{
+			"deliveries": deliveries,
+			"total":      len(deliveries),
+		})
+	}
+}
+
+func findWebhook(w http.ResponseWriter, req *http.Request, formatter *render.Render, webhookRepository WebhookRepository) (*Webhook, bool) {
+	vars := mux.Vars(req)
+	webhook, err := webhookRepository.Get(vars["id"], vars["webhookID"])
+	if err != nil {
+		formatter.JSON(w, http.StatusNotFound, map[string]interface{}{
+			"error": err.Error(),
+		})
+		return nil, false
+	}
+
+	return webhook, true
+}
@@ -0,0 +1,164 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/user"
+	"github.com/unrolled/render"
+)
+
+var (
+	formatter = render.New(render.Options{
+		IndentJSON: true,
+	})
+)
+
+// newTestServer serves the webhook routes with caller injected into every
+// request context, standing in for auth.ResolveCaller.
+func newTestServer(webhookRepository WebhookRepository, deliveryRepository DeliveryRepository, membershipRepository membership.MembershipRepository, caller *user.User) *httptest.Server {
+	router := mux.NewRouter()
+	InitRoutes(router, formatter, webhookRepository, deliveryRepository, membership.NewAuthorizer(formatter, membershipRepository, nil))
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		router.ServeHTTP(w, req.WithContext(user.NewContext(req.Context(), caller)))
+	}))
+}
+
+func doRequest(t *testing.T, method string, url string, body string) (*http.Response, []byte) {
+	req, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Add("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error in %s to %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+	payload, _ := ioutil.ReadAll(res.Body)
+	return res, payload
+}
+
+func TestCreateAndListWebhooks(t *testing.T) {
+	admin := user.NewUser(1, "Site", "Admin", "admin@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", admin.ID, membership.RoleAdmin))
+	webhookRepository := NewInMemoryWebhookRepository()
+
+	server := newTestServer(webhookRepository, NewInMemoryDeliveryRepository(), membershipRepository, admin)
+	defer server.Close()
+
+	if res, _ := doRequest(t, "POST", server.URL+"/site/1/webhooks", `{"url":"not a url"}`); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid URL to be rejected, received %s", res.Status)
+	}
+
+	res, body := doRequest(t, "POST", server.URL+"/site/1/webhooks", `{"url":"https://example.com/hook","events":["site.created"]}`)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected response status 201, received %s", res.Status)
+	}
+
+	var created Webhook
+	json.Unmarshal(body, &created)
+	if len(created.Secret) == 0 || !created.Active || created.SiteID != "1" {
+		t.Errorf("Expected an active webhook with a secret, got %+v", created)
+	}
+
+	res, body = doRequest(t, "GET", server.URL+"/site/1/webhooks", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s", res.Status)
+	}
+
+	var list struct {
+		Webhooks []Webhook `json:"webhooks"`
+	}
+	json.Unmarshal(body, &list)
+	if len(list.Webhooks) != 1 || len(list.Webhooks[0].Secret) != 0 {
+		t.Errorf("Expected one webhook listed without its secret, got %+v", list.Webhooks)
+	}
+
+	if stored, _ := webhookRepository.Get("1", created.ID); stored == nil || stored.Secret != created.Secret {
+		t.Errorf("Expected listing webhooks not to clear the stored secret")
+	}
+}
+
+func TestUpdateAndDeleteWebhook(t *testing.T) {
+	owner := user.NewUser(1, "Site", "Owner", "owner@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", owner.ID, membership.RoleOwner))
+	webhookRepository := NewInMemoryWebhookRepository()
+	webhook := NewWebhook("1", "https://example.com/hook", nil)
+	webhookRepository.Add(webhook)
+
+	server := newTestServer(webhookRepository, NewInMemoryDeliveryRepository(), membershipRepository, owner)
+	defer server.Close()
+
+	if res, _ := doRequest(t, "GET", server.URL+"/site/2/webhooks/"+webhook.ID, ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected webhooks of another site to be forbidden, received %s", res.Status)
+	}
+
+	res, _ := doRequest(t, "PUT", server.URL+"/site/1/webhooks/"+webhook.ID, `{"url":"https://example.com/new","active":false}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s", res.Status)
+	}
+
+	if updated, _ := webhookRepository.Get("1", webhook.ID); updated.URL != "https://example.com/new" || updated.Active {
+		t.Errorf("Expected the webhook to be updated and deactivated, got %+v", updated)
+	}
+
+	if res, _ := doRequest(t, "DELETE", server.URL+"/site/1/webhooks/"+webhook.ID, ""); res.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected response status 204, received %s", res.Status)
+	}
+
+	if res, _ := doRequest(t, "GET", server.URL+"/site/1/webhooks/"+webhook.ID, ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a deleted webhook to be gone, received %s", res.Status)
+	}
+}
+
+func TestWebhooksRequireSiteManagement(t *testing.T) {
+	editor := user.NewUser(2, "Site", "Editor", "editor@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", editor.ID, membership.RoleEditor))
+
+	server := newTestServer(NewInMemoryWebhookRepository(), NewInMemoryDeliveryRepository(), membershipRepository, editor)
+	defer server.Close()
+
+	if res, _ := doRequest(t, "GET", server.URL+"/site/1/webhooks", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an editor to be forbidden from viewing webhooks, received %s", res.Status)
+	}
+
+	if res, _ := doRequest(t, "POST", server.URL+"/site/1/webhooks", `{"url":"https://example.com/hook"}`); res.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an editor to be forbidden from creating webhooks, received %s", res.Status)
+	}
+}
+
+func TestGetDeliveryList(t *testing.T) {
+	admin := user.NewUser(1, "Site", "Admin", "admin@spearwind.io")
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", admin.ID, membership.RoleAdmin))
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+	webhook := NewWebhook("1", "https://example.com/hook", nil)
+	webhookRepository.Add(webhook)
+	for attempt := 1; attempt <= 3; attempt++ {
+		deliveryRepository.Add(&Delivery{WebhookID: webhook.ID, EventID: "event", Attempt: attempt})
+	}
+
+	server := newTestServer(webhookRepository, deliveryRepository, membershipRepository, admin)
+	defer server.Close()
+
+	res, body := doRequest(t, "GET", server.URL+"/site/1/webhooks/"+webhook.ID+"/deliveries?limit=2", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected response status 200, received %s", res.Status)
+	}
+
+	var list struct {
+		Deliveries []Delivery `json:"deliveries"`
+	}
+	json.Unmarshal(body, &list)
+	if len(list.Deliveries) != 2 || list.Deliveries[0].Attempt != 3 {
+		t.Errorf("Expected the two most recent deliveries, got %+v", list.Deliveries)
+	}
+}
@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type inMemoryDeliveryRepository struct {
+	mutex      sync.RWMutex
+	deliveries []Delivery
+}
+
+func NewInMemoryDeliveryRepository() *inMemoryDeliveryRepository {
+	return &inMemoryDeliveryRepository{}
+}
+
+func (repo *inMemoryDeliveryRepository) Add(delivery *Delivery) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	delivery.ID = fmt.Sprintf("%d", len(repo.deliveries)+1)
+	repo.deliveries = append(repo.deliveries, *delivery)
+	return err
+}
+
+func (repo *inMemoryDeliveryRepository) ListByWebhook(webhookID string, limit int) (deliveries []*Delivery) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for i := len(repo.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if repo.deliveries[i].WebhookID == webhookID {
+			delivery := repo.deliveries[i]
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	return deliveries
+}
+
+func (repo *inMemoryDeliveryRepository) ListDue(now time.Time, limit int) (deliveries []*Delivery) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for i := range repo.deliveries {
+		if next := repo.deliveries[i].NextAttempt; next != nil && !next.After(now) {
+			delivery := repo.deliveries[i]
+			deliveries = append(deliveries, &delivery)
+		}
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttempt.Before(*deliveries[j].NextAttempt)
+	})
+
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+
+	return deliveries
+}
+
+func (repo *inMemoryDeliveryRepository) ClaimRetry(id string) (claimed bool, err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for i := range repo.deliveries {
+		if repo.deliveries[i].ID == id {
+			claimed = repo.deliveries[i].NextAttempt != nil
+			repo.deliveries[i].NextAttempt = nil
+			repo.deliveries[i].body = nil
+			return claimed, nil
+		}
+	}
+
+	return false, errors.New("Could not find delivery in repository")
+}
@@ -0,0 +1,81 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+type inMemoryWebhookRepository struct {
+	mutex    sync.RWMutex
+	webhooks map[string]*Webhook
+	nextID   int
+}
+
+func NewInMemoryWebhookRepository() *inMemoryWebhookRepository {
+	repo := &inMemoryWebhookRepository{}
+	repo.webhooks = make(map[string]*Webhook)
+	return repo
+}
+
+func (repo *inMemoryWebhookRepository) Add(webhook *Webhook) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	repo.nextID++
+	webhook.ID = fmt.Sprintf("%d", repo.nextID)
+	stored := *webhook
+	repo.webhooks[webhook.ID] = &stored
+	return err
+}
+
+func (repo *inMemoryWebhookRepository) Update(webhook *Webhook) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.webhooks[webhook.ID]; !ok {
+		return errors.New("Could not find webhook in repository")
+	}
+
+	stored := *webhook
+	repo.webhooks[webhook.ID] = &stored
+	return err
+}
+
+func (repo *inMemoryWebhookRepository) Delete(webhook *Webhook) (err error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, ok := repo.webhooks[webhook.ID]; !ok {
+		return errors.New("Could not find webhook in repository")
+	}
+
+	delete(repo.webhooks, webhook.ID)
+	return err
+}
+
+func (repo *inMemoryWebhookRepository) Get(siteID string, id string) (*Webhook, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	if webhook, ok := repo.webhooks[id]; ok && webhook.SiteID == siteID {
+		found := *webhook
+		return &found, nil
+	}
+
+	return nil, errors.New("Could not find webhook in repository")
+}
+
+func (repo *inMemoryWebhookRepository) ListBySite(siteID string) (webhooks []*Webhook) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	for _, webhook := range repo.webhooks {
+		if webhook.SiteID == siteID {
+			found := *webhook
+			webhooks = append(webhooks, &found)
+		}
+	}
+
+	return webhooks
+}
@@ -0,0 +1,146 @@
+package webhook
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoDeliveryRepository struct {
+	Collection cfmgo.Collection
+}
+
+type deliveryRecord struct {
+	RecordID   bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	WebhookID  string        `bson:"webhook_id" json:"webhook_id"`
+	EventID    string        `bson:"event_id" json:"event_id"`
+	EventType  string        `bson:"event_type" json:"event_type"`
+	Attempt    int           `bson:"attempt" json:"attempt"`
+	StatusCode int           `bson:"status_code" json:"status_code"`
+	Error      string        `bson:"error,omitempty" json:"error"`
+	Duration   int64         `bson:"duration_ms" json:"duration_ms"`
+	Succeeded  bool          `bson:"succeeded" json:"succeeded"`
+	Created    time.Time     `bson:"date_created" json:"date_created"`
+	// NextAttempt, SiteID and Body are only kept until a failed delivery
+	// is retried.
+	NextAttempt *time.Time `bson:"next_attempt,omitempty" json:"next_attempt"`
+	SiteID      string     `bson:"site_id,omitempty" json:"site_id"`
+	Body        string     `bson:"body,omitempty" json:"-"`
+}
+
+func NewMongoDeliveryRepository(col cfmgo.Collection) *mongoDeliveryRepository {
+	return &mongoDeliveryRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoDeliveryRepository) Add(delivery *Delivery) (err error) {
+	repo.Collection.Wake()
+	record := deliveryRecord{
+		RecordID:   bson.NewObjectId(),
+		WebhookID:  delivery.WebhookID,
+		EventID:    delivery.EventID,
+		EventType:  delivery.EventType,
+		Attempt:    delivery.Attempt,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Duration:   delivery.Duration,
+		Succeeded:  delivery.Succeeded,
+		Created:    delivery.Created,
+	}
+
+	if delivery.NextAttempt != nil {
+		record.NextAttempt = delivery.NextAttempt
+		record.SiteID = delivery.siteID
+		record.Body = string(delivery.body)
+	}
+
+	if _, err = repo.Collection.UpsertID(record.RecordID, record); err == nil {
+		delivery.ID = record.RecordID.Hex()
+	}
+
+	return
+}
+
+func (repo *mongoDeliveryRepository) ListByWebhook(webhookID string, limit int) (deliveries []*Delivery) {
+	repo.Collection.Wake()
+	var records []deliveryRecord
+	params := &params.RequestParams{
+		Q: bson.M{"webhook_id": webhookID},
+	}
+
+	repo.Collection.Find(params, &records)
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Created.After(records[j].Created)
+	})
+
+	for i := 0; i < len(records) && len(deliveries) < limit; i++ {
+		deliveries = append(deliveries, toDelivery(&records[i]))
+	}
+
+	return deliveries
+}
+
+func (repo *mongoDeliveryRepository) ListDue(now time.Time, limit int) (deliveries []*Delivery) {
+	repo.Collection.Wake()
+	var records []deliveryRecord
+	params := &params.RequestParams{
+		Q: bson.M{"next_attempt": bson.M{"$lte": now}},
+	}
+
+	repo.Collection.Find(params, &records)
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].NextAttempt.Before(*records[j].NextAttempt)
+	})
+
+	for i := 0; i < len(records) && len(deliveries) < limit; i++ {
+		deliveries = append(deliveries, toDelivery(&records[i]))
+	}
+
+	return deliveries
+}
+
+// ClaimRetry only matches the delivery while it still has a next attempt, so
+// that of two servers retrying it only one can claim it.
+func (repo *mongoDeliveryRepository) ClaimRetry(id string) (claimed bool, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return false, errors.New("Could not find delivery in repository")
+	}
+
+	repo.Collection.Wake()
+	var record deliveryRecord
+	selector := bson.M{"_id": bson.ObjectIdHex(id), "next_attempt": bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{"next_attempt": "", "body": ""}}
+
+	_, err = repo.Collection.FindAndModify(selector, update, &record)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func toDelivery(record *deliveryRecord) *Delivery {
+	return &Delivery{
+		ID:          record.RecordID.Hex(),
+		WebhookID:   record.WebhookID,
+		EventID:     record.EventID,
+		EventType:   record.EventType,
+		Attempt:     record.Attempt,
+		StatusCode:  record.StatusCode,
+		Error:       record.Error,
+		Duration:    record.Duration,
+		Succeeded:   record.Succeeded,
+		Created:     record.Created,
+		NextAttempt: record.NextAttempt,
+		siteID:      record.SiteID,
+		body:        []byte(record.Body),
+	}
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudnativego/cfmgo"
+	"github.com/cloudnativego/cfmgo/params"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type mongoWebhookRepository struct {
+	Collection cfmgo.Collection
+}
+
+type webhookRecord struct {
+	RecordID bson.ObjectId `bson:"_id,omitempty" json:"id"`
+	SiteID   string        `bson:"site_id" json:"site_id"`
+	URL      string        `bson:"url" json:"url"`
+	Secret   string        `bson:"secret" json:"secret"`
+	Events   []string      `bson:"events" json:"events"`
+	Active   bool          `bson:"active" json:"active"`
+	Created  time.Time     `bson:"date_created" json:"date_created"`
+}
+
+func NewMongoWebhookRepository(col cfmgo.Collection) *mongoWebhookRepository {
+	return &mongoWebhookRepository{
+		Collection: col,
+	}
+}
+
+func (repo *mongoWebhookRepository) Add(webhook *Webhook) (err error) {
+	repo.Collection.Wake()
+	record := toWebhookRecord(webhook)
+	record.RecordID = bson.NewObjectId()
+	if _, err = repo.Collection.UpsertID(record.RecordID, record); err == nil {
+		webhook.ID = record.RecordID.Hex()
+	}
+
+	return
+}
+
+func (repo *mongoWebhookRepository) Update(webhook *Webhook) (err error) {
+	repo.Collection.Wake()
+	if _, err = repo.Get(webhook.SiteID, webhook.ID); err == nil {
+		record := toWebhookRecord(webhook)
+		_, err = repo.Collection.UpsertID(record.RecordID, record)
+	}
+
+	return
+}
+
+func (repo *mongoWebhookRepository) Delete(webhook *Webhook) (err error) {
+	repo.Collection.Wake()
+	if _, err = repo.Get(webhook.SiteID, webhook.ID); err == nil {
+		err = repo.Collection.Delete(bson.M{"_id": bson.ObjectIdHex(webhook.ID)})
+	}
+
+	return
+}
+
+func (repo *mongoWebhookRepository) Get(siteID string, id string) (webhook *Webhook, err error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.New("Could not find webhook in repository")
+	}
+
+	records := repo.find(bson.M{"_id": bson.ObjectIdHex(id), "site_id": siteID})
+	if len(records) == 0 {
+		return nil, errors.New("Could not find webhook in repository")
+	}
+
+	return toWebhook(&records[0]), nil
+}
+
+func (repo *mongoWebhookRepository) ListBySite(siteID string) (webhooks []*Webhook) {
+	for _, record := range repo.find(bson.M{"site_id": siteID}) {
+		webhooks = append(webhooks, toWebhook(&record))
+	}
+
+	return webhooks
+}
+
+func (repo *mongoWebhookRepository) find(query bson.M) (records []webhookRecord) {
+	repo.Collection.Wake()
+	params := &params.RequestParams{
+		Q: query,
+	}
+
+	repo.Collection.Find(params, &records)
+	return records
+}
+
+func toWebhookRecord(webhook *Webhook) *webhookRecord {
+	record := &webhookRecord{
+		SiteID:  webhook.SiteID,
+		URL:     webhook.URL,
+		Secret:  webhook.Secret,
+		Events:  webhook.Events,
+		Active:  webhook.Active,
+		Created: webhook.Created,
+	}
+
+	if bson.IsObjectIdHex(webhook.ID) {
+		record.RecordID = bson.ObjectIdHex(webhook.ID)
+	}
+
+	return record
+}
+
+func toWebhook(record *webhookRecord) *Webhook {
+	return &Webhook{
+		ID:      record.RecordID.Hex(),
+		SiteID:  record.SiteID,
+		URL:     record.URL,
+		Secret:  record.Secret,
+		Events:  record.Events,
+		Active:  record.Active,
+		Created: record.Created,
+	}
+}
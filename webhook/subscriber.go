@@ -0,0 +1,305 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+)
+
+// Options configures webhook delivery.
+type Options struct {
+	// MaxAttempts is how many times a delivery is tried before giving up.
+	MaxAttempts int
+	// Backoff is the wait before the first retry; it doubles on each retry.
+	Backoff time.Duration
+	// PollInterval is how often deliveries that are due a retry are looked
+	// for.
+	PollInterval time.Duration
+	// Timeout bounds each delivery attempt.
+	Timeout time.Duration
+	// AllowPrivateNetworks permits webhook URLs that resolve to loopback,
+	// link-local or private addresses. Leave it off in production so site
+	// admins can't use webhooks to reach internal services.
+	AllowPrivateNetworks bool
+}
+
+// DefaultOptions are used for any Options field left at zero.
+var DefaultOptions = Options{
+	MaxAttempts:  5,
+	Backoff:      time.Second,
+	PollInterval: time.Second,
+	Timeout:      10 * time.Second,
+}
+
+// retryBatchSize is how many due deliveries are retried per poll.
+const retryBatchSize = 100
+
+var errPrivateAddress = errors.New("Webhook URL resolves to a private network address")
+
+// Subscriber is an EventSubscriber that POSTs events to the webhooks
+// subscribed to them, and records every attempt in the delivery log. Failed
+// deliveries are retried with exponential backoff once Start is called; the
+// retries are kept in the DeliveryRepository, so that they outlive the
+// process and never hold up the events behind them.
+type Subscriber struct {
+	webhookRepository    WebhookRepository
+	deliveryRepository   DeliveryRepository
+	membershipRepository membership.MembershipRepository
+	client               *http.Client
+	options              Options
+	now                  func() time.Time
+
+	stateMutex sync.Mutex
+	running    bool
+	stop       chan struct{}
+	stopped    chan struct{}
+}
+
+func NewSubscriber(webhookRepository WebhookRepository, deliveryRepository DeliveryRepository, membershipRepository membership.MembershipRepository, options Options) *Subscriber {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DefaultOptions.MaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = DefaultOptions.Backoff
+	}
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultOptions.PollInterval
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultOptions.Timeout
+	}
+
+	return &Subscriber{
+		webhookRepository:    webhookRepository,
+		deliveryRepository:   deliveryRepository,
+		membershipRepository: membershipRepository,
+		client:               newClient(options),
+		options:              options,
+		now:                  time.Now,
+	}
+}
+
+func newClient(options Options) *http.Client {
+	dialer := &net.Dialer{Timeout: options.Timeout}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return errPrivateAddress
+			}
+
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout:   options.Timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified()
+}
+
+// Receive makes the first attempt to deliver the event envelope to every
+// subscribed webhook. Failed deliveries are scheduled for a retry rather
+// than returned, so one broken receiver doesn't cause the event to be
+// redelivered to all the others.
+func (s *Subscriber) Receive(e interface{}) error {
+	envelope, ok := e.(events.Envelope)
+	if !ok {
+		return nil
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range s.webhooksFor(envelope.Payload) {
+		if webhook.Subscribes(envelope.Type) {
+			s.deliver(webhook, envelope.ID, envelope.Type, body, 1)
+		}
+	}
+
+	return nil
+}
+
+// webhooksFor returns the webhooks of the site a site event concerns, and of
+// every site the user a user event concerns is a member of.
+func (s *Subscriber) webhooksFor(event events.DomainEvent) (webhooks []*Webhook) {
+	siteIDs := map[string]bool{}
+
+	if event, ok := event.(events.SiteEvent); ok {
+		siteIDs[event.EventSiteID()] = true
+	}
+
+	if event, ok := event.(events.UserEvent); ok {
+		for _, membership := range s.membershipRepository.ListByUser(event.EventUserID()) {
+			siteIDs[membership.SiteID] = true
+		}
+	}
+
+	for siteID := range siteIDs {
+		webhooks = append(webhooks, s.webhookRepository.ListBySite(siteID)...)
+	}
+
+	return webhooks
+}
+
+// deliver makes one attempt and records it, along with when to retry if it
+// failed and there are attempts left.
+func (s *Subscriber) deliver(webhook *Webhook, eventID string, eventType string, body []byte, attempt int) {
+	delivery := s.attempt(webhook, eventID, eventType, body)
+	delivery.Attempt = attempt
+
+	if !delivery.Succeeded && attempt < s.options.MaxAttempts {
+		backoff := s.options.Backoff
+		for i := 1; i < attempt; i++ {
+			backoff *= 2
+		}
+
+		next := s.now().Add(backoff)
+		delivery.NextAttempt = &next
+		delivery.siteID = webhook.SiteID
+		delivery.body = body
+	}
+
+	if err := s.deliveryRepository.Add(delivery); err != nil {
+		fmt.Printf("Failed to record delivery of %s to webhook %s: %v\n", eventID, webhook.ID, err)
+	}
+}
+
+// RetryDue retries the failed deliveries whose next attempt is due, and
+// returns how many it retried. Deliveries to webhooks that have since been
+// deleted or deactivated are dropped.
+func (s *Subscriber) RetryDue() (retried int) {
+	for _, delivery := range s.deliveryRepository.ListDue(s.now(), retryBatchSize) {
+		claimed, err := s.deliveryRepository.ClaimRetry(delivery.ID)
+		if err != nil {
+			fmt.Printf("Failed to claim retry of webhook delivery %s: %v\n", delivery.ID, err)
+			continue
+		} else if !claimed {
+			continue
+		}
+
+		webhook, err := s.webhookRepository.Get(delivery.siteID, delivery.WebhookID)
+		if err != nil || !webhook.Active {
+			continue
+		}
+
+		s.deliver(webhook, delivery.EventID, delivery.EventType, delivery.body, delivery.Attempt+1)
+		retried++
+	}
+
+	return retried
+}
+
+// Start begins retrying failed deliveries, including any left over from a
+// previous run.
+func (s *Subscriber) Start() {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.stop = make(chan struct{})
+	s.stopped = make(chan struct{})
+	stop, stopped := s.stop, s.stopped
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.options.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			s.RetryDue()
+
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Shutdown stops retrying once the retries in progress are done, or when ctx
+// is done. Retries that are still due are made after the next Start.
+func (s *Subscriber) Shutdown(ctx context.Context) error {
+	s.stateMutex.Lock()
+	running, stopped := s.running, s.stopped
+	if running {
+		s.running = false
+		close(s.stop)
+	}
+	s.stateMutex.Unlock()
+
+	if !running {
+		return nil
+	}
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Subscriber) attempt(webhook *Webhook, eventID string, eventType string, body []byte) *Delivery {
+	delivery := &Delivery{
+		WebhookID: webhook.ID,
+		EventID:   eventID,
+		EventType: eventType,
+		Created:   time.Now(),
+	}
+
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, eventType)
+	req.Header.Set(DeliveryHeader, eventID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	res, err := s.client.Do(req)
+	delivery.Duration = int64(time.Since(delivery.Created) / time.Millisecond)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	res.Body.Close()
+
+	delivery.StatusCode = res.StatusCode
+	delivery.Succeeded = res.StatusCode >= 200 && res.StatusCode < 300
+	return delivery
+}
@@ -0,0 +1,282 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/membership"
+	"github.com/spear-wind/cms/site"
+	"github.com/spear-wind/cms/user"
+)
+
+// receiver is an httptest server that verifies each delivery's signature and
+// fails the first failures requests.
+type receiver struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	secret   string
+	failures int
+	received []events.Envelope
+	invalid  int
+}
+
+func newReceiver(secret string, failures int) *receiver {
+	r := &receiver{secret: secret, failures: failures}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		timestamp, _ := strconv.ParseInt(req.Header.Get(TimestampHeader), 10, 64)
+
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if req.Header.Get(SignatureHeader) != Sign(r.secret, timestamp, body) {
+			r.invalid++
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.failures > 0 {
+			r.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var envelope events.Envelope
+		json.Unmarshal(body, &envelope)
+		r.received = append(r.received, envelope)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.received)
+}
+
+func (r *receiver) rejected() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.invalid
+}
+
+var testOptions = Options{Backoff: time.Millisecond, AllowPrivateNetworks: true}
+
+func newEnvelope(t *testing.T, event events.DomainEvent) events.Envelope {
+	envelope, err := events.NewEnvelope(event, nil)
+	if err != nil {
+		t.Fatalf("Failed to create envelope: %v", err)
+	}
+
+	return envelope
+}
+
+// retryAll moves the subscriber's clock on past each backoff in turn, making
+// the retries that come due.
+func retryAll(subscriber *Subscriber) {
+	now := time.Now()
+	subscriber.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		now = now.Add(time.Hour)
+		subscriber.RetryDue()
+	}
+}
+
+func addWebhook(t *testing.T, repo WebhookRepository, siteID string, url string, events ...string) *Webhook {
+	webhook := NewWebhook(siteID, url, events)
+	webhook.resetSecret()
+	if err := repo.Add(webhook); err != nil {
+		t.Fatalf("Failed to add webhook: %v", err)
+	}
+
+	return webhook
+}
+
+func TestSubscriberDeliversSignedSiteEvents(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+
+	target := newReceiver("", 0)
+	defer target.Close()
+	webhook := addWebhook(t, webhookRepository, "1", target.URL, "site.created")
+	target.secret = webhook.Secret
+
+	other := newReceiver("", 0)
+	defer other.Close()
+	otherWebhook := addWebhook(t, webhookRepository, "2", other.URL)
+	other.secret = otherWebhook.Secret
+
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membership.NewInMemoryRepository(), testOptions)
+	if err := subscriber.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1", Name: "Spearwind"})); err != nil {
+		t.Fatalf("Expected delivery to succeed, got %v", err)
+	}
+
+	if target.count() != 1 || target.rejected() != 0 {
+		t.Fatalf("Expected one correctly signed delivery, got %d valid and %d invalid", target.count(), target.rejected())
+	}
+
+	if target.received[0].Type != "site.created" || target.received[0].Payload.(site.CreatedEvent).Name != "Spearwind" {
+		t.Errorf("Expected the site.created envelope to be delivered, got %+v", target.received[0])
+	}
+
+	if other.count() != 0 {
+		t.Errorf("Expected webhooks of other sites not to receive the event")
+	}
+
+	deliveries := deliveryRepository.ListByWebhook(webhook.ID, 10)
+	if len(deliveries) != 1 || !deliveries[0].Succeeded || deliveries[0].StatusCode != http.StatusNoContent {
+		t.Errorf("Expected one successful delivery to be logged, got %+v", deliveries)
+	}
+}
+
+func TestSubscriberRetriesFailedDeliveries(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+
+	target := newReceiver("", 2)
+	defer target.Close()
+	webhook := addWebhook(t, webhookRepository, "1", target.URL)
+	target.secret = webhook.Secret
+
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membership.NewInMemoryRepository(), testOptions)
+	subscriber.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1"}))
+	retryAll(subscriber)
+
+	if target.count() != 1 {
+		t.Fatalf("Expected the event to be delivered after retrying, got %d deliveries", target.count())
+	}
+
+	deliveries := deliveryRepository.ListByWebhook(webhook.ID, 10)
+	if len(deliveries) != 3 {
+		t.Fatalf("Expected three logged attempts, got %d", len(deliveries))
+	}
+
+	if deliveries[0].Attempt != 3 || !deliveries[0].Succeeded {
+		t.Errorf("Expected the most recent attempt to be the successful third one, got %+v", deliveries[0])
+	}
+
+	if deliveries[2].Succeeded || deliveries[2].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the first attempt to be logged as failed, got %+v", deliveries[2])
+	}
+}
+
+func TestSubscriberGivesUpAfterMaxAttempts(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+
+	target := newReceiver("", 10)
+	defer target.Close()
+	webhook := addWebhook(t, webhookRepository, "1", target.URL)
+	target.secret = webhook.Secret
+
+	options := testOptions
+	options.MaxAttempts = 3
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membership.NewInMemoryRepository(), options)
+
+	if err := subscriber.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1"})); err != nil {
+		t.Errorf("Expected a failing receiver not to fail the event, got %v", err)
+	}
+	retryAll(subscriber)
+
+	if deliveries := deliveryRepository.ListByWebhook(webhook.ID, 10); len(deliveries) != 3 {
+		t.Errorf("Expected delivery to stop after 3 attempts, got %d", len(deliveries))
+	}
+}
+
+func TestSubscriberSchedulesRetriesWithoutWaiting(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+
+	target := newReceiver("", 1)
+	defer target.Close()
+	webhook := addWebhook(t, webhookRepository, "1", target.URL)
+	target.secret = webhook.Secret
+
+	options := testOptions
+	options.Backoff = time.Hour
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membership.NewInMemoryRepository(), options)
+
+	start := time.Now()
+	subscriber.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1"}))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Receive not to wait for the retry, took %v", elapsed)
+	}
+
+	deliveries := deliveryRepository.ListByWebhook(webhook.ID, 10)
+	if len(deliveries) != 1 || deliveries[0].NextAttempt == nil || deliveries[0].NextAttempt.Before(start.Add(time.Hour)) {
+		t.Fatalf("Expected the failed delivery to be retried in an hour, got %+v", deliveries)
+	}
+
+	if retried := subscriber.RetryDue(); retried != 0 {
+		t.Errorf("Expected no retry before it is due, got %d", retried)
+	}
+
+	later := start.Add(2 * time.Hour)
+	subscriber.now = func() time.Time { return later }
+
+	if retried := subscriber.RetryDue(); retried != 1 || target.count() != 1 {
+		t.Fatalf("Expected the due retry to deliver the event, got %d retried and %d delivered", retried, target.count())
+	}
+
+	if retried := subscriber.RetryDue(); retried != 0 {
+		t.Errorf("Expected a delivery to be retried only once, got %d more", retried)
+	}
+}
+
+func TestSubscriberBlocksPrivateNetworks(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+
+	target := newReceiver("", 0)
+	defer target.Close()
+	webhook := addWebhook(t, webhookRepository, "1", target.URL)
+	target.secret = webhook.Secret
+
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membership.NewInMemoryRepository(), Options{MaxAttempts: 1})
+	subscriber.Receive(newEnvelope(t, site.CreatedEvent{SiteID: "1"}))
+
+	if target.count() != 0 {
+		t.Errorf("Expected a loopback webhook not to be called")
+	}
+
+	if deliveries := deliveryRepository.ListByWebhook(webhook.ID, 10); len(deliveries) != 1 || deliveries[0].Succeeded || len(deliveries[0].Error) == 0 {
+		t.Errorf("Expected the blocked delivery to be logged with an error, got %+v", deliveries)
+	}
+}
+
+func TestSubscriberSendsUserEventsToMemberSites(t *testing.T) {
+	webhookRepository := NewInMemoryWebhookRepository()
+	deliveryRepository := NewInMemoryDeliveryRepository()
+	membershipRepository := membership.NewInMemoryRepository()
+	membershipRepository.Add(membership.NewMembership("1", 7, membership.RoleEditor))
+
+	member := newReceiver("", 0)
+	defer member.Close()
+	member.secret = addWebhook(t, webhookRepository, "1", member.URL).Secret
+
+	stranger := newReceiver("", 0)
+	defer stranger.Close()
+	stranger.secret = addWebhook(t, webhookRepository, "2", stranger.URL).Secret
+
+	subscriber := NewSubscriber(webhookRepository, deliveryRepository, membershipRepository, testOptions)
+	subscriber.Receive(newEnvelope(t, user.VerifiedEvent{UserID: 7, Email: "member@spearwind.io"}))
+
+	if member.count() != 1 {
+		t.Errorf("Expected the user's site to receive the event, got %d deliveries", member.count())
+	}
+
+	if stranger.count() != 0 {
+		t.Errorf("Expected sites the user is not a member of not to receive the event")
+	}
+}
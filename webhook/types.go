@@ -0,0 +1,148 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/security"
+	"github.com/spear-wind/cms/validator"
+)
+
+const (
+	SignatureHeader = "X-Spearwind-Signature"
+	TimestampHeader = "X-Spearwind-Timestamp"
+	EventHeader     = "X-Spearwind-Event"
+	DeliveryHeader  = "X-Spearwind-Delivery"
+)
+
+type WebhookRepository interface {
+	Add(webhook *Webhook) (err error)
+	Update(webhook *Webhook) (err error)
+	Delete(webhook *Webhook) (err error)
+	Get(siteID string, id string) (webhook *Webhook, err error)
+	ListBySite(siteID string) (webhooks []*Webhook)
+}
+
+type DeliveryRepository interface {
+	Add(delivery *Delivery) (err error)
+	// ListByWebhook returns the most recent deliveries first.
+	ListByWebhook(webhookID string, limit int) (deliveries []*Delivery)
+	// ListDue returns failed deliveries whose NextAttempt is at or before
+	// now, soonest first.
+	ListDue(now time.Time, limit int) (deliveries []*Delivery)
+	// ClaimRetry clears the delivery's NextAttempt so that it is only
+	// retried once. claimed is false if another server got there first.
+	ClaimRetry(id string) (claimed bool, err error)
+}
+
+// Webhook is a URL that events concerning a site are POSTed to. An empty
+// Events list subscribes to every event type.
+type Webhook struct {
+	ID      string    `json:"id"`
+	SiteID  string    `json:"site_id"`
+	URL     string    `json:"url"`
+	Secret  string    `json:"secret,omitempty"`
+	Events  []string  `json:"events"`
+	Active  bool      `json:"active"`
+	Created time.Time `json:"date_created"`
+}
+
+// Delivery records one attempt to deliver an event to a webhook.
+type Delivery struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	EventID    string    `json:"event_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   int64     `json:"duration_ms"`
+	Succeeded  bool      `json:"succeeded"`
+	Created    time.Time `json:"date_created"`
+	// NextAttempt is when a failed delivery will be retried. It is nil once
+	// the retry has been made, or when there are no attempts left.
+	NextAttempt *time.Time `json:"next_attempt,omitempty"`
+	// siteID and body are kept until the retry, to make it with.
+	siteID string
+	body   []byte
+}
+
+func NewWebhook(siteID string, url string, events []string) *Webhook {
+	return &Webhook{
+		SiteID:  siteID,
+		URL:     url,
+		Events:  events,
+		Active:  true,
+		Created: time.Now(),
+	}
+}
+
+// Subscribes reports whether the webhook wants events of eventType.
+func (webhook *Webhook) Subscribes(eventType string) bool {
+	if !webhook.Active {
+		return false
+	}
+
+	if len(webhook.Events) == 0 {
+		return true
+	}
+
+	for _, subscribed := range webhook.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resetSecret generates a new signing secret for the webhook.
+func (webhook *Webhook) resetSecret() (err error) {
+	webhook.Secret, err = security.GenerateRandomString(32)
+	return err
+}
+
+// Sign returns the signature header value for a delivery body sent at
+// timestamp. Receivers should recompute it from the raw request body and the
+// X-Spearwind-Timestamp header, and reject stale timestamps.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (webhook *Webhook) validate() (result validator.ValidationResult) {
+	result = validator.NewValidationResult()
+
+	if len(webhook.SiteID) == 0 {
+		result.AddError("site_id", "Site ID is required")
+	}
+
+	if parsed, err := url.Parse(webhook.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		result.AddError("url", "URL must be an absolute http or https URL")
+	}
+
+	for _, eventType := range webhook.Events {
+		if !isEventType(eventType) {
+			result.AddError("events", fmt.Sprintf("Unknown event type %s", eventType))
+		}
+	}
+
+	return result
+}
+
+func isEventType(eventType string) bool {
+	for _, known := range events.DomainEventTypes() {
+		if known == eventType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,59 @@
+package webhook
+
+import "testing"
+
+func TestSignIsStableAndKeyed(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+
+	signature := Sign("secret", 1500000000, body)
+	if signature != Sign("secret", 1500000000, body) {
+		t.Errorf("Expected the same input to produce the same signature")
+	}
+
+	if signature == Sign("other", 1500000000, body) {
+		t.Errorf("Expected a different secret to produce a different signature")
+	}
+
+	if signature == Sign("secret", 1500000001, body) {
+		t.Errorf("Expected a different timestamp to produce a different signature")
+	}
+
+	if signature[:7] != "sha256=" {
+		t.Errorf("Expected the signature to be prefixed with sha256=, got %s", signature)
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	if result := NewWebhook("1", "https://example.com/hook", []string{"site.created"}).validate(); result.HasErrors() {
+		t.Errorf("Expected a valid webhook, got %v", result.Errors)
+	}
+
+	if result := NewWebhook("1", "ftp://example.com/hook", nil).validate(); !result.HasErrors() {
+		t.Errorf("Expected a non-http URL to be rejected")
+	}
+
+	if result := NewWebhook("1", "/hook", nil).validate(); !result.HasErrors() {
+		t.Errorf("Expected a relative URL to be rejected")
+	}
+
+	if result := NewWebhook("1", "https://example.com/hook", []string{"site.exploded"}).validate(); !result.HasErrors() {
+		t.Errorf("Expected an unknown event type to be rejected")
+	}
+}
+
+func TestWebhookSubscribes(t *testing.T) {
+	all := NewWebhook("1", "https://example.com/hook", nil)
+	if !all.Subscribes("user.verified") {
+		t.Errorf("Expected a webhook without an event filter to receive every event")
+	}
+
+	filtered := NewWebhook("1", "https://example.com/hook", []string{"site.created"})
+	if filtered.Subscribes("user.verified") || !filtered.Subscribes("site.created") {
+		t.Errorf("Expected a webhook to only receive the events it subscribes to")
+	}
+
+	filtered.Active = false
+	if filtered.Subscribes("site.created") {
+		t.Errorf("Expected an inactive webhook to receive no events")
+	}
+}
@@ -0,0 +1,107 @@
+package workflow
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/validator"
+)
+
+var editorialTransitions = map[State][]State{
+	StateDraft:     {StateInReview},
+	StateInReview:  {StateDraft, StateScheduled, StatePublished},
+	StateScheduled: {StateInReview, StatePublished},
+	StatePublished: {StateDraft, StateArchived},
+	StateArchived:  {StateDraft},
+}
+
+type Engine struct {
+	transitions    map[State][]State
+	eventPublisher events.EventPublisher
+}
+
+func init() {
+	events.RegisterDomainEvent(StateChangedEvent{})
+}
+
+// NewEngine returns an Engine enforcing the editorial
+// draft -> in_review -> scheduled -> published -> archived workflow
+func NewEngine(eventPublisher events.EventPublisher) *Engine {
+	return &Engine{
+		transitions:    editorialTransitions,
+		eventPublisher: eventPublisher,
+	}
+}
+
+func (e *Engine) AllowedTransitions(from State) []State {
+	return e.transitions[from]
+}
+
+func (e *Engine) CanTransition(from State, to State) bool {
+	for _, allowed := range e.transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Transition validates and applies the requested state change to content,
+// persists it with save and then publishes a StateChangedEvent. Nothing is
+// published if validation or save fail.
+func (e *Engine) Transition(content Content, req TransitionRequest, save func() error) (validator.ValidationResult, error) {
+	result := e.validate(content, req)
+	if result.HasErrors() {
+		return result, nil
+	}
+
+	t := Transition{
+		From:    content.WorkflowState(),
+		To:      req.To,
+		Actor:   req.Actor.Actor(),
+		Comment: req.Comment,
+		When:    time.Now(),
+	}
+
+	if req.To == StateScheduled {
+		t.ScheduledFor = req.ScheduledFor
+	}
+
+	content.ApplyTransition(t)
+
+	if err := save(); err != nil {
+		return result, err
+	}
+
+	events.Publish(e.eventPublisher, t.Actor, StateChangedEvent{
+		ContentType: content.ContentType(),
+		ContentID:   content.ContentID(),
+		SiteID:      content.ContentSiteID(),
+		Transition:  t,
+	})
+
+	return result, nil
+}
+
+func (e *Engine) validate(content Content, req TransitionRequest) (result validator.ValidationResult) {
+	result = validator.NewValidationResult()
+	from := content.WorkflowState()
+
+	if !IsValidState(req.To) {
+		result.AddError("to", fmt.Sprintf("%q is not a valid workflow state", req.To))
+	} else if !e.CanTransition(from, req.To) {
+		result.AddError("to", fmt.Sprintf("Cannot move from %s to %s", from, req.To))
+	}
+
+	if req.Actor == nil {
+		result.AddError("actor", "Actor is required")
+	}
+
+	if req.To == StateScheduled && !req.ScheduledFor.After(time.Now()) {
+		result.AddError("scheduled_for", "Scheduled for must be a time in the future")
+	}
+
+	return result
+}
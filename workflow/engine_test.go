@@ -0,0 +1,143 @@
+package workflow
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+)
+
+type fakeContent struct {
+	state   State
+	history []Transition
+}
+
+func (c *fakeContent) ContentType() string   { return "fake" }
+func (c *fakeContent) ContentID() string     { return "1" }
+func (c *fakeContent) ContentSiteID() string { return "2" }
+func (c *fakeContent) WorkflowState() State  { return c.state }
+func (c *fakeContent) ApplyTransition(t Transition) {
+	c.state = t.To
+	c.history = append(c.history, t)
+}
+
+type recordingPublisher struct {
+	published []interface{}
+}
+
+func (p *recordingPublisher) Publish(e interface{}) {
+	p.published = append(p.published, e)
+}
+
+func (p *recordingPublisher) Add(s events.EventSubscriber) {}
+
+var editor = user.NewUser(1, "Ed", "Itor", "editor@spearwind.io")
+
+func noopSave() error { return nil }
+
+func TestTransitionHappyPath(t *testing.T) {
+	publisher := &recordingPublisher{}
+	engine := NewEngine(publisher)
+	content := &fakeContent{state: StateDraft}
+
+	result, err := engine.Transition(content, TransitionRequest{To: StateInReview, Actor: editor}, noopSave)
+	if err != nil || result.HasErrors() {
+		t.Fatalf("Expected draft -> in_review to succeed, got %v, %v", result.Errors, err)
+	}
+
+	if content.state != StateInReview {
+		t.Errorf("Expected content to be in_review, but was %s", content.state)
+	}
+
+	if len(content.history) != 1 || content.history[0].Actor == nil || content.history[0].Actor.UserID != editor.ID || content.history[0].When.IsZero() {
+		t.Errorf("Expected the transition to be recorded with its actor and time, got %+v", content.history)
+	}
+
+	if len(publisher.published) != 1 {
+		t.Fatalf("Expected exactly one event to be published, got %d", len(publisher.published))
+	}
+
+	envelope, ok := publisher.published[0].(events.Envelope)
+	if !ok {
+		t.Fatalf("Expected an events.Envelope, got %T", publisher.published[0])
+	}
+
+	event, ok := envelope.Payload.(StateChangedEvent)
+	if !ok {
+		t.Fatalf("Expected a StateChangedEvent, got %T", envelope.Payload)
+	}
+
+	if envelope.Type != "content.state_changed" || envelope.Actor == nil || envelope.Actor.UserID != editor.ID {
+		t.Errorf("Unexpected envelope: %+v", envelope)
+	}
+
+	if event.Transition.From != StateDraft || event.Transition.To != StateInReview || event.SiteID != "2" {
+		t.Errorf("Unexpected event contents: %+v", event)
+	}
+}
+
+func TestIllegalTransitionIsRejected(t *testing.T) {
+	publisher := &recordingPublisher{}
+	engine := NewEngine(publisher)
+	content := &fakeContent{state: StateDraft}
+
+	result, err := engine.Transition(content, TransitionRequest{To: StatePublished, Actor: editor}, noopSave)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(result.Errors) != 1 || result.Errors[0].FieldName != "to" {
+		t.Errorf("Expected a single error on 'to', got %v", result.Errors)
+	}
+
+	if content.state != StateDraft || len(publisher.published) != 0 {
+		t.Error("A rejected transition should neither change state nor publish an event")
+	}
+}
+
+func TestUnknownStateAndMissingActorAreRejected(t *testing.T) {
+	engine := NewEngine(&recordingPublisher{})
+	content := &fakeContent{state: StateDraft}
+
+	result, _ := engine.Transition(content, TransitionRequest{To: "deleted"}, noopSave)
+
+	if len(result.Errors) != 2 {
+		t.Errorf("Expected errors for the state and the actor, got %v", result.Errors)
+	}
+}
+
+func TestSchedulingRequiresFutureTime(t *testing.T) {
+	engine := NewEngine(&recordingPublisher{})
+	content := &fakeContent{state: StateInReview}
+
+	result, _ := engine.Transition(content, TransitionRequest{To: StateScheduled, Actor: editor}, noopSave)
+	if len(result.Errors) != 1 || result.Errors[0].FieldName != "scheduled_for" {
+		t.Errorf("Expected a single scheduled_for error, got %v", result.Errors)
+	}
+
+	when := time.Now().Add(time.Hour)
+	result, _ = engine.Transition(content, TransitionRequest{To: StateScheduled, Actor: editor, ScheduledFor: when}, noopSave)
+	if result.HasErrors() || !content.history[0].ScheduledFor.Equal(when) {
+		t.Errorf("Expected scheduling in the future to succeed, got %v", result.Errors)
+	}
+}
+
+func TestFailedSaveDoesNotPublish(t *testing.T) {
+	publisher := &recordingPublisher{}
+	engine := NewEngine(publisher)
+	content := &fakeContent{state: StateDraft}
+
+	_, err := engine.Transition(content, TransitionRequest{To: StateInReview, Actor: editor}, func() error {
+		return errors.New("boom")
+	})
+
+	if err == nil {
+		t.Error("Expected the save error to be returned")
+	}
+
+	if len(publisher.published) != 0 {
+		t.Error("No event should be published when saving fails")
+	}
+}
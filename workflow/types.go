@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"time"
+
+	"github.com/spear-wind/cms/events"
+	"github.com/spear-wind/cms/user"
+)
+
+type State string
+
+const (
+	StateDraft     State = "draft"
+	StateInReview  State = "in_review"
+	StateScheduled State = "scheduled"
+	StatePublished State = "published"
+	StateArchived  State = "archived"
+)
+
+// Content is implemented by any content type that moves through the
+// editorial workflow.
+type Content interface {
+	ContentType() string
+	ContentID() string
+	ContentSiteID() string
+	WorkflowState() State
+	ApplyTransition(t Transition)
+}
+
+// Transition records a state change. Only the actor's ID and email are kept,
+// as transitions are published and served with the content.
+type Transition struct {
+	From         State         `json:"from"`
+	To           State         `json:"to"`
+	Actor        *events.Actor `json:"actor"`
+	Comment      string        `json:"comment,omitempty"`
+	ScheduledFor time.Time     `json:"scheduled_for,omitempty"`
+	When         time.Time     `json:"when"`
+}
+
+// TransitionRequest asks for a state change. Actor is the authenticated
+// caller; it is never read from the request body.
+type TransitionRequest struct {
+	To           State      `json:"to"`
+	Actor        *user.User `json:"-"`
+	Comment      string     `json:"comment"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+}
+
+// StateChangedEvent is published every time content changes workflow state.
+type StateChangedEvent struct {
+	ContentType string     `json:"content_type"`
+	ContentID   string     `json:"content_id"`
+	SiteID      string     `json:"site_id"`
+	Transition  Transition `json:"transition"`
+}
+
+func (e StateChangedEvent) EventType() string   { return "content.state_changed" }
+func (e StateChangedEvent) EventVersion() int   { return 1 }
+func (e StateChangedEvent) EventSiteID() string { return e.SiteID }
+
+func IsValidState(s State) bool {
+	switch s {
+	case StateDraft, StateInReview, StateScheduled, StatePublished, StateArchived:
+		return true
+	}
+
+	return false
+}